@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+	"github.com/zuzi90/tz-enricher/internal/config"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"github.com/zuzi90/tz-enricher/internal/providers/cache"
+	"github.com/zuzi90/tz-enricher/internal/providers/codec"
+	"github.com/zuzi90/tz-enricher/internal/providers/kafka"
+	"github.com/zuzi90/tz-enricher/internal/providers/schemaregistry"
+	"github.com/zuzi90/tz-enricher/internal/providers/storage"
+	storagememory "github.com/zuzi90/tz-enricher/internal/providers/storage/memory"
+	"github.com/zuzi90/tz-enricher/internal/providers/storage/psql"
+	"github.com/zuzi90/tz-enricher/internal/providers/storage/sqlite"
+	"github.com/zuzi90/tz-enricher/internal/providers/transport"
+	"github.com/zuzi90/tz-enricher/internal/providers/transport/memory"
+	"github.com/zuzi90/tz-enricher/internal/providers/transport/redisstream"
+	message_service "github.com/zuzi90/tz-enricher/internal/services/message-service"
+	"github.com/zuzi90/tz-enricher/internal/services/resolvers"
+	"github.com/zuzi90/tz-enricher/internal/services/retention"
+	"github.com/zuzi90/tz-enricher/internal/services/userservice"
+	"os"
+)
+
+// app holds the dependencies shared by the server and the CLI subcommands.
+type app struct {
+	cfg       *config.Config
+	log       *logrus.Logger
+	kafkaOpts kafka.Options
+	redis     *redis.Client
+	cache     *cache.Redis
+	db        storage.Storage
+	broker    *memory.Broker
+	sink      transport.Sink
+	enriched  transport.Sink
+	mService  *message_service.MessageService
+	uService  *userservice.UserService
+	// retention is set only for the Postgres storage.
+	retention *retention.Service
+}
+
+func newApp(ctx context.Context, cfg *config.Config, log *logrus.Logger) (*app, error) {
+	a := app{
+		cfg:       cfg,
+		log:       log,
+		kafkaOpts: kafkaOptions(cfg),
+	}
+
+	clientRedis := redis.NewClient(&redis.Options{
+		Addr:     cfg.RedisDSN,
+		Password: cfg.RedisPassword,
+		DB:       cfg.RedisDB,
+	})
+
+	status := clientRedis.Ping(ctx)
+
+	if status.Err() != nil {
+		log.Warnf("redis ping: %v", status.Err())
+		return nil, status.Err()
+	}
+
+	log.Info("Redis up")
+	a.redis = clientRedis
+	a.cache = cache.NewRedis(clientRedis, log)
+
+	if err := a.newStorage(ctx); err != nil {
+		a.close()
+		return nil, err
+	}
+
+	if err := a.newSink(); err != nil {
+		a.close()
+		return nil, err
+	}
+
+	ageResolver := resolvers.NewAgeResolver(log, cfg.AgeURL)
+	genderResolver := resolvers.NewGenderResolver(log, cfg.GenderURL)
+	countryResolver := resolvers.NewCountryResolver(log, cfg.NationalityURL)
+
+	mCodec, err := newCodec(cfg, log)
+	if err != nil {
+		a.close()
+		return nil, err
+	}
+
+	a.mService = message_service.NewMessageService(log, a.cache, ageResolver, genderResolver, countryResolver, mCodec, a.sink, a.db)
+	a.mService.UseBatching(cfg.BatchSize, cfg.BatchInterval)
+
+	if a.enriched != nil {
+		a.mService.UseEnrichedSink(a.enriched)
+	}
+
+	a.uService = userservice.NewUserService(a.db, log, a.cache, cfg.StatsCacheTTL)
+
+	return &a, nil
+}
+
+func (a *app) close() {
+	if a.sink != nil {
+		if err := a.sink.Close(); err != nil {
+			a.log.Warnf("closing sink: %v", err)
+		}
+	}
+
+	if a.enriched != nil {
+		if err := a.enriched.Close(); err != nil {
+			a.log.Warnf("closing enriched sink: %v", err)
+		}
+	}
+
+	if a.db != nil {
+		if err := a.db.CloseDB(); err != nil {
+			a.log.Warnf("closing db: %v", err)
+		}
+	}
+}
+
+// newStorage opens the configured storage. The Postgres schema is migrated
+// or checked, the other storages create their tables themselves.
+func (a *app) newStorage(ctx context.Context) error {
+	switch a.cfg.Storage {
+	case storage.KindPostgres:
+		db, err := psql.NewStorage(ctx, a.log, a.cfg.PgDSN, storageOptions(a.cfg))
+		if err != nil {
+			return err
+		}
+
+		a.db = db
+
+		policy, err := retentionPolicy(a.cfg)
+		if err != nil {
+			return err
+		}
+
+		a.retention = retention.NewService(db, a.log, a.cache, policy)
+
+		if err = db.RegisterMetrics(prometheus.DefaultRegisterer); err != nil {
+			return err
+		}
+
+		if a.cfg.AutoMigrate {
+			return db.UpdateSchema()
+		}
+
+		return db.CheckSchema(ctx)
+	case storage.KindMemory:
+		a.db = storagememory.NewStorage()
+	case storage.KindSQLite:
+		db, err := sqlite.NewStorage(ctx, a.log, a.cfg.SQLitePath)
+		if err != nil {
+			return err
+		}
+
+		a.db = db
+	default:
+		return fmt.Errorf("unknown storage: %s", a.cfg.Storage)
+	}
+
+	a.log.Warnf("%s storage is meant for local runs, retention rules are not applied", a.cfg.Storage)
+
+	return nil
+}
+
+// newSink creates the WRONG_FN sink of the configured transport and the
+// sink of enriched users when KAFKA_TOPIC_ENRICHED is set.
+func (a *app) newSink() error {
+	if a.cfg.Transport == transport.KindKafka {
+		to := kafka.TopicOptions{
+			Create:            a.cfg.KafkaCreateTopics,
+			Partitions:        a.cfg.KafkaTopicPartitions,
+			ReplicationFactor: a.cfg.KafkaTopicReplication,
+			Retention:         a.cfg.KafkaTopicRetention,
+		}
+
+		topics := []string{a.cfg.KafkaTopic, a.cfg.KafkaTopicWrongFN}
+		if a.cfg.KafkaTopicEnriched != "" {
+			topics = append(topics, a.cfg.KafkaTopicEnriched)
+		}
+
+		if err := kafka.EnsureTopics(a.cfg.Brokers, a.kafkaOpts, to, a.log, topics...); err != nil {
+			return err
+		}
+	}
+
+	if a.cfg.Transport == transport.KindMemory {
+		a.broker = memory.NewBroker()
+	}
+
+	var err error
+
+	if a.sink, err = a.sinkFor(a.cfg.KafkaTopicWrongFN); err != nil {
+		return err
+	}
+
+	if a.cfg.KafkaTopicEnriched != "" {
+		a.enriched, err = a.sinkFor(a.cfg.KafkaTopicEnriched)
+	}
+
+	return err
+}
+
+func (a *app) sinkFor(topic string) (transport.Sink, error) {
+	switch a.cfg.Transport {
+	case transport.KindKafka:
+		producer, err := kafka.NewProducer(a.cfg.Brokers, topic, a.kafkaOpts, a.log)
+		if err != nil {
+			return nil, err
+		}
+
+		return producer, nil
+	case transport.KindMemory:
+		return memory.NewSink(a.broker, topic), nil
+	case transport.KindRedis:
+		return redisstream.NewSink(a.redis, topic, a.cfg.RedisStreamMaxLen, a.log), nil
+	default:
+		return nil, fmt.Errorf("unknown transport: %s", a.cfg.Transport)
+	}
+}
+
+// newSource creates the FN source of the configured transport. Kafka
+// transactions, when enabled, are closed with the returned func.
+func (a *app) newSource() (transport.Source, func(), error) {
+	switch a.cfg.Transport {
+	case transport.KindKafka:
+		consumer := kafka.NewConsumer(a.cfg.Brokers, a.cfg.WorkersCount, a.cfg.WorkerQueueSize, a.cfg.ConsumerMaxLag, a.cfg.KafkaTopic, a.kafkaOpts, a.log, a.mService)
+		if !a.cfg.KafkaTransactional {
+			return consumer, func() {}, nil
+		}
+
+		txn := kafka.NewTransactor(a.cfg.Brokers, a.kafkaOpts, a.cfg.KafkaConsumerGroup, a.cfg.KafkaTransactionalID, a.log)
+		consumer.UseTransactions(txn)
+
+		return consumer, func() {
+			if err := txn.Close(); err != nil {
+				a.log.Warnf("closing transactor: %v", err)
+			}
+		}, nil
+	case transport.KindMemory:
+		return memory.NewSource(a.broker, a.cfg.KafkaTopic, a.log, a.mService), func() {}, nil
+	case transport.KindRedis:
+		name := a.cfg.RedisStreamConsumer
+		if name == "" {
+			name, _ = os.Hostname()
+		}
+
+		return redisstream.NewSource(a.redis, a.cfg.KafkaTopic, a.cfg.RedisStreamGroup, name, a.log, a.mService), func() {}, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown transport: %s", a.cfg.Transport)
+	}
+}
+
+func newCodec(cfg *config.Config, log *logrus.Logger) (*codec.Codec, error) {
+	registry := schemaregistry.NewClient(log, cfg.SchemaRegistryURL, cfg.SchemaRegistryUser, cfg.SchemaRegistryPassword)
+
+	return codec.NewCodec(log, cfg.MessageFormat, registry, cfg.KafkaTopicWrongFN+"-value", cfg.SchemaRegistryAutoRegister)
+}
+
+func storageOptions(cfg *config.Config) psql.Options {
+	return psql.Options{
+		ReplicaDSN:           cfg.PgReplicaDSN,
+		MaxOpenConns:         cfg.PgMaxOpenConns,
+		MaxIdleConns:         cfg.PgMaxIdleConns,
+		ConnMaxLifetime:      cfg.PgConnMaxLifetime,
+		ConnMaxIdleTime:      cfg.PgConnMaxIdleTime,
+		StatementTimeout:     cfg.PgStatementTimeout,
+		ConnectRetries:       cfg.PgConnectRetries,
+		ConnectRetryInterval: cfg.PgConnectRetryInterval,
+	}
+}
+
+func retentionPolicy(cfg *config.Config) (models.RetentionPolicy, error) {
+	target, err := models.ParseArchiveTarget(cfg.RetentionArchiveTarget)
+	if err != nil {
+		return models.RetentionPolicy{}, err
+	}
+
+	return models.RetentionPolicy{
+		PurgeDeletedAfter: cfg.RetentionPurgeDeleted,
+		ArchiveAfter:      cfg.RetentionArchiveAfter,
+		ArchiveTarget:     target,
+		ArchiveDir:        cfg.RetentionArchiveDir,
+	}, nil
+}
+
+func kafkaOptions(cfg *config.Config) kafka.Options {
+	return kafka.Options{
+		ClientID:      cfg.KafkaClientID,
+		Version:       cfg.KafkaVersion,
+		TLSEnabled:    cfg.KafkaTLSEnabled,
+		TLSCAFile:     cfg.KafkaTLSCAFile,
+		TLSCertFile:   cfg.KafkaTLSCertFile,
+		TLSKeyFile:    cfg.KafkaTLSKeyFile,
+		TLSSkipVerify: cfg.KafkaTLSSkipVerify,
+		SASLMechanism: cfg.KafkaSASLMechanism,
+		SASLUser:      cfg.KafkaSASLUser,
+		SASLPassword:  cfg.KafkaSASLPassword,
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// runConsumer controls a running consumer through the admin API:
+//
+//	main consumer status
+//	main consumer pause -partitions 0,1
+//	main consumer rate 50
+//	main consumer workers 8
+func runConsumer(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: consumer <status|pause|resume|rate N|workers N> [flags]")
+	}
+
+	command, args := args[0], args[1:]
+
+	var value string
+	if command == "rate" || command == "workers" {
+		if len(args) == 0 {
+			return fmt.Errorf("consumer %s: value is required", command)
+		}
+
+		value, args = args[0], args[1:]
+	}
+
+	fs := flag.NewFlagSet("consumer", flag.ExitOnError)
+	addr := fs.String("addr", "http://localhost:5005", "service address")
+	token := fs.String("token", os.Getenv("ADMIN_TOKEN"), "admin API token, ADMIN_TOKEN by default")
+	partitions := fs.String("partitions", "", "comma separated partitions for pause and resume, all when empty")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var (
+		method = http.MethodGet
+		path   string
+		body   interface{}
+	)
+
+	switch command {
+	case "status":
+		path = "/admin/consumer/status"
+	case "pause", "resume":
+		parts, err := parsePartitions(*partitions)
+		if err != nil {
+			return err
+		}
+
+		method, path, body = http.MethodPost, "/admin/consumer/"+command, models.ConsumerPartitions{Partitions: parts}
+	case "rate":
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid rate %q: %w", value, err)
+		}
+
+		method, path, body = http.MethodPut, "/admin/consumer/rate", models.ConsumerRate{Rate: rate}
+	case "workers":
+		workers, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid workers %q: %w", value, err)
+		}
+
+		method, path, body = http.MethodPut, "/admin/consumer/workers", models.ConsumerWorkers{Workers: workers}
+	default:
+		return fmt.Errorf("unknown consumer command: %s", command)
+	}
+
+	out, err := callAdmin(*addr, *token, method, path, body)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(out))
+
+	return nil
+}
+
+func callAdmin(addr, token, method, path string, body interface{}) ([]byte, error) {
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+
+		reqBody = bytes.NewReader(data)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, addr+path, reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	out, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, bytes.TrimSpace(out))
+	}
+
+	return out, nil
+}
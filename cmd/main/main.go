@@ -3,17 +3,11 @@ package main
 import (
 	"context"
 	_ "github.com/jackc/pgx/v4/stdlib"
-	"github.com/redis/go-redis/v9"
 	"github.com/zuzi90/tz-enricher/internal/config"
 	"github.com/zuzi90/tz-enricher/internal/logger"
-	"github.com/zuzi90/tz-enricher/internal/providers/cache"
-	"github.com/zuzi90/tz-enricher/internal/providers/kafka"
-	"github.com/zuzi90/tz-enricher/internal/providers/storage/psql"
 	"github.com/zuzi90/tz-enricher/internal/rest"
-	message_service "github.com/zuzi90/tz-enricher/internal/services/message-service"
-	"github.com/zuzi90/tz-enricher/internal/services/resolvers"
-	"github.com/zuzi90/tz-enricher/internal/services/userservice"
 	"golang.org/x/sync/errgroup"
+	"os"
 	"os/signal"
 	"syscall"
 )
@@ -26,7 +20,22 @@ import (
 // @BasePath /
 
 func main() {
-	if err := run(); err != nil {
+	var err error
+
+	switch {
+	case len(os.Args) > 1 && os.Args[1] == "replay":
+		err = runReplay(os.Args[2:])
+	case len(os.Args) > 1 && os.Args[1] == "consumer":
+		err = runConsumer(os.Args[2:])
+	case len(os.Args) > 1 && os.Args[1] == "migrate":
+		err = runMigrate(os.Args[2:])
+	case len(os.Args) > 1 && os.Args[1] == "retention":
+		err = runRetention(os.Args[2:])
+	default:
+		err = run()
+	}
+
+	if err != nil {
 		panic(err)
 	}
 }
@@ -42,68 +51,47 @@ func run() error {
 		return err
 	}
 
-	ctx := context.Background()
-
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGHUP, syscall.SIGTERM)
 	defer cancel()
 
-	clientRedis := redis.NewClient(&redis.Options{
-		Addr:     cfg.RedisDSN,
-		Password: cfg.RedisPassword,
-		DB:       cfg.RedisDB,
-	})
-
-	status := clientRedis.Ping(ctx)
-
-	if status.Err() != nil {
-		log.Warnf("redis ping: %v", err)
-		return status.Err()
-	}
-
-	log.Info("Redis up")
-	rCache := cache.NewRedis(clientRedis, log)
-
-	db, err := psql.NewStorage(log, cfg.PgDSN)
+	a, err := newApp(ctx, cfg, log)
 	if err != nil {
 		return err
 	}
 
-	defer func() {
-		if err = db.CloseDB(); err != nil {
-			log.Warnf("closing db: %v", err)
-		}
-	}()
-
-	if err = db.UpdateSchema(); err != nil {
-		log.Warnf("update schema: %v", err)
-	}
+	defer a.close()
 
-	producer, err := kafka.NewProducer(cfg.Brokers, cfg.KafkaTopicWrongFN, log)
+	source, closeSource, err := a.newSource()
 	if err != nil {
 		return err
 	}
 
-	ageResolver := resolvers.NewAgeResolver(log, cfg.AgeURL)
-	genderResolver := resolvers.NewGenderResolver(log, cfg.GenderURL)
-	countryResolver := resolvers.NewCountryResolver(log, cfg.NationalityURL)
-
-	mService := message_service.NewMessageService(rCache, ageResolver, genderResolver, countryResolver, producer, db)
-	uService := userservice.NewUserService(db, log, rCache)
-
-	consumer := kafka.NewConsumer(cfg.Brokers, cfg.WorkersCount, cfg.KafkaTopic, log, mService)
+	defer closeSource()
 
-	server := rest.NewServer(cfg.ServerPORT, log, mService, uService)
+	server := rest.NewServer(cfg.ServerPORT, cfg.AdminToken, log, a.mService, a.uService, source)
 
 	eg, ctx := errgroup.WithContext(ctx)
 
 	eg.Go(func() error {
-		return consumer.Run(ctx)
+		return source.Run(ctx)
 	})
 
 	eg.Go(func() error {
 		return server.Run(ctx)
 	})
 
+	if cfg.DuplicatesInterval > 0 {
+		eg.Go(func() error {
+			return a.uService.RunDuplicateDetection(ctx, cfg.DuplicatesInterval, cfg.DuplicatesThreshold)
+		})
+	}
+
+	if a.retention != nil && cfg.RetentionInterval > 0 {
+		eg.Go(func() error {
+			return a.retention.Run(ctx, cfg.RetentionInterval, cfg.RetentionDryRun)
+		})
+	}
+
 	if err = eg.Wait(); err != nil {
 		return err
 	}
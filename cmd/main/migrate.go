@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/zuzi90/tz-enricher/internal/config"
+	"github.com/zuzi90/tz-enricher/internal/logger"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"github.com/zuzi90/tz-enricher/internal/providers/storage"
+	"github.com/zuzi90/tz-enricher/internal/providers/storage/psql"
+	"os/signal"
+	"strconv"
+	"syscall"
+)
+
+// runMigrate manages the database schema:
+//
+//	main migrate status
+//	main migrate up
+//	main migrate down
+//	main migrate to 5
+//	main migrate redo
+func runMigrate(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate <status|up|down|to VERSION|redo>")
+	}
+
+	command := args[0]
+
+	var version int64
+	if command == "to" {
+		if len(args) < 2 {
+			return fmt.Errorf("migrate to: version is required")
+		}
+
+		var err error
+		if version, err = strconv.ParseInt(args[1], 10, 64); err != nil || version < 0 {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+	}
+
+	cfg, err := config.NewConfig()
+	if err != nil {
+		return err
+	}
+
+	if cfg.Storage != storage.KindPostgres {
+		return fmt.Errorf("migrate: %s storage has no migrations", cfg.Storage)
+	}
+
+	log, err := logger.NewLogger(cfg.LogLvl)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	db, err := psql.NewStorage(ctx, log, cfg.PgDSN, storageOptions(cfg))
+	if err != nil {
+		return err
+	}
+
+	defer db.CloseDB()
+
+	var migrations []models.Migration
+
+	switch command {
+	case "status":
+		migrations, err = db.MigrationStatus(ctx)
+	case "up":
+		migrations, err = db.MigrateUp(ctx)
+	case "down":
+		migrations, err = db.MigrateDown(ctx)
+	case "to":
+		migrations, err = db.MigrateTo(ctx, version)
+	case "redo":
+		migrations, err = db.MigrateRedo(ctx)
+	default:
+		return fmt.Errorf("unknown migrate command: %s", command)
+	}
+
+	if err == nil || len(migrations) > 0 {
+		json := jsoniter.ConfigCompatibleWithStandardLibrary
+		out, _ := json.MarshalIndent(migrations, "", "  ")
+		fmt.Println(string(out))
+	}
+
+	return err
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/IBM/sarama"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/zuzi90/tz-enricher/internal/config"
+	"github.com/zuzi90/tz-enricher/internal/logger"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"github.com/zuzi90/tz-enricher/internal/providers/kafka"
+	"github.com/zuzi90/tz-enricher/internal/providers/transport"
+	message_service "github.com/zuzi90/tz-enricher/internal/services/message-service"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// runReplay reprocesses a range of FN or WRONG_FN messages through the
+// normal pipeline, or only decodes and validates them with -dry-run:
+//
+//	main replay -topic FN -from-time 2024-05-19T00:00:00Z -to-time 2024-05-20T00:00:00Z -rate 50
+func runReplay(args []string) error {
+	cfg, err := config.NewConfig()
+	if err != nil {
+		return err
+	}
+
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	topic := fs.String("topic", cfg.KafkaTopic, "topic to replay, FN or WRONG_FN")
+	partitions := fs.String("partitions", "", "comma separated partitions, all when empty")
+	fromOffset := fs.String("from-offset", "oldest", "start offset, a number or \"oldest\"")
+	fromTime := fs.String("from-time", "", "start from the first message produced at or after this RFC3339 time")
+	toTime := fs.String("to-time", "", "stop at the first message produced after this RFC3339 time")
+	rate := fs.Float64("rate", 0, "max messages per second, 0 for unlimited")
+	dryRun := fs.Bool("dry-run", false, "only decode and validate messages")
+	progress := fs.Duration("progress", 10*time.Second, "progress report interval")
+	idle := fs.Duration("idle", kafka.DefaultReplayIdleTimeout, "stop a partition that delivers nothing for this long before its end")
+
+	if err = fs.Parse(args); err != nil {
+		return err
+	}
+
+	ro := kafka.ReplayOptions{
+		Topic:            *topic,
+		Rate:             *rate,
+		ProgressInterval: *progress,
+		IdleTimeout:      *idle,
+	}
+
+	if ro.Partitions, err = parsePartitions(*partitions); err != nil {
+		return err
+	}
+
+	if ro.FromOffset, err = parseOffset(*fromOffset); err != nil {
+		return err
+	}
+
+	if ro.FromTime, err = parseTime(*fromTime); err != nil {
+		return err
+	}
+
+	if ro.ToTime, err = parseTime(*toTime); err != nil {
+		return err
+	}
+
+	log, err := logger.NewLogger(cfg.LogLvl)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	var handler transport.Handler
+
+	if *dryRun {
+		mCodec, err := newCodec(cfg, log)
+		if err != nil {
+			return err
+		}
+
+		mService := message_service.NewMessageService(log, nil, nil, nil, nil, mCodec, nil, nil)
+		handler = transport.HandlerFunc(mService.DryRun)
+	} else {
+		a, err := newApp(ctx, cfg, log)
+		if err != nil {
+			return err
+		}
+
+		defer a.close()
+
+		handler = replayHandler{a.mService}
+	}
+
+	replayer := kafka.NewReplayer(cfg.Brokers, kafkaOptions(cfg), log, handler)
+
+	report, err := replayer.Run(ctx, ro)
+	if report != nil {
+		json := jsoniter.ConfigCompatibleWithStandardLibrary
+		out, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(out))
+	}
+
+	return err
+}
+
+func parsePartitions(val string) ([]int32, error) {
+	if val == "" {
+		return nil, nil
+	}
+
+	var partitions []int32
+
+	for _, p := range strings.Split(val, ",") {
+		partition, err := strconv.ParseInt(strings.TrimSpace(p), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid partition %q: %w", p, err)
+		}
+
+		partitions = append(partitions, int32(partition))
+	}
+
+	return partitions, nil
+}
+
+func parseOffset(val string) (int64, error) {
+	if val == "" || val == "oldest" {
+		return sarama.OffsetOldest, nil
+	}
+
+	offset, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf("invalid offset %q", val)
+	}
+
+	return offset, nil
+}
+
+func parseTime(val string) (time.Time, error) {
+	if val == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, val)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: %w", val, err)
+	}
+
+	return t, nil
+}
+
+// replayHandler marks the changes made by a replay in the user history.
+type replayHandler struct {
+	mService *message_service.MessageService
+}
+
+func (h replayHandler) Handle(ctx context.Context, msg models.Message) error {
+	return h.mService.Handle(models.WithChangeSource(ctx, models.ChangeReplay), msg)
+}
+
+// HandleAsync lets the replay write users in batches.
+func (h replayHandler) HandleAsync(ctx context.Context, msg models.Message, done func(err error)) {
+	h.mService.HandleAsync(models.WithChangeSource(ctx, models.ChangeReplay), msg, done)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/redis/go-redis/v9"
+	"github.com/zuzi90/tz-enricher/internal/config"
+	"github.com/zuzi90/tz-enricher/internal/logger"
+	"github.com/zuzi90/tz-enricher/internal/providers/cache"
+	"github.com/zuzi90/tz-enricher/internal/providers/storage"
+	"github.com/zuzi90/tz-enricher/internal/providers/storage/psql"
+	"github.com/zuzi90/tz-enricher/internal/services/retention"
+	"os/signal"
+	"syscall"
+)
+
+// runRetention applies the retention policy once and prints the report,
+// with -dry-run only counts the users it would erase and archive:
+//
+//	main retention -dry-run
+func runRetention(args []string) error {
+	cfg, err := config.NewConfig()
+	if err != nil {
+		return err
+	}
+
+	fs := flag.NewFlagSet("retention", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "only report what would be erased and archived")
+
+	if err = fs.Parse(args); err != nil {
+		return err
+	}
+
+	if cfg.Storage != storage.KindPostgres {
+		return fmt.Errorf("retention: %s storage has no retention", cfg.Storage)
+	}
+
+	policy, err := retentionPolicy(cfg)
+	if err != nil {
+		return err
+	}
+
+	if !policy.Enabled() {
+		return fmt.Errorf("retention: no rules, set RETENTION_PURGE_DELETED or RETENTION_ARCHIVE_AFTER")
+	}
+
+	log, err := logger.NewLogger(cfg.LogLvl)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	db, err := psql.NewStorage(ctx, log, cfg.PgDSN, storageOptions(cfg))
+	if err != nil {
+		return err
+	}
+
+	defer db.CloseDB()
+
+	if err = db.CheckSchema(ctx); err != nil {
+		return err
+	}
+
+	// erased users are evicted from the cache the server reads
+	clientRedis := redis.NewClient(&redis.Options{
+		Addr:     cfg.RedisDSN,
+		Password: cfg.RedisPassword,
+		DB:       cfg.RedisDB,
+	})
+
+	defer clientRedis.Close()
+
+	if err = clientRedis.Ping(ctx).Err(); err != nil {
+		return err
+	}
+
+	report, err := retention.NewService(db, log, cache.NewRedis(clientRedis, log), policy).Apply(ctx, *dryRun)
+
+	if report != nil {
+		json := jsoniter.ConfigCompatibleWithStandardLibrary
+		out, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(out))
+	}
+
+	return err
+}
@@ -15,9 +15,944 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/api/v1/users": {
+        "/admin/consumer/pause": {
             "post": {
-                "description": "create user",
+                "description": "pause all partitions or the given ones",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Приостановить чтение партиций",
+                "parameters": [
+                    {
+                        "description": "partitions",
+                        "name": "input",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/models.ConsumerPartitions"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.ConsumerStatus"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/consumer/rate": {
+            "put": {
+                "description": "set max messages per second, 0 removes the limit",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Ограничить скорость обработки",
+                "parameters": [
+                    {
+                        "description": "rate",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.ConsumerRate"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.ConsumerStatus"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/consumer/resume": {
+            "post": {
+                "description": "resume all partitions or the given ones",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Возобновить чтение партиций",
+                "parameters": [
+                    {
+                        "description": "partitions",
+                        "name": "input",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/models.ConsumerPartitions"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.ConsumerStatus"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/consumer/status": {
+            "get": {
+                "description": "consumer status",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Состояние consumer",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.ConsumerStatus"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/consumer/workers": {
+            "put": {
+                "description": "resize the worker pool",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Изменить число воркеров",
+                "parameters": [
+                    {
+                        "description": "workers",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.ConsumerWorkers"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.ConsumerStatus"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/duplicates": {
+            "get": {
+                "description": "get candidate duplicate pairs found by the detection job, most similar first",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "duplicates"
+                ],
+                "summary": "Получить возможные дубликаты",
+                "parameters": [
+                    {
+                        "type": "number",
+                        "description": "minimum full name similarity, 0 to 1",
+                        "name": "minSimilarity",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "limit, 100 by default",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "offset",
+                        "name": "offset",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.DuplicateCandidate"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/duplicates/dismiss": {
+            "post": {
+                "description": "mark a candidate pair as different people, it is not listed again",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "duplicates"
+                ],
+                "summary": "Отклонить пару дубликатов",
+                "parameters": [
+                    {
+                        "description": "pair",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.DuplicatePair"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/stats/{dimension}": {
+            "get": {
+                "description": "count users grouped by nationality, gender or age, with the filters of the user list",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "stats"
+                ],
+                "summary": "Статистика пользователей",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "nationality, gender or age",
+                        "name": "dimension",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "comma separated ascending lower bounds of the age groups, 18,30,45,60 by default",
+                        "name": "buckets",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "text",
+                        "name": "text",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "match mode: exact, prefix or fuzzy (default)",
+                        "name": "match",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "minimum age",
+                        "name": "minAge",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "maximum age",
+                        "name": "maxAge",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "gender",
+                        "name": "gender",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "csv",
+                        "description": "nationalities, repeated or comma separated",
+                        "name": "nationality",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "created at or after, RFC3339 time or date",
+                        "name": "createdFrom",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "created at or before, RFC3339 time or date",
+                        "name": "createdTo",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "updated at or after, RFC3339 time or date",
+                        "name": "updatedFrom",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "updated at or before, RFC3339 time or date",
+                        "name": "updatedTo",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "patronymic presence",
+                        "name": "hasPatronymic",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "description": "minimum gender and nationality probability, 0 to 1",
+                        "name": "minConfidence",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "count soft-deleted users too",
+                        "name": "include_deleted",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "count soft-deleted users only",
+                        "name": "only_deleted",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.Stats"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/users": {
+            "post": {
+                "description": "create user",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Создать пользователя",
+                "parameters": [
+                    {
+                        "description": "account info",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.UserCreate"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/models.User"
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "version of the user"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/users/": {
+            "get": {
+                "description": "get users",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Получить список пользователей",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "text",
+                        "name": "text",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "match mode: exact, prefix or fuzzy (default)",
+                        "name": "match",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "cursor from the previous page, replaces offset",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "total count: exact (default), estimate or none",
+                        "name": "total",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "limit",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "offset",
+                        "name": "offset",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "comma separated fields, - for descending: -age,surname. Fields: id, name, surname, patronymic, age, gender, nationality, createdAt, updatedAt",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "deprecated, same as sort",
+                        "name": "sorting",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "reverse the order",
+                        "name": "descending",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "minimum age",
+                        "name": "minAge",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "maximum age",
+                        "name": "maxAge",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "gender",
+                        "name": "gender",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "csv",
+                        "description": "nationalities, repeated or comma separated",
+                        "name": "nationality",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "created at or after, RFC3339 time or date",
+                        "name": "createdFrom",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "created at or before, RFC3339 time or date",
+                        "name": "createdTo",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "updated at or after, RFC3339 time or date",
+                        "name": "updatedFrom",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "updated at or before, RFC3339 time or date",
+                        "name": "updatedTo",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "patronymic presence",
+                        "name": "hasPatronymic",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "description": "minimum gender and nationality probability, 0 to 1",
+                        "name": "minConfidence",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "list soft-deleted users too",
+                        "name": "include_deleted",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "list soft-deleted users only",
+                        "name": "only_deleted",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.UsersPage"
+                        },
+                        "headers": {
+                            "Link": {
+                                "type": "string",
+                                "description": "next and first page links"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/users/deleted": {
+            "delete": {
+                "description": "permanently erase users soft-deleted more than olderThan ago",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Безвозвратно удалить давно удалённых пользователей",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "time since the deletion, e.g. 720h; 0 erases all deleted users",
+                        "name": "olderThan",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.PurgeResult"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/users/export": {
+            "get": {
+                "description": "stream users in id order as CSV or NDJSON, with the filters of the user list; gzip with Accept-Encoding: gzip or gzip=true",
+                "produces": [
+                    "text/csv",
+                    "application/x-ndjson"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Выгрузить пользователей",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "csv or ndjson (default)",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "compress the response",
+                        "name": "gzip",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "text",
+                        "name": "text",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "match mode: exact, prefix or fuzzy (default)",
+                        "name": "match",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "minimum age",
+                        "name": "minAge",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "maximum age",
+                        "name": "maxAge",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "gender",
+                        "name": "gender",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "csv",
+                        "description": "nationalities, repeated or comma separated",
+                        "name": "nationality",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "created at or after, RFC3339 time or date",
+                        "name": "createdFrom",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "created at or before, RFC3339 time or date",
+                        "name": "createdTo",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "updated at or after, RFC3339 time or date",
+                        "name": "updatedFrom",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "updated at or before, RFC3339 time or date",
+                        "name": "updatedTo",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "patronymic presence",
+                        "name": "hasPatronymic",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "description": "minimum gender and nationality probability, 0 to 1",
+                        "name": "minConfidence",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "export soft-deleted users too",
+                        "name": "include_deleted",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "export soft-deleted users only",
+                        "name": "only_deleted",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/users/{id}": {
+            "get": {
+                "description": "get user",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Получить пользователя по id",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339 time to read the state of the user at, deleted users included",
+                        "name": "as_of",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of a cached copy",
+                        "name": "If-None-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.User"
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "version of the user"
+                            }
+                        }
+                    },
+                    "304": {
+                        "description": "not modified",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "delete user",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Удалить пользователя",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "reason of the deletion, up to 255 characters",
+                        "name": "reason",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "description": "update user",
                 "consumes": [
                     "application/json"
                 ],
@@ -27,23 +962,42 @@ const docTemplate = `{
                 "tags": [
                     "user"
                 ],
-                "summary": "Создать пользователя",
+                "summary": "Обновить пользователя",
                 "parameters": [
+                    {
+                        "type": "string",
+                        "description": "id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
                     {
                         "description": "account info",
                         "name": "input",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/models.UserCreate"
+                            "$ref": "#/definitions/models.UserUpdate"
                         }
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of the version being updated",
+                        "name": "If-Match",
+                        "in": "header"
                     }
                 ],
                 "responses": {
-                    "201": {
-                        "description": "Created",
+                    "200": {
+                        "description": "OK",
                         "schema": {
                             "$ref": "#/definitions/models.User"
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "version of the user"
+                            }
                         }
                     },
                     "400": {
@@ -52,6 +1006,18 @@ const docTemplate = `{
                             "type": "string"
                         }
                     },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "412": {
+                        "description": "Precondition Failed",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -61,46 +1027,23 @@ const docTemplate = `{
                 }
             }
         },
-        "/api/v1/users/": {
+        "/api/v1/users/{id}/history": {
             "get": {
-                "description": "get users",
+                "description": "get the versions of a user, oldest first, deleted users included",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "user"
                 ],
-                "summary": "Получить список пользователей",
+                "summary": "Получить историю изменений пользователя",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "text",
-                        "name": "text",
-                        "in": "query"
-                    },
-                    {
-                        "type": "string",
-                        "description": "limit",
-                        "name": "limit",
-                        "in": "query"
-                    },
-                    {
-                        "type": "string",
-                        "description": "offset",
-                        "name": "offset",
-                        "in": "query"
-                    },
-                    {
-                        "type": "string",
-                        "description": "sorting",
-                        "name": "sorting",
-                        "in": "query"
-                    },
-                    {
-                        "type": "string",
-                        "description": "descending",
-                        "name": "descending",
-                        "in": "query"
+                        "description": "id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
                     }
                 ],
                 "responses": {
@@ -109,7 +1052,7 @@ const docTemplate = `{
                         "schema": {
                             "type": "array",
                             "items": {
-                                "$ref": "#/definitions/models.User"
+                                "$ref": "#/definitions/models.UserVersion"
                             }
                         }
                     },
@@ -119,6 +1062,12 @@ const docTemplate = `{
                             "type": "string"
                         }
                     },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -128,23 +1077,35 @@ const docTemplate = `{
                 }
             }
         },
-        "/api/v1/users/{id}": {
-            "get": {
-                "description": "get user",
+        "/api/v1/users/{id}/merge": {
+            "post": {
+                "description": "merge a duplicate into the user: blank fields are filled from the duplicate, which is soft-deleted with mergedInto set",
+                "consumes": [
+                    "application/json"
+                ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "user"
+                    "duplicates"
                 ],
-                "summary": "Получить пользователя по id",
+                "summary": "Объединить дубликат с пользователем",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "id",
+                        "description": "id of the surviving user",
                         "name": "id",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "description": "duplicate",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.MergeRequest"
+                        }
                     }
                 ],
                 "responses": {
@@ -152,6 +1113,12 @@ const docTemplate = `{
                         "description": "OK",
                         "schema": {
                             "$ref": "#/definitions/models.User"
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "version of the user"
+                            }
                         }
                     },
                     "400": {
@@ -173,16 +1140,18 @@ const docTemplate = `{
                         }
                     }
                 }
-            },
+            }
+        },
+        "/api/v1/users/{id}/purge": {
             "delete": {
-                "description": "delete user",
+                "description": "permanently erase a user, deleted or not, from the database and the cache",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "user"
                 ],
-                "summary": "Удалить пользователя",
+                "summary": "Безвозвратно удалить пользователя",
                 "parameters": [
                     {
                         "type": "string",
@@ -218,19 +1187,18 @@ const docTemplate = `{
                         }
                     }
                 }
-            },
-            "patch": {
-                "description": "update user",
-                "consumes": [
-                    "application/json"
-                ],
+            }
+        },
+        "/api/v1/users/{id}/restore": {
+            "post": {
+                "description": "restore a soft-deleted user",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "user"
                 ],
-                "summary": "Обновить пользователя",
+                "summary": "Восстановить удалённого пользователя",
                 "parameters": [
                     {
                         "type": "string",
@@ -238,15 +1206,6 @@ const docTemplate = `{
                         "name": "id",
                         "in": "path",
                         "required": true
-                    },
-                    {
-                        "description": "account info",
-                        "name": "input",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/models.UserUpdate"
-                        }
                     }
                 ],
                 "responses": {
@@ -279,38 +1238,263 @@ const docTemplate = `{
         }
     },
     "definitions": {
+        "models.ChangeSource": {
+            "type": "string",
+            "enum": [
+                "kafka",
+                "rest",
+                "replay"
+            ],
+            "x-enum-varnames": [
+                "ChangeKafka",
+                "ChangeREST",
+                "ChangeReplay"
+            ]
+        },
+        "models.ConsumerPartitions": {
+            "type": "object",
+            "properties": {
+                "partitions": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                }
+            }
+        },
+        "models.ConsumerRate": {
+            "type": "object",
+            "properties": {
+                "rate": {
+                    "type": "number"
+                }
+            }
+        },
+        "models.ConsumerStatus": {
+            "type": "object",
+            "properties": {
+                "partitions": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.PartitionStatus"
+                    }
+                },
+                "rate": {
+                    "type": "number"
+                },
+                "ready": {
+                    "type": "boolean"
+                },
+                "running": {
+                    "type": "boolean"
+                },
+                "topic": {
+                    "type": "string"
+                },
+                "workers": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.ConsumerWorkers": {
+            "type": "object",
+            "properties": {
+                "workers": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.DuplicateCandidate": {
+            "type": "object",
+            "properties": {
+                "detectedAt": {
+                    "type": "string"
+                },
+                "duplicate": {
+                    "$ref": "#/definitions/models.User"
+                },
+                "duplicateId": {
+                    "type": "integer"
+                },
+                "similarity": {
+                    "type": "number"
+                },
+                "user": {
+                    "$ref": "#/definitions/models.User"
+                },
+                "userId": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.DuplicatePair": {
+            "type": "object",
+            "properties": {
+                "duplicateId": {
+                    "type": "integer"
+                },
+                "userId": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.MergeRequest": {
+            "type": "object",
+            "properties": {
+                "duplicateId": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.Operation": {
+            "type": "string",
+            "enum": [
+                "create",
+                "update",
+                "delete",
+                "restore",
+                "merge",
+                "snapshot"
+            ],
+            "x-enum-varnames": [
+                "OperationCreate",
+                "OperationUpdate",
+                "OperationDelete",
+                "OperationRestore",
+                "OperationMerge",
+                "OperationSnapshot"
+            ]
+        },
+        "models.PartitionStatus": {
+            "type": "object",
+            "properties": {
+                "highWaterMark": {
+                    "type": "integer"
+                },
+                "lag": {
+                    "type": "integer"
+                },
+                "partition": {
+                    "type": "integer"
+                },
+                "paused": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "models.PurgeResult": {
+            "type": "object",
+            "properties": {
+                "purged": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.Stats": {
+            "type": "object",
+            "properties": {
+                "dimension": {
+                    "$ref": "#/definitions/models.StatsDimension"
+                },
+                "groups": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.StatsGroup"
+                    }
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.StatsDimension": {
+            "type": "string",
+            "enum": [
+                "nationality",
+                "gender",
+                "age"
+            ],
+            "x-enum-varnames": [
+                "StatsNationality",
+                "StatsGender",
+                "StatsAge"
+            ]
+        },
+        "models.StatsGroup": {
+            "type": "object",
+            "properties": {
+                "count": {
+                    "type": "integer"
+                },
+                "key": {
+                    "type": "string"
+                }
+            }
+        },
         "models.User": {
             "type": "object",
             "properties": {
                 "age": {
                     "type": "integer"
                 },
+                "correlationId": {
+                    "type": "string"
+                },
                 "createdAt": {
                     "type": "string"
                 },
+                "deletedAt": {
+                    "type": "string"
+                },
+                "deletedReason": {
+                    "type": "string"
+                },
                 "gender": {
                     "type": "string"
                 },
+                "genderProbability": {
+                    "type": "number"
+                },
                 "id": {
                     "type": "integer"
                 },
                 "isDeleted": {
                     "type": "boolean"
                 },
+                "mergedInto": {
+                    "description": "MergedInto is the user this one was merged into as a duplicate.",
+                    "type": "integer"
+                },
+                "messageId": {
+                    "type": "string"
+                },
                 "name": {
                     "type": "string"
                 },
                 "nationality": {
                     "type": "string"
                 },
+                "nationalityProbability": {
+                    "type": "number"
+                },
                 "patronymic": {
                     "type": "string"
                 },
+                "producedAt": {
+                    "type": "string"
+                },
+                "source": {
+                    "type": "string"
+                },
                 "surname": {
                     "type": "string"
                 },
                 "updatedAt": {
                     "type": "string"
+                },
+                "version": {
+                    "description": "Version is incremented on every change, it is the ETag of the user.",
+                    "type": "integer"
                 }
             }
         },
@@ -359,6 +1543,55 @@ const docTemplate = `{
                     "type": "string"
                 }
             }
+        },
+        "models.UserVersion": {
+            "type": "object",
+            "properties": {
+                "changedAt": {
+                    "type": "string"
+                },
+                "changes": {
+                    "description": "Changes lists the fields that differ from the previous version.",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "operation": {
+                    "$ref": "#/definitions/models.Operation"
+                },
+                "source": {
+                    "$ref": "#/definitions/models.ChangeSource"
+                },
+                "user": {
+                    "$ref": "#/definitions/models.User"
+                }
+            }
+        },
+        "models.UsersPage": {
+            "type": "object",
+            "properties": {
+                "cursor": {
+                    "description": "Cursor points after the last item, empty on the last page.",
+                    "type": "string"
+                },
+                "items": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.User"
+                    }
+                },
+                "total": {
+                    "description": "Total is the number of users matching the filters, nil when not\nrequested.",
+                    "type": "integer"
+                },
+                "totalEstimated": {
+                    "type": "boolean"
+                }
+            }
         }
     }
 }`
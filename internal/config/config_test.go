@@ -0,0 +1,22 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func Test_NewConfig(t *testing.T) {
+	t.Run("success, defaults", func(t *testing.T) {
+		cfg, err := NewConfig()
+		require.NoError(t, err)
+		assert.Equal(t, 1, cfg.WorkersCount)
+	})
+
+	t.Run("failure, no workers", func(t *testing.T) {
+		t.Setenv("WORKERS_COUNT", "0")
+
+		_, err := NewConfig()
+		assert.ErrorContains(t, err, "WORKERS_COUNT")
+	})
+}
@@ -0,0 +1,31 @@
+package models
+
+type (
+	ConsumerStatus struct {
+		Topic      string            `json:"topic"`
+		Running    bool              `json:"running"`
+		Ready      bool              `json:"ready"`
+		Workers    int               `json:"workers"`
+		Rate       float64           `json:"rate"`
+		Partitions []PartitionStatus `json:"partitions"`
+	}
+
+	PartitionStatus struct {
+		Partition     int32 `json:"partition"`
+		Paused        bool  `json:"paused"`
+		HighWaterMark int64 `json:"highWaterMark"`
+		Lag           int64 `json:"lag"`
+	}
+
+	ConsumerPartitions struct {
+		Partitions []int32 `json:"partitions"`
+	}
+
+	ConsumerRate struct {
+		Rate float64 `json:"rate"`
+	}
+
+	ConsumerWorkers struct {
+		Workers int `json:"workers"`
+	}
+)
@@ -0,0 +1,73 @@
+package models
+
+import (
+	"fmt"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"time"
+)
+
+type (
+	// DuplicatePair identifies a candidate pair, UserID is the lower id.
+	DuplicatePair struct {
+		UserID      int `db:"user_id"      json:"userId"`
+		DuplicateID int `db:"duplicate_id" json:"duplicateId"`
+	}
+
+	// DuplicateCandidate is a pair of users whose full names are similar
+	// enough to be the same person.
+	DuplicateCandidate struct {
+		DuplicatePair
+		Similarity float64   `db:"similarity"  json:"similarity"`
+		DetectedAt time.Time `db:"detected_at" json:"detectedAt"`
+		User       *User     `db:"-"           json:"user"`
+		Duplicate  *User     `db:"-"           json:"duplicate"`
+	}
+
+	GetDuplicatesParams struct {
+		MinSimilarity float64 `json:"minSimilarity"`
+		Limit         int     `json:"limit"`
+		Offset        int     `json:"offset"`
+	}
+
+	// MergeRequest merges DuplicateID into the user of the request path.
+	MergeRequest struct {
+		DuplicateID int `json:"duplicateId"`
+	}
+)
+
+func NewPair(a, b int) DuplicatePair {
+	if a > b {
+		a, b = b, a
+	}
+
+	return DuplicatePair{UserID: a, DuplicateID: b}
+}
+
+func (p DuplicatePair) Validate() error {
+	err := validation.ValidateStruct(&p,
+		validation.Field(&p.UserID, validation.Required, validation.Min(1)),
+		validation.Field(&p.DuplicateID, validation.Required, validation.Min(1)),
+	)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidParam, err)
+	}
+
+	if p.UserID == p.DuplicateID {
+		return fmt.Errorf("%w: a user is not a duplicate of itself", ErrInvalidParam)
+	}
+
+	return nil
+}
+
+func (p *GetDuplicatesParams) Validate() error {
+	err := validation.ValidateStruct(p,
+		validation.Field(&p.MinSimilarity, validation.Min(0.0), validation.Max(1.0)),
+		validation.Field(&p.Limit, validation.Min(0)),
+		validation.Field(&p.Offset, validation.Min(0)),
+	)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidParam, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_DuplicatePair(t *testing.T) {
+	assert.Equal(t, DuplicatePair{UserID: 3, DuplicateID: 7}, NewPair(7, 3))
+
+	assert.NoError(t, NewPair(7, 3).Validate())
+	assert.ErrorIs(t, NewPair(3, 3).Validate(), ErrInvalidParam)
+	assert.ErrorIs(t, NewPair(0, 3).Validate(), ErrInvalidParam)
+}
+
+func Test_GetDuplicatesParams(t *testing.T) {
+	params := GetDuplicatesParams{MinSimilarity: 0.8, Limit: 10}
+	assert.NoError(t, params.Validate())
+
+	params.MinSimilarity = 1.2
+	assert.ErrorIs(t, params.Validate(), ErrInvalidParam)
+}
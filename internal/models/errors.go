@@ -4,3 +4,7 @@ import "errors"
 
 var ErrUserNotFound = errors.New("not found")
 var ErrNoRows = errors.New("err sql: no rows in result set")
+var ErrUnknownPartition = errors.New("unknown partition")
+var ErrInvalidParam = errors.New("invalid parameter")
+var ErrVersionMismatch = errors.New("version mismatch")
+var ErrSchemaOutdated = errors.New("schema is outdated")
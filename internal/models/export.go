@@ -0,0 +1,33 @@
+package models
+
+import "fmt"
+
+// ExportFormat is the encoding of a user export.
+type ExportFormat string
+
+const (
+	ExportCSV    ExportFormat = "csv"
+	ExportNDJSON ExportFormat = "ndjson"
+)
+
+// ParseExportFormat parses the format query parameter, empty means
+// ExportNDJSON.
+func ParseExportFormat(val string) (ExportFormat, error) {
+	switch format := ExportFormat(val); format {
+	case "":
+		return ExportNDJSON, nil
+	case ExportCSV, ExportNDJSON:
+		return format, nil
+	default:
+		return "", fmt.Errorf("%w: format must be csv or ndjson, got %q", ErrInvalidParam, val)
+	}
+}
+
+// ExportParams selects the exported users, with the filters of the list
+// endpoint. Users are exported in id order.
+type ExportParams struct {
+	Format ExportFormat `json:"format"`
+	Text   string       `json:"text,omitempty"`
+	Match  MatchMode    `json:"match,omitempty"`
+	UserFilter
+}
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_ParseExportFormat(t *testing.T) {
+	for val, want := range map[string]ExportFormat{"": ExportNDJSON, "csv": ExportCSV, "ndjson": ExportNDJSON} {
+		format, err := ParseExportFormat(val)
+		assert.NoError(t, err)
+		assert.Equal(t, want, format)
+	}
+
+	_, err := ParseExportFormat("xlsx")
+	assert.ErrorIs(t, err, ErrInvalidParam)
+}
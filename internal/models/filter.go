@@ -0,0 +1,199 @@
+package models
+
+import (
+	"fmt"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UserFilter narrows the user list. Nil and empty fields are not applied.
+type UserFilter struct {
+	MinAge        *int       `json:"minAge,omitempty"`
+	MaxAge        *int       `json:"maxAge,omitempty"`
+	Gender        string     `json:"gender,omitempty"`
+	Nationalities []string   `json:"nationality,omitempty"`
+	CreatedFrom   *time.Time `json:"createdFrom,omitempty"`
+	CreatedTo     *time.Time `json:"createdTo,omitempty"`
+	UpdatedFrom   *time.Time `json:"updatedFrom,omitempty"`
+	UpdatedTo     *time.Time `json:"updatedTo,omitempty"`
+	HasPatronymic *bool      `json:"hasPatronymic,omitempty"`
+	// MinConfidence keeps users whose gender and nationality were both
+	// resolved with at least this probability.
+	MinConfidence *float64 `json:"minConfidence,omitempty"`
+	// Deleted selects whether soft-deleted users are listed, by default
+	// they are not.
+	Deleted DeletedMode `json:"deleted,omitempty"`
+}
+
+// DeletedMode selects soft-deleted users in the list.
+type DeletedMode string
+
+const (
+	DeletedExclude DeletedMode = ""
+	DeletedInclude DeletedMode = "include"
+	DeletedOnly    DeletedMode = "only"
+)
+
+const dateLayout = "2006-01-02"
+
+// ParseUserFilter reads the filter from query parameters:
+//
+//	minAge, maxAge                   integers
+//	gender                           case-insensitive
+//	nationality                      repeated or comma separated, e.g. RU,UA
+//	createdFrom, createdTo,
+//	updatedFrom, updatedTo           RFC3339 time or a date, a "to" date includes the whole day
+//	hasPatronymic                    true or false
+//	minConfidence                    0 to 1
+//	include_deleted, only_deleted    true or false, only_deleted wins
+func ParseUserFilter(query url.Values) (UserFilter, error) {
+	f := UserFilter{}
+
+	var err error
+
+	if f.MinAge, err = parseInt(query, "minAge"); err != nil {
+		return f, err
+	}
+
+	if f.MaxAge, err = parseInt(query, "maxAge"); err != nil {
+		return f, err
+	}
+
+	f.Gender = strings.ToLower(query.Get("gender"))
+
+	for _, val := range query["nationality"] {
+		for _, nationality := range strings.Split(val, ",") {
+			if nationality = strings.TrimSpace(nationality); nationality != "" {
+				f.Nationalities = append(f.Nationalities, nationality)
+			}
+		}
+	}
+
+	if f.CreatedFrom, err = parseTime(query, "createdFrom", false); err != nil {
+		return f, err
+	}
+
+	if f.CreatedTo, err = parseTime(query, "createdTo", true); err != nil {
+		return f, err
+	}
+
+	if f.UpdatedFrom, err = parseTime(query, "updatedFrom", false); err != nil {
+		return f, err
+	}
+
+	if f.UpdatedTo, err = parseTime(query, "updatedTo", true); err != nil {
+		return f, err
+	}
+
+	if val := query.Get("hasPatronymic"); val != "" {
+		has, err := strconv.ParseBool(val)
+		if err != nil {
+			return f, fmt.Errorf("%w: hasPatronymic must be true or false, got %q", ErrInvalidParam, val)
+		}
+
+		f.HasPatronymic = &has
+	}
+
+	if val := query.Get("minConfidence"); val != "" {
+		confidence, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return f, fmt.Errorf("%w: minConfidence must be a number, got %q", ErrInvalidParam, val)
+		}
+
+		f.MinConfidence = &confidence
+	}
+
+	if f.Deleted, err = parseDeletedMode(query); err != nil {
+		return f, err
+	}
+
+	return f, f.Validate()
+}
+
+func (f UserFilter) Validate() error {
+	err := validation.ValidateStruct(&f,
+		validation.Field(&f.MinAge, validation.Min(0), validation.Max(150)),
+		validation.Field(&f.MaxAge, validation.Min(0), validation.Max(150)),
+		validation.Field(&f.Gender, validation.Length(2, 25)),
+		validation.Field(&f.Nationalities, validation.Each(validation.Length(2, 25))),
+		validation.Field(&f.MinConfidence, validation.Min(0.0), validation.Max(1.0)),
+	)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidParam, err)
+	}
+
+	switch {
+	case f.MinAge != nil && f.MaxAge != nil && *f.MinAge > *f.MaxAge:
+		return fmt.Errorf("%w: minAge is greater than maxAge", ErrInvalidParam)
+	case f.CreatedFrom != nil && f.CreatedTo != nil && f.CreatedFrom.After(*f.CreatedTo):
+		return fmt.Errorf("%w: createdFrom is after createdTo", ErrInvalidParam)
+	case f.UpdatedFrom != nil && f.UpdatedTo != nil && f.UpdatedFrom.After(*f.UpdatedTo):
+		return fmt.Errorf("%w: updatedFrom is after updatedTo", ErrInvalidParam)
+	}
+
+	return nil
+}
+
+func parseInt(query url.Values, key string) (*int, error) {
+	val := query.Get(key)
+	if val == "" {
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s must be an integer, got %q", ErrInvalidParam, key, val)
+	}
+
+	return &n, nil
+}
+
+func parseDeletedMode(query url.Values) (DeletedMode, error) {
+	for _, param := range []struct {
+		key  string
+		mode DeletedMode
+	}{{"only_deleted", DeletedOnly}, {"include_deleted", DeletedInclude}} {
+		val := query.Get(param.key)
+		if val == "" {
+			continue
+		}
+
+		set, err := strconv.ParseBool(val)
+		if err != nil {
+			return DeletedExclude, fmt.Errorf("%w: %s must be true or false, got %q", ErrInvalidParam, param.key, val)
+		}
+
+		if set {
+			return param.mode, nil
+		}
+	}
+
+	return DeletedExclude, nil
+}
+
+// parseTime accepts an RFC3339 time or a date. A date used as an upper bound
+// is moved to the last moment of that day.
+func parseTime(query url.Values, key string, upper bool) (*time.Time, error) {
+	val := query.Get(key)
+	if val == "" {
+		return nil, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, val); err == nil {
+		return &t, nil
+	}
+
+	t, err := time.Parse(dateLayout, val)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s must be an RFC3339 time or a date, got %q", ErrInvalidParam, key, val)
+	}
+
+	if upper {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+
+	return &t, nil
+}
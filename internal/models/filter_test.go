@@ -0,0 +1,77 @@
+package models
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func Test_ParseUserFilter(t *testing.T) {
+	t.Run("empty", func(t *testing.T) {
+		f, err := ParseUserFilter(url.Values{})
+		require.NoError(t, err)
+		assert.Equal(t, UserFilter{}, f)
+	})
+
+	t.Run("all fields", func(t *testing.T) {
+		query, err := url.ParseQuery("minAge=18&maxAge=40&gender=Male&nationality=RU,UA&nationality=KZ" +
+			"&createdFrom=2024-05-01&createdTo=2024-05-19&updatedFrom=2024-05-19T10:00:00Z" +
+			"&hasPatronymic=false&minConfidence=0.5")
+		require.NoError(t, err)
+
+		f, err := ParseUserFilter(query)
+		require.NoError(t, err)
+
+		assert.Equal(t, 18, *f.MinAge)
+		assert.Equal(t, 40, *f.MaxAge)
+		assert.Equal(t, "male", f.Gender)
+		assert.Equal(t, []string{"RU", "UA", "KZ"}, f.Nationalities)
+		assert.Equal(t, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), *f.CreatedFrom)
+		assert.Equal(t, time.Date(2024, 5, 19, 23, 59, 59, 999999999, time.UTC), *f.CreatedTo)
+		assert.Equal(t, time.Date(2024, 5, 19, 10, 0, 0, 0, time.UTC), *f.UpdatedFrom)
+		assert.Nil(t, f.UpdatedTo)
+		assert.False(t, *f.HasPatronymic)
+		assert.Equal(t, 0.5, *f.MinConfidence)
+	})
+
+	t.Run("deleted", func(t *testing.T) {
+		for query, mode := range map[string]DeletedMode{
+			"":                                       DeletedExclude,
+			"include_deleted=false":                  DeletedExclude,
+			"include_deleted=true":                   DeletedInclude,
+			"only_deleted=1":                         DeletedOnly,
+			"include_deleted=true&only_deleted=true": DeletedOnly,
+		} {
+			values, err := url.ParseQuery(query)
+			require.NoError(t, err)
+
+			f, err := ParseUserFilter(values)
+			require.NoError(t, err)
+			assert.Equal(t, mode, f.Deleted, query)
+		}
+	})
+
+	for name, query := range map[string]string{
+		"age is not a number":   "minAge=ten",
+		"negative age":          "maxAge=-1",
+		"age range reversed":    "minAge=40&maxAge=18",
+		"bad date":              "createdFrom=19.05.2024",
+		"date range reversed":   "updatedFrom=2024-05-19&updatedTo=2024-05-01",
+		"bad bool":              "hasPatronymic=maybe",
+		"confidence above one":  "minConfidence=1.5",
+		"short nationality":     "nationality=R",
+		"confidence not number": "minConfidence=high",
+		"bad include_deleted":   "include_deleted=all",
+	} {
+		query := query
+		t.Run(name, func(t *testing.T) {
+			values, err := url.ParseQuery(query)
+			require.NoError(t, err)
+
+			_, err = ParseUserFilter(values)
+			assert.ErrorIs(t, err, ErrInvalidParam)
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package models
+
+import (
+	"context"
+	"time"
+)
+
+// ChangeSource tells where a change of a user came from.
+type ChangeSource string
+
+const (
+	ChangeKafka ChangeSource = "kafka"
+	ChangeREST  ChangeSource = "rest"
+	// ChangeReplay is a re-enrichment of messages reprocessed by the replay
+	// command.
+	ChangeReplay ChangeSource = "replay"
+)
+
+type changeSourceKey struct{}
+
+// WithChangeSource attaches the source recorded in the history of users
+// changed with ctx.
+func WithChangeSource(ctx context.Context, source ChangeSource) context.Context {
+	return context.WithValue(ctx, changeSourceKey{}, source)
+}
+
+// ChangeSourceFrom returns the source attached by WithChangeSource, empty
+// when there is none.
+func ChangeSourceFrom(ctx context.Context) ChangeSource {
+	source, _ := ctx.Value(changeSourceKey{}).(ChangeSource)
+	return source
+}
+
+// Operation is the kind of change recorded in the user history.
+type Operation string
+
+const (
+	OperationCreate  Operation = "create"
+	OperationUpdate  Operation = "update"
+	OperationDelete  Operation = "delete"
+	OperationRestore Operation = "restore"
+	// OperationMerge is recorded for both users of a merge.
+	OperationMerge Operation = "merge"
+	// OperationSnapshot is the state of users that existed before the
+	// history was introduced.
+	OperationSnapshot Operation = "snapshot"
+)
+
+// UserVersion is the state of a user after a change.
+type UserVersion struct {
+	ID        int64        `db:"version_id"    json:"id"`
+	Operation Operation    `db:"operation"     json:"operation"`
+	Source    ChangeSource `db:"change_source" json:"source"`
+	ChangedAt time.Time    `db:"changed_at"    json:"changedAt"`
+	// Changes lists the fields that differ from the previous version.
+	Changes []string `db:"-" json:"changes,omitempty"`
+	User    `json:"user"`
+}
+
+// SetChanges fills Changes of each version from the one before it, versions
+// are expected oldest first.
+func SetChanges(versions []*UserVersion) {
+	for i := 1; i < len(versions); i++ {
+		versions[i].Changes = changedFields(&versions[i-1].User, &versions[i].User)
+	}
+}
+
+func changedFields(prev, next *User) []string {
+	var changes []string
+
+	add := func(changed bool, field string) {
+		if changed {
+			changes = append(changes, field)
+		}
+	}
+
+	add(prev.Name != next.Name, "name")
+	add(prev.Surname != next.Surname, "surname")
+	add(prev.Patronymic != next.Patronymic, "patronymic")
+	add(prev.Age != next.Age, "age")
+	add(prev.Gender != next.Gender, "gender")
+	add(prev.Nationality != next.Nationality, "nationality")
+	add(prev.IsDeleted != next.IsDeleted, "isDeleted")
+	add(prev.DeletedReason != next.DeletedReason, "deletedReason")
+
+	return changes
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_ChangeSource(t *testing.T) {
+	ctx := context.Background()
+	assert.Empty(t, ChangeSourceFrom(ctx))
+	assert.Equal(t, ChangeREST, ChangeSourceFrom(WithChangeSource(ctx, ChangeREST)))
+}
+
+func Test_SetChanges(t *testing.T) {
+	versions := []*UserVersion{
+		{Operation: OperationCreate, User: User{Name: "Frodo", Age: 50, Nationality: "NZ"}},
+		{Operation: OperationUpdate, User: User{Name: "Frodo", Age: 51, Nationality: "GB"}},
+		{Operation: OperationDelete, User: User{Name: "Frodo", Age: 51, Nationality: "GB", IsDeleted: true}},
+	}
+
+	SetChanges(versions)
+
+	assert.Nil(t, versions[0].Changes)
+	assert.Equal(t, []string{"age", "nationality"}, versions[1].Changes)
+	assert.Equal(t, []string{"isDeleted"}, versions[2].Changes)
+}
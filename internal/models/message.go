@@ -0,0 +1,175 @@
+package models
+
+import (
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
+	jsoniter "github.com/json-iterator/go"
+	"strings"
+	"time"
+)
+
+const (
+	HeaderMessageID     = "message-id"
+	HeaderSource        = "source"
+	HeaderCorrelationID = "correlation-id"
+	HeaderProducedAt    = "produced-at"
+	HeaderCountryHint   = "country-hint"
+)
+
+const (
+	FormatJSON     MessageFormat = "json"
+	FormatAvro     MessageFormat = "avro"
+	FormatProtobuf MessageFormat = "protobuf"
+)
+
+type MessageFormat string
+
+type (
+	Message struct {
+		Topic     string
+		Partition int32
+		Offset    int64
+		Key       []byte
+		Value     []byte
+		Headers   map[string]string
+		Timestamp time.Time
+	}
+
+	Provenance struct {
+		MessageID     string     `json:"messageId"     db:"message_id"`
+		Source        string     `json:"source"        db:"source"`
+		CorrelationID string     `json:"correlationId" db:"correlation_id"`
+		ProducedAt    *time.Time `json:"producedAt"    db:"produced_at"`
+	}
+
+	MessageMeta struct {
+		Provenance
+		CountryHint string `json:"countryHint,omitempty"`
+	}
+
+	FNEnvelope struct {
+		MessageMeta
+		Payload *UserFN `json:"payload"`
+	}
+
+	DecodedFN struct {
+		FN     UserFN
+		Meta   MessageMeta
+		Format MessageFormat
+	}
+)
+
+// ParseFN decodes both the envelope and the legacy bare FN format. Metadata
+// missing from the body is taken from the Kafka headers, key and timestamp.
+func ParseFN(msg Message) (UserFN, MessageMeta, error) {
+	env := FNEnvelope{}
+
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+	if err := json.Unmarshal(msg.Value, &env); err != nil {
+		return UserFN{}, MessageMeta{}, err
+	}
+
+	if env.Payload == nil {
+		fn := UserFN{}
+		if err := json.Unmarshal(msg.Value, &fn); err != nil {
+			return UserFN{}, MessageMeta{}, err
+		}
+
+		env = FNEnvelope{Payload: &fn}
+	}
+
+	fn, meta := env.Resolve(msg)
+
+	return fn, meta, nil
+}
+
+// Resolve returns the payload and the envelope metadata completed from msg.
+func (e FNEnvelope) Resolve(msg Message) (UserFN, MessageMeta) {
+	fn := UserFN{}
+	if e.Payload != nil {
+		fn = *e.Payload
+	}
+
+	meta := e.MessageMeta
+	meta.fillFromMessage(msg)
+
+	return fn, meta
+}
+
+func (m *MessageMeta) fillFromMessage(msg Message) {
+	if m.MessageID == "" {
+		m.MessageID = msg.Headers[HeaderMessageID]
+	}
+
+	if m.MessageID == "" {
+		m.MessageID = string(msg.Key)
+	}
+
+	if m.Source == "" {
+		m.Source = msg.Headers[HeaderSource]
+	}
+
+	if m.CorrelationID == "" {
+		m.CorrelationID = msg.Headers[HeaderCorrelationID]
+	}
+
+	if m.CountryHint == "" {
+		m.CountryHint = msg.Headers[HeaderCountryHint]
+	}
+
+	// only an ISO 3166-1 alpha-2 code is trusted as the nationality, other
+	// hints are dropped and the nationality is resolved by name
+	m.CountryHint = strings.ToUpper(strings.TrimSpace(m.CountryHint))
+	if validation.Validate(m.CountryHint, is.CountryCode2) != nil {
+		m.CountryHint = ""
+	}
+
+	if m.ProducedAt == nil {
+		if val, ok := msg.Headers[HeaderProducedAt]; ok {
+			if t, err := time.Parse(time.RFC3339Nano, val); err == nil {
+				m.ProducedAt = &t
+			}
+		}
+	}
+
+	if m.ProducedAt == nil && !msg.Timestamp.IsZero() {
+		t := msg.Timestamp
+		m.ProducedAt = &t
+	}
+}
+
+// Headers returns the metadata as Kafka headers, skipping empty values.
+func (m MessageMeta) Headers() map[string]string {
+	headers := make(map[string]string)
+
+	if m.MessageID != "" {
+		headers[HeaderMessageID] = m.MessageID
+	}
+
+	if m.Source != "" {
+		headers[HeaderSource] = m.Source
+	}
+
+	if m.CorrelationID != "" {
+		headers[HeaderCorrelationID] = m.CorrelationID
+	}
+
+	if m.ProducedAt != nil {
+		headers[HeaderProducedAt] = m.ProducedAt.Format(time.RFC3339Nano)
+	}
+
+	if m.CountryHint != "" {
+		headers[HeaderCountryHint] = m.CountryHint
+	}
+
+	return headers
+}
+
+// LogFields returns the metadata in a form suitable for logrus.WithFields.
+func (m MessageMeta) LogFields() map[string]interface{} {
+	return map[string]interface{}{
+		"message_id":     m.MessageID,
+		"source":         m.Source,
+		"correlation_id": m.CorrelationID,
+	}
+}
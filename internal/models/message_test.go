@@ -0,0 +1,75 @@
+package models
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_ParseFN(t *testing.T) {
+	t.Run("success, legacy format", func(t *testing.T) {
+		ts := time.Date(2024, 5, 19, 21, 6, 10, 0, time.UTC)
+		msg := Message{
+			Key:       []byte("key-1"),
+			Value:     []byte(`{"name":"Frodo","surname":"Baggins","patronymic":"Drogovich"}`),
+			Headers:   map[string]string{HeaderSource: "shire", HeaderCorrelationID: "corr-1"},
+			Timestamp: ts,
+		}
+
+		fn, meta, err := ParseFN(msg)
+		assert.NoError(t, err)
+		assert.Equal(t, UserFN{Name: "Frodo", Surname: "Baggins", Patronymic: "Drogovich"}, fn)
+		assert.Equal(t, "key-1", meta.MessageID)
+		assert.Equal(t, "shire", meta.Source)
+		assert.Equal(t, "corr-1", meta.CorrelationID)
+		assert.Equal(t, ts, *meta.ProducedAt)
+	})
+
+	t.Run("success, envelope format", func(t *testing.T) {
+		msg := Message{
+			Key: []byte("key-1"),
+			Value: []byte(`{"messageId":"msg-1","source":"rivendell","correlationId":"corr-2",
+				"producedAt":"2024-05-19T21:06:10Z","countryHint":"nz",
+				"payload":{"name":"Frodo","surname":"Baggins"}}`),
+			Headers: map[string]string{HeaderSource: "shire"},
+		}
+
+		fn, meta, err := ParseFN(msg)
+		assert.NoError(t, err)
+		assert.Equal(t, UserFN{Name: "Frodo", Surname: "Baggins"}, fn)
+		assert.Equal(t, "msg-1", meta.MessageID)
+		assert.Equal(t, "rivendell", meta.Source)
+		assert.Equal(t, "corr-2", meta.CorrelationID)
+		assert.Equal(t, "NZ", meta.CountryHint)
+		assert.Equal(t, time.Date(2024, 5, 19, 21, 6, 10, 0, time.UTC), meta.ProducedAt.UTC())
+	})
+
+	t.Run("success, invalid country hint dropped", func(t *testing.T) {
+		for _, hint := range []string{"germany", "XX", "N"} {
+			_, meta, err := ParseFN(Message{
+				Value:   []byte(`{"name":"Frodo","surname":"Baggins"}`),
+				Headers: map[string]string{HeaderCountryHint: hint},
+			})
+			assert.NoError(t, err)
+			assert.Empty(t, meta.CountryHint, hint)
+		}
+
+		_, meta, err := ParseFN(Message{
+			Value:   []byte(`{"name":"Frodo","surname":"Baggins"}`),
+			Headers: map[string]string{HeaderCountryHint: " de "},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "DE", meta.CountryHint)
+	})
+
+	t.Run("success, headers round trip", func(t *testing.T) {
+		meta := MessageMeta{Provenance: Provenance{MessageID: "msg-1", Source: "shire"}}
+		headers := meta.Headers()
+		assert.Equal(t, map[string]string{HeaderMessageID: "msg-1", HeaderSource: "shire"}, headers)
+	})
+
+	t.Run("failure, invalid json", func(t *testing.T) {
+		_, _, err := ParseFN(Message{Value: []byte(`{"name":`)})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// Migration is a schema migration of the service and its state in the
+// database.
+type Migration struct {
+	Version   int64      `json:"version"`
+	Name      string     `json:"name"`
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"appliedAt,omitempty"`
+}
@@ -0,0 +1,80 @@
+package models
+
+import (
+	"encoding/base64"
+	"fmt"
+	jsoniter "github.com/json-iterator/go"
+)
+
+type UsersPage struct {
+	Items []*User `json:"items"`
+	// Total is the number of users matching the filters, nil when not
+	// requested.
+	Total          *int64 `json:"total,omitempty"`
+	TotalEstimated bool   `json:"totalEstimated,omitempty"`
+	// Cursor points after the last item, empty on the last page.
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// TotalMode selects how UsersPage.Total is computed.
+type TotalMode string
+
+const (
+	TotalExact TotalMode = "exact"
+	// TotalEstimate takes the row estimate of the query plan, which is
+	// cheap on large tables but may be off.
+	TotalEstimate TotalMode = "estimate"
+	TotalNone     TotalMode = "none"
+)
+
+// ParseTotalMode parses the total query parameter, empty means TotalExact.
+func ParseTotalMode(val string) (TotalMode, error) {
+	switch mode := TotalMode(val); mode {
+	case "":
+		return TotalExact, nil
+	case TotalExact, TotalEstimate, TotalNone:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("%w: total must be one of exact, estimate, none, got %q", ErrInvalidParam, val)
+	}
+}
+
+// Cursor is the keyset position after the last returned user: the values
+// of the sort columns and the id. Results ranked by search similarity have
+// no such key and use Offset instead. Sort records the order the cursor was
+// made for, so it is not applied to a different one.
+type Cursor struct {
+	Sort   string   `json:"s"`
+	Values []string `json:"v,omitempty"`
+	ID     int      `json:"id,omitempty"`
+	Offset int      `json:"o,omitempty"`
+}
+
+// Encode returns the opaque form of the cursor used in responses.
+func (c Cursor) Encode() string {
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+	data, _ := json.Marshal(c)
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// ParseCursor decodes a cursor returned by Encode, empty means no cursor.
+func ParseCursor(val string) (*Cursor, error) {
+	if val == "" {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(val)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidParam)
+	}
+
+	c := Cursor{}
+
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+	if err = json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidParam)
+	}
+
+	return &c, nil
+}
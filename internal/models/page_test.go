@@ -0,0 +1,44 @@
+package models
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func Test_Cursor(t *testing.T) {
+	t.Run("round trip", func(t *testing.T) {
+		cursor := Cursor{Sort: "-age,-id", Values: []string{"42"}, ID: 7}
+
+		parsed, err := ParseCursor(cursor.Encode())
+		require.NoError(t, err)
+		assert.Equal(t, &cursor, parsed)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		parsed, err := ParseCursor("")
+		assert.NoError(t, err)
+		assert.Nil(t, parsed)
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		_, err := ParseCursor("not a cursor!")
+		assert.ErrorIs(t, err, ErrInvalidParam)
+
+		_, err = ParseCursor("bm90IGpzb24")
+		assert.ErrorIs(t, err, ErrInvalidParam)
+	})
+}
+
+func Test_ParseTotalMode(t *testing.T) {
+	mode, err := ParseTotalMode("")
+	assert.NoError(t, err)
+	assert.Equal(t, TotalExact, mode)
+
+	mode, err = ParseTotalMode("estimate")
+	assert.NoError(t, err)
+	assert.Equal(t, TotalEstimate, mode)
+
+	_, err = ParseTotalMode("all")
+	assert.ErrorIs(t, err, ErrInvalidParam)
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// ArchiveTarget is where archived users are moved to.
+type ArchiveTarget string
+
+const (
+	ArchiveTable ArchiveTarget = "table"
+	ArchiveFile  ArchiveTarget = "file"
+)
+
+// ParseArchiveTarget parses the RETENTION_ARCHIVE_TARGET setting, empty
+// means ArchiveTable.
+func ParseArchiveTarget(val string) (ArchiveTarget, error) {
+	switch target := ArchiveTarget(val); target {
+	case "":
+		return ArchiveTable, nil
+	case ArchiveTable, ArchiveFile:
+		return target, nil
+	default:
+		return "", fmt.Errorf("%w: archive target must be table or file, got %q", ErrInvalidParam, val)
+	}
+}
+
+// RetentionPolicy are the retention rules, a rule with a zero age is
+// disabled.
+type RetentionPolicy struct {
+	// PurgeDeletedAfter erases users soft-deleted longer ago.
+	PurgeDeletedAfter time.Duration
+	// ArchiveAfter moves users not updated for longer out of users, to the
+	// users_archive table or to NDJSON files in ArchiveDir.
+	ArchiveAfter  time.Duration
+	ArchiveTarget ArchiveTarget
+	ArchiveDir    string
+}
+
+// Enabled tells whether any rule is set.
+func (p RetentionPolicy) Enabled() bool {
+	return p.PurgeDeletedAfter > 0 || p.ArchiveAfter > 0
+}
+
+// RetentionReport tells what a retention run did, or would do with DryRun.
+type RetentionReport struct {
+	DryRun    bool      `json:"dryRun"`
+	StartedAt time.Time `json:"startedAt"`
+	// Skipped is set when another instance holds the retention lock.
+	Skipped      bool       `json:"skipped,omitempty"`
+	PurgedBefore *time.Time `json:"purgedBefore,omitempty"`
+	Purged       int        `json:"purged"`
+	// ArchivedBefore is the update time older users are archived from.
+	ArchivedBefore *time.Time `json:"archivedBefore,omitempty"`
+	Archived       int        `json:"archived"`
+	ArchiveFile    string     `json:"archiveFile,omitempty"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_ParseArchiveTarget(t *testing.T) {
+	target, err := ParseArchiveTarget("")
+	assert.NoError(t, err)
+	assert.Equal(t, ArchiveTable, target)
+
+	target, err = ParseArchiveTarget("file")
+	assert.NoError(t, err)
+	assert.Equal(t, ArchiveFile, target)
+
+	_, err = ParseArchiveTarget("s3")
+	assert.ErrorIs(t, err, ErrInvalidParam)
+}
+
+func Test_RetentionPolicy_Enabled(t *testing.T) {
+	assert.False(t, RetentionPolicy{ArchiveTarget: ArchiveFile}.Enabled())
+	assert.True(t, RetentionPolicy{PurgeDeletedAfter: 720 * time.Hour}.Enabled())
+	assert.True(t, RetentionPolicy{ArchiveAfter: time.Hour}.Enabled())
+}
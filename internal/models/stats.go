@@ -0,0 +1,113 @@
+package models
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	jsoniter "github.com/json-iterator/go"
+	"strconv"
+	"strings"
+)
+
+// StatsDimension is what users are grouped by in statistics.
+type StatsDimension string
+
+const (
+	StatsNationality StatsDimension = "nationality"
+	StatsGender      StatsDimension = "gender"
+	StatsAge         StatsDimension = "age"
+)
+
+const maxAgeBuckets = 20
+
+// DefaultAgeBuckets are the lower bounds of the age groups after the first
+// one, which starts at 0.
+var DefaultAgeBuckets = []int{18, 30, 45, 60}
+
+func ParseStatsDimension(val string) (StatsDimension, error) {
+	switch dimension := StatsDimension(val); dimension {
+	case StatsNationality, StatsGender, StatsAge:
+		return dimension, nil
+	default:
+		return "", fmt.Errorf("%w: statistics are grouped by nationality, gender or age, got %q", ErrInvalidParam, val)
+	}
+}
+
+// StatsParams selects the users counted, with the filters of the list
+// endpoint.
+type StatsParams struct {
+	Dimension StatsDimension `json:"dimension"`
+	Text      string         `json:"text,omitempty"`
+	Match     MatchMode      `json:"match,omitempty"`
+	// AgeBuckets are the ascending lower bounds of the age groups.
+	AgeBuckets []int `json:"ageBuckets,omitempty"`
+	UserFilter
+}
+
+// ParseAgeBuckets parses comma separated ascending ages, empty means
+// DefaultAgeBuckets.
+func ParseAgeBuckets(val string) ([]int, error) {
+	if val == "" {
+		return DefaultAgeBuckets, nil
+	}
+
+	parts := strings.Split(val, ",")
+	if len(parts) > maxAgeBuckets {
+		return nil, fmt.Errorf("%w: at most %d age buckets", ErrInvalidParam, maxAgeBuckets)
+	}
+
+	buckets := make([]int, 0, len(parts))
+
+	for _, part := range parts {
+		age, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || age <= 0 {
+			return nil, fmt.Errorf("%w: age buckets must be positive integers, got %q", ErrInvalidParam, part)
+		}
+
+		if len(buckets) > 0 && age <= buckets[len(buckets)-1] {
+			return nil, fmt.Errorf("%w: age buckets must be ascending, got %q", ErrInvalidParam, val)
+		}
+
+		buckets = append(buckets, age)
+	}
+
+	return buckets, nil
+}
+
+// CacheKey identifies the statistics of the params in the cache.
+func (p StatsParams) CacheKey() string {
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+	data, _ := json.Marshal(p)
+
+	sum := sha1.Sum(data)
+
+	return string(p.Dimension) + ":" + hex.EncodeToString(sum[:])
+}
+
+// AgeBucketLabel names the age group i of width_bucket over buckets: "0-17",
+// "18-29", ..., "60+".
+func AgeBucketLabel(buckets []int, i int) string {
+	lower := 0
+	if i > 0 {
+		lower = buckets[i-1]
+	}
+
+	if i >= len(buckets) {
+		return strconv.Itoa(lower) + "+"
+	}
+
+	return strconv.Itoa(lower) + "-" + strconv.Itoa(buckets[i]-1)
+}
+
+type (
+	Stats struct {
+		Dimension StatsDimension `json:"dimension"`
+		Total     int64          `json:"total"`
+		Groups    []StatsGroup   `json:"groups"`
+	}
+
+	StatsGroup struct {
+		Key   string `db:"key"   json:"key"`
+		Count int64  `db:"count" json:"count"`
+	}
+)
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func Test_ParseAgeBuckets(t *testing.T) {
+	buckets, err := ParseAgeBuckets("")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultAgeBuckets, buckets)
+
+	buckets, err = ParseAgeBuckets("20, 40")
+	require.NoError(t, err)
+	assert.Equal(t, []int{20, 40}, buckets)
+
+	for _, val := range []string{"40,20", "20,20", "0", "-5", "ten"} {
+		_, err = ParseAgeBuckets(val)
+		assert.ErrorIs(t, err, ErrInvalidParam, val)
+	}
+}
+
+func Test_AgeBucketLabel(t *testing.T) {
+	buckets := []int{18, 30}
+
+	assert.Equal(t, "0-17", AgeBucketLabel(buckets, 0))
+	assert.Equal(t, "18-29", AgeBucketLabel(buckets, 1))
+	assert.Equal(t, "30+", AgeBucketLabel(buckets, 2))
+}
+
+func Test_StatsParams_CacheKey(t *testing.T) {
+	minAge := 18
+	a := StatsParams{Dimension: StatsGender, UserFilter: UserFilter{MinAge: &minAge}}
+	b := StatsParams{Dimension: StatsGender}
+
+	assert.Equal(t, a.CacheKey(), a.CacheKey())
+	assert.NotEqual(t, a.CacheKey(), b.CacheKey())
+	assert.Contains(t, a.CacheKey(), "gender:")
+}
@@ -1,9 +1,11 @@
 package models
 
 import (
+	"fmt"
 	validation "github.com/go-ozzo/ozzo-validation/v4"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -21,6 +23,11 @@ type (
 		Age         int    `json:"age,omitempty"         db:"age"`
 		Gender      string `json:"gender,omitempty"      db:"gender"`
 		Nationality string `json:"nationality,omitempty" db:"nationality"`
+		// Probabilities reported by the enrichment APIs, nil when the value
+		// was not resolved.
+		GenderProbability      *float64 `json:"-" db:"gender_probability"`
+		NationalityProbability *float64 `json:"-" db:"nationality_probability"`
+		Provenance             `json:"-"`
 	}
 
 	ResponseFNError struct {
@@ -29,16 +36,30 @@ type (
 	}
 
 	User struct {
-		ID          int       `db:"id"          json:"id"`
-		Name        string    `db:"name"        json:"name"`
-		Surname     string    `db:"surname"     json:"surname"`
-		Patronymic  string    `db:"patronymic"  json:"patronymic"`
-		Age         int       `db:"age"         json:"age"`
-		Gender      string    `db:"gender"      json:"gender"`
-		Nationality string    `db:"nationality" json:"nationality"`
-		IsDeleted   bool      `db:"is_deleted"  json:"isDeleted"`
-		CreatedAt   time.Time `db:"created_at"  json:"createdAt"`
-		UpdatedAt   time.Time `db:"updated_at"  json:"updatedAt"`
+		ID                     int        `db:"id"          json:"id"`
+		Name                   string     `db:"name"        json:"name"`
+		Surname                string     `db:"surname"     json:"surname"`
+		Patronymic             string     `db:"patronymic"  json:"patronymic"`
+		Age                    int        `db:"age"         json:"age"`
+		Gender                 string     `db:"gender"      json:"gender"`
+		Nationality            string     `db:"nationality" json:"nationality"`
+		IsDeleted              bool       `db:"is_deleted"  json:"isDeleted"`
+		CreatedAt              time.Time  `db:"created_at"  json:"createdAt"`
+		UpdatedAt              time.Time  `db:"updated_at"  json:"updatedAt"`
+		GenderProbability      *float64   `db:"gender_probability"      json:"genderProbability,omitempty"`
+		NationalityProbability *float64   `db:"nationality_probability" json:"nationalityProbability,omitempty"`
+		DeletedAt              *time.Time `db:"deleted_at"              json:"deletedAt,omitempty"`
+		DeletedReason          string     `db:"deleted_reason"          json:"deletedReason,omitempty"`
+		// MergedInto is the user this one was merged into as a duplicate.
+		MergedInto *int `db:"merged_into" json:"mergedInto,omitempty"`
+		// Version is incremented on every change, it is the ETag of the user.
+		Version int `db:"version" json:"version"`
+		Provenance
+	}
+
+	// PurgeResult reports how many soft-deleted users were erased.
+	PurgeResult struct {
+		Purged int `json:"purged"`
 	}
 
 	UserUpdate struct {
@@ -51,14 +72,41 @@ type (
 	}
 
 	GetUsersParams struct {
-		Text       string `json:"text"       db:"text"`
-		Limit      int    `json:"limit"      db:"limit"`
-		Offset     int    `json:"offset"     db:"offset"`
-		Sorting    string `json:"sorting"    db:"sorting"`
-		Descending bool   `json:"descending" db:"descending"`
+		Text   string      `json:"text"   db:"text"`
+		Match  MatchMode   `json:"match"  db:"match"`
+		Limit  int         `json:"limit"  db:"limit"`
+		Offset int         `json:"offset" db:"offset"`
+		Sort   []SortField `json:"sort"   db:"sort"`
+		Cursor *Cursor     `json:"cursor" db:"cursor"`
+		Total  TotalMode   `json:"total"  db:"total"`
+		UserFilter
 	}
 )
 
+// MatchMode selects how GetUsersParams.Text is matched against name,
+// surname and patronymic. All modes are case-insensitive.
+type MatchMode string
+
+const (
+	MatchExact  MatchMode = "exact"
+	MatchPrefix MatchMode = "prefix"
+	// MatchFuzzy matches substrings and similar spellings, ranking results
+	// by trigram similarity.
+	MatchFuzzy MatchMode = "fuzzy"
+)
+
+// ParseMatchMode parses the match query parameter, empty means MatchFuzzy.
+func ParseMatchMode(val string) (MatchMode, error) {
+	switch mode := MatchMode(val); mode {
+	case "":
+		return MatchFuzzy, nil
+	case MatchExact, MatchPrefix, MatchFuzzy:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("%w: match must be one of exact, prefix, fuzzy, got %q", ErrInvalidParam, val)
+	}
+}
+
 func (u *UserCreate) Validate() error {
 	return validation.ValidateStruct(u,
 		validation.Field(&u.Name, validation.Required, validation.Length(2, 25)),
@@ -68,6 +116,18 @@ func (u *UserCreate) Validate() error {
 		validation.Field(&u.Nationality, validation.Required, validation.Length(2, 25)))
 }
 
+// maxDeleteReason is the size of the deleted_reason column.
+const maxDeleteReason = 255
+
+// ValidateDeleteReason checks the optional reason recorded with a deletion.
+func ValidateDeleteReason(reason string) error {
+	if err := validation.Validate(reason, validation.Length(0, maxDeleteReason)); err != nil {
+		return fmt.Errorf("%w: reason: %v", ErrInvalidParam, err)
+	}
+
+	return nil
+}
+
 func NewCreateUser(fn UserFN) UserCreate {
 	return UserCreate{
 		Name:       fn.Name,
@@ -94,6 +154,7 @@ type Country struct {
 	Probability float64 `json:"probability"`
 }
 
+// usersFieldsMapping is the sort whitelist, API field names to columns.
 var usersFieldsMapping = map[string]string{
 	"id":          "id",
 	"name":        "name",
@@ -106,31 +167,103 @@ var usersFieldsMapping = map[string]string{
 	"updatedAt":   "updated_at",
 }
 
-func NewParams(text string, limit string, offset string, sorting string, descending string) GetUsersParams {
+// SortField is a column to order by, taken from usersFieldsMapping.
+type SortField struct {
+	Column string `json:"column"`
+	Desc   bool   `json:"desc"`
+}
+
+// ParseSort parses a comma separated list of API field names, each
+// optionally prefixed with "-" for descending order: "-age,surname".
+func ParseSort(val string) ([]SortField, error) {
+	if val == "" {
+		return nil, nil
+	}
+
+	fields := make([]SortField, 0)
+	seen := make(map[string]bool)
+
+	for _, name := range strings.Split(val, ",") {
+		name = strings.TrimSpace(name)
+
+		field := SortField{}
+		if strings.HasPrefix(name, "-") {
+			name, field.Desc = name[1:], true
+		}
+
+		column, ok := usersFieldsMapping[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown sort field %q", ErrInvalidParam, name)
+		}
+
+		if seen[column] {
+			return nil, fmt.Errorf("%w: duplicate sort field %q", ErrInvalidParam, name)
+		}
+
+		seen[column] = true
+		field.Column = column
+		fields = append(fields, field)
+	}
+
+	return fields, nil
+}
+
+// FormatSort is the inverse of ParseSort.
+func FormatSort(fields []SortField) string {
+	names := make([]string, 0, len(fields))
+
+	for _, field := range fields {
+		name := field.Column
+		for api, column := range usersFieldsMapping {
+			if column == field.Column {
+				name = api
+			}
+		}
+
+		if field.Desc {
+			name = "-" + name
+		}
+
+		names = append(names, name)
+	}
+
+	return strings.Join(names, ",")
+}
+
+// NewParams builds the list parameters from query values. sorting is a
+// ParseSort list, descending=true reverses the whole order.
+func NewParams(text string, limit string, offset string, sorting string, descending string) (GetUsersParams, error) {
 	params := GetUsersParams{}
 
 	params.Text = text
+	params.Match = MatchFuzzy
+	params.Total = TotalExact
 	defaultLimit := 100
 	params.Limit, _ = strconv.Atoi(limit)
 
-	if params.Limit == 0 {
+	if params.Limit <= 0 {
 		params.Limit = defaultLimit
 	}
 
 	params.Offset, _ = strconv.Atoi(offset)
-	val, ok := usersFieldsMapping[sorting]
-	if !ok {
-		params.Sorting = "id"
-	} else {
-		params.Sorting = val
+	if params.Offset < 0 {
+		params.Offset = 0
 	}
 
-	if sorting == "" {
-		sorting = "id"
+	var err error
+	if params.Sort, err = ParseSort(sorting); err != nil {
+		return params, err
 	}
 
-	params.Sorting = sorting
-	params.Descending, _ = strconv.ParseBool(descending)
+	if reverse, _ := strconv.ParseBool(descending); reverse {
+		for i := range params.Sort {
+			params.Sort[i].Desc = !params.Sort[i].Desc
+		}
+
+		if len(params.Sort) == 0 {
+			params.Sort = []SortField{{Column: "id", Desc: true}}
+		}
+	}
 
-	return params
+	return params, nil
 }
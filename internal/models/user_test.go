@@ -2,6 +2,7 @@ package models
 
 import (
 	"github.com/stretchr/testify/assert"
+	"strings"
 	"testing"
 )
 
@@ -104,3 +105,86 @@ func Test_ValidateFIO(t *testing.T) {
 		assert.Error(t, err)
 	})
 }
+
+func Test_ParseMatchMode(t *testing.T) {
+	for val, want := range map[string]MatchMode{
+		"":       MatchFuzzy,
+		"exact":  MatchExact,
+		"prefix": MatchPrefix,
+		"fuzzy":  MatchFuzzy,
+	} {
+		mode, err := ParseMatchMode(val)
+		assert.NoError(t, err)
+		assert.Equal(t, want, mode)
+	}
+
+	_, err := ParseMatchMode("regex")
+	assert.ErrorIs(t, err, ErrInvalidParam)
+}
+
+func Test_ValidateDeleteReason(t *testing.T) {
+	assert.NoError(t, ValidateDeleteReason(""))
+	assert.NoError(t, ValidateDeleteReason("GDPR erasure request"))
+	assert.ErrorIs(t, ValidateDeleteReason(strings.Repeat("x", 256)), ErrInvalidParam)
+}
+
+func Test_ParseSort(t *testing.T) {
+	t.Run("multi column", func(t *testing.T) {
+		fields, err := ParseSort("-age, surname,createdAt")
+		assert.NoError(t, err)
+		assert.Equal(t, []SortField{
+			{Column: "age", Desc: true},
+			{Column: "surname"},
+			{Column: "created_at"},
+		}, fields)
+		assert.Equal(t, "-age,surname,createdAt", FormatSort(fields))
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		fields, err := ParseSort("")
+		assert.NoError(t, err)
+		assert.Empty(t, fields)
+	})
+
+	for name, val := range map[string]string{
+		"unknown field":  "password",
+		"injection":      "age; DROP TABLE users",
+		"column name":    "created_at",
+		"duplicate":      "age,-age",
+		"empty field":    "age,,surname",
+		"only direction": "-",
+	} {
+		val := val
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseSort(val)
+			assert.ErrorIs(t, err, ErrInvalidParam)
+		})
+	}
+}
+
+func Test_NewParams(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		params, err := NewParams("", "", "", "", "")
+		assert.NoError(t, err)
+		assert.Equal(t, 100, params.Limit)
+		assert.Equal(t, 0, params.Offset)
+		assert.Empty(t, params.Sort)
+	})
+
+	t.Run("descending reverses the order", func(t *testing.T) {
+		params, err := NewParams("", "10", "20", "-age,surname", "true")
+		assert.NoError(t, err)
+		assert.Equal(t, []SortField{{Column: "age"}, {Column: "surname", Desc: true}}, params.Sort)
+	})
+
+	t.Run("descending without sort", func(t *testing.T) {
+		params, err := NewParams("", "", "", "", "true")
+		assert.NoError(t, err)
+		assert.Equal(t, []SortField{{Column: "id", Desc: true}}, params.Sort)
+	})
+
+	t.Run("unknown sort field", func(t *testing.T) {
+		_, err := NewParams("", "", "", "createdAt DESC", "")
+		assert.ErrorIs(t, err, ErrInvalidParam)
+	})
+}
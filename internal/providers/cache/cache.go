@@ -8,12 +8,14 @@ import (
 	"github.com/redis/go-redis/v9"
 	"github.com/sirupsen/logrus"
 	"github.com/zuzi90/tz-enricher/internal/models"
+	"time"
 )
 
 var ErrWritingCache = errors.New("error writing Redis cache")
 var ErrUpdatingCache = errors.New("error updating Redis cache")
 var ErrDeletingCache = errors.New("error deleting from Redis cache")
 var ErrUserNotFound = errors.New("error user not found in Redis cache")
+var ErrStatsNotFound = errors.New("error statistics not found in Redis cache")
 
 type Redis struct {
 	client *redis.Client
@@ -63,6 +65,29 @@ func (r *Redis) Set(ctx context.Context, user *models.User) error {
 	return nil
 }
 
+// SetMany writes the users in one pipeline.
+func (r *Redis) SetMany(ctx context.Context, users []*models.User) error {
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, user := range users {
+			userByte, err := json.Marshal(user)
+			if err != nil {
+				return err
+			}
+
+			pipe.Set(ctx, fmt.Sprintf("UserKey:%d", user.ID), userByte, 0)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return ErrWritingCache
+	}
+
+	return nil
+}
+
 func (r *Redis) Update(ctx context.Context, user *models.User) error {
 	json := jsoniter.ConfigCompatibleWithStandardLibrary
 	userByte, err := json.Marshal(user)
@@ -87,3 +112,39 @@ func (r *Redis) Delete(ctx context.Context, key int) error {
 
 	return nil
 }
+
+// GetStats returns statistics stored by SetStats, ErrStatsNotFound when
+// they are missing or expired.
+func (r *Redis) GetStats(ctx context.Context, key string) (*models.Stats, error) {
+	val, err := r.client.Get(ctx, "StatsKey:"+key).Bytes()
+	if err == redis.Nil {
+		return nil, ErrStatsNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var stats models.Stats
+
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+	if err = json.Unmarshal(val, &stats); err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
+
+func (r *Redis) SetStats(ctx context.Context, key string, stats *models.Stats, ttl time.Duration) error {
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+
+	if err = r.client.Set(ctx, "StatsKey:"+key, data, ttl).Err(); err != nil {
+		return ErrWritingCache
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package codec
+
+import (
+	"github.com/linkedin/goavro/v2"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"github.com/zuzi90/tz-enricher/internal/providers/schemaregistry"
+	"sync"
+	"time"
+)
+
+type avroCodecs struct {
+	mu     sync.RWMutex
+	codecs map[int]*goavro.Codec
+}
+
+func newAvroCodecs() *avroCodecs {
+	return &avroCodecs{codecs: make(map[int]*goavro.Codec)}
+}
+
+func (a *avroCodecs) get(schema schemaregistry.Schema) (*goavro.Codec, error) {
+	a.mu.RLock()
+	codec, ok := a.codecs[schema.ID]
+	a.mu.RUnlock()
+
+	if ok {
+		return codec, nil
+	}
+
+	codec, err := goavro.NewCodec(schema.Schema)
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	a.codecs[schema.ID] = codec
+	a.mu.Unlock()
+
+	return codec, nil
+}
+
+// decode reads an FN record. The envelope fields may either sit next to the
+// name fields or wrap them in a nested "payload" record.
+func (a *avroCodecs) decode(schema schemaregistry.Schema, payload []byte) (models.FNEnvelope, error) {
+	codec, err := a.get(schema)
+	if err != nil {
+		return models.FNEnvelope{}, err
+	}
+
+	native, _, err := codec.NativeFromBinary(payload)
+	if err != nil {
+		return models.FNEnvelope{}, err
+	}
+
+	record, _ := native.(map[string]interface{})
+
+	fnRecord := record
+	if nested, ok := unwrapUnion(record["payload"]).(map[string]interface{}); ok {
+		fnRecord = nested
+	}
+
+	env := models.FNEnvelope{
+		Payload: &models.UserFN{
+			Name:       avroString(fnRecord, "name"),
+			Surname:    avroString(fnRecord, "surname"),
+			Patronymic: avroString(fnRecord, "patronymic"),
+		},
+	}
+
+	env.MessageID = avroString(record, "messageId")
+	env.Source = avroString(record, "source")
+	env.CorrelationID = avroString(record, "correlationId")
+	env.CountryHint = avroString(record, "countryHint")
+
+	switch val := unwrapUnion(record["producedAt"]).(type) {
+	case time.Time:
+		env.ProducedAt = &val
+	case int64:
+		t := time.UnixMilli(val)
+		env.ProducedAt = &t
+	}
+
+	return env, nil
+}
+
+func (a *avroCodecs) encodeWrongFN(schema schemaregistry.Schema, resp models.ResponseFNError) ([]byte, error) {
+	codec, err := a.get(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	var patronymic interface{}
+	if resp.Patronymic != "" {
+		patronymic = goavro.Union("string", resp.Patronymic)
+	}
+
+	return codec.BinaryFromNative(nil, map[string]interface{}{
+		"name":       resp.Name,
+		"surname":    resp.Surname,
+		"patronymic": patronymic,
+		"errMessage": resp.ErrMessage,
+	})
+}
+
+// unwrapUnion returns the value of a goavro union, which is decoded as a map
+// with the member type name as its only key.
+func unwrapUnion(val interface{}) interface{} {
+	union, ok := val.(map[string]interface{})
+	if !ok || len(union) != 1 {
+		return val
+	}
+
+	for _, v := range union {
+		return v
+	}
+
+	return val
+}
+
+func avroString(record map[string]interface{}, field string) string {
+	val, _ := unwrapUnion(record[field]).(string)
+	return val
+}
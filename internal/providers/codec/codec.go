@@ -0,0 +1,241 @@
+package codec
+
+import (
+	"context"
+	"embed"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/sirupsen/logrus"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"github.com/zuzi90/tz-enricher/internal/providers/schemaregistry"
+	"sync"
+)
+
+// FormatAuto picks the decoder by the Confluent wire-format magic byte:
+// framed messages are decoded according to the registered schema type,
+// anything else is treated as JSON.
+const FormatAuto models.MessageFormat = "auto"
+
+const (
+	magicByte  = 0
+	headerSize = 5
+)
+
+//go:embed schemas/*
+var embedSchemas embed.FS
+
+var ErrNotFramed = errors.New("message is not in schema registry wire format")
+
+type schemaRegistry interface {
+	GetSchema(ctx context.Context, id int) (schemaregistry.Schema, error)
+	GetLatestSchema(ctx context.Context, subject string) (schemaregistry.Schema, error)
+	Register(ctx context.Context, subject string, schemaType string, schema string) (int, error)
+}
+
+type Codec struct {
+	log            *logrus.Entry
+	format         models.MessageFormat
+	registry       schemaRegistry
+	wrongFNSubject string
+	autoRegister   bool
+	avro           *avroCodecs
+
+	mu            sync.Mutex
+	wrongFNSchema map[string]schemaregistry.Schema
+}
+
+func NewCodec(
+	log *logrus.Logger,
+	format string,
+	registry schemaRegistry,
+	wrongFNSubject string,
+	autoRegister bool,
+) (*Codec, error) {
+	c := Codec{
+		log:            log.WithField("module", "codec"),
+		format:         models.MessageFormat(format),
+		registry:       registry,
+		wrongFNSubject: wrongFNSubject,
+		autoRegister:   autoRegister,
+		avro:           newAvroCodecs(),
+		wrongFNSchema:  make(map[string]schemaregistry.Schema),
+	}
+
+	switch c.format {
+	case FormatAuto, models.FormatJSON, models.FormatAvro, models.FormatProtobuf:
+	default:
+		return nil, fmt.Errorf("unknown message format: %s", format)
+	}
+
+	return &c, nil
+}
+
+// Decode decodes msg with the configured format and reports the detected
+// format, so that the reply can be encoded the same way.
+func (c *Codec) Decode(ctx context.Context, msg models.Message) (models.DecodedFN, error) {
+	if c.format == models.FormatJSON {
+		return decodeJSON(msg)
+	}
+
+	id, payload, err := splitFrame(msg.Value)
+	if errors.Is(err, ErrNotFramed) && c.format == FormatAuto {
+		return decodeJSON(msg)
+	}
+
+	if err != nil {
+		return models.DecodedFN{}, err
+	}
+
+	schema, err := c.registry.GetSchema(ctx, id)
+	if err != nil {
+		return models.DecodedFN{}, err
+	}
+
+	format, err := formatOf(schema.SchemaType)
+	if err != nil {
+		return models.DecodedFN{}, fmt.Errorf("schema %d: %w", id, err)
+	}
+
+	if c.format != FormatAuto && c.format != format {
+		return models.DecodedFN{}, fmt.Errorf("schema %d is %s, expected %s", id, format, c.format)
+	}
+
+	var env models.FNEnvelope
+
+	switch format {
+	case models.FormatAvro:
+		env, err = c.avro.decode(schema, payload)
+	case models.FormatProtobuf:
+		env, err = decodeProtobuf(payload)
+	case models.FormatJSON:
+		msg.Value = payload
+		return decodeJSON(msg)
+	}
+
+	if err != nil {
+		return models.DecodedFN{}, err
+	}
+
+	fn, meta := env.Resolve(msg)
+
+	return models.DecodedFN{FN: fn, Meta: meta, Format: format}, nil
+}
+
+// Encode encodes the WRONG_FN reply in format. Avro and Protobuf replies use
+// the latest schema of the WRONG_FN subject, registering the built-in schema
+// when auto registration is enabled.
+func (c *Codec) Encode(ctx context.Context, format models.MessageFormat, resp models.ResponseFNError) ([]byte, error) {
+	switch format {
+	case models.FormatAvro:
+		schema, err := c.getWrongFNSchema(ctx, schemaregistry.TypeAvro)
+		if err != nil {
+			return nil, err
+		}
+
+		payload, err := c.avro.encodeWrongFN(schema, resp)
+		if err != nil {
+			return nil, err
+		}
+
+		return frame(schema.ID, payload), nil
+	case models.FormatProtobuf:
+		schema, err := c.getWrongFNSchema(ctx, schemaregistry.TypeProtobuf)
+		if err != nil {
+			return nil, err
+		}
+
+		payload := append([]byte{0}, encodeProtobufWrongFN(resp)...)
+
+		return frame(schema.ID, payload), nil
+	default:
+		json := jsoniter.ConfigCompatibleWithStandardLibrary
+		return json.Marshal(resp)
+	}
+}
+
+func (c *Codec) getWrongFNSchema(ctx context.Context, schemaType string) (schemaregistry.Schema, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if schema, ok := c.wrongFNSchema[schemaType]; ok {
+		return schema, nil
+	}
+
+	schema, err := c.registry.GetLatestSchema(ctx, c.wrongFNSubject)
+	if errors.Is(err, schemaregistry.ErrNotFound) && c.autoRegister {
+		schema, err = c.registerWrongFN(ctx, schemaType)
+	}
+
+	if err != nil {
+		return schemaregistry.Schema{}, err
+	}
+
+	if schema.SchemaType != schemaType {
+		return schemaregistry.Schema{}, fmt.Errorf("subject %s holds %s schema, expected %s",
+			c.wrongFNSubject, schema.SchemaType, schemaType)
+	}
+
+	c.wrongFNSchema[schemaType] = schema
+	c.log.Infof("wrong fn %s schema id: %d", schemaType, schema.ID)
+
+	return schema, nil
+}
+
+func (c *Codec) registerWrongFN(ctx context.Context, schemaType string) (schemaregistry.Schema, error) {
+	file := "schemas/wrong_fn.avsc"
+	if schemaType == schemaregistry.TypeProtobuf {
+		file = "schemas/wrong_fn.proto"
+	}
+
+	data, err := embedSchemas.ReadFile(file)
+	if err != nil {
+		return schemaregistry.Schema{}, err
+	}
+
+	id, err := c.registry.Register(ctx, c.wrongFNSubject, schemaType, string(data))
+	if err != nil {
+		return schemaregistry.Schema{}, err
+	}
+
+	return schemaregistry.Schema{ID: id, Subject: c.wrongFNSubject, SchemaType: schemaType, Schema: string(data)}, nil
+}
+
+func decodeJSON(msg models.Message) (models.DecodedFN, error) {
+	fn, meta, err := models.ParseFN(msg)
+	if err != nil {
+		return models.DecodedFN{}, err
+	}
+
+	return models.DecodedFN{FN: fn, Meta: meta, Format: models.FormatJSON}, nil
+}
+
+func formatOf(schemaType string) (models.MessageFormat, error) {
+	switch schemaType {
+	case schemaregistry.TypeAvro:
+		return models.FormatAvro, nil
+	case schemaregistry.TypeProtobuf:
+		return models.FormatProtobuf, nil
+	case schemaregistry.TypeJSON:
+		return models.FormatJSON, nil
+	default:
+		return "", fmt.Errorf("unsupported schema type: %s", schemaType)
+	}
+}
+
+func splitFrame(data []byte) (int, []byte, error) {
+	if len(data) < headerSize || data[0] != magicByte {
+		return 0, nil, ErrNotFramed
+	}
+
+	return int(binary.BigEndian.Uint32(data[1:headerSize])), data[headerSize:], nil
+}
+
+func frame(id int, payload []byte) []byte {
+	data := make([]byte, headerSize, headerSize+len(payload))
+	data[0] = magicByte
+	binary.BigEndian.PutUint32(data[1:headerSize], uint32(id))
+
+	return append(data, payload...)
+}
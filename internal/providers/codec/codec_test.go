@@ -0,0 +1,244 @@
+package codec
+
+import (
+	"context"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/linkedin/goavro/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"github.com/zuzi90/tz-enricher/internal/providers/schemaregistry"
+	"google.golang.org/protobuf/encoding/protowire"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// registryStub is a minimal in-memory stand-in for the Confluent Schema Registry API.
+type registryStub struct {
+	mu       sync.Mutex
+	schemas  []schemaregistry.Schema
+	subjects map[string][]int
+}
+
+func newRegistryStub() *registryStub {
+	return &registryStub{subjects: make(map[string][]int)}
+}
+
+func (r *registryStub) register(subject, schemaType, schema string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id := len(r.schemas) + 1
+	r.schemas = append(r.schemas, schemaregistry.Schema{ID: id, Subject: subject, SchemaType: schemaType, Schema: schema})
+	r.subjects[subject] = append(r.subjects[subject], id)
+
+	return id
+}
+
+func (r *registryStub) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+	path := strings.Trim(req.URL.Path, "/")
+	parts := strings.Split(path, "/")
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch {
+	case req.Method == http.MethodGet && len(parts) == 3 && parts[0] == "schemas":
+		id, _ := strconv.Atoi(parts[2])
+		if id < 1 || id > len(r.schemas) {
+			http.NotFound(w, req)
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(r.schemas[id-1])
+	case req.Method == http.MethodGet && len(parts) == 4 && parts[3] == "latest":
+		ids := r.subjects[parts[1]]
+		if len(ids) == 0 {
+			http.NotFound(w, req)
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(r.schemas[ids[len(ids)-1]-1])
+	case req.Method == http.MethodPost && len(parts) == 3:
+		schema := schemaregistry.Schema{}
+		_ = json.NewDecoder(req.Body).Decode(&schema)
+		if schema.SchemaType == "" {
+			schema.SchemaType = schemaregistry.TypeAvro
+		}
+
+		id := len(r.schemas) + 1
+		r.schemas = append(r.schemas, schemaregistry.Schema{ID: id, Subject: parts[1], SchemaType: schema.SchemaType, Schema: schema.Schema})
+		r.subjects[parts[1]] = append(r.subjects[parts[1]], id)
+
+		_ = json.NewEncoder(w).Encode(schemaregistry.Schema{ID: id})
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func newTestCodec(t *testing.T, format string) (*Codec, *registryStub) {
+	t.Helper()
+
+	stub := newRegistryStub()
+	srv := httptest.NewServer(stub)
+	t.Cleanup(srv.Close)
+
+	log := logrus.New()
+	client := schemaregistry.NewClient(log, srv.URL, "", "")
+
+	c, err := NewCodec(log, format, client, "WRONG_FN-value", true)
+	require.NoError(t, err)
+
+	return c, stub
+}
+
+func Test_Decode(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("success, json", func(t *testing.T) {
+		c, _ := newTestCodec(t, "auto")
+
+		decoded, err := c.Decode(ctx, models.Message{Value: []byte(`{"name":"Frodo","surname":"Baggins"}`)})
+		require.NoError(t, err)
+		assert.Equal(t, models.FormatJSON, decoded.Format)
+		assert.Equal(t, "Frodo", decoded.FN.Name)
+	})
+
+	t.Run("success, avro", func(t *testing.T) {
+		c, stub := newTestCodec(t, "auto")
+
+		schema, err := embedSchemas.ReadFile("schemas/fn.avsc")
+		require.NoError(t, err)
+		id := stub.register("FN-value", schemaregistry.TypeAvro, string(schema))
+
+		avroCodec, err := goavro.NewCodec(string(schema))
+		require.NoError(t, err)
+
+		producedAt := time.Date(2024, 5, 19, 21, 6, 10, 0, time.UTC)
+		payload, err := avroCodec.BinaryFromNative(nil, map[string]interface{}{
+			"name":          "Frodo",
+			"surname":       "Baggins",
+			"patronymic":    goavro.Union("string", "Drogovich"),
+			"messageId":     goavro.Union("string", "msg-1"),
+			"source":        nil,
+			"correlationId": nil,
+			"producedAt":    goavro.Union("long.timestamp-millis", producedAt),
+			"countryHint":   goavro.Union("string", "nz"),
+		})
+		require.NoError(t, err)
+
+		decoded, err := c.Decode(ctx, models.Message{Value: frame(id, payload)})
+		require.NoError(t, err)
+		assert.Equal(t, models.FormatAvro, decoded.Format)
+		assert.Equal(t, models.UserFN{Name: "Frodo", Surname: "Baggins", Patronymic: "Drogovich"}, decoded.FN)
+		assert.Equal(t, "msg-1", decoded.Meta.MessageID)
+		assert.Equal(t, "NZ", decoded.Meta.CountryHint)
+		assert.Equal(t, producedAt, decoded.Meta.ProducedAt.UTC())
+	})
+
+	t.Run("success, protobuf", func(t *testing.T) {
+		c, stub := newTestCodec(t, "auto")
+		id := stub.register("FN-value", schemaregistry.TypeProtobuf, "syntax = \"proto3\";")
+
+		payload := []byte{0}
+		payload = appendString(payload, fieldName, "Frodo")
+		payload = appendString(payload, fieldSurname, "Baggins")
+		payload = appendString(payload, fieldSource, "shire")
+		payload = protowire.AppendTag(payload, 99, protowire.VarintType)
+		payload = protowire.AppendVarint(payload, 42)
+
+		decoded, err := c.Decode(ctx, models.Message{Value: frame(id, payload)})
+		require.NoError(t, err)
+		assert.Equal(t, models.FormatProtobuf, decoded.Format)
+		assert.Equal(t, models.UserFN{Name: "Frodo", Surname: "Baggins"}, decoded.FN)
+		assert.Equal(t, "shire", decoded.Meta.Source)
+	})
+
+	t.Run("failure, format mismatch", func(t *testing.T) {
+		c, stub := newTestCodec(t, "avro")
+		id := stub.register("FN-value", schemaregistry.TypeProtobuf, "syntax = \"proto3\";")
+
+		_, err := c.Decode(ctx, models.Message{Value: frame(id, []byte{0})})
+		assert.Error(t, err)
+	})
+
+	t.Run("failure, unknown schema type", func(t *testing.T) {
+		c, stub := newTestCodec(t, "auto")
+		id := stub.register("FN-value", "XML", "<fn/>")
+
+		_, err := c.Decode(ctx, models.Message{Value: frame(id, []byte{0})})
+		assert.ErrorContains(t, err, "unsupported schema type: XML")
+	})
+
+	t.Run("failure, forced format without framing", func(t *testing.T) {
+		c, _ := newTestCodec(t, "protobuf")
+
+		_, err := c.Decode(ctx, models.Message{Value: []byte(`{"name":"Frodo"}`)})
+		assert.ErrorIs(t, err, ErrNotFramed)
+	})
+}
+
+func Test_Encode(t *testing.T) {
+	ctx := context.Background()
+	resp := models.ResponseFNError{
+		UserFN:     models.UserFN{Name: "Frodo1", Surname: "Baggins"},
+		ErrMessage: "name: must be in a valid format.",
+	}
+
+	t.Run("success, avro with auto registration", func(t *testing.T) {
+		c, stub := newTestCodec(t, "auto")
+
+		data, err := c.Encode(ctx, models.FormatAvro, resp)
+		require.NoError(t, err)
+
+		id, payload, err := splitFrame(data)
+		require.NoError(t, err)
+		assert.Equal(t, stub.subjects["WRONG_FN-value"][0], id)
+
+		avroCodec, err := goavro.NewCodec(stub.schemas[id-1].Schema)
+		require.NoError(t, err)
+
+		native, _, err := avroCodec.NativeFromBinary(payload)
+		require.NoError(t, err)
+
+		record := native.(map[string]interface{})
+		assert.Equal(t, "Frodo1", record["name"])
+		assert.Equal(t, resp.ErrMessage, record["errMessage"])
+	})
+
+	t.Run("success, protobuf", func(t *testing.T) {
+		c, stub := newTestCodec(t, "auto")
+
+		data, err := c.Encode(ctx, models.FormatProtobuf, resp)
+		require.NoError(t, err)
+
+		id, payload, err := splitFrame(data)
+		require.NoError(t, err)
+		assert.Equal(t, schemaregistry.TypeProtobuf, stub.schemas[id-1].SchemaType)
+
+		env, err := decodeProtobuf(payload)
+		require.NoError(t, err)
+		assert.Equal(t, "Frodo1", env.Payload.Name)
+		assert.Empty(t, env.MessageID)
+	})
+
+	t.Run("success, json", func(t *testing.T) {
+		c, _ := newTestCodec(t, "auto")
+
+		data, err := c.Encode(ctx, models.FormatJSON, resp)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"name":"Frodo1","surname":"Baggins","patronymic":"","errMessage":"name: must be in a valid format."}`, string(data))
+	})
+
+	t.Run("failure, unknown format", func(t *testing.T) {
+		_, err := NewCodec(logrus.New(), "xml", nil, "WRONG_FN-value", false)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,174 @@
+package codec
+
+import (
+	"errors"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"google.golang.org/protobuf/encoding/protowire"
+	"time"
+)
+
+// Field numbers of the FN and WrongFN messages, see schemas/fn.proto and
+// schemas/wrong_fn.proto.
+const (
+	fieldName          protowire.Number = 1
+	fieldSurname       protowire.Number = 2
+	fieldPatronymic    protowire.Number = 3
+	fieldMessageID     protowire.Number = 4
+	fieldSource        protowire.Number = 5
+	fieldCorrelationID protowire.Number = 6
+	fieldProducedAt    protowire.Number = 7
+	fieldCountryHint   protowire.Number = 8
+
+	fieldErrMessage protowire.Number = 9
+)
+
+var errInvalidProtobuf = errors.New("invalid protobuf payload")
+
+// decodeProtobuf reads an FN message preceded by the schema registry message
+// index list. Unknown fields are skipped, so producers may extend the schema.
+func decodeProtobuf(payload []byte) (models.FNEnvelope, error) {
+	data, err := skipMessageIndexes(payload)
+	if err != nil {
+		return models.FNEnvelope{}, err
+	}
+
+	fn := models.UserFN{}
+	env := models.FNEnvelope{Payload: &fn}
+
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return models.FNEnvelope{}, protowire.ParseError(n)
+		}
+
+		data = data[n:]
+
+		if typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return models.FNEnvelope{}, protowire.ParseError(n)
+			}
+
+			data = data[n:]
+
+			continue
+		}
+
+		val, n := protowire.ConsumeBytes(data)
+		if n < 0 {
+			return models.FNEnvelope{}, protowire.ParseError(n)
+		}
+
+		data = data[n:]
+
+		switch num {
+		case fieldName:
+			fn.Name = string(val)
+		case fieldSurname:
+			fn.Surname = string(val)
+		case fieldPatronymic:
+			fn.Patronymic = string(val)
+		case fieldMessageID:
+			env.MessageID = string(val)
+		case fieldSource:
+			env.Source = string(val)
+		case fieldCorrelationID:
+			env.CorrelationID = string(val)
+		case fieldCountryHint:
+			env.CountryHint = string(val)
+		case fieldProducedAt:
+			t, err := decodeTimestamp(val)
+			if err != nil {
+				return models.FNEnvelope{}, err
+			}
+
+			env.ProducedAt = &t
+		}
+	}
+
+	return env, nil
+}
+
+func encodeProtobufWrongFN(resp models.ResponseFNError) []byte {
+	var data []byte
+
+	data = appendString(data, fieldName, resp.Name)
+	data = appendString(data, fieldSurname, resp.Surname)
+	data = appendString(data, fieldPatronymic, resp.Patronymic)
+	data = appendString(data, fieldErrMessage, resp.ErrMessage)
+
+	return data
+}
+
+func appendString(data []byte, num protowire.Number, val string) []byte {
+	if val == "" {
+		return data
+	}
+
+	data = protowire.AppendTag(data, num, protowire.BytesType)
+
+	return protowire.AppendString(data, val)
+}
+
+// skipMessageIndexes drops the zigzag-encoded message index list that the
+// Confluent serializers put in front of every Protobuf payload.
+func skipMessageIndexes(data []byte) ([]byte, error) {
+	count, n := protowire.ConsumeVarint(data)
+	if n < 0 {
+		return nil, errInvalidProtobuf
+	}
+
+	data = data[n:]
+
+	for i := int64(0); i < protowire.DecodeZigZag(count); i++ {
+		_, n = protowire.ConsumeVarint(data)
+		if n < 0 {
+			return nil, errInvalidProtobuf
+		}
+
+		data = data[n:]
+	}
+
+	return data, nil
+}
+
+// decodeTimestamp reads google.protobuf.Timestamp.
+func decodeTimestamp(data []byte) (time.Time, error) {
+	var seconds, nanos int64
+
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return time.Time{}, protowire.ParseError(n)
+		}
+
+		data = data[n:]
+
+		if typ != protowire.VarintType {
+			n = protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return time.Time{}, protowire.ParseError(n)
+			}
+
+			data = data[n:]
+
+			continue
+		}
+
+		val, n := protowire.ConsumeVarint(data)
+		if n < 0 {
+			return time.Time{}, protowire.ParseError(n)
+		}
+
+		data = data[n:]
+
+		switch num {
+		case 1:
+			seconds = int64(val)
+		case 2:
+			nanos = int64(val)
+		}
+	}
+
+	return time.Unix(seconds, nanos).UTC(), nil
+}
@@ -2,54 +2,197 @@ package kafka
 
 import (
 	"context"
+	"fmt"
 	"github.com/IBM/sarama"
 	"github.com/sirupsen/logrus"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"hash/fnv"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type messageHandler interface {
-	Handle(ctx context.Context, fio []byte) error
+	Handle(ctx context.Context, msg models.Message) error
 }
 
+// asyncHandler is implemented by handlers that may finish a message after
+// returning, such as the batching message service. done is called once the
+// message is fully processed.
+type asyncHandler interface {
+	HandleAsync(ctx context.Context, msg models.Message, done func(err error))
+}
+
+// handleAsync hands msg to handler and calls done with the result, right
+// away for handlers that do not implement asyncHandler.
+func handleAsync(ctx context.Context, handler messageHandler, msg models.Message, done func(err error)) {
+	if h, ok := handler.(asyncHandler); ok {
+		h.HandleAsync(ctx, msg, done)
+		return
+	}
+
+	done(handler.Handle(ctx, msg))
+}
+
+const lagRefreshInterval = 5 * time.Second
+
+// noOffset marks a partition nothing has been consumed from yet.
+const noOffset int64 = math.MinInt64
+
+// Consumer hands messages to a fixed set of workers. Messages are routed by
+// key, so messages with the same key are handled sequentially in the order
+// they were read, while different keys are handled in parallel.
 type Consumer struct {
 	messageHandler messageHandler
 	consumer       sarama.Consumer
 	log            *logrus.Entry
-	poolCh         chan func()
-	workersCount   int
+	metrics        *metrics
+	limiter        *rateLimiter
+	txn            *Transactor
+	next           uint32
+	queueSize      int
 	kafkaTopic     string
 	brokers        []string
+	opts           Options
+	maxLag         int64
+
+	// poolMu is held for reading while dispatching and for writing while the
+	// pool is resized.
+	poolMu  sync.RWMutex
+	queues  []chan func()
+	workers sync.WaitGroup
+
+	mu         sync.RWMutex
+	partitions map[int32]*partitionState
+	running    bool
+	lagging    bool
+}
+
+// partitionState tracks how far a partition has been processed. Workers may
+// finish messages of different keys out of order, so processed is the
+// low-watermark: every offset up to it has been handled.
+type partitionState struct {
+	pc     sarama.PartitionConsumer
+	paused bool
+
+	mu        sync.Mutex
+	processed int64
+	// inFlight holds the offsets read but not handled yet in read order,
+	// handled marks the ones finished ahead of a lower offset.
+	inFlight []int64
+	handled  map[int64]bool
+	stopped  bool
+}
+
+func newPartitionState(pc sarama.PartitionConsumer) *partitionState {
+	return &partitionState{pc: pc, processed: noOffset, handled: make(map[int64]bool)}
+}
+
+// start registers offset as read. Offsets are read in increasing order.
+func (s *partitionState) start(offset int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.processed == noOffset {
+		s.processed = offset - 1
+	}
+
+	s.inFlight = append(s.inFlight, offset)
+}
+
+// done marks offset as handled and advances the low-watermark over the
+// offsets handled without gaps.
+func (s *partitionState) done(offset int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.handled[offset] = true
+
+	for len(s.inFlight) > 0 && s.handled[s.inFlight[0]] {
+		s.processed = s.inFlight[0]
+		delete(s.handled, s.inFlight[0])
+		s.inFlight = s.inFlight[1:]
+	}
+}
+
+// stop closes the partition consumer at an offset that failed in
+// transactional mode, the messages queued after it are skipped.
+func (s *partitionState) stop() {
+	s.mu.Lock()
+	s.stopped = true
+	s.mu.Unlock()
+
+	s.pc.AsyncClose()
+}
+
+func (s *partitionState) isStopped() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.stopped
+}
+
+func (s *partitionState) lag() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return partitionLag(s.pc.HighWaterMarkOffset(), s.processed)
 }
 
-func NewConsumer(brokers []string, wCount int, kafkaTopic string, log *logrus.Logger, messageHandler messageHandler) *Consumer {
+func NewConsumer(
+	brokers []string,
+	wCount int,
+	queueSize int,
+	maxLag int64,
+	kafkaTopic string,
+	opts Options,
+	log *logrus.Logger,
+	messageHandler messageHandler,
+) *Consumer {
 	c := Consumer{
 		messageHandler: messageHandler,
-		workersCount:   wCount,
+		metrics:        newMetrics(),
+		limiter:        newRateLimiter(0),
+		queueSize:      queueSize,
 		kafkaTopic:     kafkaTopic,
 		brokers:        brokers,
+		opts:           opts,
+		maxLag:         maxLag,
+		partitions:     make(map[int32]*partitionState),
 		log:            log.WithField("module", "consumer"),
 	}
 
-	c.poolCh = make(chan func(), c.workersCount)
-	for i := 0; i < c.workersCount; i++ {
-		go func() {
-			for f := range c.poolCh {
-				f()
-			}
-		}()
-	}
-
+	c.startWorkers(wCount)
 	c.log.Infof("consumer is ready to consume messages from topic %s", c.kafkaTopic)
 
 	return &c
 }
 
+// UseTransactions switches the consumer to transactional mode: partitions
+// resume from the offsets committed for the group and every message is
+// handled in a transaction with its offset. Must be called before Run.
+func (c *Consumer) UseTransactions(txn *Transactor) {
+	c.txn = txn
+}
+
 func (c *Consumer) Run(ctx context.Context) error {
-	config := sarama.NewConfig()
+	config, err := NewSaramaConfig(c.opts)
+	if err != nil {
+		return err
+	}
+
 	config.Consumer.IsolationLevel = sarama.ReadCommitted
 	config.Consumer.Offsets.AutoCommit.Enable = false
 	config.Consumer.Group.Rebalance.GroupStrategies = []sarama.BalanceStrategy{sarama.NewBalanceStrategyRoundRobin()}
 
-	consumer, err := sarama.NewConsumer(c.brokers, config)
+	client, err := sarama.NewClient(c.brokers, config)
+	if err != nil {
+		return err
+	}
+
+	consumer, err := sarama.NewConsumerFromClient(client)
 	if err != nil {
 		return err
 	}
@@ -63,36 +206,377 @@ func (c *Consumer) Run(ctx context.Context) error {
 	}
 
 	for _, partition := range partitions {
-		consumePartition, err := c.consumer.ConsumePartition(c.kafkaTopic, partition, sarama.OffsetNewest)
+		offset := sarama.OffsetNewest
+		if c.txn != nil {
+			if offset, err = c.txn.CommittedOffset(client, c.kafkaTopic, partition); err != nil {
+				c.log.Warnf("committed offset of partition %d is not available %v", partition, err)
+
+				return err
+			}
+		}
+
+		consumePartition, err := c.consumer.ConsumePartition(c.kafkaTopic, partition, offset)
 		if err != nil {
 			c.log.Warnf("partitions are not available %v", err)
 
 			return err
 		}
-		go func(consumePartition sarama.PartitionConsumer) error {
-			defer consumePartition.Close()
 
-			for message := range consumePartition.Messages() {
+		state := newPartitionState(consumePartition)
+
+		c.mu.Lock()
+		c.partitions[partition] = state
+		c.mu.Unlock()
 
-				select {
-				case <-ctx.Done():
+		go func(state *partitionState) error {
+			defer state.pc.Close()
+
+			for message := range state.pc.Messages() {
+				if err := c.limiter.wait(ctx); err != nil {
 					return nil
-				default:
 				}
 
-				c.poolCh <- func() {
+				msg := newMessage(message)
+				enqueued := time.Now()
+
+				c.metrics.incConsumed(msg.Topic, msg.Partition)
+				state.start(msg.Offset)
 
-					if err := c.messageHandler.Handle(ctx, message.Value); err != nil {
-						c.log.Warnf("handling message: %v: %v", string(message.Value), err)
+				task := func() {
+					c.metrics.observeQueueWait(time.Since(enqueued))
+					if c.txn != nil {
+						if !state.isStopped() && c.handleTxn(ctx, msg, state) {
+							state.done(msg.Offset)
+						}
+
+						return
 					}
 
+					// a batching handler finishes msg after the worker has
+					// moved on, the offset counts as processed only then
+					c.handleAsync(ctx, msg, func() {
+						state.done(msg.Offset)
+					})
+				}
+
+				if !c.dispatch(ctx, msg, task) {
+					return nil
 				}
 			}
 			return nil
 
-		}(consumePartition)
+		}(state)
+
+	}
+
+	c.mu.Lock()
+	c.running = true
+	c.mu.Unlock()
+
+	go c.watchLag(ctx)
+
+	return nil
+}
+
+func (c *Consumer) startWorkers(count int) {
+	c.queues = make([]chan func(), count)
+	for i := 0; i < count; i++ {
+		c.queues[i] = make(chan func(), c.queueSize)
+		c.workers.Add(1)
+
+		go func(worker int, queue chan func()) {
+			defer c.workers.Done()
+
+			for f := range queue {
+				c.metrics.setQueueDepth(worker, len(queue))
+				f()
+			}
+		}(i, c.queues[i])
+	}
+
+	c.metrics.setWorkers(count)
+}
+
+func (c *Consumer) dispatch(ctx context.Context, msg models.Message, task func()) bool {
+	c.poolMu.RLock()
+	defer c.poolMu.RUnlock()
+
+	worker := c.workerFor(msg.Key)
+	if c.txn != nil {
+		worker = c.workerForPartition(msg.Partition)
+	}
+
+	select {
+	case <-ctx.Done():
+		return false
+	case c.queues[worker] <- task:
+	}
+
+	c.metrics.setQueueDepth(worker, len(c.queues[worker]))
 
+	return true
+}
+
+// SetWorkers resizes the worker pool. Queued messages are drained by the old
+// workers first, so per-key ordering holds across the resize.
+func (c *Consumer) SetWorkers(count int) error {
+	if count < 1 {
+		return fmt.Errorf("workers count must be positive, got %d", count)
 	}
 
+	c.poolMu.Lock()
+	defer c.poolMu.Unlock()
+
+	for _, queue := range c.queues {
+		close(queue)
+	}
+
+	c.workers.Wait()
+	c.metrics.resetQueueDepth()
+	c.startWorkers(count)
+
+	c.log.Infof("worker pool resized to %d", count)
+
 	return nil
 }
+
+// SetRate limits the number of messages dispatched per second across all
+// partitions, 0 removes the limit.
+func (c *Consumer) SetRate(rate float64) {
+	c.limiter.setRate(rate)
+	c.metrics.setRateLimit(rate)
+
+	c.log.Infof("rate limit set to %v", rate)
+}
+
+// Pause stops fetching from the given partitions, or from all partitions
+// when none are given. Messages already queued are still handled.
+func (c *Consumer) Pause(partitions ...int32) error {
+	return c.setPaused(true, partitions)
+}
+
+// Resume resumes fetching from the given partitions, or from all partitions
+// when none are given.
+func (c *Consumer) Resume(partitions ...int32) error {
+	return c.setPaused(false, partitions)
+}
+
+func (c *Consumer) setPaused(paused bool, partitions []int32) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(partitions) == 0 {
+		for partition := range c.partitions {
+			partitions = append(partitions, partition)
+		}
+	}
+
+	for _, partition := range partitions {
+		if _, ok := c.partitions[partition]; !ok {
+			return fmt.Errorf("%w: %d", models.ErrUnknownPartition, partition)
+		}
+	}
+
+	for _, partition := range partitions {
+		state := c.partitions[partition]
+		if paused {
+			state.pc.Pause()
+		} else {
+			state.pc.Resume()
+		}
+
+		state.paused = paused
+		c.metrics.setPaused(c.kafkaTopic, partition, paused)
+	}
+
+	c.log.Infof("partitions %v paused: %v", partitions, paused)
+
+	return nil
+}
+
+func (c *Consumer) Status() models.ConsumerStatus {
+	c.poolMu.RLock()
+	workers := len(c.queues)
+	c.poolMu.RUnlock()
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	status := models.ConsumerStatus{
+		Topic:      c.kafkaTopic,
+		Running:    c.running,
+		Ready:      c.running && !c.lagging,
+		Workers:    workers,
+		Rate:       c.limiter.getRate(),
+		Partitions: make([]models.PartitionStatus, 0, len(c.partitions)),
+	}
+
+	for partition, state := range c.partitions {
+		hwm := state.pc.HighWaterMarkOffset()
+		status.Partitions = append(status.Partitions, models.PartitionStatus{
+			Partition:     partition,
+			Paused:        state.paused,
+			HighWaterMark: hwm,
+			Lag:           state.lag(),
+		})
+	}
+
+	sort.Slice(status.Partitions, func(i, j int) bool {
+		return status.Partitions[i].Partition < status.Partitions[j].Partition
+	})
+
+	return status
+}
+
+// Ready reports whether the consumer is running and its total lag is within
+// the configured threshold. A zero threshold disables the lag check.
+func (c *Consumer) Ready() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.running && !c.lagging
+}
+
+func (c *Consumer) watchLag(ctx context.Context) {
+	ticker := time.NewTicker(lagRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			c.mu.Lock()
+			c.running = false
+			c.mu.Unlock()
+
+			return
+		case <-ticker.C:
+			c.refreshLag()
+		}
+	}
+}
+
+func (c *Consumer) refreshLag() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var total int64
+
+	for partition, state := range c.partitions {
+		lag := state.lag()
+		c.metrics.setLag(c.kafkaTopic, partition, lag)
+		total += lag
+	}
+
+	lagging := c.maxLag > 0 && total > c.maxLag
+	if lagging != c.lagging {
+		c.log.Warnf("consumer lag %d, threshold %d, lagging: %v", total, c.maxLag, lagging)
+	}
+
+	c.lagging = lagging
+}
+
+// partitionLag returns the number of messages behind the high-water mark.
+// Before the first message is consumed the partition is considered caught up.
+func partitionLag(highWaterMark int64, processed int64) int64 {
+	if processed == noOffset || highWaterMark <= processed+1 {
+		return 0
+	}
+
+	return highWaterMark - processed - 1
+}
+
+func (c *Consumer) handle(ctx context.Context, msg models.Message) error {
+	defer c.metrics.workerBusy()()
+
+	err := c.messageHandler.Handle(ctx, msg)
+	c.handled(msg, err)
+
+	return err
+}
+
+func (c *Consumer) handleAsync(ctx context.Context, msg models.Message, done func()) {
+	defer c.metrics.workerBusy()()
+
+	handleAsync(ctx, c.messageHandler, msg, func(err error) {
+		c.handled(msg, err)
+		done()
+	})
+}
+
+func (c *Consumer) handled(msg models.Message, err error) {
+	c.metrics.incProcessed(msg.Topic, msg.Partition, err)
+
+	if err != nil {
+		c.log.WithFields(logrus.Fields{
+			"partition": msg.Partition,
+			"offset":    msg.Offset,
+			"key":       string(msg.Key),
+		}).Warnf("handling message: %v: %v", string(msg.Value), err)
+	}
+}
+
+// workerForPartition is used in transactional mode: offsets are committed
+// one by one, so a partition is handled in order by a single worker.
+func (c *Consumer) workerForPartition(partition int32) int {
+	return int(partition) % len(c.queues)
+}
+
+// handleTxn handles msg in a transaction with its offset and reports
+// whether it was committed. The handler is called once, its database write
+// is not part of the transaction. When it fails the partition is stopped,
+// so that no later offset is committed past msg, and it is resumed from msg
+// after a restart.
+func (c *Consumer) handleTxn(ctx context.Context, msg models.Message, state *partitionState) bool {
+	err := c.txn.Run(ctx, msg, func(ctx context.Context) error {
+		return c.handle(ctx, msg)
+	})
+	if err == nil {
+		return true
+	}
+
+	if ctx.Err() == nil {
+		c.log.WithFields(logrus.Fields{
+			"partition": msg.Partition,
+			"offset":    msg.Offset,
+		}).Errorf("stopping partition, offset is not committed: %v", err)
+
+		state.stop()
+	}
+
+	return false
+}
+
+// workerFor picks the worker by key hash. Messages without a key carry no
+// ordering requirement and are spread round-robin.
+func (c *Consumer) workerFor(key []byte) int {
+	if len(key) == 0 {
+		return int(atomic.AddUint32(&c.next, 1) % uint32(len(c.queues)))
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write(key)
+
+	return int(h.Sum32() % uint32(len(c.queues)))
+}
+
+func newMessage(message *sarama.ConsumerMessage) models.Message {
+	msg := models.Message{
+		Topic:     message.Topic,
+		Partition: message.Partition,
+		Offset:    message.Offset,
+		Key:       message.Key,
+		Value:     message.Value,
+		Headers:   make(map[string]string, len(message.Headers)),
+		Timestamp: message.Timestamp,
+	}
+
+	for _, header := range message.Headers {
+		if header == nil {
+			continue
+		}
+
+		msg.Headers[string(header.Key)] = string(header.Value)
+	}
+
+	return msg
+}
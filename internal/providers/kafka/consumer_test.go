@@ -0,0 +1,349 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"github.com/zuzi90/tz-enricher/internal/providers/transport"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testMetrics is shared by the tests, the collectors can be registered once.
+var testMetrics = newMetrics()
+
+func newTestConsumer(workers int) *Consumer {
+	c := &Consumer{
+		metrics:    testMetrics,
+		limiter:    newRateLimiter(0),
+		queueSize:  10,
+		partitions: make(map[int32]*partitionState),
+		log:        logrus.New().WithField("module", "consumer"),
+	}
+	c.startWorkers(workers)
+
+	return c
+}
+
+func Test_workerFor(t *testing.T) {
+	c := Consumer{queues: make([]chan func(), 4)}
+
+	t.Run("same key, same worker", func(t *testing.T) {
+		worker := c.workerFor([]byte("frodo"))
+		for i := 0; i < 10; i++ {
+			assert.Equal(t, worker, c.workerFor([]byte("frodo")))
+		}
+	})
+
+	t.Run("no key, round robin", func(t *testing.T) {
+		seen := make(map[int]bool)
+		for i := 0; i < 4; i++ {
+			seen[c.workerFor(nil)] = true
+		}
+
+		assert.Len(t, seen, 4)
+	})
+}
+
+func Test_dispatch(t *testing.T) {
+	ctx := context.Background()
+	c := newTestConsumer(4)
+
+	var mu sync.Mutex
+	handled := make(map[string][]int)
+	workers := make(map[int]bool)
+
+	keys := []string{"frodo", "sam", "merry", "pippin", "bilbo", "gandalf", "aragorn", "legolas"}
+	for i := 0; i < 50; i++ {
+		for _, key := range keys {
+			i, key := i, key
+			workers[c.workerFor([]byte(key))] = true
+
+			task := func() {
+				// Yield so that tasks of a shared queue would interleave if
+				// they were not handled sequentially.
+				time.Sleep(time.Microsecond)
+
+				mu.Lock()
+				handled[key] = append(handled[key], i)
+				mu.Unlock()
+			}
+
+			require.True(t, c.dispatch(ctx, models.Message{Key: []byte(key)}, task))
+		}
+	}
+
+	require.NoError(t, c.SetWorkers(4))
+	assert.Greater(t, len(workers), 1, "keys should be spread over several workers")
+
+	for _, key := range keys {
+		require.Len(t, handled[key], 50, key)
+		for i, got := range handled[key] {
+			assert.Equal(t, i, got, fmt.Sprintf("%s handled out of order", key))
+		}
+	}
+}
+
+func Test_SetWorkers(t *testing.T) {
+	ctx := context.Background()
+	c := newTestConsumer(2)
+
+	var mu sync.Mutex
+	var handled []int
+
+	for i := 0; i < 20; i++ {
+		i := i
+		require.True(t, c.dispatch(ctx, models.Message{Key: []byte("frodo")}, func() {
+			mu.Lock()
+			handled = append(handled, i)
+			mu.Unlock()
+		}))
+
+		if i == 9 {
+			require.NoError(t, c.SetWorkers(3))
+		}
+	}
+
+	require.NoError(t, c.SetWorkers(1))
+	assert.Len(t, c.queues, 1)
+	assert.Len(t, handled, 20)
+	assert.IsIncreasing(t, handled)
+
+	assert.Error(t, c.SetWorkers(0))
+}
+
+func Test_PauseResume(t *testing.T) {
+	c := newTestConsumer(1)
+	consumer := mocks.NewConsumer(t, nil)
+
+	for partition := int32(0); partition < 3; partition++ {
+		consumer.ExpectConsumePartition("FN", partition, sarama.OffsetNewest)
+
+		pc, err := consumer.ConsumePartition("FN", partition, sarama.OffsetNewest)
+		require.NoError(t, err)
+
+		c.partitions[partition] = newPartitionState(pc)
+	}
+
+	t.Cleanup(func() {
+		require.NoError(t, consumer.Close())
+	})
+
+	paused := func() []bool {
+		var res []bool
+		for _, partition := range c.Status().Partitions {
+			assert.Equal(t, partition.Paused, c.partitions[partition.Partition].pc.(*mocks.PartitionConsumer).IsPaused())
+			res = append(res, partition.Paused)
+		}
+
+		return res
+	}
+
+	t.Run("given partitions", func(t *testing.T) {
+		require.NoError(t, c.Pause(0, 2))
+		assert.Equal(t, []bool{true, false, true}, paused())
+
+		require.NoError(t, c.Resume(2))
+		assert.Equal(t, []bool{true, false, false}, paused())
+	})
+
+	t.Run("all partitions", func(t *testing.T) {
+		require.NoError(t, c.Pause())
+		assert.Equal(t, []bool{true, true, true}, paused())
+
+		require.NoError(t, c.Resume())
+		assert.Equal(t, []bool{false, false, false}, paused())
+	})
+
+	t.Run("unknown partition", func(t *testing.T) {
+		err := c.Pause(1, 7)
+		assert.ErrorIs(t, err, models.ErrUnknownPartition)
+		assert.Equal(t, []bool{false, false, false}, paused(), "nothing is paused on error")
+	})
+}
+
+// batchingHandler finishes messages only when flush is called.
+type batchingHandler struct {
+	mu      sync.Mutex
+	pending []func(err error)
+}
+
+func (h *batchingHandler) Handle(_ context.Context, _ models.Message) error {
+	return nil
+}
+
+func (h *batchingHandler) HandleAsync(_ context.Context, _ models.Message, done func(err error)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.pending = append(h.pending, done)
+}
+
+func (h *batchingHandler) flush() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, done := range h.pending {
+		done(nil)
+	}
+
+	h.pending = nil
+}
+
+func Test_handleAsync(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("offsets processed after the batch", func(t *testing.T) {
+		handler := &batchingHandler{}
+		c := newTestConsumer(1)
+		c.messageHandler = handler
+
+		state := newPartitionState(nil)
+
+		for offset := int64(10); offset < 13; offset++ {
+			msg := models.Message{Topic: "FN", Offset: offset}
+			state.start(offset)
+			c.handleAsync(ctx, msg, func() {
+				state.done(msg.Offset)
+			})
+		}
+
+		assert.Equal(t, int64(9), state.processed)
+
+		handler.flush()
+		assert.Equal(t, int64(12), state.processed)
+	})
+
+	t.Run("plain handler done at once", func(t *testing.T) {
+		c := newTestConsumer(1)
+		c.messageHandler = transport.HandlerFunc(func(_ context.Context, _ models.Message) error {
+			return nil
+		})
+
+		called := false
+		c.handleAsync(ctx, models.Message{Topic: "FN"}, func() {
+			called = true
+		})
+
+		assert.True(t, called)
+	})
+}
+
+func Test_handleTxn(t *testing.T) {
+	ctx := context.Background()
+	msg := models.Message{Topic: "FN", Partition: 0, Offset: 41}
+
+	newState := func(t *testing.T) *partitionState {
+		consumer := mocks.NewConsumer(t, nil)
+		consumer.ExpectConsumePartition("FN", 0, sarama.OffsetNewest)
+
+		pc, err := consumer.ConsumePartition("FN", 0, sarama.OffsetNewest)
+		require.NoError(t, err)
+
+		t.Cleanup(func() {
+			require.NoError(t, consumer.Close())
+		})
+
+		return newPartitionState(pc)
+	}
+
+	t.Run("success, committed", func(t *testing.T) {
+		c := newTestConsumer(1)
+		c.messageHandler = transport.HandlerFunc(func(_ context.Context, _ models.Message) error {
+			return nil
+		})
+
+		var producer *txnProducer
+		c.txn, producer = newTestTransactor(t)
+		state := newState(t)
+
+		assert.True(t, c.handleTxn(ctx, msg, state))
+		assert.False(t, state.isStopped())
+		assert.Equal(t, 1, producer.committed)
+	})
+
+	t.Run("failure, partition stopped", func(t *testing.T) {
+		c := newTestConsumer(1)
+
+		calls := 0
+		c.messageHandler = transport.HandlerFunc(func(_ context.Context, _ models.Message) error {
+			calls++
+			return fmt.Errorf("database is down")
+		})
+
+		var producer *txnProducer
+		c.txn, producer = newTestTransactor(t)
+		state := newState(t)
+
+		assert.False(t, c.handleTxn(ctx, msg, state))
+		assert.True(t, state.isStopped())
+		assert.Equal(t, 1, calls, "the handler is not called again")
+		assert.Equal(t, 0, producer.committed)
+	})
+}
+
+func Test_partitionLag(t *testing.T) {
+	t.Run("nothing consumed", func(t *testing.T) {
+		assert.Equal(t, int64(0), partitionLag(100, noOffset))
+	})
+
+	t.Run("caught up", func(t *testing.T) {
+		assert.Equal(t, int64(0), partitionLag(100, 99))
+	})
+
+	t.Run("behind", func(t *testing.T) {
+		assert.Equal(t, int64(40), partitionLag(100, 59))
+	})
+
+	t.Run("from the first offset", func(t *testing.T) {
+		assert.Equal(t, int64(5), partitionLag(5, -1))
+	})
+}
+
+func Test_partitionState(t *testing.T) {
+	state := newPartitionState(nil)
+	assert.Equal(t, noOffset, state.processed)
+
+	for offset := int64(10); offset < 15; offset++ {
+		state.start(offset)
+	}
+
+	assert.Equal(t, int64(9), state.processed)
+
+	t.Run("handled ahead of a lower offset", func(t *testing.T) {
+		state.done(12)
+		state.done(11)
+		assert.Equal(t, int64(9), state.processed)
+	})
+
+	t.Run("gap closed", func(t *testing.T) {
+		state.done(10)
+		assert.Equal(t, int64(12), state.processed)
+	})
+
+	t.Run("offsets skipped by the broker", func(t *testing.T) {
+		state.start(20)
+		state.done(20)
+		state.done(14)
+		assert.Equal(t, int64(12), state.processed)
+
+		state.done(13)
+		assert.Equal(t, int64(20), state.processed)
+		assert.Empty(t, state.inFlight)
+		assert.Empty(t, state.handled)
+	})
+}
+
+func Test_workerForPartition(t *testing.T) {
+	c := Consumer{queues: make([]chan func(), 3)}
+
+	assert.Equal(t, 0, c.workerForPartition(0))
+	assert.Equal(t, 2, c.workerForPartition(2))
+	assert.Equal(t, 1, c.workerForPartition(4))
+}
@@ -0,0 +1,160 @@
+package kafka
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"strconv"
+	"time"
+)
+
+type metrics struct {
+	queueDepth  *prometheus.GaugeVec
+	queueWait   prometheus.Histogram
+	workers     prometheus.Gauge
+	busyWorkers prometheus.Gauge
+	lag         *prometheus.GaugeVec
+	consumed    *prometheus.CounterVec
+	processed   *prometheus.CounterVec
+	failed      *prometheus.CounterVec
+	paused      *prometheus.GaugeVec
+	rateLimit   prometheus.Gauge
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		queueDepth: promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "fn_enricher",
+				Subsystem: "consumer",
+				Name:      "worker_queue_depth",
+				Help:      "number of messages waiting in the worker queue",
+			},
+			[]string{"worker"},
+		),
+		queueWait: promauto.NewHistogram(
+			prometheus.HistogramOpts{
+				Namespace: "fn_enricher",
+				Subsystem: "consumer",
+				Name:      "queue_wait_duration",
+				Help:      "time a message waits in the worker queue before handling",
+			}),
+		workers: promauto.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: "fn_enricher",
+				Subsystem: "consumer",
+				Name:      "workers",
+				Help:      "number of workers in the pool",
+			}),
+		busyWorkers: promauto.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: "fn_enricher",
+				Subsystem: "consumer",
+				Name:      "busy_workers",
+				Help:      "number of workers handling a message",
+			}),
+		lag: promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "fn_enricher",
+				Subsystem: "consumer",
+				Name:      "lag",
+				Help:      "high-water mark minus the next offset to process",
+			},
+			[]string{"topic", "partition"},
+		),
+		consumed: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "fn_enricher",
+				Subsystem: "consumer",
+				Name:      "messages_consumed_total",
+				Help:      "messages read from the partition",
+			},
+			[]string{"topic", "partition"},
+		),
+		processed: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "fn_enricher",
+				Subsystem: "consumer",
+				Name:      "messages_processed_total",
+				Help:      "messages handled successfully",
+			},
+			[]string{"topic", "partition"},
+		),
+		failed: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "fn_enricher",
+				Subsystem: "consumer",
+				Name:      "messages_failed_total",
+				Help:      "messages the handler returned an error for",
+			},
+			[]string{"topic", "partition"},
+		),
+		paused: promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "fn_enricher",
+				Subsystem: "consumer",
+				Name:      "partition_paused",
+				Help:      "1 when fetching from the partition is paused",
+			},
+			[]string{"topic", "partition"},
+		),
+		rateLimit: promauto.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: "fn_enricher",
+				Subsystem: "consumer",
+				Name:      "rate_limit",
+				Help:      "max messages dispatched per second, 0 when unlimited",
+			}),
+	}
+}
+
+func (m *metrics) setQueueDepth(worker int, depth int) {
+	m.queueDepth.WithLabelValues(strconv.Itoa(worker)).Set(float64(depth))
+}
+
+func (m *metrics) observeQueueWait(t time.Duration) {
+	m.queueWait.Observe(t.Seconds())
+}
+
+func (m *metrics) setWorkers(count int) {
+	m.workers.Set(float64(count))
+}
+
+func (m *metrics) workerBusy() func() {
+	m.busyWorkers.Inc()
+
+	return m.busyWorkers.Dec
+}
+
+func (m *metrics) setLag(topic string, partition int32, lag int64) {
+	m.lag.WithLabelValues(topic, strconv.Itoa(int(partition))).Set(float64(lag))
+}
+
+func (m *metrics) incConsumed(topic string, partition int32) {
+	m.consumed.WithLabelValues(topic, strconv.Itoa(int(partition))).Inc()
+}
+
+func (m *metrics) incProcessed(topic string, partition int32, err error) {
+	if err != nil {
+		m.failed.WithLabelValues(topic, strconv.Itoa(int(partition))).Inc()
+		return
+	}
+
+	m.processed.WithLabelValues(topic, strconv.Itoa(int(partition))).Inc()
+}
+
+func (m *metrics) resetQueueDepth() {
+	m.queueDepth.Reset()
+}
+
+func (m *metrics) setPaused(topic string, partition int32, paused bool) {
+	val := 0.0
+	if paused {
+		val = 1
+	}
+
+	m.paused.WithLabelValues(topic, strconv.Itoa(int(partition))).Set(val)
+}
+
+func (m *metrics) setRateLimit(rate float64) {
+	m.rateLimit.Set(rate)
+}
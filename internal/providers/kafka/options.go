@@ -0,0 +1,151 @@
+package kafka
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/IBM/sarama"
+	"github.com/xdg-go/scram"
+	"os"
+	"strings"
+)
+
+const (
+	SASLMechanismPlain       = "PLAIN"
+	SASLMechanismScramSHA256 = "SCRAM-SHA-256"
+	SASLMechanismScramSHA512 = "SCRAM-SHA-512"
+)
+
+// Options holds the connection settings shared by every Kafka client of the
+// service: the FN consumer, the WRONG_FN producer and any future producers.
+type Options struct {
+	ClientID string
+	Version  string
+
+	TLSEnabled    bool
+	TLSCAFile     string
+	TLSCertFile   string
+	TLSKeyFile    string
+	TLSSkipVerify bool
+
+	SASLMechanism string
+	SASLUser      string
+	SASLPassword  string
+}
+
+// NewSaramaConfig returns a sarama config with the connection settings from
+// opts applied. Callers add their consumer or producer specific settings.
+func NewSaramaConfig(opts Options) (*sarama.Config, error) {
+	config := sarama.NewConfig()
+
+	if opts.ClientID != "" {
+		config.ClientID = opts.ClientID
+	}
+
+	if opts.Version != "" {
+		version, err := sarama.ParseKafkaVersion(opts.Version)
+		if err != nil {
+			return nil, fmt.Errorf("kafka version: %w", err)
+		}
+
+		config.Version = version
+	}
+
+	if opts.TLSEnabled {
+		tlsConfig, err := newTLSConfig(opts)
+		if err != nil {
+			return nil, err
+		}
+
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = tlsConfig
+	}
+
+	if opts.SASLMechanism != "" {
+		config.Net.SASL.Enable = true
+		config.Net.SASL.Handshake = true
+		config.Net.SASL.User = opts.SASLUser
+		config.Net.SASL.Password = opts.SASLPassword
+
+		switch strings.ToUpper(opts.SASLMechanism) {
+		case SASLMechanismPlain:
+			config.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+		case SASLMechanismScramSHA256:
+			config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+			config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+				return &scramClient{hashGenerator: sha256.New}
+			}
+		case SASLMechanismScramSHA512:
+			config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+			config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+				return &scramClient{hashGenerator: sha512.New}
+			}
+		default:
+			return nil, fmt.Errorf("unsupported sasl mechanism: %s", opts.SASLMechanism)
+		}
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("kafka config: %w", err)
+	}
+
+	return config, nil
+}
+
+func newTLSConfig(opts Options) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: opts.TLSSkipVerify,
+	}
+
+	if opts.TLSCAFile != "" {
+		ca, err := os.ReadFile(opts.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading kafka ca file: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in kafka ca file %s", opts.TLSCAFile)
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	if opts.TLSCertFile != "" || opts.TLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.TLSCertFile, opts.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading kafka client certificate: %w", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+type scramClient struct {
+	*scram.ClientConversation
+	hashGenerator scram.HashGeneratorFcn
+}
+
+func (c *scramClient) Begin(userName, password, authzID string) error {
+	client, err := c.hashGenerator.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+
+	c.ClientConversation = client.NewConversation()
+
+	return nil
+}
+
+func (c *scramClient) Step(challenge string) (string, error) {
+	return c.ClientConversation.Step(challenge)
+}
+
+func (c *scramClient) Done() bool {
+	return c.ClientConversation.Done()
+}
@@ -0,0 +1,48 @@
+package kafka
+
+import (
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func Test_NewSaramaConfig(t *testing.T) {
+	t.Run("success, defaults", func(t *testing.T) {
+		config, err := NewSaramaConfig(Options{ClientID: "tz-enricher"})
+		require.NoError(t, err)
+		assert.Equal(t, "tz-enricher", config.ClientID)
+		assert.False(t, config.Net.TLS.Enable)
+		assert.False(t, config.Net.SASL.Enable)
+	})
+
+	t.Run("success, scram and version", func(t *testing.T) {
+		config, err := NewSaramaConfig(Options{
+			Version:       "3.6.0",
+			SASLMechanism: "scram-sha-512",
+			SASLUser:      "frodo",
+			SASLPassword:  "ring",
+			TLSEnabled:    true,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, sarama.V3_6_0_0, config.Version)
+		assert.Equal(t, sarama.SASLMechanism(sarama.SASLTypeSCRAMSHA512), config.Net.SASL.Mechanism)
+		assert.NotNil(t, config.Net.SASL.SCRAMClientGeneratorFunc())
+		assert.True(t, config.Net.TLS.Enable)
+	})
+
+	t.Run("failure, unknown mechanism", func(t *testing.T) {
+		_, err := NewSaramaConfig(Options{SASLMechanism: "GSSAPI-LIKE"})
+		assert.Error(t, err)
+	})
+
+	t.Run("failure, invalid version", func(t *testing.T) {
+		_, err := NewSaramaConfig(Options{Version: "latest"})
+		assert.Error(t, err)
+	})
+
+	t.Run("failure, missing ca file", func(t *testing.T) {
+		_, err := NewSaramaConfig(Options{TLSEnabled: true, TLSCAFile: "/nonexistent/ca.pem"})
+		assert.Error(t, err)
+	})
+}
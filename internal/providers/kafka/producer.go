@@ -1,8 +1,10 @@
 package kafka
 
 import (
+	"context"
 	"github.com/IBM/sarama"
 	"github.com/sirupsen/logrus"
+	"github.com/zuzi90/tz-enricher/internal/models"
 	"time"
 )
 
@@ -12,8 +14,12 @@ type Producer struct {
 	log      *logrus.Entry
 }
 
-func NewProducer(brokers []string, topic string, log *logrus.Logger) (*Producer, error) {
-	config := sarama.NewConfig()
+func NewProducer(brokers []string, topic string, opts Options, log *logrus.Logger) (*Producer, error) {
+	config, err := NewSaramaConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
 	config.Producer.RequiredAcks = sarama.WaitForAll
 	config.Producer.Return.Successes = true
 	config.Producer.Retry.Max = 3
@@ -32,10 +38,25 @@ func NewProducer(brokers []string, topic string, log *logrus.Logger) (*Producer,
 	}, nil
 }
 
-func (p *Producer) SendMessage(msg []byte) error {
+// SendMessage sends msg to the producer topic. When ctx carries a
+// transaction the message is added to it and sent on commit.
+func (p *Producer) SendMessage(ctx context.Context, msg models.Message) error {
 	message := &sarama.ProducerMessage{
 		Topic: p.topic,
-		Value: sarama.StringEncoder(msg),
+		Value: sarama.ByteEncoder(msg.Value),
+	}
+
+	if len(msg.Key) > 0 {
+		message.Key = sarama.ByteEncoder(msg.Key)
+	}
+
+	for key, val := range msg.Headers {
+		message.Headers = append(message.Headers, sarama.RecordHeader{Key: []byte(key), Value: []byte(val)})
+	}
+
+	if records, ok := txnFromContext(ctx); ok {
+		records.add(message)
+		return nil
 	}
 
 	_, _, err := p.producer.SendMessage(message)
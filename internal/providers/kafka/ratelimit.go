@@ -0,0 +1,76 @@
+package kafka
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// rateLimiter spaces calls to wait evenly at the configured rate. The rate
+// may be changed at any time and applies to the following calls; zero
+// disables the limit.
+type rateLimiter struct {
+	mu       sync.Mutex
+	rate     float64
+	interval time.Duration
+	next     time.Time
+}
+
+func newRateLimiter(rate float64) *rateLimiter {
+	l := rateLimiter{}
+	l.setRate(rate)
+
+	return &l
+}
+
+func (l *rateLimiter) setRate(rate float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if rate <= 0 {
+		l.rate, l.interval = 0, 0
+		return
+	}
+
+	l.rate = rate
+	l.interval = time.Duration(float64(time.Second) / rate)
+}
+
+func (l *rateLimiter) getRate() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.rate
+}
+
+func (l *rateLimiter) wait(ctx context.Context) error {
+	l.mu.Lock()
+	if l.interval == 0 {
+		l.mu.Unlock()
+		return nil
+	}
+
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+
+	at := l.next
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+
+	delay := time.Until(at)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
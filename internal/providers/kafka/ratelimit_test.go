@@ -0,0 +1,46 @@
+package kafka
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func Test_rateLimiter(t *testing.T) {
+	ctx := context.Background()
+
+	l := newRateLimiter(0)
+
+	t.Run("unlimited", func(t *testing.T) {
+		start := time.Now()
+		for i := 0; i < 100; i++ {
+			require.NoError(t, l.wait(ctx))
+		}
+
+		assert.Less(t, time.Since(start), 50*time.Millisecond)
+	})
+
+	t.Run("limited", func(t *testing.T) {
+		l.setRate(100)
+
+		start := time.Now()
+		for i := 0; i < 6; i++ {
+			require.NoError(t, l.wait(ctx))
+		}
+
+		assert.GreaterOrEqual(t, time.Since(start), 45*time.Millisecond)
+		assert.Equal(t, float64(100), l.getRate())
+	})
+
+	t.Run("cancelled context", func(t *testing.T) {
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+
+		l.setRate(1)
+		_ = l.wait(cancelled)
+
+		assert.Error(t, l.wait(cancelled))
+	})
+}
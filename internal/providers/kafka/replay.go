@@ -0,0 +1,296 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"github.com/IBM/sarama"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type ReplayOptions struct {
+	Topic      string
+	Partitions []int32
+	// FromOffset is used when FromTime is zero. sarama.OffsetOldest starts
+	// from the beginning of the retained log.
+	FromOffset int64
+	FromTime   time.Time
+	// ToTime stops a partition at the first message produced after it. The
+	// replay never goes past the high-water mark seen at start.
+	ToTime time.Time
+	// IdleTimeout stops a partition that delivers nothing for that long
+	// before the high-water mark: the offsets left are transaction markers
+	// or aborted records, which a read-committed consumer never receives.
+	// Zero means DefaultReplayIdleTimeout.
+	IdleTimeout time.Duration
+	// Rate limits handled messages per second across all partitions, 0
+	// disables the limit.
+	Rate             float64
+	ProgressInterval time.Duration
+}
+
+const DefaultReplayIdleTimeout = 5 * time.Second
+
+type PartitionReport struct {
+	Partition  int32 `json:"partition"`
+	FromOffset int64 `json:"fromOffset"`
+	EndOffset  int64 `json:"endOffset"`
+	LastOffset int64 `json:"lastOffset"`
+	Consumed   int64 `json:"consumed"`
+	Failed     int64 `json:"failed"`
+}
+
+type ReplayReport struct {
+	Topic      string             `json:"topic"`
+	Partitions []*PartitionReport `json:"partitions"`
+	Consumed   int64              `json:"consumed"`
+	Failed     int64              `json:"failed"`
+	Duration   string             `json:"duration"`
+}
+
+// Replayer reads a bounded range of a topic and feeds it to a handler,
+// independently of the live consumer.
+type Replayer struct {
+	brokers []string
+	opts    Options
+	handler messageHandler
+	log     *logrus.Entry
+}
+
+func NewReplayer(brokers []string, opts Options, log *logrus.Logger, handler messageHandler) *Replayer {
+	return &Replayer{
+		brokers: brokers,
+		opts:    opts,
+		handler: handler,
+		log:     log.WithField("module", "replayer"),
+	}
+}
+
+func (r *Replayer) Run(ctx context.Context, ro ReplayOptions) (*ReplayReport, error) {
+	started := time.Now()
+
+	config, err := NewSaramaConfig(r.opts)
+	if err != nil {
+		return nil, err
+	}
+
+	config.Consumer.IsolationLevel = sarama.ReadCommitted
+
+	client, err := sarama.NewClient(r.brokers, config)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err := client.Close(); err != nil {
+			r.log.Warnf("closing client: %v", err)
+		}
+	}()
+
+	partitions := ro.Partitions
+	if len(partitions) == 0 {
+		partitions, err = client.Partitions(ro.Topic)
+		if err != nil {
+			return nil, fmt.Errorf("partitions are not available: %w", err)
+		}
+	}
+
+	report := &ReplayReport{Topic: ro.Topic}
+
+	for _, partition := range partitions {
+		pr, err := r.partitionRange(client, ro, partition)
+		if err != nil {
+			return nil, err
+		}
+
+		report.Partitions = append(report.Partitions, pr)
+	}
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err := consumer.Close(); err != nil {
+			r.log.Warnf("closing consumer: %v", err)
+		}
+	}()
+
+	limiter := newRateLimiter(ro.Rate)
+
+	progressCtx, stopProgress := context.WithCancel(ctx)
+	defer stopProgress()
+
+	go r.reportProgress(progressCtx, report, ro.ProgressInterval)
+
+	eg, ctx := errgroup.WithContext(ctx)
+
+	for _, pr := range report.Partitions {
+		pr := pr
+		if pr.FromOffset >= pr.EndOffset {
+			continue
+		}
+
+		eg.Go(func() error {
+			return r.replayPartition(ctx, consumer, limiter, ro, pr)
+		})
+	}
+
+	err = eg.Wait()
+
+	for _, pr := range report.Partitions {
+		report.Consumed += atomic.LoadInt64(&pr.Consumed)
+		report.Failed += atomic.LoadInt64(&pr.Failed)
+	}
+
+	report.Duration = time.Since(started).String()
+
+	return report, err
+}
+
+// partitionRange resolves the first offset to replay and the high-water mark
+// the replay stops at.
+func (r *Replayer) partitionRange(client sarama.Client, ro ReplayOptions, partition int32) (*PartitionReport, error) {
+	end, err := client.GetOffset(ro.Topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return nil, fmt.Errorf("partition %d high-water mark: %w", partition, err)
+	}
+
+	from := ro.FromOffset
+
+	switch {
+	case !ro.FromTime.IsZero():
+		from, err = client.GetOffset(ro.Topic, partition, ro.FromTime.UnixMilli())
+	case from == sarama.OffsetOldest:
+		from, err = client.GetOffset(ro.Topic, partition, sarama.OffsetOldest)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("partition %d start offset: %w", partition, err)
+	}
+
+	// no message at or after FromTime
+	if from < 0 {
+		from = end
+	}
+
+	return &PartitionReport{Partition: partition, FromOffset: from, EndOffset: end, LastOffset: from - 1}, nil
+}
+
+func (r *Replayer) replayPartition(
+	ctx context.Context,
+	consumer sarama.Consumer,
+	limiter *rateLimiter,
+	ro ReplayOptions,
+	pr *PartitionReport,
+) error {
+	pc, err := consumer.ConsumePartition(ro.Topic, pr.Partition, pr.FromOffset)
+	if err != nil {
+		return fmt.Errorf("consume partition %d: %w", pr.Partition, err)
+	}
+
+	defer pc.AsyncClose()
+
+	// the report counts a message once the handler is done with it, a
+	// batching handler may still be writing when the partition ends
+	var pending sync.WaitGroup
+	defer pending.Wait()
+
+	idleTimeout := ro.IdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = DefaultReplayIdleTimeout
+	}
+
+	idle := time.NewTimer(idleTimeout)
+	defer idle.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-idle.C:
+			r.log.WithFields(logrus.Fields{
+				"partition": pr.Partition,
+				"offset":    atomic.LoadInt64(&pr.LastOffset),
+				"end":       pr.EndOffset,
+			}).Infof("no messages for %v, the rest of the partition holds no committed records", idleTimeout)
+
+			return nil
+		case consumerErr := <-pc.Errors():
+			if consumerErr != nil {
+				return consumerErr
+			}
+		case message := <-pc.Messages():
+			if message == nil {
+				return nil
+			}
+
+			if !ro.ToTime.IsZero() && message.Timestamp.After(ro.ToTime) {
+				return nil
+			}
+
+			if err := limiter.wait(ctx); err != nil {
+				return err
+			}
+
+			msg := newMessage(message)
+
+			pending.Add(1)
+			handleAsync(ctx, r.handler, msg, func(err error) {
+				defer pending.Done()
+
+				if err != nil {
+					atomic.AddInt64(&pr.Failed, 1)
+					r.log.WithFields(logrus.Fields{
+						"partition": msg.Partition,
+						"offset":    msg.Offset,
+					}).Warnf("replaying message: %v", err)
+				}
+
+				atomic.AddInt64(&pr.Consumed, 1)
+			})
+
+			atomic.StoreInt64(&pr.LastOffset, message.Offset)
+
+			if message.Offset >= pr.EndOffset-1 {
+				return nil
+			}
+
+			if !idle.Stop() {
+				<-idle.C
+			}
+
+			idle.Reset(idleTimeout)
+		}
+	}
+}
+
+func (r *Replayer) reportProgress(ctx context.Context, report *ReplayReport, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, pr := range report.Partitions {
+				r.log.WithFields(logrus.Fields{
+					"partition": pr.Partition,
+					"offset":    atomic.LoadInt64(&pr.LastOffset),
+					"end":       pr.EndOffset,
+					"consumed":  atomic.LoadInt64(&pr.Consumed),
+					"failed":    atomic.LoadInt64(&pr.Failed),
+				}).Info("replay progress")
+			}
+		}
+	}
+}
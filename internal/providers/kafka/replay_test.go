@@ -0,0 +1,73 @@
+package kafka
+
+import (
+	"context"
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"github.com/zuzi90/tz-enricher/internal/providers/transport"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_replayPartition(t *testing.T) {
+	var handled int64
+
+	r := NewReplayer(nil, Options{}, logrus.New(), transport.HandlerFunc(func(_ context.Context, _ models.Message) error {
+		atomic.AddInt64(&handled, 1)
+		return nil
+	}))
+
+	run := func(t *testing.T, pr *PartitionReport, values ...string) error {
+		consumer := mocks.NewConsumer(t, nil)
+		pc := consumer.ExpectConsumePartition("WRONG_FN", 0, pr.FromOffset)
+
+		for _, value := range values {
+			pc.YieldMessage(&sarama.ConsumerMessage{Value: []byte(value)})
+		}
+
+		t.Cleanup(func() {
+			require.NoError(t, consumer.Close())
+		})
+
+		ro := ReplayOptions{Topic: "WRONG_FN", IdleTimeout: 50 * time.Millisecond}
+
+		return r.replayPartition(context.Background(), consumer, newRateLimiter(0), ro, pr)
+	}
+
+	t.Run("stops at the end offset", func(t *testing.T) {
+		atomic.StoreInt64(&handled, 0)
+		pr := &PartitionReport{FromOffset: 0, EndOffset: 2, LastOffset: -1}
+
+		require.NoError(t, run(t, pr, "a", "b"))
+		assert.Equal(t, int64(1), pr.LastOffset)
+		assert.Equal(t, int64(2), pr.Consumed)
+		assert.Equal(t, int64(2), atomic.LoadInt64(&handled))
+	})
+
+	t.Run("trailing control record", func(t *testing.T) {
+		atomic.StoreInt64(&handled, 0)
+		// offset 2 is the commit marker of the transaction that wrote 0 and 1,
+		// it counts in the high-water mark but is never delivered
+		pr := &PartitionReport{FromOffset: 0, EndOffset: 3, LastOffset: -1}
+
+		done := make(chan error)
+		go func() {
+			done <- run(t, pr, "a", "b")
+		}()
+
+		select {
+		case err := <-done:
+			require.NoError(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("replay did not stop after the last committed record")
+		}
+
+		assert.Equal(t, int64(1), pr.LastOffset)
+		assert.Equal(t, int64(2), atomic.LoadInt64(&handled))
+	})
+}
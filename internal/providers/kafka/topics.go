@@ -0,0 +1,106 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"github.com/IBM/sarama"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"time"
+)
+
+// TopicOptions controls how missing topics are handled at startup.
+type TopicOptions struct {
+	// Create creates missing topics instead of failing.
+	Create            bool
+	Partitions        int32
+	ReplicationFactor int16
+	// Retention sets retention.ms of created topics, 0 keeps the broker
+	// default.
+	Retention time.Duration
+}
+
+// EnsureTopics checks that the topics exist and can be described with the
+// configured credentials, creating missing ones when to.Create is set. The
+// returned error says what to change, so the service fails at startup rather
+// than on the first message. Read and Write access is not checked: a missing
+// Read or Write ACL still shows up only on the first fetch or produce.
+func EnsureTopics(brokers []string, opts Options, to TopicOptions, log *logrus.Logger, topics ...string) error {
+	config, err := NewSaramaConfig(opts)
+	if err != nil {
+		return err
+	}
+
+	admin, err := sarama.NewClusterAdmin(brokers, config)
+	if err != nil {
+		return fmt.Errorf("connecting to kafka brokers %v: %w, check BROKERS and the TLS/SASL settings", brokers, err)
+	}
+
+	defer func() {
+		if err := admin.Close(); err != nil {
+			log.Warnf("closing cluster admin: %v", err)
+		}
+	}()
+
+	metadata, err := admin.DescribeTopics(topics)
+	if err != nil {
+		return fmt.Errorf("describing topics %v: %w", topics, err)
+	}
+
+	entry := log.WithField("module", "topics")
+
+	for _, topic := range metadata {
+		switch {
+		case errors.Is(topic.Err, sarama.ErrNoError):
+			if to.Partitions > 0 && int32(len(topic.Partitions)) < to.Partitions {
+				entry.Warnf("topic %s has %d partitions, %d configured", topic.Name, len(topic.Partitions), to.Partitions)
+			}
+
+			entry.Infof("topic %s: %d partitions", topic.Name, len(topic.Partitions))
+		case errors.Is(topic.Err, sarama.ErrUnknownTopicOrPartition):
+			if !to.Create {
+				return fmt.Errorf("topic %s does not exist, create it or set KAFKA_CREATE_TOPICS=true", topic.Name)
+			}
+
+			if err := createTopic(admin, topic.Name, to); err != nil {
+				return err
+			}
+
+			entry.Infof("topic %s created with %d partitions", topic.Name, to.Partitions)
+		case errors.Is(topic.Err, sarama.ErrTopicAuthorizationFailed):
+			return fmt.Errorf("not authorized to describe topic %s as %q, grant the Describe ACL "+
+				"(the service also needs Read and Write, which are not checked at startup)", topic.Name, opts.SASLUser)
+		default:
+			return fmt.Errorf("topic %s: %w", topic.Name, topic.Err)
+		}
+	}
+
+	return nil
+}
+
+func createTopic(admin sarama.ClusterAdmin, topic string, to TopicOptions) error {
+	err := admin.CreateTopic(topic, topicDetail(to), false)
+
+	switch {
+	case err == nil, errors.Is(err, sarama.ErrTopicAlreadyExists):
+		return nil
+	case errors.Is(err, sarama.ErrTopicAuthorizationFailed), errors.Is(err, sarama.ErrClusterAuthorizationFailed):
+		return fmt.Errorf("not authorized to create topic %s, create it manually or grant the Create ACL: %w", topic, err)
+	default:
+		return fmt.Errorf("creating topic %s: %w", topic, err)
+	}
+}
+
+func topicDetail(to TopicOptions) *sarama.TopicDetail {
+	detail := sarama.TopicDetail{
+		NumPartitions:     to.Partitions,
+		ReplicationFactor: to.ReplicationFactor,
+	}
+
+	if to.Retention > 0 {
+		retention := strconv.FormatInt(to.Retention.Milliseconds(), 10)
+		detail.ConfigEntries = map[string]*string{"retention.ms": &retention}
+	}
+
+	return &detail
+}
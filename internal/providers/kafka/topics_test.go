@@ -0,0 +1,74 @@
+package kafka
+
+import (
+	"github.com/IBM/sarama"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func Test_topicDetail(t *testing.T) {
+	t.Run("broker default retention", func(t *testing.T) {
+		detail := topicDetail(TopicOptions{Partitions: 3, ReplicationFactor: 2})
+
+		assert.Equal(t, int32(3), detail.NumPartitions)
+		assert.Equal(t, int16(2), detail.ReplicationFactor)
+		assert.Nil(t, detail.ConfigEntries)
+	})
+
+	t.Run("retention", func(t *testing.T) {
+		detail := topicDetail(TopicOptions{Partitions: 1, ReplicationFactor: 1, Retention: 72 * time.Hour})
+
+		require.Contains(t, detail.ConfigEntries, "retention.ms")
+		assert.Equal(t, "259200000", *detail.ConfigEntries["retention.ms"])
+	})
+}
+
+func TestEnsureTopics(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetController(broker.BrokerID()).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("FN", 0, broker.BrokerID()),
+		"CreateTopicsRequest": sarama.NewMockCreateTopicsResponse(t),
+	})
+
+	opts := Options{Version: sarama.V2_1_0_0.String()}
+	log := logrus.New()
+
+	t.Run("existing topic", func(t *testing.T) {
+		assert.NoError(t, EnsureTopics([]string{broker.Addr()}, opts, TopicOptions{}, log, "FN"))
+	})
+
+	t.Run("missing topic", func(t *testing.T) {
+		err := EnsureTopics([]string{broker.Addr()}, opts, TopicOptions{}, log, "FN", "WRONG_FN")
+		assert.ErrorContains(t, err, "KAFKA_CREATE_TOPICS")
+	})
+
+	t.Run("missing topic created", func(t *testing.T) {
+		to := TopicOptions{Create: true, Partitions: 1, ReplicationFactor: 1}
+		assert.NoError(t, EnsureTopics([]string{broker.Addr()}, opts, to, log, "FN", "WRONG_FN"))
+	})
+}
+
+func TestEnsureTopics_notAuthorized(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetController(broker.BrokerID()).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetError("FN", sarama.ErrTopicAuthorizationFailed),
+	})
+
+	opts := Options{Version: sarama.V2_1_0_0.String(), SASLUser: "enricher"}
+
+	err := EnsureTopics([]string{broker.Addr()}, opts, TopicOptions{}, logrus.New(), "FN")
+	assert.ErrorContains(t, err, `not authorized to describe topic FN as "enricher"`)
+}
@@ -0,0 +1,237 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"github.com/IBM/sarama"
+	"github.com/sirupsen/logrus"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"sync"
+	"time"
+)
+
+const txnBackoff = time.Second
+
+// txnRecords collects the records sent while a message is processed, they
+// are produced when its transaction is committed.
+type txnRecords struct {
+	mu      sync.Mutex
+	records []*sarama.ProducerMessage
+}
+
+func (r *txnRecords) add(record *sarama.ProducerMessage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.records = append(r.records, record)
+}
+
+type txnKey struct{}
+
+// withTxn attaches records to ctx, so that Producer.SendMessage adds the
+// record to the transaction instead of sending it on its own.
+func withTxn(ctx context.Context, records *txnRecords) context.Context {
+	return context.WithValue(ctx, txnKey{}, records)
+}
+
+func txnFromContext(ctx context.Context) (*txnRecords, bool) {
+	records, ok := ctx.Value(txnKey{}).(*txnRecords)
+	return records, ok
+}
+
+// Transactor runs the processing of a consumed message inside a Kafka
+// transaction that also commits the consumed offset for the group, so a
+// WRONG_FN record and the offset of the message it came from become visible
+// together or not at all.
+//
+// Each input partition gets its own transactional producer with the id
+// <idPrefix>-<topic>-<partition>, so a restarted instance fences off the
+// zombie that owned the partition before it.
+type Transactor struct {
+	brokers  []string
+	opts     Options
+	group    string
+	idPrefix string
+	backoff  time.Duration
+	log      *logrus.Entry
+
+	mu        sync.Mutex
+	producers map[int32]sarama.SyncProducer
+}
+
+func NewTransactor(brokers []string, opts Options, group, idPrefix string, log *logrus.Logger) *Transactor {
+	return &Transactor{
+		brokers:   brokers,
+		opts:      opts,
+		group:     group,
+		idPrefix:  idPrefix,
+		backoff:   txnBackoff,
+		producers: make(map[int32]sarama.SyncProducer),
+		log:       log.WithField("module", "transactor"),
+	}
+}
+
+// Run calls process once with the context collecting the records it sends,
+// then produces them and commits the offset after msg in one transaction.
+// When process fails nothing is produced or committed. A failed transaction
+// is retried with the same records until it commits or ctx is done, process
+// is not called again: its database writes are not part of the transaction.
+func (t *Transactor) Run(ctx context.Context, msg models.Message, process func(ctx context.Context) error) error {
+	records := &txnRecords{}
+
+	if err := process(withTxn(ctx, records)); err != nil {
+		return fmt.Errorf("process message: %w", err)
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := t.commit(msg, records.records)
+		if err == nil {
+			return nil
+		}
+
+		t.log.WithFields(logrus.Fields{
+			"partition": msg.Partition,
+			"offset":    msg.Offset,
+			"attempt":   attempt,
+		}).Warnf("transaction failed: %v", err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(t.backoff * time.Duration(attempt)):
+		}
+	}
+}
+
+func (t *Transactor) commit(msg models.Message, records []*sarama.ProducerMessage) error {
+	producer, err := t.producer(msg.Topic, msg.Partition)
+	if err != nil {
+		return err
+	}
+
+	if err = producer.BeginTxn(); err != nil {
+		t.reset(msg.Partition, producer)
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+
+	if len(records) > 0 {
+		err = producer.SendMessages(records)
+	}
+
+	offsets := map[string][]*sarama.PartitionOffsetMetadata{
+		msg.Topic: {{Partition: msg.Partition, Offset: msg.Offset + 1, LeaderEpoch: -1}},
+	}
+
+	if err == nil {
+		err = producer.AddOffsetsToTxn(offsets, t.group)
+	}
+
+	if err == nil {
+		err = producer.CommitTxn()
+	}
+
+	if err != nil {
+		t.abort(msg.Partition, producer)
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (t *Transactor) abort(partition int32, producer sarama.SyncProducer) {
+	if err := producer.AbortTxn(); err != nil {
+		t.log.Warnf("aborting transaction: %v", err)
+	}
+
+	t.reset(partition, producer)
+}
+
+// CommittedOffset returns the offset to resume the partition from, or
+// sarama.OffsetNewest when the group has not committed one yet.
+func (t *Transactor) CommittedOffset(client sarama.Client, topic string, partition int32) (int64, error) {
+	om, err := sarama.NewOffsetManagerFromClient(t.group, client)
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		if err := om.Close(); err != nil {
+			t.log.Warnf("closing offset manager: %v", err)
+		}
+	}()
+
+	pom, err := om.ManagePartition(topic, partition)
+	if err != nil {
+		return 0, err
+	}
+
+	defer pom.AsyncClose()
+
+	offset, _ := pom.NextOffset()
+
+	return offset, nil
+}
+
+func (t *Transactor) producer(topic string, partition int32) (sarama.SyncProducer, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if producer, ok := t.producers[partition]; ok {
+		return producer, nil
+	}
+
+	config, err := NewSaramaConfig(t.opts)
+	if err != nil {
+		return nil, err
+	}
+
+	config.Producer.Idempotent = true
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Producer.Return.Successes = true
+	config.Producer.Retry.Max = 3
+	config.Producer.Transaction.ID = fmt.Sprintf("%s-%s-%d", t.idPrefix, topic, partition)
+	config.Net.MaxOpenRequests = 1
+
+	producer, err := sarama.NewSyncProducer(t.brokers, config)
+	if err != nil {
+		return nil, fmt.Errorf("transactional producer for partition %d: %w", partition, err)
+	}
+
+	t.producers[partition] = producer
+
+	return producer, nil
+}
+
+// reset drops a producer left in a fatal state, the next transaction for the
+// partition starts with a new one.
+func (t *Transactor) reset(partition int32, producer sarama.SyncProducer) {
+	if producer.TxnStatus()&sarama.ProducerTxnFlagFatalError == 0 {
+		return
+	}
+
+	t.mu.Lock()
+	delete(t.producers, partition)
+	t.mu.Unlock()
+
+	if err := producer.Close(); err != nil {
+		t.log.Warnf("closing transactional producer: %v", err)
+	}
+}
+
+func (t *Transactor) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var err error
+
+	for partition, producer := range t.producers {
+		if closeErr := producer.Close(); closeErr != nil {
+			t.log.Warnf("closing transactional producer for partition %d: %v", partition, closeErr)
+			err = closeErr
+		}
+
+		delete(t.producers, partition)
+	}
+
+	return err
+}
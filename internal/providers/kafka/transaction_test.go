@@ -0,0 +1,137 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"testing"
+	"time"
+)
+
+// txnProducer records how the transactions of the mock producer ended,
+// the first commitErrs commits fail.
+type txnProducer struct {
+	*mocks.SyncProducer
+	offsets    map[string][]*sarama.PartitionOffsetMetadata
+	committed  int
+	aborted    int
+	commitErrs int
+}
+
+func (p *txnProducer) AddOffsetsToTxn(offsets map[string][]*sarama.PartitionOffsetMetadata, groupID string) error {
+	p.offsets = offsets
+	return p.SyncProducer.AddOffsetsToTxn(offsets, groupID)
+}
+
+func (p *txnProducer) CommitTxn() error {
+	if p.commitErrs > 0 {
+		p.commitErrs--
+		return sarama.ErrOutOfBrokers
+	}
+
+	p.committed++
+	return p.SyncProducer.CommitTxn()
+}
+
+func (p *txnProducer) AbortTxn() error {
+	p.aborted++
+	return p.SyncProducer.AbortTxn()
+}
+
+func newTestTransactor(t *testing.T) (*Transactor, *txnProducer) {
+	t.Helper()
+
+	config := mocks.NewTestConfig()
+	config.Version = sarama.V2_8_0_0
+	config.Producer.Idempotent = true
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Producer.Return.Successes = true
+	config.Net.MaxOpenRequests = 1
+	config.Producer.Transaction.ID = "tz-enricher-FN-0"
+
+	producer := &txnProducer{SyncProducer: mocks.NewSyncProducer(t, config)}
+	t.Cleanup(func() {
+		require.NoError(t, producer.Close())
+	})
+
+	txn := NewTransactor(nil, Options{}, "tz-enricher", "tz-enricher", logrus.New())
+	txn.producers[0] = producer
+	txn.backoff = time.Millisecond
+
+	return txn, producer
+}
+
+func Test_TransactorRun(t *testing.T) {
+	ctx := context.Background()
+	msg := models.Message{Topic: "FN", Partition: 0, Offset: 41}
+
+	t.Run("success, offset committed", func(t *testing.T) {
+		txn, producer := newTestTransactor(t)
+		producer.ExpectSendMessageAndSucceed()
+
+		err := txn.Run(ctx, msg, func(ctx context.Context) error {
+			p := &Producer{topic: "WRONG_FN", log: txn.log}
+			return p.SendMessage(ctx, models.Message{Value: []byte("wrong fn")})
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, producer.committed)
+		assert.Equal(t, 0, producer.aborted)
+		require.Len(t, producer.offsets["FN"], 1)
+		assert.Equal(t, int64(42), producer.offsets["FN"][0].Offset)
+	})
+
+	t.Run("success, commit retried without processing again", func(t *testing.T) {
+		txn, producer := newTestTransactor(t)
+		producer.commitErrs = 2
+		// the record is sent again in every attempt
+		for i := 0; i < 3; i++ {
+			producer.ExpectSendMessageAndSucceed()
+		}
+
+		calls := 0
+		err := txn.Run(ctx, msg, func(ctx context.Context) error {
+			calls++
+			p := &Producer{topic: "WRONG_FN", log: txn.log}
+			return p.SendMessage(ctx, models.Message{Value: []byte("wrong fn")})
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, calls)
+		assert.Equal(t, 1, producer.committed)
+		assert.Equal(t, 2, producer.aborted)
+	})
+
+	t.Run("failure, process error", func(t *testing.T) {
+		txn, producer := newTestTransactor(t)
+		processErr := errors.New("schema registry is down")
+
+		err := txn.Run(ctx, msg, func(ctx context.Context) error {
+			return processErr
+		})
+		assert.ErrorIs(t, err, processErr)
+
+		assert.Equal(t, 0, producer.committed)
+		assert.Nil(t, producer.offsets, "offset must not be committed")
+		assert.Equal(t, sarama.ProducerTxnFlagReady, producer.TxnStatus())
+	})
+
+	t.Run("failure, canceled while retrying", func(t *testing.T) {
+		txn, producer := newTestTransactor(t)
+		producer.commitErrs = 1
+
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		err := txn.Run(ctx, msg, func(ctx context.Context) error {
+			return nil
+		})
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, 0, producer.committed)
+	})
+}
@@ -0,0 +1,203 @@
+package schemaregistry
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const contentType = "application/vnd.schemaregistry.v1+json"
+
+const (
+	TypeAvro     = "AVRO"
+	TypeProtobuf = "PROTOBUF"
+	TypeJSON     = "JSON"
+)
+
+var ErrNotFound = errors.New("schema registry: not found")
+var ErrNotConfigured = errors.New("schema registry: url is not configured")
+
+type Schema struct {
+	ID         int    `json:"id"`
+	Subject    string `json:"subject,omitempty"`
+	Version    int    `json:"version,omitempty"`
+	SchemaType string `json:"schemaType,omitempty"`
+	Schema     string `json:"schema"`
+}
+
+// Reference points to a schema imported by another one.
+type Reference struct {
+	Name    string `json:"name"`
+	Subject string `json:"subject"`
+	Version int    `json:"version"`
+}
+
+// registerRequest is the body of a registration. It carries no id: the
+// registry takes an explicit id for an import and rejects it on a subject
+// in READWRITE mode.
+type registerRequest struct {
+	Schema     string      `json:"schema"`
+	SchemaType string      `json:"schemaType,omitempty"`
+	References []Reference `json:"references,omitempty"`
+}
+
+type Client struct {
+	client   *http.Client
+	log      *logrus.Entry
+	url      string
+	user     string
+	password string
+
+	mu      sync.RWMutex
+	schemas map[int]Schema
+}
+
+func NewClient(log *logrus.Logger, registryURL, user, password string) *Client {
+	return &Client{
+		client:   &http.Client{Timeout: 5 * time.Second},
+		log:      log.WithField("module", "schema_registry"),
+		url:      strings.TrimRight(registryURL, "/"),
+		user:     user,
+		password: password,
+		schemas:  make(map[int]Schema),
+	}
+}
+
+// GetSchema returns the schema registered under id. Schemas are immutable in
+// the registry, so successful lookups are cached for the client lifetime.
+func (c *Client) GetSchema(ctx context.Context, id int) (Schema, error) {
+	c.mu.RLock()
+	schema, ok := c.schemas[id]
+	c.mu.RUnlock()
+
+	if ok {
+		return schema, nil
+	}
+
+	if err := c.do(ctx, http.MethodGet, "/schemas/ids/"+strconv.Itoa(id), nil, &schema); err != nil {
+		return Schema{}, fmt.Errorf("get schema %d: %w", id, err)
+	}
+
+	schema.ID = id
+	if schema.SchemaType == "" {
+		schema.SchemaType = TypeAvro
+	}
+
+	c.mu.Lock()
+	c.schemas[id] = schema
+	c.mu.Unlock()
+
+	return schema, nil
+}
+
+func (c *Client) GetLatestSchema(ctx context.Context, subject string) (Schema, error) {
+	schema := Schema{}
+
+	path := "/subjects/" + url.PathEscape(subject) + "/versions/latest"
+	if err := c.do(ctx, http.MethodGet, path, nil, &schema); err != nil {
+		return Schema{}, fmt.Errorf("get latest schema %s: %w", subject, err)
+	}
+
+	if schema.SchemaType == "" {
+		schema.SchemaType = TypeAvro
+	}
+
+	c.mu.Lock()
+	c.schemas[schema.ID] = schema
+	c.mu.Unlock()
+
+	return schema, nil
+}
+
+// Register registers schema under subject and returns its id. Registering a
+// schema that already exists returns the existing id.
+func (c *Client) Register(ctx context.Context, subject string, schemaType string, schema string) (int, error) {
+	req := registerRequest{Schema: schema}
+	if schemaType != TypeAvro {
+		req.SchemaType = schemaType
+	}
+
+	resp := Schema{}
+
+	path := "/subjects/" + url.PathEscape(subject) + "/versions"
+	if err := c.do(ctx, http.MethodPost, path, req, &resp); err != nil {
+		return 0, fmt.Errorf("register schema %s: %w", subject, err)
+	}
+
+	c.mu.Lock()
+	c.schemas[resp.ID] = Schema{ID: resp.ID, Subject: subject, SchemaType: schemaType, Schema: schema}
+	c.mu.Unlock()
+
+	c.log.Infof("schema registered, subject: %s, id: %d", subject, resp.ID)
+
+	return resp.ID, nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body interface{}, result interface{}) error {
+	if c.url == "" {
+		return ErrNotConfigured
+	}
+
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.url+path, reqBody)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", contentType)
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	if c.user != "" {
+		req.SetBasicAuth(c.user, c.password)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("schema registry: %w", err)
+	}
+
+	defer func() {
+		if err = resp.Body.Close(); err != nil {
+			c.log.Warnf("closing response body err: %v", err)
+		}
+	}()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		c.log.Warnf("unexpected status code %d", resp.StatusCode)
+		return fmt.Errorf("response status code: %d: %s", resp.StatusCode, string(data))
+	}
+
+	return json.Unmarshal(data, result)
+}
@@ -0,0 +1,149 @@
+package schemaregistry
+
+import (
+	"context"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	return NewClient(logrus.New(), srv.URL+"/", "user", "secret")
+}
+
+func Test_GetSchema(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("success, cached", func(t *testing.T) {
+		var calls int32
+
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+
+			user, password, ok := r.BasicAuth()
+			assert.True(t, ok)
+			assert.Equal(t, "user", user)
+			assert.Equal(t, "secret", password)
+			assert.Equal(t, contentType, r.Header.Get("Accept"))
+			assert.Equal(t, "/schemas/ids/7", r.URL.Path)
+
+			_, _ = w.Write([]byte(`{"schema":"\"string\""}`))
+		})
+
+		for i := 0; i < 2; i++ {
+			schema, err := c.GetSchema(ctx, 7)
+			require.NoError(t, err)
+			assert.Equal(t, Schema{ID: 7, SchemaType: TypeAvro, Schema: `"string"`}, schema)
+		}
+
+		assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	})
+
+	t.Run("failure, not found", func(t *testing.T) {
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			http.NotFound(w, r)
+		})
+
+		_, err := c.GetSchema(ctx, 7)
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("failure, server error", func(t *testing.T) {
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "boom", http.StatusInternalServerError)
+		})
+
+		_, err := c.GetSchema(ctx, 7)
+		assert.ErrorContains(t, err, "response status code: 500")
+	})
+
+	t.Run("failure, not configured", func(t *testing.T) {
+		c := NewClient(logrus.New(), "", "", "")
+
+		_, err := c.GetSchema(ctx, 7)
+		assert.ErrorIs(t, err, ErrNotConfigured)
+	})
+}
+
+func Test_GetLatestSchema(t *testing.T) {
+	ctx := context.Background()
+
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/subjects/WRONG_FN-value/versions/latest", r.URL.Path)
+
+		_, _ = w.Write([]byte(`{"id":3,"subject":"WRONG_FN-value","version":2,"schemaType":"PROTOBUF","schema":"syntax = \"proto3\";"}`))
+	})
+
+	schema, err := c.GetLatestSchema(ctx, "WRONG_FN-value")
+	require.NoError(t, err)
+	assert.Equal(t, 3, schema.ID)
+	assert.Equal(t, 2, schema.Version)
+	assert.Equal(t, TypeProtobuf, schema.SchemaType)
+
+	cached, err := c.GetSchema(ctx, 3)
+	require.NoError(t, err)
+	assert.Equal(t, schema, cached)
+}
+
+func Test_Register(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("success, avro", func(t *testing.T) {
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			json := jsoniter.ConfigCompatibleWithStandardLibrary
+
+			assert.Equal(t, http.MethodPost, r.Method)
+			assert.Equal(t, "/subjects/WRONG_FN-value/versions", r.URL.Path)
+			assert.Equal(t, contentType, r.Header.Get("Content-Type"))
+
+			req := map[string]interface{}{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			assert.Equal(t, map[string]interface{}{"schema": `"string"`}, req)
+
+			_, _ = w.Write([]byte(`{"id":5}`))
+		})
+
+		id, err := c.Register(ctx, "WRONG_FN-value", TypeAvro, `"string"`)
+		require.NoError(t, err)
+		assert.Equal(t, 5, id)
+
+		schema, err := c.GetSchema(ctx, 5)
+		require.NoError(t, err)
+		assert.Equal(t, Schema{ID: 5, Subject: "WRONG_FN-value", SchemaType: TypeAvro, Schema: `"string"`}, schema)
+	})
+
+	t.Run("success, protobuf sends schema type", func(t *testing.T) {
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			json := jsoniter.ConfigCompatibleWithStandardLibrary
+
+			req := map[string]interface{}{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			assert.Equal(t, map[string]interface{}{"schema": `syntax = "proto3";`, "schemaType": TypeProtobuf}, req)
+
+			_, _ = w.Write([]byte(`{"id":6}`))
+		})
+
+		id, err := c.Register(ctx, "WRONG_FN-value", TypeProtobuf, `syntax = "proto3";`)
+		require.NoError(t, err)
+		assert.Equal(t, 6, id)
+	})
+
+	t.Run("failure, conflict", func(t *testing.T) {
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, `{"error_code":409}`, http.StatusConflict)
+		})
+
+		_, err := c.Register(ctx, "WRONG_FN-value", TypeAvro, `"string"`)
+		assert.ErrorContains(t, err, "response status code: 409")
+	})
+}
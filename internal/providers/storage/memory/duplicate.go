@@ -0,0 +1,181 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"github.com/zuzi90/tz-enricher/internal/providers/storage"
+	"sort"
+	"time"
+)
+
+type candidate struct {
+	similarity float64
+	detectedAt time.Time
+	dismissed  bool
+}
+
+func fullName(user *models.User) string {
+	return user.Name + " " + user.Surname + " " + user.Patronymic
+}
+
+// DetectDuplicates records the pairs of users whose full names have at least
+// the given trigram similarity and returns how many pairs were found.
+// Dismissed pairs stay dismissed.
+func (s *Storage) DetectDuplicates(_ context.Context, threshold float64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	active := make([]*models.User, 0, len(s.users))
+	for _, user := range s.users {
+		if !user.IsDeleted {
+			active = append(active, user)
+		}
+	}
+
+	now := time.Now()
+
+	var found int64
+
+	for i, a := range active {
+		for _, b := range active[i+1:] {
+			similarity := storage.Similarity(fullName(a), fullName(b))
+			if similarity < threshold {
+				continue
+			}
+
+			pair := models.NewPair(a.ID, b.ID)
+
+			c, ok := s.candidates[pair]
+			if !ok {
+				c = &candidate{}
+				s.candidates[pair] = c
+			}
+
+			c.similarity, c.detectedAt = similarity, now
+			found++
+		}
+	}
+
+	return found, nil
+}
+
+// GetDuplicates returns the pairs to review, most similar first. Pairs with
+// a deleted user are skipped.
+func (s *Storage) GetDuplicates(_ context.Context, params models.GetDuplicatesParams) ([]*models.DuplicateCandidate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	candidates := make([]*models.DuplicateCandidate, 0)
+
+	for pair, c := range s.candidates {
+		user, duplicate := s.users[pair.UserID], s.users[pair.DuplicateID]
+
+		if c.dismissed || c.similarity < params.MinSimilarity || user.IsDeleted || duplicate.IsDeleted {
+			continue
+		}
+
+		candidates = append(candidates, &models.DuplicateCandidate{
+			DuplicatePair: pair,
+			Similarity:    c.similarity,
+			DetectedAt:    c.detectedAt,
+			User:          clone(user),
+			Duplicate:     clone(duplicate),
+		})
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+
+		switch {
+		case a.Similarity != b.Similarity:
+			return a.Similarity > b.Similarity
+		case a.UserID != b.UserID:
+			return a.UserID < b.UserID
+		default:
+			return a.DuplicateID < b.DuplicateID
+		}
+	})
+
+	if params.Offset >= len(candidates) {
+		return candidates[:0], nil
+	}
+
+	candidates = candidates[params.Offset:]
+	if params.Limit < len(candidates) {
+		candidates = candidates[:params.Limit]
+	}
+
+	return candidates, nil
+}
+
+// DismissDuplicate marks the pair as not a duplicate, it is not listed
+// again.
+func (s *Storage) DismissDuplicate(_ context.Context, pair models.DuplicatePair) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.candidates[pair]
+	if !ok {
+		return models.ErrUserNotFound
+	}
+
+	c.dismissed = true
+
+	return nil
+}
+
+// MergeUser merges the duplicate into the survivor: blank fields of the
+// survivor are filled from the duplicate, the duplicate is soft-deleted with
+// merged_into set, both changes are recorded in the history and the
+// candidate pairs of the duplicate are dropped.
+func (s *Storage) MergeUser(ctx context.Context, survivorID, duplicateID int) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	survivor, duplicate := s.users[survivorID], s.users[duplicateID]
+	if survivor == nil || duplicate == nil || survivor.IsDeleted || duplicate.IsDeleted {
+		return nil, models.ErrUserNotFound
+	}
+
+	now := time.Now()
+
+	duplicate.IsDeleted, duplicate.DeletedAt = true, &now
+	duplicate.DeletedReason = fmt.Sprintf("merged into %d", survivorID)
+	duplicate.MergedInto = &survivorID
+	duplicate.Version++
+
+	if survivor.Surname == "" {
+		survivor.Surname = duplicate.Surname
+	}
+
+	if survivor.Patronymic == "" {
+		survivor.Patronymic = duplicate.Patronymic
+	}
+
+	if survivor.Gender == "" {
+		survivor.Gender, survivor.GenderProbability = duplicate.Gender, duplicate.GenderProbability
+	}
+
+	if survivor.Nationality == "" {
+		survivor.Nationality, survivor.NationalityProbability = duplicate.Nationality, duplicate.NationalityProbability
+	}
+
+	if survivor.Age == 0 {
+		survivor.Age = duplicate.Age
+	}
+
+	survivor.UpdatedAt = now
+	survivor.Version++
+
+	s.record(ctx, models.OperationMerge, duplicate, now)
+	s.record(ctx, models.OperationMerge, survivor, now)
+
+	for pair := range s.candidates {
+		if pair.UserID == duplicateID || pair.DuplicateID == duplicateID {
+			delete(s.candidates, pair)
+		}
+	}
+
+	return clone(survivor), nil
+}
@@ -0,0 +1,59 @@
+package memory
+
+import (
+	"context"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"time"
+)
+
+// record appends the state of the changed user to its history, the caller
+// holds the write lock.
+func (s *Storage) record(ctx context.Context, op models.Operation, user *models.User, at time.Time) {
+	s.lastVersion++
+
+	s.versions[user.ID] = append(s.versions[user.ID], &models.UserVersion{
+		ID:        s.lastVersion,
+		Operation: op,
+		Source:    models.ChangeSourceFrom(ctx),
+		ChangedAt: at,
+		User:      *user,
+	})
+}
+
+// GetUserHistory returns the versions of the user, deleted or not, oldest
+// first.
+func (s *Storage) GetUserHistory(_ context.Context, id int) ([]*models.UserVersion, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stored := s.versions[id]
+	if len(stored) == 0 {
+		return nil, models.ErrUserNotFound
+	}
+
+	versions := make([]*models.UserVersion, 0, len(stored))
+
+	for _, version := range stored {
+		c := *version
+		versions = append(versions, &c)
+	}
+
+	return versions, nil
+}
+
+// GetUserAsOf returns the user as it was at the given time. A user deleted
+// by then is returned with IsDeleted set.
+func (s *Storage) GetUserAsOf(_ context.Context, id int, at time.Time) (*models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stored := s.versions[id]
+
+	for i := len(stored) - 1; i >= 0; i-- {
+		if !stored[i].ChangedAt.After(at) {
+			return clone(&stored[i].User), nil
+		}
+	}
+
+	return nil, models.ErrUserNotFound
+}
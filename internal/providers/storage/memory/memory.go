@@ -0,0 +1,360 @@
+// Package memory is a storage kept in process memory, for local runs and
+// tests without Postgres. Its data is lost on restart.
+package memory
+
+import (
+	"context"
+	"fmt"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Storage struct {
+	mu          sync.RWMutex
+	users       map[int]*models.User
+	lastID      int
+	versions    map[int][]*models.UserVersion
+	lastVersion int64
+	candidates  map[models.DuplicatePair]*candidate
+}
+
+func NewStorage() *Storage {
+	return &Storage{
+		users:      make(map[int]*models.User),
+		versions:   make(map[int][]*models.UserVersion),
+		candidates: make(map[models.DuplicatePair]*candidate),
+	}
+}
+
+func (s *Storage) CloseDB() error {
+	return nil
+}
+
+// clone copies the user, so that callers never share the stored one.
+// Pointer fields are replaced, never changed in place, and are shared.
+func clone(user *models.User) *models.User {
+	c := *user
+	return &c
+}
+
+func (s *Storage) CreateUser(ctx context.Context, val models.UserCreate) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return clone(s.insert(ctx, val, time.Now())), nil
+}
+
+// CreateUsers inserts the users and returns them in the same order.
+func (s *Storage) CreateUsers(ctx context.Context, vals []models.UserCreate) ([]*models.User, error) {
+	if len(vals) == 0 {
+		return nil, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	users := make([]*models.User, 0, len(vals))
+
+	for _, val := range vals {
+		users = append(users, clone(s.insert(ctx, val, now)))
+	}
+
+	return users, nil
+}
+
+func (s *Storage) insert(ctx context.Context, val models.UserCreate, now time.Time) *models.User {
+	s.lastID++
+
+	user := &models.User{
+		ID:                     s.lastID,
+		Name:                   val.Name,
+		Surname:                val.Surname,
+		Patronymic:             val.Patronymic,
+		Age:                    val.Age,
+		Gender:                 val.Gender,
+		Nationality:            val.Nationality,
+		CreatedAt:              now,
+		UpdatedAt:              now,
+		GenderProbability:      copyFloat(val.GenderProbability),
+		NationalityProbability: copyFloat(val.NationalityProbability),
+		Version:                1,
+		Provenance:             val.Provenance,
+	}
+
+	if val.ProducedAt != nil {
+		producedAt := *val.ProducedAt
+		user.ProducedAt = &producedAt
+	}
+
+	s.users[user.ID] = user
+	s.record(ctx, models.OperationCreate, user, now)
+
+	return user
+}
+
+func copyFloat(f *float64) *float64 {
+	if f == nil {
+		return nil
+	}
+
+	c := *f
+
+	return &c
+}
+
+func (s *Storage) GetUser(_ context.Context, id int) (*models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[id]
+	if !ok || user.IsDeleted {
+		return nil, models.ErrUserNotFound
+	}
+
+	return clone(user), nil
+}
+
+// UpdateUser applies the set fields of user. With versions given, the user
+// is updated only if its current version is one of them, otherwise
+// ErrVersionMismatch is returned.
+func (s *Storage) UpdateUser(ctx context.Context, val models.UserUpdate, id int, versions []int) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok || user.IsDeleted {
+		return nil, models.ErrNoRows
+	}
+
+	if len(versions) > 0 && !containsInt(versions, user.Version) {
+		return nil, models.ErrVersionMismatch
+	}
+
+	if val.Name != nil {
+		user.Name = *val.Name
+	}
+
+	if val.Surname != nil {
+		user.Surname = *val.Surname
+	}
+
+	if val.Patronymic != nil {
+		user.Patronymic = *val.Patronymic
+	}
+
+	if val.Age != nil {
+		user.Age = *val.Age
+	}
+
+	if val.Gender != nil {
+		user.Gender = *val.Gender
+	}
+
+	if val.Nationality != nil {
+		user.Nationality = *val.Nationality
+	}
+
+	now := time.Now()
+	user.UpdatedAt = now
+	user.Version++
+
+	s.record(ctx, models.OperationUpdate, user, now)
+
+	return clone(user), nil
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Storage) DeleteUser(ctx context.Context, id int, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok || user.IsDeleted {
+		return models.ErrUserNotFound
+	}
+
+	now := time.Now()
+	user.IsDeleted, user.DeletedAt, user.DeletedReason = true, &now, reason
+	user.Version++
+
+	s.record(ctx, models.OperationDelete, user, now)
+
+	return nil
+}
+
+// RestoreUser undoes DeleteUser, ErrUserNotFound is returned when the user
+// does not exist or is not deleted.
+func (s *Storage) RestoreUser(ctx context.Context, id int) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok || !user.IsDeleted {
+		return nil, models.ErrUserNotFound
+	}
+
+	now := time.Now()
+	user.IsDeleted, user.DeletedAt, user.DeletedReason = false, nil, ""
+	user.UpdatedAt = now
+	user.Version++
+
+	s.record(ctx, models.OperationRestore, user, now)
+
+	return clone(user), nil
+}
+
+// PurgeUser permanently removes the user, deleted or not, and its history.
+func (s *Storage) PurgeUser(_ context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[id]; !ok {
+		return models.ErrUserNotFound
+	}
+
+	s.remove(id)
+
+	return nil
+}
+
+// PurgeDeleted permanently removes users soft-deleted before the given time
+// with their history and returns their ids.
+func (s *Storage) PurgeDeleted(_ context.Context, before time.Time) ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]int, 0)
+
+	for id, user := range s.users {
+		if user.IsDeleted && user.DeletedAt != nil && user.DeletedAt.Before(before) {
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Ints(ids)
+
+	for _, id := range ids {
+		s.remove(id)
+	}
+
+	return ids, nil
+}
+
+// remove drops the user with everything referring to it, as the foreign
+// keys of the Postgres schema do.
+func (s *Storage) remove(id int) {
+	delete(s.users, id)
+	delete(s.versions, id)
+
+	for pair := range s.candidates {
+		if pair.UserID == id || pair.DuplicateID == id {
+			delete(s.candidates, pair)
+		}
+	}
+
+	for _, user := range s.users {
+		if user.MergedInto != nil && *user.MergedInto == id {
+			user.MergedInto = nil
+		}
+	}
+}
+
+// ExportUsers calls fn for every user selected by params in id order. The
+// users are copied first, fn is called without holding the lock.
+func (s *Storage) ExportUsers(ctx context.Context, params models.ExportParams, fn func(user *models.User) error) error {
+	s.mu.RLock()
+	selected := s.selectUsers(params.Text, params.Match, params.UserFilter)
+	s.mu.RUnlock()
+
+	sort.Slice(selected, func(i, j int) bool {
+		return selected[i].user.ID < selected[j].user.ID
+	})
+
+	for _, r := range selected {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := fn(r.user); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetStats counts the users selected by params grouped by params.Dimension.
+// Age groups are returned in order, empty ones included, other groups by
+// count descending.
+func (s *Storage) GetStats(_ context.Context, params models.StatsParams) (*models.Stats, error) {
+	var key func(user *models.User) string
+
+	switch params.Dimension {
+	case models.StatsNationality:
+		key = func(user *models.User) string { return strings.ToUpper(user.Nationality) }
+	case models.StatsGender:
+		key = func(user *models.User) string { return strings.ToLower(user.Gender) }
+	case models.StatsAge:
+		key = func(user *models.User) string {
+			return models.AgeBucketLabel(params.AgeBuckets, ageBucket(params.AgeBuckets, user.Age))
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown statistics dimension %q", models.ErrInvalidParam, params.Dimension)
+	}
+
+	s.mu.RLock()
+	selected := s.selectUsers(params.Text, params.Match, params.UserFilter)
+	s.mu.RUnlock()
+
+	counts := make(map[string]int64)
+	for _, r := range selected {
+		counts[key(r.user)]++
+	}
+
+	stats := models.Stats{Dimension: params.Dimension, Groups: make([]models.StatsGroup, 0, len(counts))}
+
+	if params.Dimension == models.StatsAge {
+		for i := 0; i <= len(params.AgeBuckets); i++ {
+			label := models.AgeBucketLabel(params.AgeBuckets, i)
+			stats.Groups = append(stats.Groups, models.StatsGroup{Key: label, Count: counts[label]})
+		}
+	} else {
+		for k, count := range counts {
+			stats.Groups = append(stats.Groups, models.StatsGroup{Key: k, Count: count})
+		}
+
+		sort.Slice(stats.Groups, func(i, j int) bool {
+			a, b := stats.Groups[i], stats.Groups[j]
+			if a.Count != b.Count {
+				return a.Count > b.Count
+			}
+
+			return a.Key < b.Key
+		})
+	}
+
+	stats.Total = int64(len(selected))
+
+	return &stats, nil
+}
+
+// ageBucket is width_bucket of age over the ascending buckets: the number
+// of bounds not above it.
+func ageBucket(buckets []int, age int) int {
+	return sort.Search(len(buckets), func(i int) bool {
+		return buckets[i] > age
+	})
+}
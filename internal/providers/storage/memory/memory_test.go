@@ -0,0 +1,13 @@
+package memory
+
+import (
+	"github.com/zuzi90/tz-enricher/internal/providers/storage"
+	"github.com/zuzi90/tz-enricher/internal/providers/storage/storagetest"
+	"testing"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return NewStorage()
+	})
+}
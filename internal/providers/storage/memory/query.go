@@ -0,0 +1,315 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"github.com/zuzi90/tz-enricher/internal/providers/storage"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ranked is a selected user with its search similarity.
+type ranked struct {
+	user *models.User
+	rank float64
+}
+
+// selectUsers returns copies of the users of the list endpoint selected by
+// the filter and text, the caller holds the lock.
+func (s *Storage) selectUsers(text string, match models.MatchMode, f models.UserFilter) []ranked {
+	selected := make([]ranked, 0)
+
+	for _, user := range s.users {
+		if !matchesFilter(user, f) {
+			continue
+		}
+
+		r := ranked{user: user}
+
+		if text != "" {
+			var ok bool
+			if ok, r.rank = matchesText(user, match, text); !ok {
+				continue
+			}
+		}
+
+		r.user = clone(user)
+		selected = append(selected, r)
+	}
+
+	return selected
+}
+
+func (s *Storage) GetUsers(_ context.Context, params models.GetUsersParams) (*models.UsersPage, error) {
+	s.mu.RLock()
+	selected := s.selectUsers(params.Text, params.Match, params.UserFilter)
+	s.mu.RUnlock()
+
+	page := models.UsersPage{Items: make([]*models.User, 0)}
+
+	if params.Total == models.TotalExact || params.Total == models.TotalEstimate {
+		total := int64(len(selected))
+		page.Total = &total
+	}
+
+	keys := storage.SortKeys(params)
+	spec := storage.SortSpec(keys)
+	offset := params.Offset
+	rankedSearch := isRanked(params.Text, params.Match)
+
+	if rankedSearch {
+		spec = "rank," + spec
+	}
+
+	sort.Slice(selected, func(i, j int) bool {
+		if rankedSearch && selected[i].rank != selected[j].rank {
+			return selected[i].rank > selected[j].rank
+		}
+
+		return compareUsers(selected[i].user, selected[j].user, keys) < 0
+	})
+
+	keyset := params.Cursor != nil && !rankedSearch
+
+	switch {
+	case params.Cursor != nil && params.Cursor.Sort != spec:
+		return nil, fmt.Errorf("%w: cursor does not match the requested order", models.ErrInvalidParam)
+	case keyset && len(params.Cursor.Values) != len(keys)-1:
+		return nil, fmt.Errorf("%w: malformed cursor", models.ErrInvalidParam)
+	case keyset:
+		position, err := cursorUser(keys, params.Cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		// the first user after the cursor position
+		offset = sort.Search(len(selected), func(i int) bool {
+			return compareUsers(selected[i].user, position, keys) > 0
+		})
+	case params.Cursor != nil:
+		// ranked search results have no stable key to continue from
+		offset = params.Cursor.Offset
+	}
+
+	if offset > len(selected) {
+		offset = len(selected)
+	}
+
+	for _, r := range selected[offset:] {
+		// one extra user tells whether there is a next page
+		if len(page.Items) > params.Limit {
+			break
+		}
+
+		page.Items = append(page.Items, r.user)
+	}
+
+	if len(page.Items) > params.Limit {
+		page.Items = page.Items[:params.Limit]
+
+		cursor := models.Cursor{Sort: spec, Offset: offset + params.Limit}
+		if !rankedSearch {
+			cursor = storage.NewCursor(keys, spec, page.Items[len(page.Items)-1])
+		}
+
+		page.Cursor = cursor.Encode()
+	}
+
+	return &page, nil
+}
+
+// isRanked tells whether the search results are ordered by similarity,
+// which is done for fuzzy search.
+func isRanked(text string, match models.MatchMode) bool {
+	return text != "" && match != models.MatchExact && match != models.MatchPrefix
+}
+
+func matchesFilter(user *models.User, f models.UserFilter) bool {
+	switch f.Deleted {
+	case models.DeletedInclude:
+	case models.DeletedOnly:
+		if !user.IsDeleted {
+			return false
+		}
+	default:
+		if user.IsDeleted {
+			return false
+		}
+	}
+
+	switch {
+	case f.MinAge != nil && user.Age < *f.MinAge,
+		f.MaxAge != nil && user.Age > *f.MaxAge,
+		f.Gender != "" && !strings.EqualFold(user.Gender, f.Gender),
+		len(f.Nationalities) > 0 && !containsFold(f.Nationalities, user.Nationality),
+		f.CreatedFrom != nil && user.CreatedAt.Before(*f.CreatedFrom),
+		f.CreatedTo != nil && user.CreatedAt.After(*f.CreatedTo),
+		f.UpdatedFrom != nil && user.UpdatedAt.Before(*f.UpdatedFrom),
+		f.UpdatedTo != nil && user.UpdatedAt.After(*f.UpdatedTo),
+		f.HasPatronymic != nil && *f.HasPatronymic != (user.Patronymic != ""):
+		return false
+	}
+
+	if f.MinConfidence != nil {
+		confidence, ok := leastProbability(user)
+		if !ok || confidence < *f.MinConfidence {
+			return false
+		}
+	}
+
+	return true
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// leastProbability is the lower of the resolved probabilities, unresolved
+// ones are skipped as LEAST does with NULL.
+func leastProbability(user *models.User) (float64, bool) {
+	switch g, n := user.GenderProbability, user.NationalityProbability; {
+	case g != nil && n != nil:
+		if *g < *n {
+			return *g, true
+		}
+
+		return *n, true
+	case g != nil:
+		return *g, true
+	case n != nil:
+		return *n, true
+	default:
+		return 0, false
+	}
+}
+
+// matchesText matches text against the name columns as the ILIKE and
+// trigram conditions of the Postgres storage do, and returns the rank of
+// fuzzy matches.
+func matchesText(user *models.User, mode models.MatchMode, text string) (bool, float64) {
+	columns := []string{user.Name, user.Surname, user.Patronymic}
+	lower := strings.ToLower(text)
+
+	switch mode {
+	case models.MatchExact:
+		for _, column := range columns {
+			if strings.EqualFold(column, text) {
+				return true, 0
+			}
+		}
+
+		return false, 0
+	case models.MatchPrefix:
+		for _, column := range columns {
+			if strings.HasPrefix(strings.ToLower(column), lower) {
+				return true, 0
+			}
+		}
+
+		return false, 0
+	default:
+		matched, rank := false, 0.0
+
+		for _, column := range columns {
+			similarity := storage.Similarity(column, text)
+
+			if strings.Contains(strings.ToLower(column), lower) || similarity >= storage.SimilarityThreshold {
+				matched = true
+			}
+
+			if similarity > rank {
+				rank = similarity
+			}
+		}
+
+		return matched, rank
+	}
+}
+
+// compareUsers orders users by keys, the last of which is id.
+func compareUsers(a, b *models.User, keys []storage.SortKey) int {
+	for _, key := range keys {
+		c := compareColumn(a, b, key.Column)
+		if key.Desc {
+			c = -c
+		}
+
+		if c != 0 {
+			return c
+		}
+	}
+
+	return 0
+}
+
+func compareColumn(a, b *models.User, column string) int {
+	switch column {
+	case "age":
+		return compareInt(a.Age, b.Age)
+	case "created_at":
+		return a.CreatedAt.Compare(b.CreatedAt)
+	case "updated_at":
+		return a.UpdatedAt.Compare(b.UpdatedAt)
+	case "id":
+		return compareInt(a.ID, b.ID)
+	default:
+		return strings.Compare(storage.SortValue(a, column), storage.SortValue(b, column))
+	}
+}
+
+func compareInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// cursorUser returns a user with the sort columns set to the cursor
+// position, to be compared with compareUsers.
+func cursorUser(keys []storage.SortKey, cursor *models.Cursor) (*models.User, error) {
+	user := &models.User{ID: cursor.ID}
+
+	for i, key := range keys[:len(keys)-1] {
+		val := cursor.Values[i]
+
+		var err error
+
+		switch key.Column {
+		case "name":
+			user.Name = val
+		case "surname":
+			user.Surname = val
+		case "patronymic":
+			user.Patronymic = val
+		case "gender":
+			user.Gender = val
+		case "nationality":
+			user.Nationality = val
+		case "age":
+			user.Age, err = strconv.Atoi(val)
+		case "created_at":
+			user.CreatedAt, err = time.Parse(time.RFC3339Nano, val)
+		case "updated_at":
+			user.UpdatedAt, err = time.Parse(time.RFC3339Nano, val)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("%w: malformed cursor", models.ErrInvalidParam)
+		}
+	}
+
+	return user, nil
+}
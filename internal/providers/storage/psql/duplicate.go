@@ -0,0 +1,176 @@
+package psql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"strconv"
+)
+
+// fullName is the normalized full name of the users row aliased as alias,
+// matching the expression of users_full_name_trgm_idx.
+func fullName(alias string) string {
+	return `lower(` + alias + `.name || ' ' || COALESCE(` + alias + `.surname, '') || ' ' || COALESCE(` + alias + `.patronymic, ''))`
+}
+
+// DetectDuplicates records the pairs of users whose full names have at least
+// the given trigram similarity and returns how many pairs were found.
+// Dismissed pairs stay dismissed.
+func (s *Storage) DetectDuplicates(ctx context.Context, threshold float64) (int64, error) {
+	query := `INSERT INTO duplicate_candidates (user_id, duplicate_id, similarity)
+			 SELECT a.id, b.id, similarity(` + fullName("a") + `, ` + fullName("b") + `)
+			 FROM users a JOIN users b ON a.id < b.id AND ` + fullName("a") + ` % ` + fullName("b") + `
+			 WHERE a.is_deleted = false AND b.is_deleted = false
+			   AND similarity(` + fullName("a") + `, ` + fullName("b") + `) >= $1
+			 ON CONFLICT (user_id, duplicate_id) DO UPDATE SET similarity = EXCLUDED.similarity, detected_at = NOW()`
+
+	var found int64
+
+	// % matches pairs above pg_trgm.similarity_threshold, 0.3 by default, so
+	// it is set to threshold for lower thresholds to take effect
+	settings := map[string]string{
+		"pg_trgm.similarity_threshold": strconv.FormatFloat(threshold, 'f', -1, 64),
+		"statement_timeout":            noStatementTimeout["statement_timeout"],
+	}
+
+	err := s.inTx(ctx, nil, settings, func(tx *sqlx.Tx) error {
+		result, err := tx.ExecContext(ctx, query, threshold)
+		if err != nil {
+			return err
+		}
+
+		found, err = result.RowsAffected()
+
+		return err
+	})
+
+	return found, err
+}
+
+// GetDuplicates returns the pairs to review, most similar first. Pairs with
+// a deleted user are skipped.
+func (s *Storage) GetDuplicates(ctx context.Context, params models.GetDuplicatesParams) ([]*models.DuplicateCandidate, error) {
+	candidates := make([]*models.DuplicateCandidate, 0)
+
+	query := `SELECT c.user_id, c.duplicate_id, c.similarity, c.detected_at
+			 FROM duplicate_candidates c
+			 JOIN users a ON a.id = c.user_id AND a.is_deleted = false
+			 JOIN users b ON b.id = c.duplicate_id AND b.is_deleted = false
+			 WHERE c.dismissed = false AND c.similarity >= $1
+			 ORDER BY c.similarity DESC, c.user_id, c.duplicate_id
+			 LIMIT $2 OFFSET $3`
+
+	err := s.db.SelectContext(ctx, &candidates, query, params.MinSimilarity, params.Limit, params.Offset)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(candidates) == 0 {
+		return candidates, nil
+	}
+
+	ids := make([]int, 0, 2*len(candidates))
+	for _, c := range candidates {
+		ids = append(ids, c.UserID, c.DuplicateID)
+	}
+
+	users := make([]*models.User, 0, len(ids))
+
+	err = s.db.SelectContext(ctx, &users, `SELECT `+userColumns+` FROM users WHERE id = ANY($1)`, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[int]*models.User, len(users))
+	for _, user := range users {
+		byID[user.ID] = user
+	}
+
+	for _, c := range candidates {
+		c.User, c.Duplicate = byID[c.UserID], byID[c.DuplicateID]
+	}
+
+	return candidates, nil
+}
+
+// DismissDuplicate marks the pair as not a duplicate, it is not listed
+// again.
+func (s *Storage) DismissDuplicate(ctx context.Context, pair models.DuplicatePair) error {
+	result, err := s.db.ExecContext(ctx, `UPDATE duplicate_candidates SET dismissed = true
+			 WHERE user_id = $1 AND duplicate_id = $2`, pair.UserID, pair.DuplicateID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return models.ErrUserNotFound
+	}
+
+	return nil
+}
+
+// MergeUser merges the duplicate into the survivor in one statement: blank
+// fields of the survivor are filled from the duplicate, the duplicate is
+// soft-deleted with merged_into set, both changes are recorded in the
+// history and the candidate pairs of the duplicate are dropped.
+func (s *Storage) MergeUser(ctx context.Context, survivorID, duplicateID int) (*models.User, error) {
+	var user models.User
+
+	// fill takes the column from the duplicate when the survivor has none
+	fill := func(column string) string {
+		return column + ` = CASE WHEN COALESCE(s.` + column + `, '') = '' THEN dup.` + column + ` ELSE s.` + column + ` END`
+	}
+
+	// fillWith is fill for a column resolved together with its probability
+	fillWith := func(column, probability string) string {
+		return fill(column) + `, ` + probability + ` = CASE WHEN COALESCE(s.` + column + `, '') = ''
+			     THEN dup.` + probability + ` ELSE s.` + probability + ` END`
+	}
+
+	query := `WITH dup AS (
+			     UPDATE users SET is_deleted = true, deleted_at = NOW(), deleted_reason = $3, merged_into = $1,
+			                      version = version + 1
+			     WHERE id = $2 AND is_deleted = false
+			       AND EXISTS (SELECT 1 FROM users WHERE id = $1 AND is_deleted = false)
+			     RETURNING *
+			 ),
+			 survivor AS (
+			     UPDATE users s SET ` + fill("surname") + `, ` + fill("patronymic") + `,
+			                        ` + fillWith("gender", "gender_probability") + `,
+			                        ` + fillWith("nationality", "nationality_probability") + `,
+			                        age = CASE WHEN s.age = 0 THEN dup.age ELSE s.age END,
+			                        updated_at = NOW(), version = s.version + 1
+			     FROM dup WHERE s.id = $1
+			     RETURNING s.*
+			 ),
+			 version AS (
+			     INSERT INTO user_versions (user_id, operation, source, data)
+			     SELECT id, $4, $5, to_jsonb(dup) FROM dup
+			     UNION ALL
+			     SELECT id, $4, $5, to_jsonb(survivor) FROM survivor
+			 ),
+			 cleared AS (
+			     DELETE FROM duplicate_candidates WHERE $2 IN (user_id, duplicate_id)
+			 )
+			 SELECT ` + userColumns + ` FROM survivor`
+
+	err := s.db.GetContext(ctx, &user, query, survivorID, duplicateID, fmt.Sprintf("merged into %d", survivorID),
+		string(models.OperationMerge), string(models.ChangeSourceFrom(ctx)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrUserNotFound
+		}
+
+		return nil, err
+	}
+
+	return &user, nil
+}
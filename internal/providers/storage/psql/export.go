@@ -0,0 +1,52 @@
+package psql
+
+import (
+	"context"
+	"database/sql"
+	"github.com/jmoiron/sqlx"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"strconv"
+)
+
+// exportBatch is the number of rows fetched from the export cursor at once.
+const exportBatch = 1000
+
+// ExportUsers calls fn for every user selected by params in id order. Rows
+// are read through a server side cursor in batches of exportBatch, so only
+// one batch is held in memory. An error returned by fn stops the export.
+func (s *Storage) ExportUsers(ctx context.Context, params models.ExportParams, fn func(user *models.User) error) error {
+	where, _, args := usersWhere(params.Text, params.Match, params.UserFilter)
+
+	return s.export(ctx, where, args, fn)
+}
+
+// export calls fn for every user selected by where in id order. The
+// statement timeout does not apply, the cursor may stay open for long.
+func (s *Storage) export(ctx context.Context, where string, args []interface{}, fn func(user *models.User) error) error {
+	return s.inTx(ctx, &sql.TxOptions{ReadOnly: true}, noStatementTimeout, func(tx *sqlx.Tx) error {
+		_, err := tx.ExecContext(ctx, `DECLARE users_export NO SCROLL CURSOR FOR SELECT `+userColumns+` FROM users`+where+` ORDER BY id`, args...)
+		if err != nil {
+			return err
+		}
+
+		fetch := `FETCH FORWARD ` + strconv.Itoa(exportBatch) + ` FROM users_export`
+
+		for {
+			batch := make([]*models.User, 0, exportBatch)
+
+			if err = tx.SelectContext(ctx, &batch, fetch); err != nil {
+				return err
+			}
+
+			for _, user := range batch {
+				if err = fn(user); err != nil {
+					return err
+				}
+			}
+
+			if len(batch) < exportBatch {
+				return nil
+			}
+		}
+	})
+}
@@ -0,0 +1,72 @@
+package psql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"strconv"
+	"time"
+)
+
+// versioned wraps a statement changing users, without its RETURNING clause,
+// so that every changed row is also written to user_versions in the same
+// statement. The query returns the changed users.
+func versioned(ctx context.Context, statement string, op models.Operation, args []interface{}) (string, []interface{}) {
+	args = append(args, string(op), string(models.ChangeSourceFrom(ctx)))
+	n := len(args)
+
+	query := `WITH changed AS (` + statement + ` RETURNING *),
+			 version AS (
+			     INSERT INTO user_versions (user_id, operation, source, data)
+			     SELECT id, $` + strconv.Itoa(n-1) + `, $` + strconv.Itoa(n) + `, to_jsonb(changed) FROM changed
+			 )
+			 SELECT ` + userColumns + ` FROM changed`
+
+	return query, args
+}
+
+// versionsQuery selects user_versions with the snapshots expanded into the
+// users columns.
+const versionsQuery = `SELECT version_id, operation, change_source, changed_at, ` + userColumns + ` FROM (
+			     SELECT v.id AS version_id, v.operation, v.source AS change_source, v.changed_at,
+			            (jsonb_populate_record(NULL::users, v.data)).*
+			     FROM user_versions v WHERE v.user_id = $1
+			 ) versions`
+
+// GetUserHistory returns the versions of the user, deleted or not, oldest
+// first.
+func (s *Storage) GetUserHistory(ctx context.Context, id int) ([]*models.UserVersion, error) {
+	versions := make([]*models.UserVersion, 0)
+
+	query := versionsQuery + ` ORDER BY changed_at, version_id`
+
+	if err := s.db.SelectContext(ctx, &versions, query, id); err != nil {
+		return nil, err
+	}
+
+	if len(versions) == 0 {
+		return nil, models.ErrUserNotFound
+	}
+
+	return versions, nil
+}
+
+// GetUserAsOf returns the user as it was at the given time. A user deleted
+// by then is returned with IsDeleted set.
+func (s *Storage) GetUserAsOf(ctx context.Context, id int, at time.Time) (*models.User, error) {
+	var version models.UserVersion
+
+	query := versionsQuery + ` WHERE changed_at <= $2 ORDER BY changed_at DESC, version_id DESC LIMIT 1`
+
+	err := s.db.GetContext(ctx, &version, query, id, at)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrUserNotFound
+		}
+
+		return nil, err
+	}
+
+	return &version.User, nil
+}
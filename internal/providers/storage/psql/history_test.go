@@ -0,0 +1,18 @@
+package psql
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"testing"
+)
+
+func Test_versioned(t *testing.T) {
+	ctx := models.WithChangeSource(context.Background(), models.ChangeREST)
+
+	query, args := versioned(ctx, `UPDATE users SET age = $1 WHERE id = $2`, models.OperationUpdate, []interface{}{42, 7})
+
+	assert.Contains(t, query, `WITH changed AS (UPDATE users SET age = $1 WHERE id = $2 RETURNING *)`)
+	assert.Contains(t, query, `SELECT id, $3, $4, to_jsonb(changed) FROM changed`)
+	assert.Equal(t, []interface{}{42, 7, "update", "rest"}, args)
+}
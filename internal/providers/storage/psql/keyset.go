@@ -0,0 +1,69 @@
+package psql
+
+import (
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"github.com/zuzi90/tz-enricher/internal/providers/storage"
+	"strconv"
+	"strings"
+)
+
+// sortColumns maps the columns of the sort whitelist to their SQL types,
+// used to cast cursor values back.
+var sortColumns = map[string]string{
+	"id":          "int",
+	"name":        "varchar",
+	"surname":     "varchar",
+	"patronymic":  "varchar",
+	"age":         "int",
+	"gender":      "varchar",
+	"nationality": "varchar",
+	"created_at":  "timestamptz",
+	"updated_at":  "timestamptz",
+}
+
+// sortExpr is the ordering expression of key. Nullable text columns are
+// coalesced, so that row comparisons in keysetCondition never meet a NULL.
+func sortExpr(key storage.SortKey) string {
+	if sortColumns[key.Column] == "varchar" {
+		return `COALESCE(` + key.Column + `, '')`
+	}
+
+	return key.Column
+}
+
+// keysetCondition selects rows after the cursor position. The last key is
+// always id, its value is taken from Cursor.ID:
+//
+//	(k1 > v1) OR (k1 = v1 AND k2 > v2) OR ...
+func keysetCondition(keys []storage.SortKey, cursor *models.Cursor, args []interface{}) (string, []interface{}) {
+	params := make([]string, len(keys))
+
+	for i, key := range keys {
+		if i < len(cursor.Values) && i < len(keys)-1 {
+			args = append(args, cursor.Values[i])
+		} else {
+			args = append(args, strconv.Itoa(cursor.ID))
+		}
+
+		params[i] = `$` + strconv.Itoa(len(args)) + `::` + sortColumns[key.Column]
+	}
+
+	ors := make([]string, 0, len(keys))
+
+	for i, key := range keys {
+		ands := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			ands = append(ands, sortExpr(keys[j])+` = `+params[j])
+		}
+
+		op := ` > `
+		if key.Desc {
+			op = ` < `
+		}
+
+		ands = append(ands, sortExpr(key)+op+params[i])
+		ors = append(ors, `(`+strings.Join(ands, ` AND `)+`)`)
+	}
+
+	return `(` + strings.Join(ors, ` OR `) + `)`, args
+}
@@ -0,0 +1,33 @@
+package psql
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"github.com/zuzi90/tz-enricher/internal/providers/storage"
+	"testing"
+)
+
+func Test_keysetCondition(t *testing.T) {
+	t.Run("by id", func(t *testing.T) {
+		cond, args := keysetCondition([]storage.SortKey{{Column: "id"}}, &models.Cursor{ID: 7}, nil)
+
+		assert.Equal(t, `((id > $1::int))`, cond)
+		assert.Equal(t, []interface{}{"7"}, args)
+	})
+
+	t.Run("descending text column", func(t *testing.T) {
+		keys := []storage.SortKey{{Column: "surname", Desc: true}, {Column: "id", Desc: true}}
+		cond, args := keysetCondition(keys, &models.Cursor{Values: []string{"Baggins"}, ID: 7}, []interface{}{18})
+
+		assert.Equal(t, `((COALESCE(surname, '') < $2::varchar) OR `+
+			`(COALESCE(surname, '') = $2::varchar AND id < $3::int))`, cond)
+		assert.Equal(t, []interface{}{18, "Baggins", "7"}, args)
+	})
+}
+
+func Test_planRows(t *testing.T) {
+	rows, err := planRows(`[{"Plan": {"Node Type": "Seq Scan", "Plan Rows": 1520}}]`)
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1520), rows)
+}
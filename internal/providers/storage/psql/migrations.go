@@ -0,0 +1,204 @@
+package psql
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"github.com/pressly/goose/v3"
+	"github.com/pressly/goose/v3/lock"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+)
+
+//go:embed migrations/*.sql
+var embedMigrations embed.FS
+
+// migrator returns the goose provider of the embedded migrations, it has
+// its own connection without the statement timeout and must be closed. The
+// session lock keeps several instances from migrating at once.
+func (s *Storage) migrator() (*goose.Provider, error) {
+	fsys, err := fs.Sub(embedMigrations, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	locker, err := lock.NewPostgresSessionLocker()
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("pgx", s.dbDSN)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := goose.NewProvider(goose.DialectPostgres, db, fsys, goose.WithSessionLocker(locker))
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// MigrateUp applies all pending migrations.
+func (s *Storage) MigrateUp(ctx context.Context) ([]models.Migration, error) {
+	p, err := s.migrator()
+	if err != nil {
+		return nil, err
+	}
+
+	defer p.Close()
+
+	results, err := p.Up(ctx)
+
+	return migrationResults(results), err
+}
+
+// MigrateDown rolls back the last applied migration.
+func (s *Storage) MigrateDown(ctx context.Context) ([]models.Migration, error) {
+	p, err := s.migrator()
+	if err != nil {
+		return nil, err
+	}
+
+	defer p.Close()
+
+	result, err := p.Down(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return migrationResults([]*goose.MigrationResult{result}), nil
+}
+
+// MigrateTo applies or rolls back migrations until the schema is at the
+// given version, 0 rolls back everything.
+func (s *Storage) MigrateTo(ctx context.Context, version int64) ([]models.Migration, error) {
+	p, err := s.migrator()
+	if err != nil {
+		return nil, err
+	}
+
+	defer p.Close()
+
+	current, err := p.GetDBVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*goose.MigrationResult
+
+	switch {
+	case version == current:
+		return nil, nil
+	case version > current:
+		results, err = p.UpTo(ctx, version)
+	default:
+		results, err = p.DownTo(ctx, version)
+	}
+
+	return migrationResults(results), err
+}
+
+// MigrateRedo rolls back the last applied migration and applies it again.
+func (s *Storage) MigrateRedo(ctx context.Context) ([]models.Migration, error) {
+	p, err := s.migrator()
+	if err != nil {
+		return nil, err
+	}
+
+	defer p.Close()
+
+	down, err := p.Down(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	up, err := p.ApplyVersion(ctx, down.Source.Version, true)
+	if err != nil {
+		return migrationResults([]*goose.MigrationResult{down}), err
+	}
+
+	return migrationResults([]*goose.MigrationResult{down, up}), nil
+}
+
+// MigrationStatus returns the migrations of the service, oldest first.
+func (s *Storage) MigrationStatus(ctx context.Context) ([]models.Migration, error) {
+	p, err := s.migrator()
+	if err != nil {
+		return nil, err
+	}
+
+	defer p.Close()
+
+	statuses, err := p.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]models.Migration, 0, len(statuses))
+
+	for _, status := range statuses {
+		m := models.Migration{
+			Version: status.Source.Version,
+			Name:    migrationName(status.Source),
+			Applied: status.State == goose.StateApplied,
+		}
+
+		if m.Applied {
+			appliedAt := status.AppliedAt
+			m.AppliedAt = &appliedAt
+		}
+
+		migrations = append(migrations, m)
+	}
+
+	return migrations, nil
+}
+
+// CheckSchema returns models.ErrSchemaOutdated when some of the migrations
+// of the service are not applied.
+func (s *Storage) CheckSchema(ctx context.Context) error {
+	migrations, err := s.MigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+
+	var pending []string
+
+	for _, m := range migrations {
+		if !m.Applied {
+			pending = append(pending, strconv.FormatInt(m.Version, 10))
+		}
+	}
+
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: migrations %s are not applied, run migrate up", models.ErrSchemaOutdated, strings.Join(pending, ", "))
+	}
+
+	return nil
+}
+
+func migrationResults(results []*goose.MigrationResult) []models.Migration {
+	migrations := make([]models.Migration, 0, len(results))
+
+	for _, result := range results {
+		migrations = append(migrations, models.Migration{
+			Version: result.Source.Version,
+			Name:    migrationName(result.Source),
+			Applied: result.Direction == "up",
+		})
+	}
+
+	return migrations
+}
+
+// migrationName is the file name of the migration without the extension.
+func migrationName(source *goose.Source) string {
+	return strings.TrimSuffix(path.Base(source.Path), ".sql")
+}
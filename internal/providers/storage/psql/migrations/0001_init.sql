@@ -14,4 +14,10 @@ CREATE TABLE users
     updated_at  timestamptz NOT NULL DEFAULT NOW()
 );
 
--- +goose StatementEnd
\ No newline at end of file
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS users;
+
+-- +goose StatementEnd
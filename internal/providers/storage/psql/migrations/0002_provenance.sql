@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users
+    ADD COLUMN message_id     varchar NOT NULL DEFAULT '',
+    ADD COLUMN source         varchar NOT NULL DEFAULT '',
+    ADD COLUMN correlation_id varchar NOT NULL DEFAULT '',
+    ADD COLUMN produced_at    timestamptz;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users
+    DROP COLUMN IF EXISTS message_id,
+    DROP COLUMN IF EXISTS source,
+    DROP COLUMN IF EXISTS correlation_id,
+    DROP COLUMN IF EXISTS produced_at;
+
+-- +goose StatementEnd
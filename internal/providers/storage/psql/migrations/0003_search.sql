@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS users_name_trgm_idx       ON users USING gin (name gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS users_surname_trgm_idx    ON users USING gin (surname gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS users_patronymic_trgm_idx ON users USING gin (patronymic gin_trgm_ops);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS users_name_trgm_idx;
+DROP INDEX IF EXISTS users_surname_trgm_idx;
+DROP INDEX IF EXISTS users_patronymic_trgm_idx;
+
+-- pg_trgm is kept, it may be used outside of the service
+
+-- +goose StatementEnd
@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users
+    ADD COLUMN gender_probability      real,
+    ADD COLUMN nationality_probability real;
+
+CREATE INDEX IF NOT EXISTS users_age_idx         ON users (age);
+CREATE INDEX IF NOT EXISTS users_nationality_idx ON users (upper(nationality));
+CREATE INDEX IF NOT EXISTS users_created_at_idx  ON users (created_at);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS users_age_idx;
+DROP INDEX IF EXISTS users_nationality_idx;
+DROP INDEX IF EXISTS users_created_at_idx;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS gender_probability,
+    DROP COLUMN IF EXISTS nationality_probability;
+
+-- +goose StatementEnd
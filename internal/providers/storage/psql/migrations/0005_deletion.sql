@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users
+    ADD COLUMN deleted_at     timestamptz,
+    ADD COLUMN deleted_reason varchar(255) NOT NULL DEFAULT '';
+
+UPDATE users SET deleted_at = updated_at WHERE is_deleted = true;
+
+CREATE INDEX IF NOT EXISTS users_deleted_at_idx ON users (deleted_at) WHERE is_deleted = true;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS users_deleted_at_idx;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS deleted_at,
+    DROP COLUMN IF EXISTS deleted_reason;
+
+-- +goose StatementEnd
@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS user_versions
+(
+    id         bigserial   PRIMARY KEY,
+    user_id    int         NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    operation  varchar(16) NOT NULL,
+    source     varchar(16) NOT NULL DEFAULT '',
+    changed_at timestamptz NOT NULL DEFAULT NOW(),
+    data       jsonb       NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS user_versions_user_id_idx ON user_versions (user_id, changed_at);
+
+INSERT INTO user_versions (user_id, operation, changed_at, data)
+SELECT id, 'snapshot', updated_at, to_jsonb(users) FROM users;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS user_versions;
+
+-- +goose StatementEnd
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users ADD COLUMN version int NOT NULL DEFAULT 1;
+
+UPDATE user_versions SET data = data || '{"version": 1}' WHERE NOT data ? 'version';
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+UPDATE user_versions SET data = data - 'version';
+
+ALTER TABLE users DROP COLUMN IF EXISTS version;
+
+-- +goose StatementEnd
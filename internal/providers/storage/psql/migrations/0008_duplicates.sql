@@ -0,0 +1,31 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users ADD COLUMN merged_into int REFERENCES users (id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS users_full_name_trgm_idx ON users
+    USING gin (lower(name || ' ' || COALESCE(surname, '') || ' ' || COALESCE(patronymic, '')) gin_trgm_ops);
+
+CREATE TABLE IF NOT EXISTS duplicate_candidates
+(
+    user_id      int         NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    duplicate_id int         NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    similarity   real        NOT NULL,
+    dismissed    bool        NOT NULL DEFAULT false,
+    detected_at  timestamptz NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, duplicate_id),
+    CHECK (user_id < duplicate_id)
+);
+
+CREATE INDEX IF NOT EXISTS duplicate_candidates_similarity_idx ON duplicate_candidates (similarity DESC) WHERE dismissed = false;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS duplicate_candidates;
+
+DROP INDEX IF EXISTS users_full_name_trgm_idx;
+
+ALTER TABLE users DROP COLUMN IF EXISTS merged_into;
+
+-- +goose StatementEnd
@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+-- LIKE copies the users columns as of this migration. A migration adding a
+-- column to users must add it to users_archive too, and to archiveColumns in
+-- retention.go to archive it.
+CREATE TABLE IF NOT EXISTS users_archive
+(
+    LIKE users,
+    archived_at timestamptz NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX IF NOT EXISTS users_updated_at_idx ON users (updated_at);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS users_updated_at_idx;
+
+DROP TABLE IF EXISTS users_archive;
+
+-- +goose StatementEnd
@@ -0,0 +1,24 @@
+package psql
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+func Test_migrationsReversible(t *testing.T) {
+	names, err := fs.Glob(embedMigrations, "migrations/*.sql")
+	require.NoError(t, err)
+	require.NotEmpty(t, names)
+
+	for _, name := range names {
+		data, err := embedMigrations.ReadFile(name)
+		require.NoError(t, err)
+
+		assert.Contains(t, string(data), "-- +goose Up", name)
+		assert.Contains(t, string(data), "-- +goose Down", name)
+		assert.Less(t, strings.Index(string(data), "-- +goose Up"), strings.Index(string(data), "-- +goose Down"), name)
+	}
+}
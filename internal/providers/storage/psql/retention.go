@@ -0,0 +1,120 @@
+package psql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"github.com/jmoiron/sqlx"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"time"
+)
+
+// TryAdvisoryLock runs fn holding the session advisory lock key. When
+// another session holds the lock fn is not run and false is returned. The
+// lock is taken on a connection of its own, which is discarded if the lock
+// cannot be released, so it never stays with a pooled connection.
+func (s *Storage) TryAdvisoryLock(ctx context.Context, key int64, fn func(ctx context.Context) error) (bool, error) {
+	conn, err := s.db.Connx(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	defer conn.Close()
+
+	var locked bool
+	if err = conn.GetContext(ctx, &locked, `SELECT pg_try_advisory_lock($1)`, key); err != nil {
+		return false, err
+	}
+
+	if !locked {
+		return false, nil
+	}
+
+	defer func() {
+		// ctx may be canceled by now, the lock is released anyway
+		if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, key); err != nil {
+			s.log.Warnf("releasing advisory lock %d: %v", key, err)
+
+			_ = conn.Raw(func(interface{}) error {
+				return driver.ErrBadConn
+			})
+		}
+	}()
+
+	return true, fn(ctx)
+}
+
+// CountRetention counts the users PurgeDeleted would erase with
+// purgeBefore and the users ArchiveUsers would archive with archiveBefore,
+// a nil time disables its rule.
+func (s *Storage) CountRetention(ctx context.Context, purgeBefore, archiveBefore *time.Time) (purged, archived int, err error) {
+	var counts struct {
+		Purged   int `db:"purged"`
+		Archived int `db:"archived"`
+	}
+
+	query := `SELECT count(*) FILTER (WHERE is_deleted = true AND deleted_at < $1) AS purged,
+			        count(*) FILTER (WHERE updated_at < $2 AND is_deleted = false) AS archived
+			 FROM users`
+
+	err = s.inTx(ctx, &sql.TxOptions{ReadOnly: true}, noStatementTimeout, func(tx *sqlx.Tx) error {
+		return tx.GetContext(ctx, &counts, query, purgeBefore, archiveBefore)
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return counts.Purged, counts.Archived, nil
+}
+
+// archiveColumns are the columns of users_archive, created in 0009_archive
+// as a copy of users. A column added to users later is not archived until a
+// migration adds it to users_archive and here.
+const archiveColumns = `id, name, surname, patronymic, age, gender, nationality, is_deleted, created_at, updated_at,
+			 gender_probability, nationality_probability, deleted_at, deleted_reason, merged_into, version,
+			 message_id, source, correlation_id, produced_at`
+
+// ArchiveUsers moves the users not updated since before to users_archive in
+// one statement and returns their ids. Their history and duplicate pairs
+// are erased. Deleted users are left to PurgeDeleted, DeleteUser does not
+// touch updated_at.
+func (s *Storage) ArchiveUsers(ctx context.Context, before time.Time) ([]int, error) {
+	ids := make([]int, 0)
+
+	query := `WITH moved AS (DELETE FROM users WHERE updated_at < $1 AND is_deleted = false RETURNING *)
+			 INSERT INTO users_archive (` + archiveColumns + `)
+			 SELECT ` + archiveColumns + ` FROM moved
+			 RETURNING id`
+
+	err := s.inTx(ctx, nil, noStatementTimeout, func(tx *sqlx.Tx) error {
+		return tx.SelectContext(ctx, &ids, query, before)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// ExportArchivable calls fn for every user ArchiveUsers would archive in id
+// order, as ExportUsers does.
+func (s *Storage) ExportArchivable(ctx context.Context, before time.Time, fn func(user *models.User) error) error {
+	return s.export(ctx, ` WHERE updated_at < $1 AND is_deleted = false`, []interface{}{before}, fn)
+}
+
+// DeleteArchived erases the exported users that were neither updated nor
+// deleted since and returns their ids.
+func (s *Storage) DeleteArchived(ctx context.Context, ids []int, before time.Time) ([]int, error) {
+	deleted := make([]int, 0, len(ids))
+
+	query := `DELETE FROM users WHERE id = ANY($1) AND updated_at < $2 AND is_deleted = false RETURNING id`
+
+	err := s.inTx(ctx, nil, noStatementTimeout, func(tx *sqlx.Tx) error {
+		return tx.SelectContext(ctx, &deleted, query, ids, before)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return deleted, nil
+}
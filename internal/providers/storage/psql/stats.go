@@ -0,0 +1,52 @@
+package psql
+
+import (
+	"context"
+	"fmt"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"github.com/zuzi90/tz-enricher/internal/providers/storage"
+	"strconv"
+)
+
+// GetStats counts the users selected by params grouped by params.Dimension
+// in a single aggregate query. Age groups are returned in order, empty ones
+// included, other groups by count descending.
+func (s *Storage) GetStats(ctx context.Context, params models.StatsParams) (*models.Stats, error) {
+	where, _, args := usersWhere(params.Text, params.Match, params.UserFilter)
+
+	var key, order string
+
+	switch params.Dimension {
+	case models.StatsNationality:
+		key, order = `COALESCE(upper(nationality), '')`, `count DESC, key`
+	case models.StatsGender:
+		key, order = `COALESCE(lower(gender), '')`, `count DESC, key`
+	case models.StatsAge:
+		args = append(args, params.AgeBuckets)
+		key, order = `width_bucket(age, $`+strconv.Itoa(len(args))+`::int[])::text`, `min(age)`
+	default:
+		return nil, fmt.Errorf("%w: unknown statistics dimension %q", models.ErrInvalidParam, params.Dimension)
+	}
+
+	groups := make([]models.StatsGroup, 0)
+
+	query := `SELECT ` + key + ` AS key, count(*) AS count FROM users` + where + ` GROUP BY 1 ORDER BY ` + order
+
+	if err := s.replica.SelectContext(ctx, &groups, query, args...); err != nil {
+		return nil, err
+	}
+
+	if params.Dimension == models.StatsAge {
+		var err error
+		if groups, err = storage.AgeGroups(params.AgeBuckets, groups); err != nil {
+			return nil, err
+		}
+	}
+
+	stats := models.Stats{Dimension: params.Dimension, Groups: groups}
+	for _, group := range groups {
+		stats.Total += group.Count
+	}
+
+	return &stats, nil
+}
@@ -2,59 +2,172 @@ package psql
 
 import (
 	"context"
-	"embed"
+	"database/sql"
 	"fmt"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
 	"github.com/jmoiron/sqlx"
-	"github.com/pressly/goose/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/sirupsen/logrus"
+	"sort"
+	"strconv"
+	"time"
 )
 
-//go:embed migrations/*.up.sql
-var embedMigrations embed.FS
+// Options configure the connection pools of the storage, zero values keep
+// the database/sql defaults.
+type Options struct {
+	// ReplicaDSN is a read replica serving GetUser, GetUsers and GetStats,
+	// the primary serves them when empty.
+	ReplicaDSN      string
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	// StatementTimeout cancels statements running longer, 0 disables it.
+	// Exports, retention and duplicate detection run without it.
+	// Migrations are not limited.
+	StatementTimeout time.Duration
+	// ConnectRetries is how many times the startup ping is retried,
+	// ConnectRetryInterval apart.
+	ConnectRetries       int
+	ConnectRetryInterval time.Duration
+}
 
 type Storage struct {
-	db    *sqlx.DB
-	dbDSN string
-	log   *logrus.Entry
+	db *sqlx.DB
+	// replica is db when there is no read replica.
+	replica *sqlx.DB
+	dbDSN   string
+	log     *logrus.Entry
 }
 
-func NewStorage(log *logrus.Logger, dbDSN string) (*Storage, error) {
+func NewStorage(ctx context.Context, log *logrus.Logger, dbDSN string, opts Options) (*Storage, error) {
 	stor := Storage{
 		dbDSN: dbDSN,
 		log:   log.WithField("module", "storage"),
 	}
 
-	clientSqlx, err := sqlx.Open("pgx", stor.dbDSN)
-	if err != nil {
-		return nil, fmt.Errorf("err client sqlx: %v", err)
+	var err error
+
+	if stor.db, err = stor.connect(ctx, dbDSN, opts); err != nil {
+		return nil, fmt.Errorf("err connecting to primary: %w", err)
 	}
 
-	stor.db = clientSqlx
+	stor.replica = stor.db
+
+	if opts.ReplicaDSN != "" {
+		if stor.replica, err = stor.connect(ctx, opts.ReplicaDSN, opts); err != nil {
+			_ = stor.db.Close()
+			return nil, fmt.Errorf("err connecting to replica: %w", err)
+		}
+	}
 
 	return &stor, nil
 }
 
-func (s *Storage) getMigrations() error {
-	err := goose.SetDialect("postgres")
+// connect opens a pool configured by opts and pings it until it responds
+// or the retries run out.
+func (s *Storage) connect(ctx context.Context, dsn string, opts Options) (*sqlx.DB, error) {
+	connConfig, err := pgx.ParseConfig(dsn)
 	if err != nil {
-		return fmt.Errorf("discovering migrations by caller: %w", err)
+		return nil, fmt.Errorf("err parsing dsn: %w", err)
 	}
 
-	goose.SetBaseFS(embedMigrations)
-	err = goose.Up(s.db.DB, "migrations")
+	if opts.StatementTimeout > 0 {
+		connConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(opts.StatementTimeout.Milliseconds(), 10)
+	}
+
+	db := sqlx.NewDb(stdlib.OpenDB(*connConfig), "pgx")
+	db.SetMaxOpenConns(opts.MaxOpenConns)
+	if opts.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(opts.MaxIdleConns)
+	}
+	db.SetConnMaxLifetime(opts.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(opts.ConnMaxIdleTime)
+
+	for attempt := 0; ; attempt++ {
+		err = db.PingContext(ctx)
+		if err == nil {
+			return db, nil
+		}
+
+		if attempt >= opts.ConnectRetries {
+			_ = db.Close()
+			return nil, err
+		}
+
+		s.log.Warnf("ping %s: %v, retrying in %s", connConfig.Host, err, opts.ConnectRetryInterval)
+
+		select {
+		case <-ctx.Done():
+			_ = db.Close()
+			return nil, ctx.Err()
+		case <-time.After(opts.ConnectRetryInterval):
+		}
+	}
+}
+
+// noStatementTimeout lifts StatementTimeout for the jobs that scan or rewrite
+// the whole users table: the timeout is sized for API requests.
+var noStatementTimeout = map[string]string{"statement_timeout": "0"}
+
+// inTx runs fn in a transaction on the primary. settings are applied with
+// SET LOCAL semantics, so they end with the transaction and do not leak to
+// the pooled connection.
+func (s *Storage) inTx(ctx context.Context, opts *sql.TxOptions, settings map[string]string, fn func(tx *sqlx.Tx) error) error {
+	tx, err := s.db.BeginTxx(ctx, opts)
 	if err != nil {
-		return fmt.Errorf("error when trying to access the migration files: %w", err)
+		return err
 	}
 
-	return nil
+	defer func() {
+		_ = tx.Rollback()
+	}()
 
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if _, err = tx.ExecContext(ctx, `SELECT set_config($1, $2, true)`, name, settings[name]); err != nil {
+			return fmt.Errorf("err setting %s: %w", name, err)
+		}
+	}
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
-func (s *Storage) UpdateSchema() error {
-	if err := s.getMigrations(); err != nil {
+
+// RegisterMetrics exports the stats of the connection pools, labeled
+// primary and replica.
+func (s *Storage) RegisterMetrics(reg prometheus.Registerer) error {
+	if err := reg.Register(collectors.NewDBStatsCollector(s.db.DB, "primary")); err != nil {
 		return err
 	}
 
-	s.log.Info("Migration applied")
+	if s.replica != s.db {
+		return reg.Register(collectors.NewDBStatsCollector(s.replica.DB, "replica"))
+	}
+
+	return nil
+}
+
+// UpdateSchema applies all pending migrations.
+func (s *Storage) UpdateSchema() error {
+	applied, err := s.MigrateUp(context.Background())
+	if err != nil {
+		return fmt.Errorf("applying migrations: %w", err)
+	}
+
+	s.log.Infof("Migration applied: %d", len(applied))
 
 	return nil
 }
@@ -64,6 +177,12 @@ func (s *Storage) CloseDB() error {
 		return nil
 	}
 
+	if s.replica != s.db {
+		if err := s.replica.Close(); err != nil {
+			s.log.Warnf("closing replica: %v", err)
+		}
+	}
+
 	return s.db.Close()
 }
 
@@ -5,19 +5,34 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	jsoniter "github.com/json-iterator/go"
 	"github.com/zuzi90/tz-enricher/internal/models"
+	"github.com/zuzi90/tz-enricher/internal/providers/storage"
 	"strconv"
+	"strings"
+	"time"
 )
 
+// userColumns is the users row. ArchiveUsers copies archiveColumns, add a
+// new column to users_archive and there as well to archive it.
+const userColumns = `id, name, surname, patronymic, age, gender, nationality, is_deleted, created_at, updated_at,
+			 gender_probability, nationality_probability, deleted_at, deleted_reason, merged_into, version,
+			 message_id, source, correlation_id, produced_at`
+
 func (s *Storage) CreateUser(ctx context.Context, val models.UserCreate) (*models.User, error) {
 	user := models.User{}
 
-	query := `
-			 INSERT INTO users(name, surname, patronymic, age, gender, nationality)
-			 VALUES($1,$2,$3,$4,$5,$6)
-			 RETURNING id, name, surname, patronymic, age, gender, nationality, is_deleted, created_at, updated_at`
-	err := s.db.GetContext(ctx, &user, query, val.Name, val.Surname, val.Patronymic, val.Age, val.Gender, val.Nationality)
+	query, args := versioned(ctx, `
+			 INSERT INTO users(name, surname, patronymic, age, gender, nationality,
+			                   gender_probability, nationality_probability,
+			                   message_id, source, correlation_id, produced_at)
+			 VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)`, models.OperationCreate,
+		[]interface{}{val.Name, val.Surname, val.Patronymic, val.Age, val.Gender, val.Nationality,
+			val.GenderProbability, val.NationalityProbability, val.MessageID, val.Source, val.CorrelationID, val.ProducedAt})
 
+	err := s.db.GetContext(ctx, &user, query, args...)
 	if err != nil {
 		return &models.User{}, err
 	}
@@ -25,14 +40,65 @@ func (s *Storage) CreateUser(ctx context.Context, val models.UserCreate) (*model
 	return &user, nil
 }
 
+// CreateUsers inserts the users with one statement and returns them in the
+// same order.
+func (s *Storage) CreateUsers(ctx context.Context, vals []models.UserCreate) ([]*models.User, error) {
+	if len(vals) == 0 {
+		return nil, nil
+	}
+
+	var builder strings.Builder
+
+	args := make([]interface{}, 0, len(vals)*12)
+
+	builder.WriteString(`INSERT INTO users(name, surname, patronymic, age, gender, nationality,
+			                   gender_probability, nationality_probability,
+			                   message_id, source, correlation_id, produced_at)
+			 VALUES`)
+
+	for i, val := range vals {
+		if i > 0 {
+			builder.WriteString(`,`)
+		}
+
+		builder.WriteString(`(`)
+
+		for j := 1; j <= 12; j++ {
+			if j > 1 {
+				builder.WriteString(`,`)
+			}
+
+			builder.WriteString(`$` + strconv.Itoa(len(args)+j))
+		}
+
+		builder.WriteString(`)`)
+
+		args = append(args, val.Name, val.Surname, val.Patronymic, val.Age, val.Gender, val.Nationality,
+			val.GenderProbability, val.NationalityProbability, val.MessageID, val.Source, val.CorrelationID, val.ProducedAt)
+	}
+
+	query, args := versioned(ctx, builder.String(), models.OperationCreate, args)
+
+	users := make([]*models.User, 0, len(vals))
+
+	// ids are assigned in the order of the values
+	if err := s.db.SelectContext(ctx, &users, query+` ORDER BY id`, args...); err != nil {
+		return nil, err
+	}
+
+	if len(users) != len(vals) {
+		return nil, fmt.Errorf("inserted %d users of %d", len(users), len(vals))
+	}
+
+	return users, nil
+}
+
 func (s *Storage) GetUser(ctx context.Context, id int) (*models.User, error) {
 	var user models.User
 
-	query := `
-			 SELECT id, name, surname, patronymic, age, gender, nationality,  is_deleted, created_at, updated_at
-			 FROM users WHERE id = $1 AND is_deleted = false`
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1 AND is_deleted = false`
 
-	err := s.db.GetContext(ctx, &user, query, id)
+	err := s.replica.GetContext(ctx, &user, query, id)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, models.ErrUserNotFound
@@ -44,48 +110,275 @@ func (s *Storage) GetUser(ctx context.Context, id int) (*models.User, error) {
 	return &user, nil
 }
 
-func (s *Storage) GetUsers(ctx context.Context, params models.GetUsersParams) ([]*models.User, error) {
+func (s *Storage) GetUsers(ctx context.Context, params models.GetUsersParams) (*models.UsersPage, error) {
+	where, rank, args := usersWhere(params.Text, params.Match, params.UserFilter)
+
+	page := models.UsersPage{Items: make([]*models.User, 0)}
+
+	if err := s.countUsers(ctx, &page, params.Total, where, args); err != nil {
+		return nil, err
+	}
+
+	keys := storage.SortKeys(params)
+	spec := storage.SortSpec(keys)
+	offset := params.Offset
+
+	if rank != "" {
+		spec = "rank," + spec
+	}
 
-	var args []interface{}
 	var builder bytes.Buffer
-	users := make([]*models.User, 0)
 
-	builder.WriteString(`SELECT id, name, surname, patronymic, age, gender, nationality, is_deleted, created_at, updated_at FROM users WHERE is_deleted = false`)
+	builder.WriteString(`SELECT ` + userColumns + ` FROM users` + where)
+
+	keyset := params.Cursor != nil && rank == ""
+
+	switch {
+	case params.Cursor != nil && params.Cursor.Sort != spec:
+		return nil, fmt.Errorf("%w: cursor does not match the requested order", models.ErrInvalidParam)
+	case keyset && len(params.Cursor.Values) != len(keys)-1:
+		return nil, fmt.Errorf("%w: malformed cursor", models.ErrInvalidParam)
+	case keyset:
+		var cond string
+		cond, args = keysetCondition(keys, params.Cursor, args)
+		builder.WriteString(` AND ` + cond)
+	case params.Cursor != nil:
+		// ranked search results have no stable key to continue from
+		offset = params.Cursor.Offset
+	}
 
-	if params.Text != "" {
-		args = append(args, params.Text)
-		builder.WriteString(` AND (name LIKE $` + strconv.Itoa(len(args)) + ` OR surname LIKE $` + strconv.Itoa(len(args)) + ` OR patronymic LIKE $` + strconv.Itoa(len(args)) + `)`)
+	builder.WriteString(` ORDER BY `)
+	if rank != "" {
+		builder.WriteString(rank + ` DESC, `)
 	}
 
-	if params.Sorting != "" {
-		builder.WriteString(` ORDER BY ` + params.Sorting)
-		if params.Descending {
+	for i, key := range keys {
+		if i > 0 {
+			builder.WriteString(`, `)
+		}
+
+		builder.WriteString(sortExpr(key))
+		if key.Desc {
 			builder.WriteString(` DESC`)
 		}
 	}
 
-	args = append(args, params.Limit)
+	// one extra row tells whether there is a next page
+	args = append(args, params.Limit+1)
 	builder.WriteString(` LIMIT $` + strconv.Itoa(len(args)))
-	args = append(args, params.Offset)
-	builder.WriteString(` OFFSET $` + strconv.Itoa(len(args)))
-	query := builder.String()
 
-	err := s.db.SelectContext(ctx, &users, query, args...)
+	if !keyset {
+		args = append(args, offset)
+		builder.WriteString(` OFFSET $` + strconv.Itoa(len(args)))
+	}
+
+	err := s.replica.SelectContext(ctx, &page.Items, builder.String(), args...)
 	if err != nil {
 		return nil, err
 	}
 
-	return users, nil
+	if len(page.Items) > params.Limit {
+		page.Items = page.Items[:params.Limit]
+
+		cursor := models.Cursor{Sort: spec, Offset: offset + params.Limit}
+		if rank == "" {
+			cursor = storage.NewCursor(keys, spec, page.Items[len(page.Items)-1])
+		}
+
+		page.Cursor = cursor.Encode()
+	}
+
+	return &page, nil
+}
+
+// usersWhere builds the " WHERE ..." clause selecting the users of the list
+// endpoint, rank is the search similarity when text is ranked.
+func usersWhere(text string, match models.MatchMode, f models.UserFilter) (where, rank string, args []interface{}) {
+	var builder strings.Builder
+
+	builder.WriteString(` WHERE ` + deletedCondition(f.Deleted))
+
+	var filter string
+	filter, args = filterConditions(f, args)
+	builder.WriteString(filter)
+
+	if text != "" {
+		var cond string
+		cond, rank, args = searchCondition(match, text, args)
+		builder.WriteString(` AND ` + cond)
+	}
+
+	return builder.String(), rank, args
+}
+
+// countUsers sets the total of the page according to mode.
+func (s *Storage) countUsers(ctx context.Context, page *models.UsersPage, mode models.TotalMode, where string, args []interface{}) error {
+	var total int64
+
+	switch mode {
+	case models.TotalExact:
+		if err := s.replica.GetContext(ctx, &total, `SELECT count(*) FROM users`+where, args...); err != nil {
+			return err
+		}
+	case models.TotalEstimate:
+		var plan string
+		if err := s.replica.GetContext(ctx, &plan, `EXPLAIN (FORMAT JSON) SELECT 1 FROM users`+where, args...); err != nil {
+			return err
+		}
+
+		var err error
+		if total, err = planRows(plan); err != nil {
+			return err
+		}
+
+		page.TotalEstimated = true
+	default:
+		return nil
+	}
+
+	page.Total = &total
+
+	return nil
+}
+
+func planRows(plan string) (int64, error) {
+	var explain []struct {
+		Plan struct {
+			Rows float64 `json:"Plan Rows"`
+		} `json:"Plan"`
+	}
+
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+	if err := json.Unmarshal([]byte(plan), &explain); err != nil {
+		return 0, fmt.Errorf("parsing query plan: %w", err)
+	}
+
+	if len(explain) == 0 {
+		return 0, errors.New("empty query plan")
+	}
+
+	return int64(explain[0].Plan.Rows), nil
 }
 
-func (s *Storage) UpdateUser(ctx context.Context, user models.UserUpdate, id int) (*models.User, error) {
+// filterConditions returns the " AND ..." conditions of the set filter
+// fields with their arguments appended to args.
+func filterConditions(f models.UserFilter, args []interface{}) (string, []interface{}) {
+	var builder strings.Builder
+
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		builder.WriteString(` AND ` + strings.ReplaceAll(cond, `?`, `$`+strconv.Itoa(len(args))))
+	}
+
+	if f.MinAge != nil {
+		add(`age >= ?`, *f.MinAge)
+	}
+
+	if f.MaxAge != nil {
+		add(`age <= ?`, *f.MaxAge)
+	}
+
+	if f.Gender != "" {
+		add(`lower(gender) = ?`, strings.ToLower(f.Gender))
+	}
+
+	if len(f.Nationalities) > 0 {
+		nationalities := make([]string, 0, len(f.Nationalities))
+		for _, nationality := range f.Nationalities {
+			nationalities = append(nationalities, strings.ToUpper(nationality))
+		}
+
+		add(`upper(nationality) = ANY(?)`, nationalities)
+	}
+
+	if f.CreatedFrom != nil {
+		add(`created_at >= ?`, *f.CreatedFrom)
+	}
+
+	if f.CreatedTo != nil {
+		add(`created_at <= ?`, *f.CreatedTo)
+	}
+
+	if f.UpdatedFrom != nil {
+		add(`updated_at >= ?`, *f.UpdatedFrom)
+	}
+
+	if f.UpdatedTo != nil {
+		add(`updated_at <= ?`, *f.UpdatedTo)
+	}
+
+	if f.HasPatronymic != nil {
+		if *f.HasPatronymic {
+			builder.WriteString(` AND COALESCE(patronymic, '') <> ''`)
+		} else {
+			builder.WriteString(` AND COALESCE(patronymic, '') = ''`)
+		}
+	}
+
+	if f.MinConfidence != nil {
+		add(`LEAST(gender_probability, nationality_probability) >= ?`, *f.MinConfidence)
+	}
+
+	return builder.String(), args
+}
+
+// searchCondition returns the WHERE condition matching text against the
+// name columns and, for fuzzy search, the similarity expression to rank by.
+// ILIKE and the % operator are served by the trigram indexes.
+func searchCondition(mode models.MatchMode, text string, args []interface{}) (string, string, []interface{}) {
+	switch mode {
+	case models.MatchExact:
+		args = append(args, escapeLike(text))
+		n := `$` + strconv.Itoa(len(args))
+
+		return `(name ILIKE ` + n + ` OR surname ILIKE ` + n + ` OR patronymic ILIKE ` + n + `)`, "", args
+	case models.MatchPrefix:
+		args = append(args, escapeLike(text)+"%")
+		n := `$` + strconv.Itoa(len(args))
+
+		return `(name ILIKE ` + n + ` OR surname ILIKE ` + n + ` OR patronymic ILIKE ` + n + `)`, "", args
+	default:
+		args = append(args, "%"+escapeLike(text)+"%", text)
+		p, t := `$`+strconv.Itoa(len(args)-1), `$`+strconv.Itoa(len(args))
+
+		cond := `(name ILIKE ` + p + ` OR surname ILIKE ` + p + ` OR patronymic ILIKE ` + p +
+			` OR name % ` + t + ` OR surname % ` + t + ` OR patronymic % ` + t + `)`
+		rank := `GREATEST(similarity(name, ` + t + `), similarity(surname, ` + t + `), similarity(patronymic, ` + t + `))`
+
+		return cond, rank, args
+	}
+}
+
+// deletedCondition selects users by their soft deletion state.
+func deletedCondition(mode models.DeletedMode) string {
+	switch mode {
+	case models.DeletedInclude:
+		return `true`
+	case models.DeletedOnly:
+		return `is_deleted = true`
+	default:
+		return `is_deleted = false`
+	}
+}
+
+// escapeLike escapes the LIKE wildcards, so user input is matched literally.
+func escapeLike(text string) string {
+	return likeEscaper.Replace(text)
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// UpdateUser applies the set fields of user. With versions given, the user
+// is updated only if its current version is one of them, otherwise
+// ErrVersionMismatch is returned.
+func (s *Storage) UpdateUser(ctx context.Context, user models.UserUpdate, id int, versions []int) (*models.User, error) {
 	var args []interface{}
 
 	var builder bytes.Buffer
 
 	var userResponse models.User
 
-	builder.WriteString(`UPDATE users SET updated_at = NOW()`)
+	builder.WriteString(`UPDATE users SET updated_at = NOW(), version = version + 1`)
 
 	if user.Name != nil {
 		args = append(args, *user.Name)
@@ -117,18 +410,30 @@ func (s *Storage) UpdateUser(ctx context.Context, user models.UserUpdate, id int
 		builder.WriteString(`, nationality = ` + `$` + strconv.Itoa(len(args)))
 	}
 
-	builder.WriteString(`WHERE id =` + strconv.Itoa(id))
-	builder.WriteString(`AND is_deleted = false `)
-	builder.WriteString(`RETURNING id, name, surname,
-							patronymic, age, gender, nationality, is_deleted, created_at, updated_at`)
+	args = append(args, id)
+	builder.WriteString(` WHERE id = $` + strconv.Itoa(len(args)) + ` AND is_deleted = false`)
+
+	for i, version := range versions {
+		if i == 0 {
+			builder.WriteString(` AND version IN (`)
+		} else {
+			builder.WriteString(`, `)
+		}
+
+		args = append(args, version)
+		builder.WriteString(`$` + strconv.Itoa(len(args)))
+	}
+
+	if len(versions) > 0 {
+		builder.WriteString(`)`)
+	}
 
-	err := s.db.QueryRowContext(ctx, builder.String(), args...).Scan(&userResponse.ID,
-		&userResponse.Name, &userResponse.Surname,
-		&userResponse.Patronymic, &userResponse.Age, &userResponse.Gender, &userResponse.Nationality,
-		&userResponse.IsDeleted, &userResponse.CreatedAt, &userResponse.UpdatedAt)
+	query, args := versioned(ctx, builder.String(), models.OperationUpdate, args)
+
+	err := s.db.GetContext(ctx, &userResponse, query, args...)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, models.ErrNoRows
+			return nil, s.updateMissed(ctx, id, versions)
 		}
 
 		return &models.User{}, err
@@ -138,9 +443,69 @@ func (s *Storage) UpdateUser(ctx context.Context, user models.UserUpdate, id int
 
 }
 
-func (s *Storage) DeleteUser(ctx context.Context, id int) error {
-	query := `UPDATE users SET is_deleted = true WHERE id = $1 AND is_deleted = false`
-	result, err := s.db.ExecContext(ctx, query, id)
+// updateMissed tells why a conditional update changed nothing: the user is
+// gone or its version did not match.
+func (s *Storage) updateMissed(ctx context.Context, id int, versions []int) error {
+	if len(versions) == 0 {
+		return models.ErrNoRows
+	}
+
+	var exists bool
+
+	err := s.db.GetContext(ctx, &exists, `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1 AND is_deleted = false)`, id)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return models.ErrNoRows
+	}
+
+	return models.ErrVersionMismatch
+}
+
+func (s *Storage) DeleteUser(ctx context.Context, id int, reason string) error {
+	var user models.User
+
+	query, args := versioned(ctx, `UPDATE users SET is_deleted = true, deleted_at = NOW(), deleted_reason = $2, version = version + 1
+			 WHERE id = $1 AND is_deleted = false`, models.OperationDelete, []interface{}{id, reason})
+
+	err := s.db.GetContext(ctx, &user, query, args...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.ErrUserNotFound
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// RestoreUser undoes DeleteUser, ErrUserNotFound is returned when the user
+// does not exist or is not deleted.
+func (s *Storage) RestoreUser(ctx context.Context, id int) (*models.User, error) {
+	var user models.User
+
+	query, args := versioned(ctx, `UPDATE users SET is_deleted = false, deleted_at = NULL, deleted_reason = '', updated_at = NOW(),
+			                  version = version + 1
+			 WHERE id = $1 AND is_deleted = true`, models.OperationRestore, []interface{}{id})
+
+	err := s.db.GetContext(ctx, &user, query, args...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrUserNotFound
+		}
+
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// PurgeUser permanently removes the user, deleted or not, and its history.
+func (s *Storage) PurgeUser(ctx context.Context, id int) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, id)
 	if err != nil {
 		return err
 	}
@@ -156,3 +521,20 @@ func (s *Storage) DeleteUser(ctx context.Context, id int) error {
 
 	return nil
 }
+
+// PurgeDeleted permanently removes users soft-deleted before the given time
+// with their history and returns their ids.
+func (s *Storage) PurgeDeleted(ctx context.Context, before time.Time) ([]int, error) {
+	ids := make([]int, 0)
+
+	query := `DELETE FROM users WHERE is_deleted = true AND deleted_at < $1 RETURNING id`
+
+	err := s.inTx(ctx, nil, noStatementTimeout, func(tx *sqlx.Tx) error {
+		return tx.SelectContext(ctx, &ids, query, before)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,73 @@
+package psql
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"testing"
+)
+
+func Test_escapeLike(t *testing.T) {
+	assert.Equal(t, `50\% off\_now \\o/`, escapeLike(`50% off_now \o/`))
+}
+
+func Test_searchCondition(t *testing.T) {
+	t.Run("exact", func(t *testing.T) {
+		cond, rank, args := searchCondition(models.MatchExact, "and_", []interface{}{1})
+
+		assert.Equal(t, `(name ILIKE $2 OR surname ILIKE $2 OR patronymic ILIKE $2)`, cond)
+		assert.Empty(t, rank)
+		assert.Equal(t, []interface{}{1, `and\_`}, args)
+	})
+
+	t.Run("prefix", func(t *testing.T) {
+		cond, rank, args := searchCondition(models.MatchPrefix, "And", nil)
+
+		assert.Equal(t, `(name ILIKE $1 OR surname ILIKE $1 OR patronymic ILIKE $1)`, cond)
+		assert.Empty(t, rank)
+		assert.Equal(t, []interface{}{"And%"}, args)
+	})
+
+	t.Run("fuzzy", func(t *testing.T) {
+		cond, rank, args := searchCondition(models.MatchFuzzy, "ndre", nil)
+
+		assert.Contains(t, cond, `name ILIKE $1`)
+		assert.Contains(t, cond, `name % $2`)
+		assert.Equal(t, `GREATEST(similarity(name, $2), similarity(surname, $2), similarity(patronymic, $2))`, rank)
+		assert.Equal(t, []interface{}{"%ndre%", "ndre"}, args)
+	})
+}
+
+func Test_filterConditions(t *testing.T) {
+	t.Run("empty", func(t *testing.T) {
+		cond, args := filterConditions(models.UserFilter{}, nil)
+
+		assert.Empty(t, cond)
+		assert.Empty(t, args)
+	})
+
+	t.Run("numbered after existing args", func(t *testing.T) {
+		minAge, has, confidence := 18, true, 0.7
+
+		cond, args := filterConditions(models.UserFilter{
+			MinAge:        &minAge,
+			Gender:        "Female",
+			Nationalities: []string{"ru", "UA"},
+			HasPatronymic: &has,
+			MinConfidence: &confidence,
+		}, []interface{}{"text"})
+
+		assert.Equal(t, ` AND age >= $2 AND lower(gender) = $3 AND upper(nationality) = ANY($4)`+
+			` AND COALESCE(patronymic, '') <> '' AND LEAST(gender_probability, nationality_probability) >= $5`, cond)
+		assert.Equal(t, []interface{}{"text", 18, "female", []string{"RU", "UA"}, 0.7}, args)
+	})
+}
+
+func Test_deletedCondition(t *testing.T) {
+	assert.Equal(t, `is_deleted = false`, deletedCondition(models.DeletedExclude))
+	assert.Equal(t, `true`, deletedCondition(models.DeletedInclude))
+	assert.Equal(t, `is_deleted = true`, deletedCondition(models.DeletedOnly))
+}
+
+func Test_fullName(t *testing.T) {
+	assert.Equal(t, `lower(a.name || ' ' || COALESCE(a.surname, '') || ' ' || COALESCE(a.patronymic, ''))`, fullName("a"))
+}
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SortKey is a column of the user list order.
+type SortKey struct {
+	Column string
+	Desc   bool
+}
+
+// SortKeys returns the requested order with id appended as the tiebreaker.
+// Fields after id are dropped, id already makes the order total.
+func SortKeys(params models.GetUsersParams) []SortKey {
+	keys := make([]SortKey, 0, len(params.Sort)+1)
+
+	for _, field := range params.Sort {
+		keys = append(keys, SortKey{Column: field.Column, Desc: field.Desc})
+
+		if field.Column == "id" {
+			return keys
+		}
+	}
+
+	desc := false
+	if len(keys) > 0 {
+		desc = keys[len(keys)-1].Desc
+	}
+
+	return append(keys, SortKey{Column: "id", Desc: desc})
+}
+
+// SortSpec identifies the order in cursors, e.g. "-age,id".
+func SortSpec(keys []SortKey) string {
+	specs := make([]string, 0, len(keys))
+
+	for _, key := range keys {
+		if key.Desc {
+			specs = append(specs, "-"+key.Column)
+		} else {
+			specs = append(specs, key.Column)
+		}
+	}
+
+	return strings.Join(specs, ",")
+}
+
+// NewCursor returns the position after user in the given order.
+func NewCursor(keys []SortKey, spec string, user *models.User) models.Cursor {
+	cursor := models.Cursor{Sort: spec, ID: user.ID}
+
+	for _, key := range keys[:len(keys)-1] {
+		cursor.Values = append(cursor.Values, SortValue(user, key.Column))
+	}
+
+	return cursor
+}
+
+// SortValue is the value of the sort column of user as stored in cursors,
+// times are RFC3339.
+func SortValue(user *models.User, column string) string {
+	switch column {
+	case "name":
+		return user.Name
+	case "surname":
+		return user.Surname
+	case "patronymic":
+		return user.Patronymic
+	case "age":
+		return strconv.Itoa(user.Age)
+	case "gender":
+		return user.Gender
+	case "nationality":
+		return user.Nationality
+	case "created_at":
+		return user.CreatedAt.Format(time.RFC3339Nano)
+	case "updated_at":
+		return user.UpdatedAt.Format(time.RFC3339Nano)
+	default:
+		return strconv.Itoa(user.ID)
+	}
+}
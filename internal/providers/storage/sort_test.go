@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"testing"
+	"time"
+)
+
+func Test_SortKeys(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		keys := SortKeys(models.GetUsersParams{})
+
+		assert.Equal(t, []SortKey{{Column: "id"}}, keys)
+	})
+
+	t.Run("id tiebreak follows the last field", func(t *testing.T) {
+		keys := SortKeys(models.GetUsersParams{Sort: []models.SortField{
+			{Column: "age", Desc: true},
+			{Column: "surname"},
+		}})
+
+		assert.Equal(t, []SortKey{{Column: "age", Desc: true}, {Column: "surname"}, {Column: "id"}}, keys)
+		assert.Equal(t, "-age,surname,id", SortSpec(keys))
+	})
+
+	t.Run("fields after id are dropped", func(t *testing.T) {
+		keys := SortKeys(models.GetUsersParams{Sort: []models.SortField{
+			{Column: "id", Desc: true},
+			{Column: "age"},
+		}})
+
+		assert.Equal(t, []SortKey{{Column: "id", Desc: true}}, keys)
+	})
+}
+
+func Test_NewCursor(t *testing.T) {
+	created := time.Date(2024, 5, 19, 21, 6, 10, 900143000, time.UTC)
+	user := &models.User{ID: 181, Age: 55, CreatedAt: created}
+
+	keys := []SortKey{{Column: "created_at"}, {Column: "id"}}
+	cursor := NewCursor(keys, SortSpec(keys), user)
+
+	assert.Equal(t, models.Cursor{Sort: "created_at,id", Values: []string{"2024-05-19T21:06:10.900143Z"}, ID: 181}, cursor)
+}
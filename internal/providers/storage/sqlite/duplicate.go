@@ -0,0 +1,166 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/zuzi90/tz-enricher/internal/models"
+)
+
+// fullName is the full name of the users row aliased as alias, similarity
+// ignores the case itself.
+func fullName(alias string) string {
+	return alias + `.name || ' ' || ` + alias + `.surname || ' ' || ` + alias + `.patronymic`
+}
+
+// DetectDuplicates records the pairs of users whose full names have at least
+// the given trigram similarity and returns how many pairs were found.
+// Dismissed pairs stay dismissed.
+func (s *Storage) DetectDuplicates(ctx context.Context, threshold float64) (int64, error) {
+	query := `INSERT INTO duplicate_candidates (user_id, duplicate_id, similarity, detected_at)
+			 SELECT a.id, b.id, similarity(` + fullName("a") + `, ` + fullName("b") + `), ?2
+			 FROM users a JOIN users b ON a.id < b.id
+			 WHERE a.is_deleted = FALSE AND b.is_deleted = FALSE
+			   AND similarity(` + fullName("a") + `, ` + fullName("b") + `) >= ?1
+			 ON CONFLICT (user_id, duplicate_id) DO UPDATE SET similarity = excluded.similarity, detected_at = excluded.detected_at`
+
+	result, err := s.db.ExecContext(ctx, query, threshold, now())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// GetDuplicates returns the pairs to review, most similar first. Pairs with
+// a deleted user are skipped.
+func (s *Storage) GetDuplicates(ctx context.Context, params models.GetDuplicatesParams) ([]*models.DuplicateCandidate, error) {
+	candidates := make([]*models.DuplicateCandidate, 0)
+
+	query := `SELECT c.user_id, c.duplicate_id, c.similarity, c.detected_at
+			 FROM duplicate_candidates c
+			 JOIN users a ON a.id = c.user_id AND a.is_deleted = FALSE
+			 JOIN users b ON b.id = c.duplicate_id AND b.is_deleted = FALSE
+			 WHERE c.dismissed = FALSE AND c.similarity >= ?1
+			 ORDER BY c.similarity DESC, c.user_id, c.duplicate_id
+			 LIMIT ?2 OFFSET ?3`
+
+	err := s.db.SelectContext(ctx, &candidates, query, params.MinSimilarity, params.Limit, params.Offset)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(candidates) == 0 {
+		return candidates, nil
+	}
+
+	ids := make([]int, 0, 2*len(candidates))
+	for _, c := range candidates {
+		ids = append(ids, c.UserID, c.DuplicateID)
+	}
+
+	users, err := usersByID(ctx, s.db, ids...)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[int]*models.User, len(users))
+	for _, user := range users {
+		byID[user.ID] = user
+	}
+
+	for _, c := range candidates {
+		c.User, c.Duplicate = byID[c.UserID], byID[c.DuplicateID]
+	}
+
+	return candidates, nil
+}
+
+// DismissDuplicate marks the pair as not a duplicate, it is not listed
+// again.
+func (s *Storage) DismissDuplicate(ctx context.Context, pair models.DuplicatePair) error {
+	result, err := s.db.ExecContext(ctx, `UPDATE duplicate_candidates SET dismissed = TRUE
+			 WHERE user_id = ?1 AND duplicate_id = ?2`, pair.UserID, pair.DuplicateID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return models.ErrUserNotFound
+	}
+
+	return nil
+}
+
+// MergeUser merges the duplicate into the survivor in one transaction:
+// blank fields of the survivor are filled from the duplicate, the duplicate
+// is soft-deleted with merged_into set, both changes are recorded in the
+// history and the candidate pairs of the duplicate are dropped.
+func (s *Storage) MergeUser(ctx context.Context, survivorID, duplicateID int) (*models.User, error) {
+	var merged *models.User
+
+	// fill takes the column from the duplicate when the survivor has none
+	fill := func(column string) string {
+		return column + ` = CASE WHEN s.` + column + ` = '' THEN dup.` + column + ` ELSE s.` + column + ` END`
+	}
+
+	// fillWith is fill for a column resolved together with its probability
+	fillWith := func(column, probability string) string {
+		return fill(column) + `, ` + probability + ` = CASE WHEN s.` + column + ` = ''
+			     THEN dup.` + probability + ` ELSE s.` + probability + ` END`
+	}
+
+	err := s.write(ctx, func(tx *sqlx.Tx) error {
+		at := now()
+
+		changed, err := exec(ctx, tx, `UPDATE users SET is_deleted = TRUE, deleted_at = ?3, deleted_reason = ?4, merged_into = ?1,
+			                  version = version + 1
+			 WHERE id = ?2 AND is_deleted = FALSE
+			   AND EXISTS (SELECT 1 FROM users WHERE id = ?1 AND is_deleted = FALSE)`,
+			survivorID, duplicateID, at, fmt.Sprintf("merged into %d", survivorID))
+		if err != nil {
+			return err
+		}
+
+		if !changed {
+			return models.ErrUserNotFound
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE users AS s SET `+fill("surname")+`, `+fill("patronymic")+`,
+			                        `+fillWith("gender", "gender_probability")+`,
+			                        `+fillWith("nationality", "nationality_probability")+`,
+			                        age = CASE WHEN s.age = 0 THEN dup.age ELSE s.age END,
+			                        updated_at = ?3, version = s.version + 1
+			 FROM users AS dup WHERE s.id = ?1 AND dup.id = ?2`, survivorID, duplicateID, at)
+		if err != nil {
+			return err
+		}
+
+		if err = record(ctx, tx, models.OperationMerge, at, duplicateID); err != nil {
+			return err
+		}
+
+		if err = record(ctx, tx, models.OperationMerge, at, survivorID); err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM duplicate_candidates WHERE ?1 IN (user_id, duplicate_id)`, duplicateID)
+		if err != nil {
+			return err
+		}
+
+		merged, err = userByID(ctx, tx, survivorID)
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return merged, nil
+}
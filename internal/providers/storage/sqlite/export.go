@@ -0,0 +1,33 @@
+package sqlite
+
+import (
+	"context"
+	"github.com/zuzi90/tz-enricher/internal/models"
+)
+
+// ExportUsers calls fn for every user selected by params in id order. SQLite
+// steps through the result row by row, so only one user is held in memory.
+// An error returned by fn stops the export.
+func (s *Storage) ExportUsers(ctx context.Context, params models.ExportParams, fn func(user *models.User) error) error {
+	where, _, args := usersWhere(params.Text, params.Match, params.UserFilter)
+
+	rows, err := s.db.QueryxContext(ctx, `SELECT `+userColumns+` FROM users`+where+` ORDER BY id`, args...)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var user models.User
+		if err = rows.StructScan(&user); err != nil {
+			return err
+		}
+
+		if err = fn(&user); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,50 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"time"
+)
+
+// versionsQuery selects the versions of the user ?1.
+const versionsQuery = `SELECT version_id, operation, change_source, changed_at, ` + userColumns + `
+			 FROM user_versions WHERE id = ?1`
+
+// GetUserHistory returns the versions of the user, deleted or not, oldest
+// first.
+func (s *Storage) GetUserHistory(ctx context.Context, id int) ([]*models.UserVersion, error) {
+	versions := make([]*models.UserVersion, 0)
+
+	query := versionsQuery + ` ORDER BY changed_at, version_id`
+
+	if err := s.db.SelectContext(ctx, &versions, query, id); err != nil {
+		return nil, err
+	}
+
+	if len(versions) == 0 {
+		return nil, models.ErrUserNotFound
+	}
+
+	return versions, nil
+}
+
+// GetUserAsOf returns the user as it was at the given time. A user deleted
+// by then is returned with IsDeleted set.
+func (s *Storage) GetUserAsOf(ctx context.Context, id int, at time.Time) (*models.User, error) {
+	var version models.UserVersion
+
+	query := versionsQuery + ` AND changed_at <= ?2 ORDER BY changed_at DESC, version_id DESC LIMIT 1`
+
+	err := s.db.GetContext(ctx, &version, query, id, at.UTC())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrUserNotFound
+		}
+
+		return nil, err
+	}
+
+	return &version.User, nil
+}
@@ -0,0 +1,65 @@
+package sqlite
+
+import (
+	"fmt"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"github.com/zuzi90/tz-enricher/internal/providers/storage"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cursorValue converts a cursor value of column back to the type it is
+// stored as.
+func cursorValue(column, value string) (interface{}, error) {
+	switch column {
+	case "id", "age":
+		return strconv.Atoi(value)
+	case "created_at", "updated_at":
+		t, err := time.Parse(time.RFC3339Nano, value)
+		return t.UTC(), err
+	default:
+		return value, nil
+	}
+}
+
+// keysetCondition selects rows after the cursor position. The last key is
+// always id, its value is taken from Cursor.ID:
+//
+//	(k1 > v1) OR (k1 = v1 AND k2 > v2) OR ...
+func keysetCondition(keys []storage.SortKey, cursor *models.Cursor, args []interface{}) (string, []interface{}, error) {
+	params := make([]string, len(keys))
+
+	for i, key := range keys {
+		var value interface{} = cursor.ID
+
+		if i < len(cursor.Values) && i < len(keys)-1 {
+			var err error
+			if value, err = cursorValue(key.Column, cursor.Values[i]); err != nil {
+				return "", nil, fmt.Errorf("%w: malformed cursor", models.ErrInvalidParam)
+			}
+		}
+
+		args = append(args, value)
+		params[i] = param(args)
+	}
+
+	ors := make([]string, 0, len(keys))
+
+	for i, key := range keys {
+		ands := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			ands = append(ands, keys[j].Column+` = `+params[j])
+		}
+
+		op := ` > `
+		if key.Desc {
+			op = ` < `
+		}
+
+		ands = append(ands, key.Column+op+params[i])
+		ors = append(ors, `(`+strings.Join(ands, ` AND `)+`)`)
+	}
+
+	return `(` + strings.Join(ors, ` OR `) + `)`, args, nil
+}
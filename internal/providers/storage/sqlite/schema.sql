@@ -0,0 +1,77 @@
+-- The schema of the SQLite storage, the state the Postgres migrations lead
+-- to. Text columns are NOT NULL, times are stored as UTC text, which sorts
+-- in time order.
+
+CREATE TABLE IF NOT EXISTS users
+(
+    id                      INTEGER   PRIMARY KEY AUTOINCREMENT,
+    name                    TEXT      NOT NULL,
+    surname                 TEXT      NOT NULL DEFAULT '',
+    patronymic              TEXT      NOT NULL DEFAULT '',
+    age                     INTEGER   NOT NULL CHECK (age >= 0),
+    gender                  TEXT      NOT NULL DEFAULT '',
+    nationality             TEXT      NOT NULL DEFAULT '',
+    is_deleted              BOOLEAN   NOT NULL DEFAULT FALSE,
+    created_at              TIMESTAMP NOT NULL,
+    updated_at              TIMESTAMP NOT NULL,
+    gender_probability      REAL,
+    nationality_probability REAL,
+    deleted_at              TIMESTAMP,
+    deleted_reason          TEXT      NOT NULL DEFAULT '',
+    merged_into             INTEGER   REFERENCES users (id) ON DELETE SET NULL,
+    version                 INTEGER   NOT NULL DEFAULT 1,
+    message_id              TEXT      NOT NULL DEFAULT '',
+    source                  TEXT      NOT NULL DEFAULT '',
+    correlation_id          TEXT      NOT NULL DEFAULT '',
+    produced_at             TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS users_age_idx         ON users (age);
+CREATE INDEX IF NOT EXISTS users_nationality_idx ON users (upper(nationality));
+CREATE INDEX IF NOT EXISTS users_created_at_idx  ON users (created_at);
+CREATE INDEX IF NOT EXISTS users_deleted_at_idx  ON users (deleted_at) WHERE is_deleted = TRUE;
+
+-- user_versions keeps the state of the user after each change in the
+-- columns of users, id is the user.
+CREATE TABLE IF NOT EXISTS user_versions
+(
+    version_id              INTEGER   PRIMARY KEY AUTOINCREMENT,
+    operation               TEXT      NOT NULL,
+    change_source           TEXT      NOT NULL DEFAULT '',
+    changed_at              TIMESTAMP NOT NULL,
+    id                      INTEGER   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name                    TEXT      NOT NULL,
+    surname                 TEXT      NOT NULL,
+    patronymic              TEXT      NOT NULL,
+    age                     INTEGER   NOT NULL,
+    gender                  TEXT      NOT NULL,
+    nationality             TEXT      NOT NULL,
+    is_deleted              BOOLEAN   NOT NULL,
+    created_at              TIMESTAMP NOT NULL,
+    updated_at              TIMESTAMP NOT NULL,
+    gender_probability      REAL,
+    nationality_probability REAL,
+    deleted_at              TIMESTAMP,
+    deleted_reason          TEXT      NOT NULL,
+    merged_into             INTEGER,
+    version                 INTEGER   NOT NULL,
+    message_id              TEXT      NOT NULL,
+    source                  TEXT      NOT NULL,
+    correlation_id          TEXT      NOT NULL,
+    produced_at             TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS user_versions_user_id_idx ON user_versions (id, changed_at);
+
+CREATE TABLE IF NOT EXISTS duplicate_candidates
+(
+    user_id      INTEGER   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    duplicate_id INTEGER   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    similarity   REAL      NOT NULL,
+    dismissed    BOOLEAN   NOT NULL DEFAULT FALSE,
+    detected_at  TIMESTAMP NOT NULL,
+    PRIMARY KEY (user_id, duplicate_id),
+    CHECK (user_id < duplicate_id)
+);
+
+CREATE INDEX IF NOT EXISTS duplicate_candidates_similarity_idx ON duplicate_candidates (similarity DESC) WHERE dismissed = FALSE;
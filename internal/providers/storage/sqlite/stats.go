@@ -0,0 +1,60 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"github.com/zuzi90/tz-enricher/internal/providers/storage"
+	"strings"
+)
+
+// GetStats counts the users selected by params grouped by params.Dimension
+// in a single aggregate query. Age groups are returned in order, empty ones
+// included, other groups by count descending.
+func (s *Storage) GetStats(ctx context.Context, params models.StatsParams) (*models.Stats, error) {
+	where, _, args := usersWhere(params.Text, params.Match, params.UserFilter)
+
+	var key string
+
+	switch params.Dimension {
+	case models.StatsNationality:
+		key = `upper(nationality)`
+	case models.StatsGender:
+		key = `lower(gender)`
+	case models.StatsAge:
+		// width_bucket: the number of bounds not above the age
+		bounds := make([]string, 0, len(params.AgeBuckets)+1)
+		bounds = append(bounds, `0`)
+
+		for _, bucket := range params.AgeBuckets {
+			args = append(args, bucket)
+			bounds = append(bounds, `(age >= `+param(args)+`)`)
+		}
+
+		key = `CAST(` + strings.Join(bounds, ` + `) + ` AS TEXT)`
+	default:
+		return nil, fmt.Errorf("%w: unknown statistics dimension %q", models.ErrInvalidParam, params.Dimension)
+	}
+
+	groups := make([]models.StatsGroup, 0)
+
+	query := `SELECT ` + key + ` AS key, count(*) AS count FROM users` + where + ` GROUP BY 1 ORDER BY count DESC, key`
+
+	if err := s.db.SelectContext(ctx, &groups, query, args...); err != nil {
+		return nil, err
+	}
+
+	if params.Dimension == models.StatsAge {
+		var err error
+		if groups, err = storage.AgeGroups(params.AgeBuckets, groups); err != nil {
+			return nil, err
+		}
+	}
+
+	stats := models.Stats{Dimension: params.Dimension, Groups: groups}
+	for _, group := range groups {
+		stats.Total += group.Count
+	}
+
+	return &stats, nil
+}
@@ -0,0 +1,93 @@
+// Package sqlite is a storage in a SQLite file, for local runs without
+// Postgres. Its queries follow the Postgres storage, pg_trgm similarity is
+// provided by storage.Similarity registered as a SQL function.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/mattn/go-sqlite3"
+	"github.com/sirupsen/logrus"
+	"github.com/zuzi90/tz-enricher/internal/providers/storage"
+	"net/url"
+	"time"
+)
+
+// driverName is go-sqlite3 with the similarity function.
+const driverName = "sqlite3_enricher"
+
+func init() {
+	sql.Register(driverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("similarity", storage.Similarity, true)
+		},
+	})
+}
+
+//go:embed schema.sql
+var schema string
+
+type Storage struct {
+	db  *sqlx.DB
+	log *logrus.Entry
+}
+
+// NewStorage opens the database at path, creating it and its tables when
+// missing. ":memory:" keeps the database in memory until CloseDB.
+func NewStorage(ctx context.Context, log *logrus.Logger, path string) (*Storage, error) {
+	params := url.Values{}
+	params.Set("_foreign_keys", "1")
+	params.Set("_busy_timeout", "5000")
+	// writers take the lock when the transaction begins, so that two of them
+	// never deadlock upgrading a read lock
+	params.Set("_txlock", "immediate")
+
+	if path != ":memory:" {
+		params.Set("_journal_mode", "WAL")
+	}
+
+	db, err := sqlx.Open(driverName, "file:"+path+"?"+params.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("err opening %s: %w", path, err)
+	}
+
+	if path == ":memory:" {
+		// every connection would have its own database
+		db.SetMaxOpenConns(1)
+	}
+
+	if _, err = db.ExecContext(ctx, schema); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("err creating schema: %w", err)
+	}
+
+	return &Storage{db: db, log: log.WithField("module", "storage")}, nil
+}
+
+func (s *Storage) CloseDB() error {
+	return s.db.Close()
+}
+
+// write runs fn in a transaction committed when fn succeeds.
+func (s *Storage) write(ctx context.Context, fn func(tx *sqlx.Tx) error) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err = fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// now is the time of a change. Times are bound in UTC, so that their text
+// compares in time order.
+func now() time.Time {
+	return time.Now().UTC()
+}
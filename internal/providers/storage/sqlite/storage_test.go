@@ -0,0 +1,46 @@
+package sqlite
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"github.com/zuzi90/tz-enricher/internal/providers/storage"
+	"github.com/zuzi90/tz-enricher/internal/providers/storage/storagetest"
+	"path/filepath"
+	"testing"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		s, err := NewStorage(context.Background(), logrus.New(), filepath.Join(t.TempDir(), "users.db"))
+		require.NoError(t, err)
+
+		t.Cleanup(func() {
+			_ = s.CloseDB()
+		})
+
+		return s
+	})
+}
+
+// TestStorage_memory checks that an in-memory database is shared by all
+// queries, as each connection would open its own.
+func TestStorage_memory(t *testing.T) {
+	ctx := context.Background()
+
+	s, err := NewStorage(ctx, logrus.New(), ":memory:")
+	require.NoError(t, err)
+
+	defer func() {
+		_ = s.CloseDB()
+	}()
+
+	user, err := s.CreateUser(ctx, models.UserCreate{Name: "Frodo", Age: 50})
+	require.NoError(t, err)
+
+	got, err := s.GetUser(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Frodo", got.Name)
+}
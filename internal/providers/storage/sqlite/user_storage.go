@@ -0,0 +1,579 @@
+package sqlite
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"github.com/zuzi90/tz-enricher/internal/providers/storage"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const userColumns = `id, name, surname, patronymic, age, gender, nationality, is_deleted, created_at, updated_at,
+			 gender_probability, nationality_probability, deleted_at, deleted_reason, merged_into, version,
+			 message_id, source, correlation_id, produced_at`
+
+// param is the placeholder of the last argument of args.
+func param(args []interface{}) string {
+	return `?` + strconv.Itoa(len(args))
+}
+
+// inList appends values to args and returns their placeholders, e.g.
+// "?3, ?4".
+func inList(values []interface{}, args []interface{}) (string, []interface{}) {
+	params := make([]string, 0, len(values))
+
+	for _, value := range values {
+		args = append(args, value)
+		params = append(params, param(args))
+	}
+
+	return strings.Join(params, `, `), args
+}
+
+func intValues(ids []int) []interface{} {
+	values := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		values = append(values, id)
+	}
+
+	return values
+}
+
+// record writes the current state of the users to user_versions.
+func record(ctx context.Context, tx *sqlx.Tx, op models.Operation, at time.Time, ids ...int) error {
+	args := []interface{}{string(op), string(models.ChangeSourceFrom(ctx)), at}
+
+	in, args := inList(intValues(ids), args)
+
+	_, err := tx.ExecContext(ctx, `INSERT INTO user_versions (operation, change_source, changed_at, `+userColumns+`)
+			 SELECT ?1, ?2, ?3, `+userColumns+` FROM users WHERE id IN (`+in+`) ORDER BY id`, args...)
+
+	return err
+}
+
+// usersByID selects the users, deleted or not, in id order.
+func usersByID(ctx context.Context, q sqlx.QueryerContext, ids ...int) ([]*models.User, error) {
+	users := make([]*models.User, 0, len(ids))
+
+	in, args := inList(intValues(ids), nil)
+
+	err := sqlx.SelectContext(ctx, q, &users, `SELECT `+userColumns+` FROM users WHERE id IN (`+in+`) ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+func (s *Storage) CreateUser(ctx context.Context, val models.UserCreate) (*models.User, error) {
+	users, err := s.CreateUsers(ctx, []models.UserCreate{val})
+	if err != nil {
+		return &models.User{}, err
+	}
+
+	return users[0], nil
+}
+
+// CreateUsers inserts the users in one transaction and returns them in the
+// same order.
+func (s *Storage) CreateUsers(ctx context.Context, vals []models.UserCreate) ([]*models.User, error) {
+	if len(vals) == 0 {
+		return nil, nil
+	}
+
+	var users []*models.User
+
+	err := s.write(ctx, func(tx *sqlx.Tx) error {
+		at := now()
+		ids := make([]int, 0, len(vals))
+
+		for _, val := range vals {
+			result, err := tx.ExecContext(ctx, `INSERT INTO users(name, surname, patronymic, age, gender, nationality,
+			                   gender_probability, nationality_probability,
+			                   message_id, source, correlation_id, produced_at, created_at, updated_at)
+			 VALUES(?1,?2,?3,?4,?5,?6,?7,?8,?9,?10,?11,?12,?13,?13)`,
+				val.Name, val.Surname, val.Patronymic, val.Age, val.Gender, val.Nationality,
+				val.GenderProbability, val.NationalityProbability, val.MessageID, val.Source, val.CorrelationID, val.ProducedAt, at)
+			if err != nil {
+				return err
+			}
+
+			id, err := result.LastInsertId()
+			if err != nil {
+				return err
+			}
+
+			ids = append(ids, int(id))
+		}
+
+		if err := record(ctx, tx, models.OperationCreate, at, ids...); err != nil {
+			return err
+		}
+
+		// ids grow in the order of the values
+		var err error
+		users, err = usersByID(ctx, tx, ids...)
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+func (s *Storage) GetUser(ctx context.Context, id int) (*models.User, error) {
+	var user models.User
+
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = ?1 AND is_deleted = FALSE`
+
+	err := s.db.GetContext(ctx, &user, query, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrUserNotFound
+		}
+
+		return &models.User{}, err
+	}
+
+	return &user, nil
+}
+
+func (s *Storage) GetUsers(ctx context.Context, params models.GetUsersParams) (*models.UsersPage, error) {
+	where, rank, args := usersWhere(params.Text, params.Match, params.UserFilter)
+
+	page := models.UsersPage{Items: make([]*models.User, 0)}
+
+	// SQLite has no row estimates, the estimate is the exact count
+	if params.Total == models.TotalExact || params.Total == models.TotalEstimate {
+		var total int64
+		if err := s.db.GetContext(ctx, &total, `SELECT count(*) FROM users`+where, args...); err != nil {
+			return nil, err
+		}
+
+		page.Total = &total
+	}
+
+	keys := storage.SortKeys(params)
+	spec := storage.SortSpec(keys)
+	offset := params.Offset
+
+	if rank != "" {
+		spec = "rank," + spec
+	}
+
+	var builder bytes.Buffer
+
+	builder.WriteString(`SELECT ` + userColumns + ` FROM users` + where)
+
+	keyset := params.Cursor != nil && rank == ""
+
+	switch {
+	case params.Cursor != nil && params.Cursor.Sort != spec:
+		return nil, fmt.Errorf("%w: cursor does not match the requested order", models.ErrInvalidParam)
+	case keyset && len(params.Cursor.Values) != len(keys)-1:
+		return nil, fmt.Errorf("%w: malformed cursor", models.ErrInvalidParam)
+	case keyset:
+		var cond string
+		var err error
+		if cond, args, err = keysetCondition(keys, params.Cursor, args); err != nil {
+			return nil, err
+		}
+
+		builder.WriteString(` AND ` + cond)
+	case params.Cursor != nil:
+		// ranked search results have no stable key to continue from
+		offset = params.Cursor.Offset
+	}
+
+	builder.WriteString(` ORDER BY `)
+	if rank != "" {
+		builder.WriteString(rank + ` DESC, `)
+	}
+
+	for i, key := range keys {
+		if i > 0 {
+			builder.WriteString(`, `)
+		}
+
+		builder.WriteString(key.Column)
+		if key.Desc {
+			builder.WriteString(` DESC`)
+		}
+	}
+
+	// one extra row tells whether there is a next page
+	args = append(args, params.Limit+1)
+	builder.WriteString(` LIMIT ` + param(args))
+
+	if !keyset {
+		args = append(args, offset)
+		builder.WriteString(` OFFSET ` + param(args))
+	}
+
+	err := s.db.SelectContext(ctx, &page.Items, builder.String(), args...)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(page.Items) > params.Limit {
+		page.Items = page.Items[:params.Limit]
+
+		cursor := models.Cursor{Sort: spec, Offset: offset + params.Limit}
+		if rank == "" {
+			cursor = storage.NewCursor(keys, spec, page.Items[len(page.Items)-1])
+		}
+
+		page.Cursor = cursor.Encode()
+	}
+
+	return &page, nil
+}
+
+// usersWhere builds the " WHERE ..." clause selecting the users of the list
+// endpoint, rank is the search similarity when text is ranked.
+func usersWhere(text string, match models.MatchMode, f models.UserFilter) (where, rank string, args []interface{}) {
+	var builder strings.Builder
+
+	builder.WriteString(` WHERE ` + deletedCondition(f.Deleted))
+
+	var filter string
+	filter, args = filterConditions(f, args)
+	builder.WriteString(filter)
+
+	if text != "" {
+		var cond string
+		cond, rank, args = searchCondition(match, text, args)
+		builder.WriteString(` AND ` + cond)
+	}
+
+	return builder.String(), rank, args
+}
+
+// filterConditions returns the " AND ..." conditions of the set filter
+// fields with their arguments appended to args.
+func filterConditions(f models.UserFilter, args []interface{}) (string, []interface{}) {
+	var builder strings.Builder
+
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		builder.WriteString(` AND ` + strings.ReplaceAll(cond, `?`, param(args)))
+	}
+
+	if f.MinAge != nil {
+		add(`age >= ?`, *f.MinAge)
+	}
+
+	if f.MaxAge != nil {
+		add(`age <= ?`, *f.MaxAge)
+	}
+
+	if f.Gender != "" {
+		add(`lower(gender) = ?`, strings.ToLower(f.Gender))
+	}
+
+	if len(f.Nationalities) > 0 {
+		nationalities := make([]interface{}, 0, len(f.Nationalities))
+		for _, nationality := range f.Nationalities {
+			nationalities = append(nationalities, strings.ToUpper(nationality))
+		}
+
+		var in string
+		in, args = inList(nationalities, args)
+		builder.WriteString(` AND upper(nationality) IN (` + in + `)`)
+	}
+
+	if f.CreatedFrom != nil {
+		add(`created_at >= ?`, f.CreatedFrom.UTC())
+	}
+
+	if f.CreatedTo != nil {
+		add(`created_at <= ?`, f.CreatedTo.UTC())
+	}
+
+	if f.UpdatedFrom != nil {
+		add(`updated_at >= ?`, f.UpdatedFrom.UTC())
+	}
+
+	if f.UpdatedTo != nil {
+		add(`updated_at <= ?`, f.UpdatedTo.UTC())
+	}
+
+	if f.HasPatronymic != nil {
+		if *f.HasPatronymic {
+			builder.WriteString(` AND patronymic <> ''`)
+		} else {
+			builder.WriteString(` AND patronymic = ''`)
+		}
+	}
+
+	if f.MinConfidence != nil {
+		// min() is NULL when either is, LEAST of Postgres skips NULL
+		add(`COALESCE(min(gender_probability, nationality_probability), gender_probability, nationality_probability) >= ?`,
+			*f.MinConfidence)
+	}
+
+	return builder.String(), args
+}
+
+// searchCondition returns the WHERE condition matching text against the
+// name columns and, for fuzzy search, the similarity expression to rank by.
+// LIKE of SQLite ignores the case of ASCII letters, as ILIKE does.
+func searchCondition(mode models.MatchMode, text string, args []interface{}) (string, string, []interface{}) {
+	like := func(n string) string {
+		return `name LIKE ` + n + ` ESCAPE '\' OR surname LIKE ` + n + ` ESCAPE '\' OR patronymic LIKE ` + n + ` ESCAPE '\'`
+	}
+
+	switch mode {
+	case models.MatchExact:
+		args = append(args, escapeLike(text))
+
+		return `(` + like(param(args)) + `)`, "", args
+	case models.MatchPrefix:
+		args = append(args, escapeLike(text)+"%")
+
+		return `(` + like(param(args)) + `)`, "", args
+	default:
+		args = append(args, "%"+escapeLike(text)+"%", text, storage.SimilarityThreshold)
+		p, t, th := `?`+strconv.Itoa(len(args)-2), `?`+strconv.Itoa(len(args)-1), param(args)
+
+		cond := `(` + like(p) + ` OR similarity(name, ` + t + `) >= ` + th +
+			` OR similarity(surname, ` + t + `) >= ` + th + ` OR similarity(patronymic, ` + t + `) >= ` + th + `)`
+		rank := `max(similarity(name, ` + t + `), similarity(surname, ` + t + `), similarity(patronymic, ` + t + `))`
+
+		return cond, rank, args
+	}
+}
+
+// deletedCondition selects users by their soft deletion state.
+func deletedCondition(mode models.DeletedMode) string {
+	switch mode {
+	case models.DeletedInclude:
+		return `TRUE`
+	case models.DeletedOnly:
+		return `is_deleted = TRUE`
+	default:
+		return `is_deleted = FALSE`
+	}
+}
+
+// escapeLike escapes the LIKE wildcards, so user input is matched literally.
+func escapeLike(text string) string {
+	return likeEscaper.Replace(text)
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// UpdateUser applies the set fields of user. With versions given, the user
+// is updated only if its current version is one of them, otherwise
+// ErrVersionMismatch is returned.
+func (s *Storage) UpdateUser(ctx context.Context, user models.UserUpdate, id int, versions []int) (*models.User, error) {
+	var updated *models.User
+
+	err := s.write(ctx, func(tx *sqlx.Tx) error {
+		at := now()
+		args := []interface{}{at}
+
+		var builder bytes.Buffer
+
+		builder.WriteString(`UPDATE users SET updated_at = ?1, version = version + 1`)
+
+		set := func(column string, value interface{}) {
+			args = append(args, value)
+			builder.WriteString(`, ` + column + ` = ` + param(args))
+		}
+
+		if user.Name != nil {
+			set("name", *user.Name)
+		}
+
+		if user.Surname != nil {
+			set("surname", *user.Surname)
+		}
+
+		if user.Patronymic != nil {
+			set("patronymic", *user.Patronymic)
+		}
+
+		if user.Age != nil {
+			set("age", *user.Age)
+		}
+
+		if user.Gender != nil {
+			set("gender", *user.Gender)
+		}
+
+		if user.Nationality != nil {
+			set("nationality", *user.Nationality)
+		}
+
+		args = append(args, id)
+		builder.WriteString(` WHERE id = ` + param(args) + ` AND is_deleted = FALSE`)
+
+		if len(versions) > 0 {
+			var in string
+			in, args = inList(intValues(versions), args)
+			builder.WriteString(` AND version IN (` + in + `)`)
+		}
+
+		changed, err := exec(ctx, tx, builder.String(), args...)
+		if err != nil {
+			return err
+		}
+
+		if !changed {
+			return updateMissed(ctx, tx, id, versions)
+		}
+
+		if err = record(ctx, tx, models.OperationUpdate, at, id); err != nil {
+			return err
+		}
+
+		updated, err = userByID(ctx, tx, id)
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
+
+// exec runs a statement and tells whether it changed any row.
+func exec(ctx context.Context, tx *sqlx.Tx, query string, args ...interface{}) (bool, error) {
+	result, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows > 0, nil
+}
+
+func userByID(ctx context.Context, tx *sqlx.Tx, id int) (*models.User, error) {
+	var user models.User
+
+	if err := tx.GetContext(ctx, &user, `SELECT `+userColumns+` FROM users WHERE id = ?1`, id); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// updateMissed tells why a conditional update changed nothing: the user is
+// gone or its version did not match.
+func updateMissed(ctx context.Context, tx *sqlx.Tx, id int, versions []int) error {
+	if len(versions) == 0 {
+		return models.ErrNoRows
+	}
+
+	var exists bool
+
+	err := tx.GetContext(ctx, &exists, `SELECT EXISTS (SELECT 1 FROM users WHERE id = ?1 AND is_deleted = FALSE)`, id)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return models.ErrNoRows
+	}
+
+	return models.ErrVersionMismatch
+}
+
+func (s *Storage) DeleteUser(ctx context.Context, id int, reason string) error {
+	return s.write(ctx, func(tx *sqlx.Tx) error {
+		at := now()
+
+		changed, err := exec(ctx, tx, `UPDATE users SET is_deleted = TRUE, deleted_at = ?1, deleted_reason = ?2, version = version + 1
+			 WHERE id = ?3 AND is_deleted = FALSE`, at, reason, id)
+		if err != nil {
+			return err
+		}
+
+		if !changed {
+			return models.ErrUserNotFound
+		}
+
+		return record(ctx, tx, models.OperationDelete, at, id)
+	})
+}
+
+// RestoreUser undoes DeleteUser, ErrUserNotFound is returned when the user
+// does not exist or is not deleted.
+func (s *Storage) RestoreUser(ctx context.Context, id int) (*models.User, error) {
+	var restored *models.User
+
+	err := s.write(ctx, func(tx *sqlx.Tx) error {
+		at := now()
+
+		changed, err := exec(ctx, tx, `UPDATE users SET is_deleted = FALSE, deleted_at = NULL, deleted_reason = '', updated_at = ?1,
+			                  version = version + 1
+			 WHERE id = ?2 AND is_deleted = TRUE`, at, id)
+		if err != nil {
+			return err
+		}
+
+		if !changed {
+			return models.ErrUserNotFound
+		}
+
+		if err = record(ctx, tx, models.OperationRestore, at, id); err != nil {
+			return err
+		}
+
+		restored, err = userByID(ctx, tx, id)
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return restored, nil
+}
+
+// PurgeUser permanently removes the user, deleted or not, and its history.
+func (s *Storage) PurgeUser(ctx context.Context, id int) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM users WHERE id = ?1`, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return models.ErrUserNotFound
+	}
+
+	return nil
+}
+
+// PurgeDeleted permanently removes users soft-deleted before the given time
+// with their history and returns their ids.
+func (s *Storage) PurgeDeleted(ctx context.Context, before time.Time) ([]int, error) {
+	ids := make([]int, 0)
+
+	query := `DELETE FROM users WHERE is_deleted = TRUE AND deleted_at < ?1 RETURNING id`
+
+	if err := s.db.SelectContext(ctx, &ids, query, before.UTC()); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
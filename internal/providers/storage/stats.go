@@ -0,0 +1,27 @@
+package storage
+
+import (
+	"fmt"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"strconv"
+)
+
+// AgeGroups turns width_bucket indexes counted by a backend into labels and
+// adds the empty groups.
+func AgeGroups(buckets []int, counted []models.StatsGroup) ([]models.StatsGroup, error) {
+	groups := make([]models.StatsGroup, len(buckets)+1)
+	for i := range groups {
+		groups[i].Key = models.AgeBucketLabel(buckets, i)
+	}
+
+	for _, group := range counted {
+		i, err := strconv.Atoi(group.Key)
+		if err != nil || i < 0 || i >= len(groups) {
+			return nil, fmt.Errorf("unexpected age bucket %q", group.Key)
+		}
+
+		groups[i].Count = group.Count
+	}
+
+	return groups, nil
+}
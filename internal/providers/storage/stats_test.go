@@ -0,0 +1,22 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"testing"
+)
+
+func Test_AgeGroups(t *testing.T) {
+	groups, err := AgeGroups([]int{18, 30}, []models.StatsGroup{{Key: "2", Count: 4}, {Key: "0", Count: 1}})
+	require.NoError(t, err)
+
+	assert.Equal(t, []models.StatsGroup{
+		{Key: "0-17", Count: 1},
+		{Key: "18-29", Count: 0},
+		{Key: "30+", Count: 4},
+	}, groups)
+
+	_, err = AgeGroups([]int{18}, []models.StatsGroup{{Key: "5", Count: 1}})
+	assert.Error(t, err)
+}
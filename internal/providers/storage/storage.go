@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"context"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Kinds of storage selectable with the STORAGE setting.
+const (
+	KindPostgres = "postgres"
+	KindMemory   = "memory"
+	KindSQLite   = "sqlite"
+)
+
+// Storage keeps the users, their history and the duplicate candidates.
+// psql.Storage, memory.Storage and sqlite.Storage implement it, the
+// storagetest suite checks that they behave the same.
+type Storage interface {
+	CreateUser(ctx context.Context, val models.UserCreate) (*models.User, error)
+	CreateUsers(ctx context.Context, vals []models.UserCreate) ([]*models.User, error)
+	GetUser(ctx context.Context, id int) (*models.User, error)
+	GetUsers(ctx context.Context, params models.GetUsersParams) (*models.UsersPage, error)
+	UpdateUser(ctx context.Context, user models.UserUpdate, id int, versions []int) (*models.User, error)
+	DeleteUser(ctx context.Context, id int, reason string) error
+	RestoreUser(ctx context.Context, id int) (*models.User, error)
+	PurgeUser(ctx context.Context, id int) error
+	PurgeDeleted(ctx context.Context, before time.Time) ([]int, error)
+	GetUserHistory(ctx context.Context, id int) ([]*models.UserVersion, error)
+	GetUserAsOf(ctx context.Context, id int, at time.Time) (*models.User, error)
+	DetectDuplicates(ctx context.Context, threshold float64) (int64, error)
+	GetDuplicates(ctx context.Context, params models.GetDuplicatesParams) ([]*models.DuplicateCandidate, error)
+	DismissDuplicate(ctx context.Context, pair models.DuplicatePair) error
+	MergeUser(ctx context.Context, survivorID, duplicateID int) (*models.User, error)
+	GetStats(ctx context.Context, params models.StatsParams) (*models.Stats, error)
+	ExportUsers(ctx context.Context, params models.ExportParams, fn func(user *models.User) error) error
+	CloseDB() error
+}
+
+// SimilarityThreshold is the similarity from which pg_trgm considers two
+// strings similar, the default of pg_trgm.similarity_threshold.
+const SimilarityThreshold = 0.3
+
+// Similarity is the pg_trgm similarity of a and b: the share of trigrams
+// they have in common. Backends without pg_trgm use it to match and rank
+// the same way.
+func Similarity(a, b string) float64 {
+	ta, tb := trigrams(a), trigrams(b)
+	if len(ta) == 0 || len(tb) == 0 {
+		return 0
+	}
+
+	common := 0
+
+	for t := range ta {
+		if tb[t] {
+			common++
+		}
+	}
+
+	return float64(common) / float64(len(ta)+len(tb)-common)
+}
+
+// trigrams returns the trigrams of the lower-cased words of s, each word
+// padded with two spaces in front and one behind as pg_trgm does.
+func trigrams(s string) map[string]bool {
+	set := make(map[string]bool)
+
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	for _, word := range words {
+		runes := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(runes); i++ {
+			set[string(runes[i:i+3])] = true
+		}
+	}
+
+	return set
+}
@@ -0,0 +1,15 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_Similarity(t *testing.T) {
+	assert.InDelta(t, 1.0, Similarity("Frodo", "frodo"), 1e-6)
+	assert.InDelta(t, 0.0, Similarity("Frodo", ""), 1e-6)
+	assert.InDelta(t, 0.0, Similarity("Frodo", "Sam"), 1e-6)
+	// the example of the pg_trgm documentation
+	assert.InDelta(t, 0.363636, Similarity("word", "two words"), 1e-6)
+	assert.InDelta(t, 0.545454, Similarity("Yitzhak", "Yitzchak"), 1e-6)
+}
@@ -0,0 +1,620 @@
+// Package storagetest is the conformance suite of the storage backends.
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"github.com/zuzi90/tz-enricher/internal/providers/storage"
+	"testing"
+	"time"
+)
+
+// Run checks that a backend behaves like the Postgres storage. newStorage
+// returns an empty storage for each test.
+func Run(t *testing.T, newStorage func(t *testing.T) storage.Storage) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s storage.Storage)
+	}{
+		{"create", testCreate},
+		{"update", testUpdate},
+		{"delete and restore", testDelete},
+		{"purge", testPurge},
+		{"list", testGetUsers},
+		{"history", testHistory},
+		{"duplicates", testDuplicates},
+		{"duplicates below the pg_trgm default threshold", testDuplicatesLowThreshold},
+		{"stats", testStats},
+		{"export", testExport},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newStorage(t))
+		})
+	}
+}
+
+var ctx = models.WithChangeSource(context.Background(), models.ChangeREST)
+
+func probability(p float64) *float64 {
+	return &p
+}
+
+// fixture are the users of the list tests, created in this order. Names
+// sort the same in byte order and in the usual collations.
+var fixture = []models.UserCreate{
+	{Name: "Frodo", Surname: "Baggins", Age: 50, Gender: "male", Nationality: "NZ",
+		GenderProbability: probability(0.99), NationalityProbability: probability(0.8)},
+	{Name: "Hamfast", Surname: "Gamgie", Age: 70, Gender: "male", Nationality: "GB"},
+	{Name: "Samwise", Surname: "Gamgee", Age: 38, Gender: "male", Nationality: "GB",
+		GenderProbability: probability(0.9), NationalityProbability: probability(0.4)},
+	{Name: "Arwen", Surname: "Undomiel", Patronymic: "Elrondovna", Age: 27, Gender: "female", Nationality: "NZ",
+		GenderProbability: probability(0.95), NationalityProbability: probability(0.9)},
+	{Name: "Eowyn", Surname: "Rohan", Age: 24, Gender: "female", Nationality: "DK"},
+	{Name: "Bilbo", Surname: "Baggins", Patronymic: "Bungovich", Age: 111, Gender: "male", Nationality: "NZ",
+		GenderProbability: probability(0.97)},
+}
+
+func create(t *testing.T, s storage.Storage, vals ...models.UserCreate) []*models.User {
+	users := make([]*models.User, 0, len(vals))
+
+	for _, val := range vals {
+		user, err := s.CreateUser(ctx, val)
+		require.NoError(t, err)
+
+		users = append(users, user)
+	}
+
+	return users
+}
+
+func names(users []*models.User) []string {
+	result := make([]string, 0, len(users))
+	for _, user := range users {
+		result = append(result, user.Name)
+	}
+
+	return result
+}
+
+func testCreate(t *testing.T, s storage.Storage) {
+	produced := time.Date(2024, 5, 19, 21, 6, 10, 0, time.UTC)
+
+	val := fixture[0]
+	val.Provenance = models.Provenance{MessageID: "FN/0/42", Source: "FN", CorrelationID: "c-1", ProducedAt: &produced}
+
+	user := create(t, s, val)[0]
+
+	assert.Positive(t, user.ID)
+	assert.Equal(t, 1, user.Version)
+	assert.False(t, user.IsDeleted)
+	assert.False(t, user.CreatedAt.IsZero())
+
+	got, err := s.GetUser(ctx, user.ID)
+	require.NoError(t, err)
+
+	assert.Equal(t, user.ID, got.ID)
+	assert.Equal(t, "Frodo", got.Name)
+	assert.Equal(t, "Baggins", got.Surname)
+	assert.Equal(t, 50, got.Age)
+	assert.Equal(t, "male", got.Gender)
+	assert.Equal(t, "NZ", got.Nationality)
+	require.NotNil(t, got.GenderProbability)
+	assert.InDelta(t, 0.99, *got.GenderProbability, 1e-6)
+	assert.Equal(t, "FN/0/42", got.MessageID)
+	assert.Equal(t, "c-1", got.CorrelationID)
+	require.NotNil(t, got.ProducedAt)
+	assert.True(t, produced.Equal(*got.ProducedAt))
+
+	_, err = s.GetUser(ctx, user.ID+1000)
+	assert.ErrorIs(t, err, models.ErrUserNotFound)
+
+	users, err := s.CreateUsers(ctx, fixture[1:3])
+	require.NoError(t, err)
+	require.Len(t, users, 2)
+	assert.Equal(t, []string{"Hamfast", "Samwise"}, names(users))
+	assert.Less(t, user.ID, users[0].ID)
+	assert.Less(t, users[0].ID, users[1].ID)
+	assert.Equal(t, 1, users[1].Version)
+
+	users, err = s.CreateUsers(ctx, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, users)
+}
+
+func testUpdate(t *testing.T, s storage.Storage) {
+	user := create(t, s, fixture[0])[0]
+
+	age, nationality := 51, "GB"
+
+	updated, err := s.UpdateUser(ctx, models.UserUpdate{Age: &age, Nationality: &nationality}, user.ID, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, "Frodo", updated.Name)
+	assert.Equal(t, 51, updated.Age)
+	assert.Equal(t, "GB", updated.Nationality)
+	assert.Equal(t, 2, updated.Version)
+	assert.False(t, updated.UpdatedAt.Before(user.UpdatedAt))
+
+	_, err = s.UpdateUser(ctx, models.UserUpdate{Age: &age}, user.ID, []int{1})
+	assert.ErrorIs(t, err, models.ErrVersionMismatch)
+
+	updated, err = s.UpdateUser(ctx, models.UserUpdate{Age: &age}, user.ID, []int{1, 2})
+	require.NoError(t, err)
+	assert.Equal(t, 3, updated.Version)
+
+	_, err = s.UpdateUser(ctx, models.UserUpdate{Age: &age}, user.ID+1000, nil)
+	assert.ErrorIs(t, err, models.ErrNoRows)
+
+	_, err = s.UpdateUser(ctx, models.UserUpdate{Age: &age}, user.ID+1000, []int{1})
+	assert.ErrorIs(t, err, models.ErrNoRows)
+}
+
+func testDelete(t *testing.T, s storage.Storage) {
+	user := create(t, s, fixture[0])[0]
+
+	require.NoError(t, s.DeleteUser(ctx, user.ID, "spam"))
+
+	_, err := s.GetUser(ctx, user.ID)
+	assert.ErrorIs(t, err, models.ErrUserNotFound)
+	assert.ErrorIs(t, s.DeleteUser(ctx, user.ID, ""), models.ErrUserNotFound)
+
+	age := 51
+	_, err = s.UpdateUser(ctx, models.UserUpdate{Age: &age}, user.ID, nil)
+	assert.ErrorIs(t, err, models.ErrNoRows)
+
+	page, err := s.GetUsers(ctx, listParams(models.UserFilter{Deleted: models.DeletedOnly}))
+	require.NoError(t, err)
+	require.Len(t, page.Items, 1)
+
+	deleted := page.Items[0]
+	assert.True(t, deleted.IsDeleted)
+	assert.NotNil(t, deleted.DeletedAt)
+	assert.Equal(t, "spam", deleted.DeletedReason)
+	assert.Equal(t, 2, deleted.Version)
+
+	restored, err := s.RestoreUser(ctx, user.ID)
+	require.NoError(t, err)
+	assert.False(t, restored.IsDeleted)
+	assert.Nil(t, restored.DeletedAt)
+	assert.Empty(t, restored.DeletedReason)
+	assert.Equal(t, 3, restored.Version)
+
+	_, err = s.RestoreUser(ctx, user.ID)
+	assert.ErrorIs(t, err, models.ErrUserNotFound)
+
+	_, err = s.GetUser(ctx, user.ID)
+	assert.NoError(t, err)
+}
+
+func testPurge(t *testing.T, s storage.Storage) {
+	users := create(t, s, fixture[:3]...)
+
+	require.NoError(t, s.DeleteUser(ctx, users[0].ID, ""))
+	require.NoError(t, s.DeleteUser(ctx, users[1].ID, ""))
+
+	ids, err := s.PurgeDeleted(ctx, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+
+	ids, err = s.PurgeDeleted(ctx, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []int{users[0].ID, users[1].ID}, ids)
+
+	_, err = s.GetUserHistory(ctx, users[0].ID)
+	assert.ErrorIs(t, err, models.ErrUserNotFound)
+
+	require.NoError(t, s.PurgeUser(ctx, users[2].ID))
+	assert.ErrorIs(t, s.PurgeUser(ctx, users[2].ID), models.ErrUserNotFound)
+
+	_, err = s.GetUserHistory(ctx, users[2].ID)
+	assert.ErrorIs(t, err, models.ErrUserNotFound)
+}
+
+func listParams(filter models.UserFilter) models.GetUsersParams {
+	return models.GetUsersParams{Limit: 100, Match: models.MatchFuzzy, Total: models.TotalNone, UserFilter: filter}
+}
+
+// pages walks the cursors of the list and returns the names of all pages.
+func pages(t *testing.T, s storage.Storage, params models.GetUsersParams) []string {
+	var result []string
+
+	for i := 0; i < 10; i++ {
+		page, err := s.GetUsers(ctx, params)
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(page.Items), params.Limit)
+
+		result = append(result, names(page.Items)...)
+
+		if page.Cursor == "" {
+			return result
+		}
+
+		params.Cursor, err = models.ParseCursor(page.Cursor)
+		require.NoError(t, err)
+	}
+
+	t.Fatal("too many pages")
+
+	return nil
+}
+
+func testGetUsers(t *testing.T, s storage.Storage) {
+	create(t, s, fixture...)
+
+	yes, no := true, false
+	minAge, confidence := 30, 0.85
+
+	for _, tt := range []struct {
+		name   string
+		params models.GetUsersParams
+		want   []string
+	}{
+		{"all", listParams(models.UserFilter{}), []string{"Frodo", "Hamfast", "Samwise", "Arwen", "Eowyn", "Bilbo"}},
+		{"min age", listParams(models.UserFilter{MinAge: &minAge}), []string{"Frodo", "Hamfast", "Samwise", "Bilbo"}},
+		{"gender", listParams(models.UserFilter{Gender: "FEMALE"}), []string{"Arwen", "Eowyn"}},
+		{"nationality", listParams(models.UserFilter{Nationalities: []string{"nz", "DK"}}), []string{"Frodo", "Arwen", "Eowyn", "Bilbo"}},
+		{"with patronymic", listParams(models.UserFilter{HasPatronymic: &yes}), []string{"Arwen", "Bilbo"}},
+		{"without patronymic", listParams(models.UserFilter{HasPatronymic: &no}), []string{"Frodo", "Hamfast", "Samwise", "Eowyn"}},
+		// an unresolved probability does not count
+		{"min confidence", listParams(models.UserFilter{MinConfidence: &confidence}), []string{"Arwen", "Bilbo"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := s.GetUsers(ctx, tt.params)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, names(page.Items))
+		})
+	}
+
+	t.Run("search", func(t *testing.T) {
+		for _, tt := range []struct {
+			match models.MatchMode
+			text  string
+			want  []string
+		}{
+			{models.MatchExact, "baggins", []string{"Frodo", "Bilbo"}},
+			{models.MatchExact, "bag%", nil},
+			{models.MatchPrefix, "SAM", []string{"Samwise"}},
+			{models.MatchPrefix, "elrond", []string{"Arwen"}},
+			{models.MatchFuzzy, "rond", []string{"Arwen"}},
+			{models.MatchFuzzy, "Baggims", []string{"Frodo", "Bilbo"}},
+			// ranked by similarity, not by id
+			{models.MatchFuzzy, "Gamgee", []string{"Samwise", "Hamfast"}},
+		} {
+			params := listParams(models.UserFilter{})
+			params.Text, params.Match = tt.text, tt.match
+
+			page, err := s.GetUsers(ctx, params)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, nilIfEmpty(names(page.Items)), "%s %q", tt.match, tt.text)
+		}
+	})
+
+	t.Run("sort and cursor", func(t *testing.T) {
+		params := listParams(models.UserFilter{})
+		params.Limit = 2
+		params.Sort = []models.SortField{{Column: "age", Desc: true}}
+
+		assert.Equal(t, []string{"Bilbo", "Hamfast", "Frodo", "Samwise", "Arwen", "Eowyn"}, pages(t, s, params))
+
+		params.Limit = 4
+		params.Sort = []models.SortField{{Column: "surname"}}
+
+		assert.Equal(t, []string{"Frodo", "Bilbo", "Samwise", "Hamfast", "Eowyn", "Arwen"}, pages(t, s, params))
+
+		params.Limit = 1
+		params.Sort = nil
+		params.Text = "Gamgee"
+
+		assert.Equal(t, []string{"Samwise", "Hamfast"}, pages(t, s, params))
+	})
+
+	t.Run("offset", func(t *testing.T) {
+		params := listParams(models.UserFilter{})
+		params.Limit, params.Offset = 2, 4
+
+		page, err := s.GetUsers(ctx, params)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Eowyn", "Bilbo"}, names(page.Items))
+	})
+
+	t.Run("cursor of another order", func(t *testing.T) {
+		params := listParams(models.UserFilter{})
+		params.Limit = 2
+
+		page, err := s.GetUsers(ctx, params)
+		require.NoError(t, err)
+
+		params.Cursor, err = models.ParseCursor(page.Cursor)
+		require.NoError(t, err)
+
+		params.Sort = []models.SortField{{Column: "age"}}
+
+		_, err = s.GetUsers(ctx, params)
+		assert.ErrorIs(t, err, models.ErrInvalidParam)
+	})
+
+	t.Run("total", func(t *testing.T) {
+		params := listParams(models.UserFilter{})
+		params.Limit = 2
+
+		page, err := s.GetUsers(ctx, params)
+		require.NoError(t, err)
+		assert.Nil(t, page.Total)
+
+		params.Total = models.TotalExact
+
+		page, err = s.GetUsers(ctx, params)
+		require.NoError(t, err)
+		require.NotNil(t, page.Total)
+		assert.Equal(t, int64(6), *page.Total)
+
+		params.Total = models.TotalEstimate
+
+		page, err = s.GetUsers(ctx, params)
+		require.NoError(t, err)
+		assert.NotNil(t, page.Total)
+	})
+
+	t.Run("deleted", func(t *testing.T) {
+		page, err := s.GetUsers(ctx, listParams(models.UserFilter{Gender: "female"}))
+		require.NoError(t, err)
+		require.NoError(t, s.DeleteUser(ctx, page.Items[1].ID, ""))
+
+		for mode, want := range map[models.DeletedMode][]string{
+			models.DeletedExclude: {"Arwen"},
+			models.DeletedInclude: {"Arwen", "Eowyn"},
+			models.DeletedOnly:    {"Eowyn"},
+		} {
+			page, err = s.GetUsers(ctx, listParams(models.UserFilter{Gender: "female", Deleted: mode}))
+			require.NoError(t, err)
+			assert.Equal(t, want, names(page.Items), "deleted %q", mode)
+		}
+	})
+}
+
+func nilIfEmpty(values []string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+
+	return values
+}
+
+func testHistory(t *testing.T, s storage.Storage) {
+	kafkaCtx := models.WithChangeSource(context.Background(), models.ChangeKafka)
+
+	_, err := s.GetUserHistory(ctx, -1)
+	assert.ErrorIs(t, err, models.ErrUserNotFound)
+
+	before := time.Now()
+	time.Sleep(20 * time.Millisecond)
+
+	user, err := s.CreateUser(kafkaCtx, fixture[0])
+	require.NoError(t, err)
+
+	time.Sleep(20 * time.Millisecond)
+	created := time.Now()
+	time.Sleep(20 * time.Millisecond)
+
+	age := 51
+	_, err = s.UpdateUser(ctx, models.UserUpdate{Age: &age}, user.ID, nil)
+	require.NoError(t, err)
+	require.NoError(t, s.DeleteUser(ctx, user.ID, "spam"))
+
+	versions, err := s.GetUserHistory(ctx, user.ID)
+	require.NoError(t, err)
+	require.Len(t, versions, 3)
+
+	for i, want := range []struct {
+		op      models.Operation
+		source  models.ChangeSource
+		age     int
+		deleted bool
+	}{
+		{models.OperationCreate, models.ChangeKafka, 50, false},
+		{models.OperationUpdate, models.ChangeREST, 51, false},
+		{models.OperationDelete, models.ChangeREST, 51, true},
+	} {
+		assert.Equal(t, want.op, versions[i].Operation)
+		assert.Equal(t, want.source, versions[i].Source)
+		assert.Equal(t, user.ID, versions[i].User.ID)
+		assert.Equal(t, want.age, versions[i].Age)
+		assert.Equal(t, want.deleted, versions[i].IsDeleted)
+		assert.Equal(t, i+1, versions[i].Version)
+	}
+
+	_, err = s.GetUserAsOf(ctx, user.ID, before)
+	assert.ErrorIs(t, err, models.ErrUserNotFound)
+
+	old, err := s.GetUserAsOf(ctx, user.ID, created)
+	require.NoError(t, err)
+	assert.Equal(t, 50, old.Age)
+
+	latest, err := s.GetUserAsOf(ctx, user.ID, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.True(t, latest.IsDeleted)
+}
+
+func testDuplicates(t *testing.T, s storage.Storage) {
+	users := create(t, s,
+		models.UserCreate{Name: "Yitzhak", Surname: "Rabinowitz", Age: 73, Nationality: "IL"},
+		models.UserCreate{Name: "Yitzchak", Surname: "Rabinowitz", Patronymic: "Nehemiah", Age: 70,
+			Gender: "male", GenderProbability: probability(0.9), Nationality: "IL"},
+		fixture[0],
+	)
+	survivor, duplicate := users[0], users[1]
+
+	found, err := s.DetectDuplicates(ctx, 0.5)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), found)
+
+	params := models.GetDuplicatesParams{MinSimilarity: 0.5, Limit: 10}
+
+	candidates, err := s.GetDuplicates(ctx, params)
+	require.NoError(t, err)
+	require.Len(t, candidates, 1)
+
+	c := candidates[0]
+	assert.Equal(t, models.NewPair(survivor.ID, duplicate.ID), c.DuplicatePair)
+	assert.InDelta(t, 16.0/30, c.Similarity, 1e-3)
+	require.NotNil(t, c.User)
+	require.NotNil(t, c.Duplicate)
+	assert.Equal(t, "Yitzhak", c.User.Name)
+	assert.Equal(t, "Yitzchak", c.Duplicate.Name)
+
+	candidates, err = s.GetDuplicates(ctx, models.GetDuplicatesParams{MinSimilarity: 0.6, Limit: 10})
+	require.NoError(t, err)
+	assert.Empty(t, candidates)
+
+	require.NoError(t, s.DismissDuplicate(ctx, c.DuplicatePair))
+	assert.ErrorIs(t, s.DismissDuplicate(ctx, models.NewPair(survivor.ID, users[2].ID)), models.ErrUserNotFound)
+
+	_, err = s.DetectDuplicates(ctx, 0.5)
+	require.NoError(t, err)
+
+	candidates, err = s.GetDuplicates(ctx, params)
+	require.NoError(t, err)
+	assert.Empty(t, candidates, "dismissed pairs stay dismissed")
+
+	merged, err := s.MergeUser(ctx, survivor.ID, duplicate.ID)
+	require.NoError(t, err)
+
+	assert.Equal(t, survivor.ID, merged.ID)
+	assert.Equal(t, "Yitzhak", merged.Name)
+	assert.Equal(t, "Nehemiah", merged.Patronymic)
+	assert.Equal(t, 73, merged.Age)
+	assert.Equal(t, "male", merged.Gender)
+	require.NotNil(t, merged.GenderProbability)
+	assert.InDelta(t, 0.9, *merged.GenderProbability, 1e-6)
+	assert.Equal(t, 2, merged.Version)
+
+	_, err = s.GetUser(ctx, duplicate.ID)
+	assert.ErrorIs(t, err, models.ErrUserNotFound)
+
+	page, err := s.GetUsers(ctx, listParams(models.UserFilter{Deleted: models.DeletedOnly}))
+	require.NoError(t, err)
+	require.Len(t, page.Items, 1)
+	require.NotNil(t, page.Items[0].MergedInto)
+	assert.Equal(t, survivor.ID, *page.Items[0].MergedInto)
+	assert.Equal(t, fmt.Sprintf("merged into %d", survivor.ID), page.Items[0].DeletedReason)
+
+	versions, err := s.GetUserHistory(ctx, duplicate.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.OperationMerge, versions[len(versions)-1].Operation)
+
+	_, err = s.MergeUser(ctx, survivor.ID, duplicate.ID)
+	assert.ErrorIs(t, err, models.ErrUserNotFound)
+
+	// the survivor is kept, a merged user that is purged is forgotten
+	require.NoError(t, s.PurgeUser(ctx, survivor.ID))
+
+	page, err = s.GetUsers(ctx, listParams(models.UserFilter{Deleted: models.DeletedOnly}))
+	require.NoError(t, err)
+	require.Len(t, page.Items, 1)
+	assert.Nil(t, page.Items[0].MergedInto)
+}
+
+// testDuplicatesLowThreshold checks that thresholds below the pg_trgm
+// default of 0.3 are applied rather than cut off by the trigram operator.
+func testDuplicatesLowThreshold(t *testing.T, s storage.Storage) {
+	create(t, s,
+		models.UserCreate{Name: "Frodo", Surname: "Baggins"},
+		models.UserCreate{Name: "Frodo", Surname: "Gamgee"},
+	)
+
+	found, err := s.DetectDuplicates(ctx, storage.SimilarityThreshold)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), found)
+
+	found, err = s.DetectDuplicates(ctx, 0.25)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), found)
+
+	candidates, err := s.GetDuplicates(ctx, models.GetDuplicatesParams{MinSimilarity: 0.25, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, candidates, 1)
+	assert.InDelta(t, 2.0/7, candidates[0].Similarity, 1e-3)
+}
+
+func testStats(t *testing.T, s storage.Storage) {
+	create(t, s, fixture...)
+
+	for _, tt := range []struct {
+		params models.StatsParams
+		want   []models.StatsGroup
+	}{
+		{
+			models.StatsParams{Dimension: models.StatsGender},
+			[]models.StatsGroup{{Key: "male", Count: 4}, {Key: "female", Count: 2}},
+		},
+		{
+			models.StatsParams{Dimension: models.StatsNationality},
+			[]models.StatsGroup{{Key: "NZ", Count: 3}, {Key: "GB", Count: 2}, {Key: "DK", Count: 1}},
+		},
+		{
+			models.StatsParams{Dimension: models.StatsAge, AgeBuckets: models.DefaultAgeBuckets},
+			[]models.StatsGroup{{Key: "0-17"}, {Key: "18-29", Count: 2}, {Key: "30-44", Count: 1}, {Key: "45-59", Count: 1}, {Key: "60+", Count: 2}},
+		},
+		{
+			models.StatsParams{Dimension: models.StatsNationality, UserFilter: models.UserFilter{Gender: "female"}},
+			[]models.StatsGroup{{Key: "DK", Count: 1}, {Key: "NZ", Count: 1}},
+		},
+	} {
+		stats, err := s.GetStats(ctx, tt.params)
+		require.NoError(t, err)
+
+		assert.Equal(t, tt.params.Dimension, stats.Dimension)
+		assert.Equal(t, tt.want, stats.Groups, "%+v", tt.params)
+
+		var total int64
+		for _, group := range tt.want {
+			total += group.Count
+		}
+
+		assert.Equal(t, total, stats.Total)
+	}
+
+	_, err := s.GetStats(ctx, models.StatsParams{Dimension: "height"})
+	assert.ErrorIs(t, err, models.ErrInvalidParam)
+}
+
+func testExport(t *testing.T, s storage.Storage) {
+	users := create(t, s, fixture...)
+	require.NoError(t, s.DeleteUser(ctx, users[4].ID, ""))
+
+	var exported []*models.User
+
+	err := s.ExportUsers(ctx, models.ExportParams{}, func(user *models.User) error {
+		exported = append(exported, user)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Frodo", "Hamfast", "Samwise", "Arwen", "Bilbo"}, names(exported))
+
+	exported = nil
+
+	params := models.ExportParams{Text: "baggins", Match: models.MatchFuzzy}
+
+	err = s.ExportUsers(ctx, params, func(user *models.User) error {
+		exported = append(exported, user)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Frodo", "Bilbo"}, names(exported))
+
+	stop := errors.New("stop")
+	calls := 0
+
+	err = s.ExportUsers(ctx, models.ExportParams{}, func(user *models.User) error {
+		calls++
+		return stop
+	})
+	assert.ErrorIs(t, err, stop)
+	assert.Equal(t, 1, calls)
+}
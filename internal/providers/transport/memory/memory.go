@@ -0,0 +1,154 @@
+package memory
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"github.com/zuzi90/tz-enricher/internal/providers/transport"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Broker keeps topics in memory. It is meant for tests and local runs: all
+// messages are lost on exit and every topic has a single partition.
+type Broker struct {
+	mu     sync.Mutex
+	topics map[string]*topic
+}
+
+type topic struct {
+	messages []models.Message
+	// notify is closed and replaced when a message is appended.
+	notify chan struct{}
+}
+
+func NewBroker() *Broker {
+	return &Broker{topics: make(map[string]*topic)}
+}
+
+// Publish appends msg to the topic and returns its offset.
+func (b *Broker) Publish(topicName string, msg models.Message) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	t := b.topic(topicName)
+
+	msg.Topic = topicName
+	msg.Partition = 0
+	msg.Offset = int64(len(t.messages))
+
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = time.Now()
+	}
+
+	t.messages = append(t.messages, msg)
+
+	close(t.notify)
+	t.notify = make(chan struct{})
+
+	return msg.Offset
+}
+
+// Messages returns a copy of the messages published to the topic.
+func (b *Broker) Messages(topicName string) []models.Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	t := b.topic(topicName)
+
+	return append([]models.Message(nil), t.messages...)
+}
+
+// next returns the message at offset, or a channel closed once it is published.
+func (b *Broker) next(topicName string, offset int64) (models.Message, bool, <-chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	t := b.topic(topicName)
+	if offset < int64(len(t.messages)) {
+		return t.messages[offset], true, nil
+	}
+
+	return models.Message{}, false, t.notify
+}
+
+func (b *Broker) topic(name string) *topic {
+	t, ok := b.topics[name]
+	if !ok {
+		t = &topic{notify: make(chan struct{})}
+		b.topics[name] = t
+	}
+
+	return t
+}
+
+// Source hands the messages of a topic to the handler one by one, starting
+// from the first message of the topic.
+type Source struct {
+	broker  *Broker
+	topic   string
+	handler transport.Handler
+	log     *logrus.Entry
+	running atomic.Bool
+}
+
+func NewSource(broker *Broker, topic string, log *logrus.Logger, handler transport.Handler) *Source {
+	return &Source{
+		broker:  broker,
+		topic:   topic,
+		handler: handler,
+		log:     log.WithField("module", "memory_source"),
+	}
+}
+
+func (s *Source) Run(ctx context.Context) error {
+	s.running.Store(true)
+	defer s.running.Store(false)
+
+	s.log.Infof("consuming messages from in-memory topic %s", s.topic)
+
+	var offset int64
+
+	for {
+		msg, ok, notify := s.broker.next(s.topic, offset)
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-notify:
+				continue
+			}
+		}
+
+		if err := s.handler.Handle(ctx, msg); err != nil {
+			s.log.WithField("offset", msg.Offset).Warnf("handling message: %v: %v", string(msg.Value), err)
+		}
+
+		offset++
+	}
+}
+
+func (s *Source) Ready() bool {
+	return s.running.Load()
+}
+
+// Sink publishes messages to a topic of the broker.
+type Sink struct {
+	broker *Broker
+	topic  string
+}
+
+func NewSink(broker *Broker, topic string) *Sink {
+	return &Sink{broker: broker, topic: topic}
+}
+
+func (s *Sink) SendMessage(_ context.Context, msg models.Message) error {
+	s.broker.Publish(s.topic, msg)
+
+	return nil
+}
+
+func (s *Sink) Close() error {
+	return nil
+}
@@ -0,0 +1,63 @@
+package memory
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"github.com/zuzi90/tz-enricher/internal/providers/transport"
+	"testing"
+	"time"
+)
+
+func TestSource(t *testing.T) {
+	broker := NewBroker()
+	broker.Publish("FN", models.Message{Key: []byte("1"), Value: []byte("first")})
+
+	received := make(chan models.Message, 2)
+	handler := transport.HandlerFunc(func(_ context.Context, msg models.Message) error {
+		received <- msg
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	source := NewSource(broker, "FN", logrus.New(), handler)
+	done := make(chan error)
+
+	go func() {
+		done <- source.Run(ctx)
+	}()
+
+	broker.Publish("FN", models.Message{Key: []byte("2"), Value: []byte("second")})
+
+	for i, want := range []string{"first", "second"} {
+		select {
+		case msg := <-received:
+			assert.Equal(t, want, string(msg.Value))
+			assert.Equal(t, "FN", msg.Topic)
+			assert.Equal(t, int64(i), msg.Offset)
+		case <-time.After(time.Second):
+			t.Fatalf("message %q was not delivered", want)
+		}
+	}
+
+	assert.True(t, source.Ready())
+
+	cancel()
+	require.NoError(t, <-done)
+	assert.False(t, source.Ready())
+}
+
+func TestSink(t *testing.T) {
+	broker := NewBroker()
+	sink := NewSink(broker, "WRONG_FN")
+
+	require.NoError(t, sink.SendMessage(context.Background(), models.Message{Value: []byte("bad")}))
+
+	messages := broker.Messages("WRONG_FN")
+	require.Len(t, messages, 1)
+	assert.Equal(t, "bad", string(messages[0].Value))
+	assert.Empty(t, broker.Messages("FN"))
+}
@@ -0,0 +1,279 @@
+package redisstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"github.com/zuzi90/tz-enricher/internal/providers/transport"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Stream entry fields. Headers are stored as separate fields with a prefix.
+const (
+	fieldKey     = "key"
+	fieldValue   = "value"
+	headerPrefix = "h:"
+)
+
+const (
+	readCount = 10
+	readBlock = 5 * time.Second
+	// retryDelay is the pause after a failed read, e.g. while Redis restarts.
+	retryDelay = time.Second
+	// Entries pending longer than claimIdle, failed ones or those of a
+	// consumer that is gone, are claimed every claimInterval.
+	claimIdle     = time.Minute
+	claimInterval = 30 * time.Second
+)
+
+// streamClient is the part of *redis.Client the source uses.
+type streamClient interface {
+	XGroupCreateMkStream(ctx context.Context, stream, group, start string) *redis.StatusCmd
+	XReadGroup(ctx context.Context, a *redis.XReadGroupArgs) *redis.XStreamSliceCmd
+	XAutoClaim(ctx context.Context, a *redis.XAutoClaimArgs) *redis.XAutoClaimCmd
+	XAck(ctx context.Context, stream, group string, ids ...string) *redis.IntCmd
+}
+
+// Source reads a stream as a member of a consumer group and acknowledges
+// each entry once it is handled successfully. Entries that failed or were
+// left unacknowledged by a crash stay pending: they are handled again on
+// restart and claimed with XAUTOCLAIM by a running consumer once idle for
+// claimIdle. Entries are handled one at a time; run more instances with
+// distinct consumer names to scale out.
+type Source struct {
+	client   streamClient
+	stream   string
+	group    string
+	consumer string
+	handler  transport.Handler
+	log      *logrus.Entry
+	running  atomic.Bool
+}
+
+func NewSource(
+	client *redis.Client,
+	stream string,
+	group string,
+	consumer string,
+	log *logrus.Logger,
+	handler transport.Handler,
+) *Source {
+	return &Source{
+		client:   client,
+		stream:   stream,
+		group:    group,
+		consumer: consumer,
+		handler:  handler,
+		log:      log.WithField("module", "redis_source"),
+	}
+}
+
+func (s *Source) Run(ctx context.Context) error {
+	err := s.client.XGroupCreateMkStream(ctx, s.stream, s.group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("creating consumer group %s: %w", s.group, err)
+	}
+
+	s.running.Store(true)
+	defer s.running.Store(false)
+
+	s.log.Infof("consuming messages from redis stream %s as %s/%s", s.stream, s.group, s.consumer)
+
+	// "0" reads entries delivered to this consumer but not acknowledged,
+	// ">" reads new ones once those are done.
+	id := "0"
+	claimed := time.Now()
+
+	for ctx.Err() == nil {
+		if time.Since(claimed) >= claimInterval {
+			s.claim(ctx)
+			claimed = time.Now()
+		}
+
+		streams, err := s.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    s.group,
+			Consumer: s.consumer,
+			Streams:  []string{s.stream, id},
+			Count:    readCount,
+			Block:    readBlock,
+		}).Result()
+
+		switch {
+		case errors.Is(err, redis.Nil):
+			continue
+		case err != nil:
+			if ctx.Err() != nil {
+				continue
+			}
+
+			s.log.Warnf("reading stream: %v", err)
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(retryDelay):
+			}
+
+			continue
+		}
+
+		var last string
+
+		for _, stream := range streams {
+			for _, entry := range stream.Messages {
+				s.handle(ctx, entry)
+				last = entry.ID
+			}
+		}
+
+		// pending entries are paged by id, the ones failing again stay
+		// pending behind it
+		if id != ">" {
+			id = last
+			if last == "" {
+				id = ">"
+			}
+		}
+	}
+
+	return nil
+}
+
+// claim takes over and handles the entries of the group pending longer than
+// claimIdle.
+func (s *Source) claim(ctx context.Context) {
+	start := "0-0"
+
+	for {
+		entries, next, err := s.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   s.stream,
+			Group:    s.group,
+			Consumer: s.consumer,
+			MinIdle:  claimIdle,
+			Start:    start,
+			Count:    readCount,
+		}).Result()
+		if err != nil {
+			if ctx.Err() == nil {
+				s.log.Warnf("claiming pending messages: %v", err)
+			}
+
+			return
+		}
+
+		for _, entry := range entries {
+			s.handle(ctx, entry)
+		}
+
+		if next == "0-0" || next == "" {
+			return
+		}
+
+		start = next
+	}
+}
+
+func (s *Source) handle(ctx context.Context, entry redis.XMessage) {
+	msg := decode(s.stream, entry)
+
+	if err := s.handler.Handle(ctx, msg); err != nil {
+		s.log.WithField("id", entry.ID).Warnf("handling message, left pending: %v: %v", string(msg.Value), err)
+		return
+	}
+
+	if err := s.client.XAck(ctx, s.stream, s.group, entry.ID).Err(); err != nil {
+		s.log.WithField("id", entry.ID).Warnf("acknowledging message: %v", err)
+	}
+}
+
+func (s *Source) Ready() bool {
+	return s.running.Load()
+}
+
+// Sink appends messages to a stream, trimmed approximately to maxLen entries
+// when maxLen is positive.
+type Sink struct {
+	client *redis.Client
+	stream string
+	maxLen int64
+	log    *logrus.Entry
+}
+
+func NewSink(client *redis.Client, stream string, maxLen int64, log *logrus.Logger) *Sink {
+	return &Sink{
+		client: client,
+		stream: stream,
+		maxLen: maxLen,
+		log:    log.WithField("module", "redis_sink"),
+	}
+}
+
+func (s *Sink) SendMessage(ctx context.Context, msg models.Message) error {
+	err := s.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: s.stream,
+		MaxLen: s.maxLen,
+		Approx: s.maxLen > 0,
+		Values: encode(msg),
+	}).Err()
+	if err != nil {
+		s.log.Warnf("failed to send message: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// Close does nothing, the client is shared with the cache and closed by its
+// owner.
+func (s *Sink) Close() error {
+	return nil
+}
+
+func encode(msg models.Message) map[string]interface{} {
+	values := make(map[string]interface{}, len(msg.Headers)+2)
+	values[fieldValue] = msg.Value
+
+	if len(msg.Key) > 0 {
+		values[fieldKey] = msg.Key
+	}
+
+	for key, val := range msg.Headers {
+		values[headerPrefix+key] = val
+	}
+
+	return values
+}
+
+// decode converts a stream entry to a message. The entry id
+// <milliseconds>-<sequence> provides the timestamp.
+func decode(stream string, entry redis.XMessage) models.Message {
+	msg := models.Message{
+		Topic:   stream,
+		Headers: make(map[string]string),
+	}
+
+	for field, val := range entry.Values {
+		str, _ := val.(string)
+
+		switch {
+		case field == fieldValue:
+			msg.Value = []byte(str)
+		case field == fieldKey:
+			msg.Key = []byte(str)
+		case strings.HasPrefix(field, headerPrefix):
+			msg.Headers[strings.TrimPrefix(field, headerPrefix)] = str
+		}
+	}
+
+	ms, _, _ := strings.Cut(entry.ID, "-")
+	if millis, err := strconv.ParseInt(ms, 10, 64); err == nil {
+		msg.Timestamp = time.UnixMilli(millis)
+	}
+
+	return msg
+}
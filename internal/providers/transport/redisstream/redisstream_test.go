@@ -0,0 +1,104 @@
+package redisstream
+
+import (
+	"context"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"github.com/zuzi90/tz-enricher/internal/providers/transport"
+	"testing"
+	"time"
+)
+
+// fakeClient serves the pending entries claimed by XAUTOCLAIM and records
+// the acknowledged ids.
+type fakeClient struct {
+	pending []redis.XMessage
+	acked   []string
+}
+
+func (f *fakeClient) XGroupCreateMkStream(_ context.Context, _, _, _ string) *redis.StatusCmd {
+	return redis.NewStatusResult("OK", nil)
+}
+
+func (f *fakeClient) XReadGroup(_ context.Context, _ *redis.XReadGroupArgs) *redis.XStreamSliceCmd {
+	return redis.NewXStreamSliceCmdResult(nil, redis.Nil)
+}
+
+func (f *fakeClient) XAutoClaim(ctx context.Context, _ *redis.XAutoClaimArgs) *redis.XAutoClaimCmd {
+	cmd := redis.NewXAutoClaimCmd(ctx)
+	cmd.SetVal(f.pending, "0-0")
+
+	return cmd
+}
+
+func (f *fakeClient) XAck(_ context.Context, _, _ string, ids ...string) *redis.IntCmd {
+	f.acked = append(f.acked, ids...)
+	return redis.NewIntResult(int64(len(ids)), nil)
+}
+
+func Test_Source_claim(t *testing.T) {
+	client := &fakeClient{pending: []redis.XMessage{
+		{ID: "1-0", Values: map[string]interface{}{fieldValue: "ok"}},
+		{ID: "2-0", Values: map[string]interface{}{fieldValue: "fail"}},
+	}}
+
+	s := NewSource(nil, "FN", "tz-enricher", "worker-1", logrus.New(), transport.HandlerFunc(
+		func(_ context.Context, msg models.Message) error {
+			if string(msg.Value) == "fail" {
+				return errors.New("postgres is down")
+			}
+
+			return nil
+		}))
+	s.client = client
+
+	s.claim(context.Background())
+
+	assert.Equal(t, []string{"1-0"}, client.acked, "a failed message stays pending")
+}
+
+func Test_decode(t *testing.T) {
+	entry := redis.XMessage{
+		ID: "1716142570000-3",
+		Values: map[string]interface{}{
+			fieldKey:                  "frodo",
+			fieldValue:                `{"name":"Frodo"}`,
+			headerPrefix + "source":   "crm",
+			headerPrefix + "trace-id": "42",
+			"unknown":                 "skipped",
+		},
+	}
+
+	msg := decode("FN", entry)
+
+	assert.Equal(t, "FN", msg.Topic)
+	assert.Equal(t, []byte("frodo"), msg.Key)
+	assert.Equal(t, []byte(`{"name":"Frodo"}`), msg.Value)
+	assert.Equal(t, map[string]string{"source": "crm", "trace-id": "42"}, msg.Headers)
+	assert.Equal(t, time.UnixMilli(1716142570000), msg.Timestamp)
+}
+
+func Test_encode(t *testing.T) {
+	t.Run("with key and headers", func(t *testing.T) {
+		values := encode(models.Message{
+			Key:     []byte("frodo"),
+			Value:   []byte("{}"),
+			Headers: map[string]string{"source": "crm"},
+		})
+
+		assert.Equal(t, map[string]interface{}{
+			fieldKey:                []byte("frodo"),
+			fieldValue:              []byte("{}"),
+			headerPrefix + "source": "crm",
+		}, values)
+	})
+
+	t.Run("no key", func(t *testing.T) {
+		values := encode(models.Message{Value: []byte("{}")})
+
+		assert.NotContains(t, values, fieldKey)
+	})
+}
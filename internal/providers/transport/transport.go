@@ -0,0 +1,39 @@
+package transport
+
+import (
+	"context"
+	"github.com/zuzi90/tz-enricher/internal/models"
+)
+
+// Kinds of transport selectable with the TRANSPORT setting.
+const (
+	KindKafka  = "kafka"
+	KindMemory = "memory"
+	KindRedis  = "redis"
+)
+
+// Handler processes a consumed message.
+type Handler interface {
+	Handle(ctx context.Context, msg models.Message) error
+}
+
+// HandlerFunc adapts a function to the Handler interface.
+type HandlerFunc func(ctx context.Context, msg models.Message) error
+
+func (f HandlerFunc) Handle(ctx context.Context, msg models.Message) error {
+	return f(ctx, msg)
+}
+
+// Source delivers FN messages to a handler until ctx is done.
+// kafka.Consumer, memory.Source and redisstream.Source implement it.
+type Source interface {
+	Run(ctx context.Context) error
+	Ready() bool
+}
+
+// Sink publishes messages, such as WRONG_FN replies, to its topic.
+// kafka.Producer, memory.Sink and redisstream.Sink implement it.
+type Sink interface {
+	SendMessage(ctx context.Context, msg models.Message) error
+	Close() error
+}
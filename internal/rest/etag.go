@@ -0,0 +1,63 @@
+package rest
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// etag is the entity tag of a user with the given version.
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+func setETag(w http.ResponseWriter, version int) {
+	w.Header().Set("ETag", etag(version))
+}
+
+// parseETags reads the versions listed in an If-Match or If-None-Match
+// header. wildcard is set for "*". Weak tags are read only with weak set:
+// If-None-Match uses the weak comparison, If-Match the strong one, which a
+// weak tag never satisfies. Tags that are not versions are skipped as they
+// can not match.
+func parseETags(header string, weak bool) (versions []int, wildcard bool) {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return nil, true
+		}
+
+		if strings.HasPrefix(tag, "W/") {
+			if !weak {
+				continue
+			}
+
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+
+		version, err := strconv.Atoi(strings.Trim(tag, `"`))
+		if err != nil || !strings.HasPrefix(tag, `"`) {
+			continue
+		}
+
+		versions = append(versions, version)
+	}
+
+	return versions, false
+}
+
+// matchETag tells whether an If-None-Match header matches the version.
+func matchETag(header string, version int) bool {
+	versions, wildcard := parseETags(header, true)
+	if wildcard {
+		return true
+	}
+
+	for _, v := range versions {
+		if v == version {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,35 @@
+package rest
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_parseETags(t *testing.T) {
+	t.Run("weak comparison", func(t *testing.T) {
+		versions, wildcard := parseETags(`"3", W/"4", "x", 5`, true)
+		assert.False(t, wildcard)
+		assert.Equal(t, []int{3, 4}, versions)
+	})
+
+	t.Run("strong comparison skips weak tags", func(t *testing.T) {
+		versions, wildcard := parseETags(`"3", W/"4"`, false)
+		assert.False(t, wildcard)
+		assert.Equal(t, []int{3}, versions)
+
+		versions, _ = parseETags(`W/"3"`, false)
+		assert.Empty(t, versions)
+	})
+
+	_, wildcard := parseETags(`*`, false)
+	assert.True(t, wildcard)
+}
+
+func Test_matchETag(t *testing.T) {
+	assert.True(t, matchETag(etag(7), 7))
+	assert.True(t, matchETag(`"6", "7"`, 7))
+	assert.True(t, matchETag(`W/"7"`, 7), "If-None-Match uses the weak comparison")
+	assert.True(t, matchETag(`*`, 7))
+	assert.False(t, matchETag(`"6"`, 7))
+	assert.False(t, matchETag(``, 7))
+}
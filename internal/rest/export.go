@@ -0,0 +1,142 @@
+package rest
+
+import (
+	"encoding/csv"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// userEncoder writes exported users one by one.
+type userEncoder interface {
+	Encode(user *models.User) error
+	// Close writes what is left, the CSV header of an empty export.
+	Close() error
+}
+
+func newUserEncoder(format models.ExportFormat, w io.Writer) userEncoder {
+	if format == models.ExportCSV {
+		return &csvEncoder{w: csv.NewWriter(w)}
+	}
+
+	return &ndjsonEncoder{enc: jsoniter.ConfigCompatibleWithStandardLibrary.NewEncoder(w)}
+}
+
+// ndjsonEncoder writes a JSON object per line.
+type ndjsonEncoder struct {
+	enc *jsoniter.Encoder
+}
+
+func (e *ndjsonEncoder) Encode(user *models.User) error {
+	return e.enc.Encode(user)
+}
+
+func (e *ndjsonEncoder) Close() error {
+	return nil
+}
+
+var csvHeader = []string{
+	"id", "name", "surname", "patronymic", "age", "gender", "nationality",
+	"genderProbability", "nationalityProbability", "isDeleted", "deletedAt", "deletedReason", "mergedInto",
+	"version", "createdAt", "updatedAt", "messageId", "source", "correlationId", "producedAt",
+}
+
+// csvEncoder writes the header before the first user, so nothing reaches the
+// response until there is a user or the export is over.
+type csvEncoder struct {
+	w      *csv.Writer
+	header bool
+}
+
+func (e *csvEncoder) Encode(user *models.User) error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+
+	return e.w.Write(csvRecord(user))
+}
+
+func (e *csvEncoder) Close() error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+
+	e.w.Flush()
+
+	return e.w.Error()
+}
+
+func (e *csvEncoder) writeHeader() error {
+	if e.header {
+		return nil
+	}
+
+	e.header = true
+
+	return e.w.Write(csvHeader)
+}
+
+func csvRecord(u *models.User) []string {
+	float := func(f *float64) string {
+		if f == nil {
+			return ""
+		}
+
+		return strconv.FormatFloat(*f, 'f', -1, 64)
+	}
+
+	timestamp := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+
+		return t.Format(time.RFC3339Nano)
+	}
+
+	mergedInto := ""
+	if u.MergedInto != nil {
+		mergedInto = strconv.Itoa(*u.MergedInto)
+	}
+
+	return []string{
+		strconv.Itoa(u.ID), u.Name, u.Surname, u.Patronymic, strconv.Itoa(u.Age), u.Gender, u.Nationality,
+		float(u.GenderProbability), float(u.NationalityProbability), strconv.FormatBool(u.IsDeleted),
+		timestamp(u.DeletedAt), u.DeletedReason, mergedInto,
+		strconv.Itoa(u.Version), timestamp(&u.CreatedAt), timestamp(&u.UpdatedAt),
+		u.MessageID, u.Source, u.CorrelationID, timestamp(u.ProducedAt),
+	}
+}
+
+// acceptsGzip tells whether an Accept-Encoding header allows gzip: the gzip
+// coding, or "*" when gzip is not listed, with a q-value above zero.
+func acceptsGzip(header string) bool {
+	wildcard := false
+
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+
+		q := 1.0
+
+		for _, param := range strings.Split(params, ";") {
+			name, val, _ := strings.Cut(param, "=")
+			if strings.EqualFold(strings.TrimSpace(name), "q") {
+				if q, _ = strconv.ParseFloat(strings.TrimSpace(val), 64); q < 0 {
+					q = 0
+				}
+			}
+		}
+
+		switch coding {
+		case "gzip", "x-gzip":
+			return q > 0
+		case "*":
+			wildcard = q > 0
+		}
+	}
+
+	return wildcard
+}
@@ -0,0 +1,78 @@
+package rest
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_userEncoder(t *testing.T) {
+	probability := 0.98
+	user := &models.User{
+		ID:                7,
+		Name:              "Bilbo",
+		Surname:           "Baggins, Esq.",
+		Age:               111,
+		Gender:            "male",
+		Nationality:       "NZ",
+		GenderProbability: &probability,
+		CreatedAt:         time.Date(2024, 5, 19, 10, 0, 0, 0, time.UTC),
+		UpdatedAt:         time.Date(2024, 5, 19, 10, 0, 0, 0, time.UTC),
+		Version:           2,
+	}
+
+	t.Run("csv", func(t *testing.T) {
+		var buf bytes.Buffer
+		enc := newUserEncoder(models.ExportCSV, &buf)
+
+		require.NoError(t, enc.Encode(user))
+		require.NoError(t, enc.Close())
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		require.Len(t, lines, 2)
+		assert.Equal(t, strings.Join(csvHeader, ","), lines[0])
+		assert.Equal(t, `7,Bilbo,"Baggins, Esq.",,111,male,NZ,0.98,,false,,,,2,2024-05-19T10:00:00Z,2024-05-19T10:00:00Z,,,,`, lines[1])
+	})
+
+	t.Run("empty csv has a header", func(t *testing.T) {
+		var buf bytes.Buffer
+		enc := newUserEncoder(models.ExportCSV, &buf)
+
+		require.NoError(t, enc.Close())
+		assert.Equal(t, strings.Join(csvHeader, ",")+"\n", buf.String())
+	})
+
+	t.Run("ndjson", func(t *testing.T) {
+		var buf bytes.Buffer
+		enc := newUserEncoder(models.ExportNDJSON, &buf)
+
+		require.NoError(t, enc.Encode(user))
+		require.NoError(t, enc.Encode(user))
+		require.NoError(t, enc.Close())
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		require.Len(t, lines, 2)
+		assert.True(t, strings.HasPrefix(lines[0], `{"id":7,"name":"Bilbo"`))
+	})
+}
+
+func Test_acceptsGzip(t *testing.T) {
+	for header, want := range map[string]bool{
+		"":                      false,
+		"gzip":                  true,
+		"deflate, GZIP;q=0.5":   true,
+		"br;q=1.0, gzip; q=0.1": true,
+		"gzip;q=0":              false,
+		"gzip;q=0.000, *":       false,
+		"*":                     true,
+		"*;q=0":                 false,
+		"identity":              false,
+		"x-gzip":                true,
+	} {
+		assert.Equal(t, want, acceptsGzip(header), header)
+	}
+}
@@ -3,11 +3,15 @@ package rest
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/go-chi/chi/v5"
 	jsoniter "github.com/json-iterator/go"
 	"github.com/zuzi90/tz-enricher/internal/models"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // @Summary Создать пользователя
@@ -17,6 +21,7 @@ import (
 // @Produce json
 // @Param input body models.UserCreate true "account info"
 // @Success 201 {object} models.User
+// @Header 201 {string} ETag "version of the user"
 // @Failure 400 {string} string
 // @Failure 500 {string} string
 // @Router /api/v1/users [post].
@@ -43,6 +48,7 @@ func (s *Server) addUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	setETag(w, user.Version)
 	s.response(w, http.StatusCreated, user)
 }
 
@@ -51,7 +57,11 @@ func (s *Server) addUser(w http.ResponseWriter, r *http.Request) {
 // @Description get user
 // @Produce json
 // @Param id  path  string  true  "id"
+// @Param as_of query string false "RFC3339 time to read the state of the user at, deleted users included"
+// @Param If-None-Match header string false "ETag of a cached copy"
 // @Success 200 {object} models.User
+// @Header 200 {string} ETag "version of the user"
+// @Success 304 {string} string "not modified"
 // @Failure 400 {string} string
 // @Failure 404 {string} string
 // @Failure 500 {string} string
@@ -66,7 +76,20 @@ func (s *Server) getUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := s.uService.GetUser(ctx, id)
+	var user *models.User
+
+	if asOf := r.URL.Query().Get("as_of"); asOf != "" {
+		var at time.Time
+		if at, err = time.Parse(time.RFC3339, asOf); err != nil {
+			http.Error(w, fmt.Sprintf("as_of must be an RFC3339 time, got %q", asOf), http.StatusBadRequest)
+			return
+		}
+
+		user, err = s.uService.GetUserAsOf(ctx, id, at)
+	} else {
+		user, err = s.uService.GetUser(ctx, id)
+	}
+
 	switch {
 	case errors.Is(err, models.ErrUserNotFound):
 		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
@@ -77,19 +100,77 @@ func (s *Server) getUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	setETag(w, user.Version)
+
+	if matchETag(r.Header.Get("If-None-Match"), user.Version) {
+		s.responseOk(w, http.StatusNotModified)
+		return
+	}
+
 	s.response(w, http.StatusOK, user)
 }
 
+// @Summary Получить историю изменений пользователя
+// @Tags user
+// @Description get the versions of a user, oldest first, deleted users included
+// @Produce json
+// @Param id  path  string  true  "id"
+// @Success 200 {array} models.UserVersion
+// @Failure 400 {string} string
+// @Failure 404 {string} string
+// @Failure 500 {string} string
+// @Router /api/v1/users/{id}/history [get].
+func (s *Server) getUserHistory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	val := chi.URLParam(r, "id")
+
+	id, err := strconv.Atoi(val)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	versions, err := s.uService.GetUserHistory(ctx, id)
+	switch {
+	case errors.Is(err, models.ErrUserNotFound):
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		s.log.WithField("request", id).Warnf("err getting user history: %v", err)
+		return
+	}
+
+	s.response(w, http.StatusOK, versions)
+}
+
 // @Summary Получить список пользователей
 // @Tags user
 // @Description get users
 // @Produce json
 // @Param text query string false "text"
+// @Param match query string false "match mode: exact, prefix or fuzzy (default)"
+// @Param cursor query string false "cursor from the previous page, replaces offset"
+// @Param total query string false "total count: exact (default), estimate or none"
 // @Param limit query string false "limit"
 // @Param offset query string false "offset"
-// @Param sorting query string false "sorting"
-// @Param descending query string false "descending"
-// @Success 200 {array} models.User
+// @Param sort query string false "comma separated fields, - for descending: -age,surname. Fields: id, name, surname, patronymic, age, gender, nationality, createdAt, updatedAt"
+// @Param sorting query string false "deprecated, same as sort"
+// @Param descending query string false "reverse the order"
+// @Param minAge query int false "minimum age"
+// @Param maxAge query int false "maximum age"
+// @Param gender query string false "gender"
+// @Param nationality query []string false "nationalities, repeated or comma separated" collectionFormat(csv)
+// @Param createdFrom query string false "created at or after, RFC3339 time or date"
+// @Param createdTo query string false "created at or before, RFC3339 time or date"
+// @Param updatedFrom query string false "updated at or after, RFC3339 time or date"
+// @Param updatedTo query string false "updated at or before, RFC3339 time or date"
+// @Param hasPatronymic query bool false "patronymic presence"
+// @Param minConfidence query number false "minimum gender and nationality probability, 0 to 1"
+// @Param include_deleted query bool false "list soft-deleted users too"
+// @Param only_deleted query bool false "list soft-deleted users only"
+// @Success 200 {object} models.UsersPage
+// @Header 200 {string} Link "next and first page links"
 // @Failure 400 {string} string
 // @Failure 500 {string} string
 // @Router /api/v1/users/ [get].
@@ -97,22 +178,69 @@ func (s *Server) getUsers(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	text := r.URL.Query().Get("text")
+	match := r.URL.Query().Get("match")
 	limit := r.URL.Query().Get("limit")
 	offset := r.URL.Query().Get("offset")
-	sorting := r.URL.Query().Get("sorting")
+	sorting := r.URL.Query().Get("sort")
+	if sorting == "" {
+		sorting = r.URL.Query().Get("sorting")
+	}
+
 	descending := r.URL.Query().Get("descending")
 
-	params := models.NewParams(text, limit, offset, sorting, descending)
+	matchMode, err := models.ParseMatchMode(match)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	filter, err := models.ParseUserFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cursor, err := models.ParseCursor(r.URL.Query().Get("cursor"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if cursor != nil && offset != "" {
+		http.Error(w, "cursor and offset can not be used together", http.StatusBadRequest)
+		return
+	}
+
+	totalMode, err := models.ParseTotalMode(r.URL.Query().Get("total"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	users, err := s.uService.GetUsers(ctx, params)
+	params, err := models.NewParams(text, limit, offset, sorting, descending)
 	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	params.Match = matchMode
+	params.UserFilter = filter
+	params.Cursor = cursor
+	params.Total = totalMode
+
+	page, err := s.uService.GetUsers(ctx, params)
+	switch {
+	case errors.Is(err, models.ErrInvalidParam):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		s.log.WithField("request", "getUsers").Warnf("err get all users: %v", err)
 		return
-
 	}
 
-	s.response(w, http.StatusOK, users)
+	w.Header().Set("Link", pageLinks(r.URL, params, page))
+	s.response(w, http.StatusOK, page)
 }
 
 // @Summary Обновить пользователя
@@ -122,9 +250,12 @@ func (s *Server) getUsers(w http.ResponseWriter, r *http.Request) {
 // @Produce json
 // @Param id  path  string  true  "id"
 // @Param input body models.UserUpdate true "account info"
+// @Param If-Match header string false "ETag of the version being updated"
 // @Success 200 {object} models.User
+// @Header 200 {string} ETag "version of the user"
 // @Failure 400 {string} string
 // @Failure 404 {string} string
+// @Failure 412 {string} string
 // @Failure 500 {string} string
 // @Router /api/v1/users/{id} [patch].
 func (s *Server) updateUser(w http.ResponseWriter, r *http.Request) {
@@ -142,17 +273,31 @@ func (s *Server) updateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := s.uService.UpdateUser(ctx, id, userReq)
+	var versions []int
+
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		var wildcard bool
+		if versions, wildcard = parseETags(ifMatch, false); !wildcard && len(versions) == 0 {
+			http.Error(w, http.StatusText(http.StatusPreconditionFailed), http.StatusPreconditionFailed)
+			return
+		}
+	}
+
+	user, err := s.uService.UpdateUser(ctx, id, userReq, versions)
 	switch {
 	case errors.Is(err, models.ErrNoRows):
 		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 		return
+	case errors.Is(err, models.ErrVersionMismatch):
+		http.Error(w, http.StatusText(http.StatusPreconditionFailed), http.StatusPreconditionFailed)
+		return
 	case err != nil:
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		s.log.WithField("request", id).Warnf("err updating user: %v", err)
 		return
 	}
 
+	setETag(w, user.Version)
 	s.response(w, http.StatusOK, user)
 }
 
@@ -161,6 +306,7 @@ func (s *Server) updateUser(w http.ResponseWriter, r *http.Request) {
 // @Description delete user
 // @Produce json
 // @Param id  path  string  true  "id"
+// @Param reason query string false "reason of the deletion, up to 255 characters"
 // @Success 200 {string} string
 // @Failure 400 {string} string
 // @Failure 404 {string} string
@@ -176,7 +322,13 @@ func (s *Server) deleteUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = s.uService.DeleteUser(ctx, id)
+	reason := r.URL.Query().Get("reason")
+	if err = models.ValidateDeleteReason(reason); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = s.uService.DeleteUser(ctx, id, reason)
 
 	switch {
 	case errors.Is(err, models.ErrUserNotFound):
@@ -190,3 +342,132 @@ func (s *Server) deleteUser(w http.ResponseWriter, r *http.Request) {
 
 	s.responseOk(w, http.StatusOK)
 }
+
+// @Summary Восстановить удалённого пользователя
+// @Tags user
+// @Description restore a soft-deleted user
+// @Produce json
+// @Param id  path  string  true  "id"
+// @Success 200 {object} models.User
+// @Failure 400 {string} string
+// @Failure 404 {string} string
+// @Failure 500 {string} string
+// @Router /api/v1/users/{id}/restore [post].
+func (s *Server) restoreUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	val := chi.URLParam(r, "id")
+
+	id, err := strconv.Atoi(val)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.uService.RestoreUser(ctx, id)
+	switch {
+	case errors.Is(err, models.ErrUserNotFound):
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		s.log.WithField("request", id).Warnf("err restoring user: %v", err)
+		return
+	}
+
+	setETag(w, user.Version)
+	s.response(w, http.StatusOK, user)
+}
+
+// @Summary Безвозвратно удалить пользователя
+// @Tags user
+// @Description permanently erase a user, deleted or not, from the database and the cache
+// @Produce json
+// @Param id  path  string  true  "id"
+// @Success 200 {string} string
+// @Failure 400 {string} string
+// @Failure 404 {string} string
+// @Failure 500 {string} string
+// @Router /api/v1/users/{id}/purge [delete].
+func (s *Server) purgeUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	val := chi.URLParam(r, "id")
+
+	id, err := strconv.Atoi(val)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = s.uService.PurgeUser(ctx, id)
+	switch {
+	case errors.Is(err, models.ErrUserNotFound):
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		s.log.WithField("request", id).Warnf("err purging user: %v", err)
+		return
+	}
+
+	s.responseOk(w, http.StatusOK)
+}
+
+// @Summary Безвозвратно удалить давно удалённых пользователей
+// @Tags user
+// @Description permanently erase users soft-deleted more than olderThan ago
+// @Produce json
+// @Param olderThan query string true "time since the deletion, e.g. 720h; 0 erases all deleted users"
+// @Success 200 {object} models.PurgeResult
+// @Failure 400 {string} string
+// @Failure 500 {string} string
+// @Router /api/v1/users/deleted [delete].
+func (s *Server) purgeDeleted(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	val := r.URL.Query().Get("olderThan")
+
+	olderThan, err := time.ParseDuration(val)
+	if err != nil || olderThan < 0 {
+		http.Error(w, fmt.Sprintf("olderThan must be a non-negative duration such as 720h, got %q", val), http.StatusBadRequest)
+		return
+	}
+
+	purged, err := s.uService.PurgeDeleted(ctx, olderThan)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		s.log.WithField("request", val).Warnf("err purging deleted users: %v", err)
+		return
+	}
+
+	s.response(w, http.StatusOK, models.PurgeResult{Purged: purged})
+}
+
+// pageLinks builds the Link header of a user list page.
+func pageLinks(u *url.URL, params models.GetUsersParams, page *models.UsersPage) string {
+	link := func(rel string, set func(query url.Values)) string {
+		query := u.Query()
+		query.Del("cursor")
+		query.Del("offset")
+		set(query)
+
+		next := *u
+		next.RawQuery = query.Encode()
+
+		return `<` + next.RequestURI() + `>; rel="` + rel + `"`
+	}
+
+	links := []string{link("first", func(url.Values) {})}
+
+	if page.Cursor != "" {
+		links = append(links, link("next", func(query url.Values) {
+			query.Set("cursor", page.Cursor)
+		}))
+	}
+
+	if params.Cursor == nil && params.Offset > 0 {
+		links = append(links, link("prev", func(query url.Values) {
+			query.Set("offset", strconv.Itoa(max(params.Offset-params.Limit, 0)))
+		}))
+	}
+
+	return strings.Join(links, ", ")
+}
@@ -0,0 +1,123 @@
+package rest
+
+import (
+	"errors"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"net/http"
+)
+
+// @Summary Состояние consumer
+// @Tags admin
+// @Description consumer status
+// @Produce json
+// @Success 200 {object} models.ConsumerStatus
+// @Router /admin/consumer/status [get].
+func (s *Server) consumerStatus(w http.ResponseWriter, _ *http.Request) {
+	s.response(w, http.StatusOK, s.control.Status())
+}
+
+// @Summary Приостановить чтение партиций
+// @Tags admin
+// @Description pause all partitions or the given ones
+// @Accept json
+// @Produce json
+// @Param input body models.ConsumerPartitions false "partitions"
+// @Success 200 {object} models.ConsumerStatus
+// @Failure 400 {string} string
+// @Router /admin/consumer/pause [post].
+func (s *Server) pauseConsumer(w http.ResponseWriter, r *http.Request) {
+	s.changePartitions(w, r, s.control.Pause)
+}
+
+// @Summary Возобновить чтение партиций
+// @Tags admin
+// @Description resume all partitions or the given ones
+// @Accept json
+// @Produce json
+// @Param input body models.ConsumerPartitions false "partitions"
+// @Success 200 {object} models.ConsumerStatus
+// @Failure 400 {string} string
+// @Router /admin/consumer/resume [post].
+func (s *Server) resumeConsumer(w http.ResponseWriter, r *http.Request) {
+	s.changePartitions(w, r, s.control.Resume)
+}
+
+func (s *Server) changePartitions(w http.ResponseWriter, r *http.Request, change func(partitions ...int32) error) {
+	req := models.ConsumerPartitions{}
+
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	err := change(req.Partitions...)
+	switch {
+	case errors.Is(err, models.ErrUnknownPartition):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		s.log.WithField("request", req).Warnf("err changing partitions: %v", err)
+		return
+	}
+
+	s.response(w, http.StatusOK, s.control.Status())
+}
+
+// @Summary Ограничить скорость обработки
+// @Tags admin
+// @Description set max messages per second, 0 removes the limit
+// @Accept json
+// @Produce json
+// @Param input body models.ConsumerRate true "rate"
+// @Success 200 {object} models.ConsumerStatus
+// @Failure 400 {string} string
+// @Router /admin/consumer/rate [put].
+func (s *Server) setConsumerRate(w http.ResponseWriter, r *http.Request) {
+	req := models.ConsumerRate{}
+
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Rate < 0 {
+		http.Error(w, "rate must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	s.control.SetRate(req.Rate)
+
+	s.response(w, http.StatusOK, s.control.Status())
+}
+
+// @Summary Изменить число воркеров
+// @Tags admin
+// @Description resize the worker pool
+// @Accept json
+// @Produce json
+// @Param input body models.ConsumerWorkers true "workers"
+// @Success 200 {object} models.ConsumerStatus
+// @Failure 400 {string} string
+// @Router /admin/consumer/workers [put].
+func (s *Server) setConsumerWorkers(w http.ResponseWriter, r *http.Request) {
+	req := models.ConsumerWorkers{}
+
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.control.SetWorkers(req.Workers); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.response(w, http.StatusOK, s.control.Status())
+}
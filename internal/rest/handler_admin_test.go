@@ -0,0 +1,182 @@
+package rest
+
+import (
+	"fmt"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testAdminToken = "secret"
+
+type controlStub struct {
+	paused  map[int32]bool
+	rate    float64
+	workers int
+}
+
+func newControlStub() *controlStub {
+	return &controlStub{paused: map[int32]bool{0: false, 1: false}, workers: 1}
+}
+
+func (c *controlStub) Ready() bool {
+	return true
+}
+
+func (c *controlStub) Status() models.ConsumerStatus {
+	status := models.ConsumerStatus{Topic: "FN", Running: true, Ready: true, Workers: c.workers, Rate: c.rate}
+	for partition := int32(0); partition < int32(len(c.paused)); partition++ {
+		status.Partitions = append(status.Partitions, models.PartitionStatus{Partition: partition, Paused: c.paused[partition]})
+	}
+
+	return status
+}
+
+func (c *controlStub) Pause(partitions ...int32) error {
+	return c.setPaused(true, partitions)
+}
+
+func (c *controlStub) Resume(partitions ...int32) error {
+	return c.setPaused(false, partitions)
+}
+
+func (c *controlStub) setPaused(paused bool, partitions []int32) error {
+	if len(partitions) == 0 {
+		for partition := range c.paused {
+			partitions = append(partitions, partition)
+		}
+	}
+
+	for _, partition := range partitions {
+		if _, ok := c.paused[partition]; !ok {
+			return fmt.Errorf("%w: %d", models.ErrUnknownPartition, partition)
+		}
+	}
+
+	for _, partition := range partitions {
+		c.paused[partition] = paused
+	}
+
+	return nil
+}
+
+func (c *controlStub) SetRate(rate float64) {
+	c.rate = rate
+}
+
+func (c *controlStub) SetWorkers(count int) error {
+	if count < 1 {
+		return fmt.Errorf("workers count must be positive, got %d", count)
+	}
+
+	c.workers = count
+
+	return nil
+}
+
+func adminRequest(t *testing.T, s *Server, method, path, token, body string) (*httptest.ResponseRecorder, models.ConsumerStatus) {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+
+	status := models.ConsumerStatus{}
+	if rec.Code == http.StatusOK {
+		json := jsoniter.ConfigCompatibleWithStandardLibrary
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+	}
+
+	return rec, status
+}
+
+func Test_adminAuth(t *testing.T) {
+	s := NewServer(":0", testAdminToken, logrus.New(), nil, nil, newControlStub())
+
+	t.Run("success", func(t *testing.T) {
+		rec, status := adminRequest(t, s, http.MethodGet, "/admin/consumer/status", testAdminToken, "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "FN", status.Topic)
+	})
+
+	t.Run("failure, no token", func(t *testing.T) {
+		rec, _ := adminRequest(t, s, http.MethodPost, "/admin/consumer/pause", "", "")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("failure, wrong token", func(t *testing.T) {
+		rec, _ := adminRequest(t, s, http.MethodPut, "/admin/consumer/workers", "guess", `{"workers":4}`)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("failure, admin API disabled without token", func(t *testing.T) {
+		s := NewServer(":0", "", logrus.New(), nil, nil, newControlStub())
+
+		rec, _ := adminRequest(t, s, http.MethodGet, "/admin/consumer/status", "", "")
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
+
+func Test_pauseResumeConsumer(t *testing.T) {
+	s := NewServer(":0", testAdminToken, logrus.New(), nil, nil, newControlStub())
+
+	t.Run("success, given partitions", func(t *testing.T) {
+		rec, status := adminRequest(t, s, http.MethodPost, "/admin/consumer/pause", testAdminToken, `{"partitions":[1]}`)
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.False(t, status.Partitions[0].Paused)
+		assert.True(t, status.Partitions[1].Paused)
+	})
+
+	t.Run("success, all partitions", func(t *testing.T) {
+		rec, status := adminRequest(t, s, http.MethodPost, "/admin/consumer/pause", testAdminToken, "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.True(t, status.Partitions[0].Paused)
+
+		rec, status = adminRequest(t, s, http.MethodPost, "/admin/consumer/resume", testAdminToken, "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.False(t, status.Partitions[0].Paused)
+		assert.False(t, status.Partitions[1].Paused)
+	})
+
+	t.Run("failure, unknown partition", func(t *testing.T) {
+		rec, _ := adminRequest(t, s, http.MethodPost, "/admin/consumer/resume", testAdminToken, `{"partitions":[7]}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("failure, invalid body", func(t *testing.T) {
+		rec, _ := adminRequest(t, s, http.MethodPost, "/admin/consumer/pause", testAdminToken, `{"partitions":`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
+
+func Test_setConsumerRate(t *testing.T) {
+	s := NewServer(":0", testAdminToken, logrus.New(), nil, nil, newControlStub())
+
+	rec, status := adminRequest(t, s, http.MethodPut, "/admin/consumer/rate", testAdminToken, `{"rate":50}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, float64(50), status.Rate)
+
+	rec, _ = adminRequest(t, s, http.MethodPut, "/admin/consumer/rate", testAdminToken, `{"rate":-1}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func Test_setConsumerWorkers(t *testing.T) {
+	s := NewServer(":0", testAdminToken, logrus.New(), nil, nil, newControlStub())
+
+	rec, status := adminRequest(t, s, http.MethodPut, "/admin/consumer/workers", testAdminToken, `{"workers":8}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 8, status.Workers)
+
+	rec, _ = adminRequest(t, s, http.MethodPut, "/admin/consumer/workers", testAdminToken, `{"workers":0}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -0,0 +1,154 @@
+package rest
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"net/http"
+	"strconv"
+)
+
+// @Summary Получить возможные дубликаты
+// @Tags duplicates
+// @Description get candidate duplicate pairs found by the detection job, most similar first
+// @Produce json
+// @Param minSimilarity query number false "minimum full name similarity, 0 to 1"
+// @Param limit query int false "limit, 100 by default"
+// @Param offset query int false "offset"
+// @Success 200 {array} models.DuplicateCandidate
+// @Failure 400 {string} string
+// @Failure 500 {string} string
+// @Router /api/v1/duplicates [get].
+func (s *Server) getDuplicates(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	params := models.GetDuplicatesParams{Limit: 100}
+
+	for key, dst := range map[string]*int{"limit": &params.Limit, "offset": &params.Offset} {
+		if val := r.URL.Query().Get(key); val != "" {
+			n, err := strconv.Atoi(val)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("%s must be an integer, got %q", key, val), http.StatusBadRequest)
+				return
+			}
+
+			*dst = n
+		}
+	}
+
+	if val := r.URL.Query().Get("minSimilarity"); val != "" {
+		similarity, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("minSimilarity must be a number, got %q", val), http.StatusBadRequest)
+			return
+		}
+
+		params.MinSimilarity = similarity
+	}
+
+	if err := params.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	candidates, err := s.uService.GetDuplicates(ctx, params)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		s.log.WithField("request", params).Warnf("err getting duplicates: %v", err)
+		return
+	}
+
+	s.response(w, http.StatusOK, candidates)
+}
+
+// @Summary Отклонить пару дубликатов
+// @Tags duplicates
+// @Description mark a candidate pair as different people, it is not listed again
+// @Accept json
+// @Param input body models.DuplicatePair true "pair"
+// @Success 200 {string} string
+// @Failure 400 {string} string
+// @Failure 404 {string} string
+// @Failure 500 {string} string
+// @Router /api/v1/duplicates/dismiss [post].
+func (s *Server) dismissDuplicate(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	req := models.DuplicatePair{}
+
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	pair := models.NewPair(req.UserID, req.DuplicateID)
+	if err := pair.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := s.uService.DismissDuplicate(ctx, pair)
+	switch {
+	case errors.Is(err, models.ErrUserNotFound):
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		s.log.WithField("request", pair).Warnf("err dismissing duplicate: %v", err)
+		return
+	}
+
+	s.responseOk(w, http.StatusOK)
+}
+
+// @Summary Объединить дубликат с пользователем
+// @Tags duplicates
+// @Description merge a duplicate into the user: blank fields are filled from the duplicate, which is soft-deleted with mergedInto set
+// @Accept json
+// @Produce json
+// @Param id  path  string  true  "id of the surviving user"
+// @Param input body models.MergeRequest true "duplicate"
+// @Success 200 {object} models.User
+// @Header 200 {string} ETag "version of the user"
+// @Failure 400 {string} string
+// @Failure 404 {string} string
+// @Failure 500 {string} string
+// @Router /api/v1/users/{id}/merge [post].
+func (s *Server) mergeUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	val := chi.URLParam(r, "id")
+
+	id, err := strconv.Atoi(val)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	req := models.MergeRequest{}
+
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+	if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err = models.NewPair(id, req.DuplicateID).Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.uService.MergeUser(ctx, id, req.DuplicateID)
+	switch {
+	case errors.Is(err, models.ErrUserNotFound):
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		s.log.WithField("request", id).Warnf("err merging users: %v", err)
+		return
+	}
+
+	setETag(w, user.Version)
+	s.response(w, http.StatusOK, user)
+}
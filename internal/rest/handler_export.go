@@ -0,0 +1,134 @@
+package rest
+
+import (
+	"compress/gzip"
+	"errors"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// exportFlushRows is how often the export is flushed to the client.
+const exportFlushRows = 1000
+
+// @Summary Выгрузить пользователей
+// @Tags user
+// @Description stream users in id order as CSV or NDJSON, with the filters of the user list; gzip with Accept-Encoding: gzip or gzip=true
+// @Produce text/csv
+// @Produce application/x-ndjson
+// @Param format query string false "csv or ndjson (default)"
+// @Param gzip query bool false "compress the response"
+// @Param text query string false "text"
+// @Param match query string false "match mode: exact, prefix or fuzzy (default)"
+// @Param minAge query int false "minimum age"
+// @Param maxAge query int false "maximum age"
+// @Param gender query string false "gender"
+// @Param nationality query []string false "nationalities, repeated or comma separated" collectionFormat(csv)
+// @Param createdFrom query string false "created at or after, RFC3339 time or date"
+// @Param createdTo query string false "created at or before, RFC3339 time or date"
+// @Param updatedFrom query string false "updated at or after, RFC3339 time or date"
+// @Param updatedTo query string false "updated at or before, RFC3339 time or date"
+// @Param hasPatronymic query bool false "patronymic presence"
+// @Param minConfidence query number false "minimum gender and nationality probability, 0 to 1"
+// @Param include_deleted query bool false "export soft-deleted users too"
+// @Param only_deleted query bool false "export soft-deleted users only"
+// @Success 200 {string} string
+// @Failure 400 {string} string
+// @Failure 500 {string} string
+// @Router /api/v1/users/export [get].
+func (s *Server) exportUsers(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	params := models.ExportParams{Text: r.URL.Query().Get("text")}
+
+	var err error
+
+	if params.Format, err = models.ParseExportFormat(r.URL.Query().Get("format")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if params.Match, err = models.ParseMatchMode(r.URL.Query().Get("match")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if params.UserFilter, err = models.ParseUserFilter(r.URL.Query()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	compress, _ := strconv.ParseBool(r.URL.Query().Get("gzip"))
+	compress = compress || acceptsGzip(r.Header.Get("Accept-Encoding"))
+
+	// headers are sent with the first written byte, until then a failed
+	// export still gets an error status
+	contentType := "application/x-ndjson"
+	if params.Format == models.ExportCSV {
+		contentType = "text/csv; charset=utf-8"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="users.`+string(params.Format)+`"`)
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	var out io.Writer = w
+	var zw *gzip.Writer
+
+	if compress {
+		w.Header().Set("Content-Encoding", "gzip")
+		zw = gzip.NewWriter(w)
+		out = zw
+	}
+
+	flusher, _ := w.(http.Flusher)
+	enc := newUserEncoder(params.Format, out)
+	rows := 0
+
+	err = s.uService.ExportUsers(ctx, params, func(user *models.User) error {
+		if err := enc.Encode(user); err != nil {
+			return err
+		}
+
+		if rows++; rows%exportFlushRows == 0 && flusher != nil {
+			if zw != nil {
+				if err := zw.Flush(); err != nil {
+					return err
+				}
+			}
+
+			flusher.Flush()
+		}
+
+		return nil
+	})
+
+	if err == nil {
+		err = enc.Close()
+	}
+
+	if err == nil && zw != nil {
+		err = zw.Close()
+	}
+
+	switch {
+	case err == nil:
+		return
+	case rows == 0:
+		w.Header().Del("Content-Encoding")
+		w.Header().Del("Content-Disposition")
+
+		if errors.Is(err, models.ErrInvalidParam) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		s.log.WithField("request", params).Warnf("err exporting users: %v", err)
+	default:
+		// the status is already sent, aborting the connection tells the
+		// client the export is incomplete
+		s.log.WithField("request", params).Warnf("err exporting users after %d rows: %v", rows, err)
+		panic(http.ErrAbortHandler)
+	}
+}
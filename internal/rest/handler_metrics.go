@@ -10,3 +10,12 @@ func (s *Server) metrics(w http.ResponseWriter, r *http.Request) {
 	prom.ServeHTTP(w, r)
 	return
 }
+
+func (s *Server) ready(w http.ResponseWriter, _ *http.Request) {
+	if !s.consumer.Ready() {
+		http.Error(w, "consumer is not ready", http.StatusServiceUnavailable)
+		return
+	}
+
+	s.responseOk(w, http.StatusOK)
+}
@@ -0,0 +1,74 @@
+package rest
+
+import (
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"net/http"
+)
+
+// @Summary Статистика пользователей
+// @Tags stats
+// @Description count users grouped by nationality, gender or age, with the filters of the user list
+// @Produce json
+// @Param dimension path string true "nationality, gender or age"
+// @Param buckets query string false "comma separated ascending lower bounds of the age groups, 18,30,45,60 by default"
+// @Param text query string false "text"
+// @Param match query string false "match mode: exact, prefix or fuzzy (default)"
+// @Param minAge query int false "minimum age"
+// @Param maxAge query int false "maximum age"
+// @Param gender query string false "gender"
+// @Param nationality query []string false "nationalities, repeated or comma separated" collectionFormat(csv)
+// @Param createdFrom query string false "created at or after, RFC3339 time or date"
+// @Param createdTo query string false "created at or before, RFC3339 time or date"
+// @Param updatedFrom query string false "updated at or after, RFC3339 time or date"
+// @Param updatedTo query string false "updated at or before, RFC3339 time or date"
+// @Param hasPatronymic query bool false "patronymic presence"
+// @Param minConfidence query number false "minimum gender and nationality probability, 0 to 1"
+// @Param include_deleted query bool false "count soft-deleted users too"
+// @Param only_deleted query bool false "count soft-deleted users only"
+// @Success 200 {object} models.Stats
+// @Failure 400 {string} string
+// @Failure 500 {string} string
+// @Router /api/v1/stats/{dimension} [get].
+func (s *Server) getStats(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	params := models.StatsParams{Text: r.URL.Query().Get("text")}
+
+	var err error
+
+	if params.Dimension, err = models.ParseStatsDimension(chi.URLParam(r, "dimension")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if params.Match, err = models.ParseMatchMode(r.URL.Query().Get("match")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if params.UserFilter, err = models.ParseUserFilter(r.URL.Query()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if params.Dimension == models.StatsAge {
+		if params.AgeBuckets, err = models.ParseAgeBuckets(r.URL.Query().Get("buckets")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	stats, err := s.uService.GetStats(ctx, params)
+	switch {
+	case errors.Is(err, models.ErrInvalidParam):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		s.log.WithField("request", params).Warnf("err getting stats: %v", err)
+		return
+	}
+
+	s.response(w, http.StatusOK, stats)
+}
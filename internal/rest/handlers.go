@@ -5,6 +5,7 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 	httpSwagger "github.com/swaggo/http-swagger"
 	_ "github.com/zuzi90/tz-enricher/docs"
+	"github.com/zuzi90/tz-enricher/internal/models"
 )
 
 func (s *Server) InitRoutes() {
@@ -16,16 +17,41 @@ func (s *Server) InitRoutes() {
 	s.router.Group(func(r chi.Router) {
 
 		r.Get("/metrics", s.metrics)
+		r.Get("/readyz", s.ready)
 	})
+	switch {
+	case s.control == nil:
+	case s.adminToken == "":
+		s.log.Warn("admin API is disabled, ADMIN_TOKEN is not set")
+	default:
+		s.router.Route("/admin/consumer", func(r chi.Router) {
+			r.Use(adminAuth(s.adminToken))
+			r.Get("/status", s.consumerStatus)
+			r.Post("/pause", s.pauseConsumer)
+			r.Post("/resume", s.resumeConsumer)
+			r.Put("/rate", s.setConsumerRate)
+			r.Put("/workers", s.setConsumerWorkers)
+		})
+	}
 	s.router.Group(func(r chi.Router) {
 		s.router.Route("/api", func(r chi.Router) {
 			r.Route("/v1", func(r chi.Router) {
 				r.Group(func(r chi.Router) {
+					r.Use(changeSource(models.ChangeREST))
 					r.Get("/users/{id}", s.getUser)
+					r.Get("/users/{id}/history", s.getUserHistory)
 					r.Get("/users/", s.getUsers)
+					r.Get("/users/export", s.exportUsers)
 					r.Post("/users", s.addUser)
 					r.Patch("/users/{id}", s.updateUser)
 					r.Delete("/users/{id}", s.deleteUser)
+					r.Post("/users/{id}/restore", s.restoreUser)
+					r.Delete("/users/{id}/purge", s.purgeUser)
+					r.Delete("/users/deleted", s.purgeDeleted)
+					r.Post("/users/{id}/merge", s.mergeUser)
+					r.Get("/duplicates", s.getDuplicates)
+					r.Post("/duplicates/dismiss", s.dismissDuplicate)
+					r.Get("/stats/{dimension}", s.getStats)
 				})
 			})
 		})
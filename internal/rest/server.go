@@ -2,44 +2,80 @@ package rest
 
 import (
 	"context"
+	"crypto/subtle"
 	"errors"
 	"github.com/go-chi/chi/v5"
 	jsoniter "github.com/json-iterator/go"
 	"github.com/sirupsen/logrus"
 	"github.com/zuzi90/tz-enricher/internal/models"
 	"net/http"
+	"time"
 )
 
 type messageService interface {
-	Handle(ctx context.Context, val []byte) error
+	Handle(ctx context.Context, msg models.Message) error
 }
 
 type userService interface {
 	CreateUser(ctx context.Context, val models.UserCreate) (*models.User, error)
-	DeleteUser(ctx context.Context, key int) error
+	DeleteUser(ctx context.Context, key int, reason string) error
+	RestoreUser(ctx context.Context, id int) (*models.User, error)
+	PurgeUser(ctx context.Context, id int) error
+	PurgeDeleted(ctx context.Context, olderThan time.Duration) (int, error)
 	GetUser(ctx context.Context, id int) (*models.User, error)
-	GetUsers(ctx context.Context, params models.GetUsersParams) ([]*models.User, error)
-	UpdateUser(ctx context.Context, id int, val models.UserUpdate) (*models.User, error)
+	GetUserAsOf(ctx context.Context, id int, at time.Time) (*models.User, error)
+	GetUserHistory(ctx context.Context, id int) ([]*models.UserVersion, error)
+	GetDuplicates(ctx context.Context, params models.GetDuplicatesParams) ([]*models.DuplicateCandidate, error)
+	DismissDuplicate(ctx context.Context, pair models.DuplicatePair) error
+	MergeUser(ctx context.Context, survivorID, duplicateID int) (*models.User, error)
+	GetStats(ctx context.Context, params models.StatsParams) (*models.Stats, error)
+	ExportUsers(ctx context.Context, params models.ExportParams, fn func(user *models.User) error) error
+	GetUsers(ctx context.Context, params models.GetUsersParams) (*models.UsersPage, error)
+	UpdateUser(ctx context.Context, id int, val models.UserUpdate, versions []int) (*models.User, error)
+}
+
+type consumerStatus interface {
+	Ready() bool
+}
+
+// consumerControl is implemented by sources that can be managed at runtime,
+// the admin API is served only for them.
+type consumerControl interface {
+	consumerStatus
+	Status() models.ConsumerStatus
+	Pause(partitions ...int32) error
+	Resume(partitions ...int32) error
+	SetRate(rate float64)
+	SetWorkers(count int) error
 }
 
 type Server struct {
-	log      *logrus.Entry
-	router   *chi.Mux
-	server   *http.Server
-	address  string
-	services messageService
-	uService userService
+	log        *logrus.Entry
+	router     *chi.Mux
+	server     *http.Server
+	address    string
+	adminToken string
+	services   messageService
+	uService   userService
+	consumer   consumerStatus
+	control    consumerControl
 }
 
-func NewServer(port string, log *logrus.Logger, services messageService, uService userService) *Server {
+// NewServer creates the HTTP server. The admin API requires adminToken as a
+// bearer token and is not served when adminToken is empty.
+func NewServer(port string, adminToken string, log *logrus.Logger, services messageService, uService userService, consumer consumerStatus) *Server {
 	srv := Server{
-		log:      log.WithField("module", "server"),
-		router:   chi.NewRouter(),
-		address:  port,
-		services: services,
-		uService: uService,
+		log:        log.WithField("module", "server"),
+		router:     chi.NewRouter(),
+		address:    port,
+		adminToken: adminToken,
+		services:   services,
+		uService:   uService,
+		consumer:   consumer,
 	}
 
+	srv.control, _ = consumer.(consumerControl)
+
 	srv.InitRoutes()
 
 	server := http.Server{
@@ -83,6 +119,34 @@ func (s *Server) response(w http.ResponseWriter, code int, data interface{}) {
 	}
 }
 
+// changeSource marks changes made by the handlers with source in the user
+// history.
+func changeSource(source models.ChangeSource) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(models.WithChangeSource(r.Context(), source)))
+		})
+	}
+}
+
+// adminAuth lets through requests carrying token in the Authorization
+// header as "Bearer <token>".
+func adminAuth(token string) func(http.Handler) http.Handler {
+	expected := []byte("Bearer " + token)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func (s *Server) responseOk(w http.ResponseWriter, code int) {
 	w.WriteHeader(code)
 }
@@ -0,0 +1,214 @@
+package message_service
+
+import (
+	"context"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"sync"
+	"time"
+)
+
+// maxBatchSize keeps a multi-row insert within the limit of bind parameters.
+const maxBatchSize = 1000
+
+type batchStorage interface {
+	CreateUser(ctx context.Context, user models.UserCreate) (*models.User, error)
+	CreateUsers(ctx context.Context, users []models.UserCreate) ([]*models.User, error)
+}
+
+type batchCache interface {
+	SetMany(ctx context.Context, users []*models.User) error
+}
+
+type batchResult struct {
+	user *models.User
+	err  error
+}
+
+type batchItem struct {
+	ctx  context.Context
+	val  models.UserCreate
+	done func(user *models.User, err error)
+}
+
+// batcher collects users and writes them with one insert when size users
+// are pending or interval has passed since the first of them. Add does not
+// wait for the write, so one caller can fill a batch; its done callback is
+// called after the write, and only then may the caller acknowledge the
+// message. Batches are written one at a time in the order they were filled.
+type batcher struct {
+	db       batchStorage
+	cache    batchCache
+	log      *logrus.Entry
+	metrics  *metrics
+	size     int
+	interval time.Duration
+
+	// flushMu is held while a batch is taken and written.
+	flushMu sync.Mutex
+
+	mu      sync.Mutex
+	pending []*batchItem
+	timer   *time.Timer
+}
+
+func newBatcher(db batchStorage, cache batchCache, log *logrus.Entry, m *metrics, size int, interval time.Duration) *batcher {
+	return &batcher{
+		db:       db,
+		cache:    cache,
+		log:      log,
+		metrics:  m,
+		size:     min(size, maxBatchSize),
+		interval: interval,
+	}
+}
+
+// Add adds the user to the current batch and returns, done is called with
+// the stored user once the batch is written. The batch is written by Add
+// when it is full.
+func (b *batcher) Add(ctx context.Context, val models.UserCreate, done func(user *models.User, err error)) {
+	b.mu.Lock()
+	b.pending = append(b.pending, &batchItem{ctx: ctx, val: val, done: done})
+
+	full := len(b.pending) >= b.size
+	if len(b.pending) == 1 && !full {
+		b.timer = time.AfterFunc(b.interval, b.flush)
+	}
+
+	b.mu.Unlock()
+
+	if full {
+		b.flush()
+	}
+}
+
+// flush writes up to size pending users.
+func (b *batcher) flush() {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	b.mu.Lock()
+	batch := b.take()
+	b.mu.Unlock()
+
+	if len(batch) > 0 {
+		b.write(batch)
+	}
+}
+
+// take returns up to size pending items, b.mu must be held. The timer is
+// restarted for the items left.
+func (b *batcher) take() []*batchItem {
+	n := min(len(b.pending), b.size)
+	batch := b.pending[:n:n]
+	b.pending = append([]*batchItem(nil), b.pending[n:]...)
+
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+
+	if len(b.pending) > 0 {
+		b.timer = time.AfterFunc(b.interval, b.flush)
+	}
+
+	return batch
+}
+
+// write stores a batch, one insert per change source. Items whose caller
+// has given up are not written: the message is not acknowledged and would
+// be inserted again when it is redelivered.
+func (b *batcher) write(batch []*batchItem) {
+	live := batch[:0:0]
+
+	for _, item := range batch {
+		if err := item.ctx.Err(); err != nil {
+			item.done(nil, err)
+			continue
+		}
+
+		live = append(live, item)
+	}
+
+	if len(live) == 0 {
+		return
+	}
+
+	b.metrics.observeBatch(len(live))
+
+	var sources []models.ChangeSource
+
+	bySource := make(map[models.ChangeSource][]*batchItem)
+	for _, item := range live {
+		source := models.ChangeSourceFrom(item.ctx)
+		if _, ok := bySource[source]; !ok {
+			sources = append(sources, source)
+		}
+
+		bySource[source] = append(bySource[source], item)
+	}
+
+	for _, source := range sources {
+		items := bySource[source]
+
+		// the write is not canceled by one of the callers, each of them
+		// has been checked above
+		ctx := models.WithChangeSource(context.Background(), source)
+
+		results := b.insert(ctx, items)
+
+		users := make([]*models.User, 0, len(results))
+		for _, res := range results {
+			if res.err == nil {
+				users = append(users, res.user)
+			}
+		}
+
+		if len(users) > 0 {
+			if err := b.cache.SetMany(ctx, users); err != nil {
+				for i := range results {
+					if results[i].err == nil {
+						results[i].err = fmt.Errorf("err cache set %w", err)
+					}
+				}
+			}
+		}
+
+		for i, item := range items {
+			item.done(results[i].user, results[i].err)
+		}
+	}
+}
+
+// insert writes the items with a multi-row insert, falling back to one
+// insert per item when it fails, so that a bad user fails only its own
+// message.
+func (b *batcher) insert(ctx context.Context, items []*batchItem) []batchResult {
+	results := make([]batchResult, len(items))
+
+	vals := make([]models.UserCreate, 0, len(items))
+	for _, item := range items {
+		vals = append(vals, item.val)
+	}
+
+	users, err := b.db.CreateUsers(ctx, vals)
+	if err == nil {
+		for i := range results {
+			results[i].user = users[i]
+		}
+
+		return results
+	}
+
+	b.log.Warnf("batch insert of %d users failed, inserting one by one: %v", len(items), err)
+	b.metrics.incBatchFallback()
+
+	for i, item := range items {
+		if results[i].user, err = b.db.CreateUser(ctx, item.val); err != nil {
+			results[i].err = fmt.Errorf("err db create user %w", err)
+		}
+	}
+
+	return results
+}
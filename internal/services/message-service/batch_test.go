@@ -0,0 +1,218 @@
+package message_service
+
+import (
+	"context"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testMetrics = newMetrics()
+
+type fakeStorage struct {
+	mu      sync.Mutex
+	nextID  int
+	batches [][]models.UserCreate
+	sources []models.ChangeSource
+	failAll bool
+}
+
+func (f *fakeStorage) CreateUser(_ context.Context, val models.UserCreate) (*models.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if val.Name == "bad" {
+		return nil, errors.New("value too long")
+	}
+
+	f.nextID++
+
+	return &models.User{ID: f.nextID, Name: val.Name}, nil
+}
+
+func (f *fakeStorage) CreateUsers(ctx context.Context, vals []models.UserCreate) ([]*models.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.batches = append(f.batches, vals)
+	f.sources = append(f.sources, models.ChangeSourceFrom(ctx))
+
+	if f.failAll {
+		return nil, errors.New("value too long")
+	}
+
+	users := make([]*models.User, 0, len(vals))
+	for _, val := range vals {
+		f.nextID++
+		users = append(users, &models.User{ID: f.nextID, Name: val.Name})
+	}
+
+	return users, nil
+}
+
+type fakeCache struct {
+	mu    sync.Mutex
+	users map[int]*models.User
+}
+
+func (f *fakeCache) SetMany(_ context.Context, users []*models.User) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, user := range users {
+		f.users[user.ID] = user
+	}
+
+	return nil
+}
+
+func newTestBatcher(db *fakeStorage, size int, interval time.Duration) (*batcher, *fakeCache) {
+	c := &fakeCache{users: make(map[int]*models.User)}
+	return newBatcher(db, c, logrus.NewEntry(logrus.New()), testMetrics, size, interval), c
+}
+
+type added struct {
+	user *models.User
+	err  error
+}
+
+// addAll adds the users one after another, as a single worker does, and
+// returns the channel their results are delivered to.
+func addAll(ctx context.Context, b *batcher, names ...string) chan added {
+	results := make(chan added, len(names))
+
+	for _, name := range names {
+		b.Add(ctx, models.UserCreate{Name: name}, func(user *models.User, err error) {
+			results <- added{user: user, err: err}
+		})
+	}
+
+	return results
+}
+
+func receive(t *testing.T, results chan added, n int) []added {
+	t.Helper()
+
+	res := make([]added, 0, n)
+
+	for i := 0; i < n; i++ {
+		select {
+		case r := <-results:
+			res = append(res, r)
+		case <-time.After(time.Second):
+			t.Fatalf("%d of %d results received", i, n)
+		}
+	}
+
+	return res
+}
+
+func Test_batcher(t *testing.T) {
+	ctx := models.WithChangeSource(context.Background(), models.ChangeKafka)
+
+	t.Run("one caller fills a batch", func(t *testing.T) {
+		db := &fakeStorage{}
+		b, c := newTestBatcher(db, 3, time.Hour)
+
+		res := receive(t, addAll(ctx, b, "Frodo", "Sam", "Pippin"), 3)
+
+		for i, name := range []string{"Frodo", "Sam", "Pippin"} {
+			require.NoError(t, res[i].err)
+			assert.Equal(t, name, res[i].user.Name)
+		}
+
+		assert.Len(t, db.batches, 1)
+		assert.Equal(t, []models.ChangeSource{models.ChangeKafka}, db.sources)
+		assert.Len(t, c.users, 3)
+	})
+
+	t.Run("done only after the write", func(t *testing.T) {
+		db := &fakeStorage{}
+		b, _ := newTestBatcher(db, 10, time.Hour)
+
+		results := addAll(ctx, b, "Merry")
+		assert.Empty(t, results)
+		assert.Empty(t, db.batches)
+
+		b.flush()
+
+		res := receive(t, results, 1)
+		require.NoError(t, res[0].err)
+		assert.Len(t, db.batches, 1)
+	})
+
+	t.Run("flushes after the interval", func(t *testing.T) {
+		db := &fakeStorage{}
+		b, _ := newTestBatcher(db, 10, 10*time.Millisecond)
+
+		res := receive(t, addAll(ctx, b, "Merry"), 1)
+		require.NoError(t, res[0].err)
+		assert.Equal(t, "Merry", res[0].user.Name)
+		assert.Len(t, db.batches, 1)
+	})
+
+	t.Run("splits into batches in order", func(t *testing.T) {
+		db := &fakeStorage{}
+		b, _ := newTestBatcher(db, 2, 10*time.Millisecond)
+
+		res := receive(t, addAll(ctx, b, "Frodo", "Sam", "Merry", "Pippin", "Bilbo"), 5)
+
+		for i, name := range []string{"Frodo", "Sam", "Merry", "Pippin", "Bilbo"} {
+			assert.Equal(t, name, res[i].user.Name)
+			assert.Equal(t, i+1, res[i].user.ID)
+		}
+
+		require.Len(t, db.batches, 3)
+		assert.Len(t, db.batches[2], 1)
+	})
+
+	t.Run("isolates a bad user", func(t *testing.T) {
+		db := &fakeStorage{failAll: true}
+		b, c := newTestBatcher(db, 3, time.Hour)
+
+		res := receive(t, addAll(ctx, b, "Frodo", "bad", "Sam"), 3)
+
+		assert.NoError(t, res[0].err)
+		assert.Error(t, res[1].err)
+		assert.NoError(t, res[2].err)
+		assert.Equal(t, "Sam", res[2].user.Name)
+		assert.Len(t, c.users, 2)
+	})
+
+	t.Run("groups by change source", func(t *testing.T) {
+		db := &fakeStorage{}
+		b, _ := newTestBatcher(db, 2, time.Hour)
+
+		kafka := addAll(ctx, b, "Gandalf")
+		replay := addAll(models.WithChangeSource(context.Background(), models.ChangeReplay), b, "Gandalf")
+
+		receive(t, kafka, 1)
+		receive(t, replay, 1)
+
+		assert.Len(t, db.batches, 2)
+		assert.Equal(t, []models.ChangeSource{models.ChangeKafka, models.ChangeReplay}, db.sources)
+	})
+
+	t.Run("caller gives up", func(t *testing.T) {
+		db := &fakeStorage{}
+		b, _ := newTestBatcher(db, 2, time.Hour)
+
+		canceled, cancel := context.WithCancel(ctx)
+		results := addAll(canceled, b, "Boromir")
+		cancel()
+
+		res := receive(t, addAll(ctx, b, "Faramir"), 1)
+		require.NoError(t, res[0].err)
+
+		res = receive(t, results, 1)
+		assert.ErrorIs(t, res[0].err, context.Canceled)
+
+		require.Len(t, db.batches, 1)
+		assert.Equal(t, []models.UserCreate{{Name: "Faramir"}}, db.batches[0])
+	})
+}
@@ -9,6 +9,8 @@ import (
 type metrics struct {
 	invalidFN        *prometheus.CounterVec
 	handlingDuration prometheus.Histogram
+	batchSize        prometheus.Histogram
+	batchFallbacks   prometheus.Counter
 }
 
 func newMetrics() *metrics {
@@ -29,6 +31,21 @@ func newMetrics() *metrics {
 				Name:      "fn_handling_duration",
 				Help:      "fn handling duration histogram",
 			}),
+		batchSize: promauto.NewHistogram(
+			prometheus.HistogramOpts{
+				Namespace: "fn_enricher",
+				Subsystem: "fn_processor",
+				Name:      "user_batch_size",
+				Help:      "number of users written by one batch insert",
+				Buckets:   prometheus.ExponentialBuckets(1, 2, 11),
+			}),
+		batchFallbacks: promauto.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: "fn_enricher",
+				Subsystem: "fn_processor",
+				Name:      "user_batch_fallback_count",
+				Help:      "batch inserts that failed and were retried one user at a time",
+			}),
 	}
 }
 
@@ -39,3 +56,11 @@ func (m *metrics) incInvalidFN(err error) {
 func (m *metrics) observe(t time.Duration) {
 	m.handlingDuration.Observe(t.Seconds())
 }
+
+func (m *metrics) observeBatch(size int) {
+	m.batchSize.Observe(float64(size))
+}
+
+func (m *metrics) incBatchFallback() {
+	m.batchFallbacks.Inc()
+}
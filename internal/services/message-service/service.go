@@ -4,13 +4,19 @@ import (
 	"context"
 	"fmt"
 	jsoniter "github.com/json-iterator/go"
+	"github.com/sirupsen/logrus"
 	"github.com/zuzi90/tz-enricher/internal/models"
 	"golang.org/x/sync/errgroup"
 	"time"
 )
 
 type messageProducer interface {
-	SendMessage(msg []byte) error
+	SendMessage(ctx context.Context, msg models.Message) error
+}
+
+type messageCodec interface {
+	Decode(ctx context.Context, msg models.Message) (models.DecodedFN, error)
+	Encode(ctx context.Context, format models.MessageFormat, resp models.ResponseFNError) ([]byte, error)
 }
 
 type ageResolver interface {
@@ -18,87 +24,169 @@ type ageResolver interface {
 }
 
 type genderResolver interface {
-	GetGender(ctx context.Context, name string) (string, error)
+	GetGender(ctx context.Context, name string) (string, float64, error)
 }
 
 type countryResolver interface {
-	GetCountry(ctx context.Context, name string) (string, error)
+	GetCountry(ctx context.Context, name string) (string, float64, error)
 }
 
 type appStorage interface {
 	CreateUser(ctx context.Context, user models.UserCreate) (*models.User, error)
-	DeleteUser(ctx context.Context, id int) error
+	CreateUsers(ctx context.Context, users []models.UserCreate) ([]*models.User, error)
+	DeleteUser(ctx context.Context, id int, reason string) error
 }
 
 type cache interface {
 	Set(ctx context.Context, user *models.User) error
+	SetMany(ctx context.Context, users []*models.User) error
 	Delete(ctx context.Context, key int) error
 	Update(ctx context.Context, user *models.User) error
 }
 
 type MessageService struct {
+	log             *logrus.Entry
 	metrics         *metrics
 	cache           cache
 	ageResolver     ageResolver
 	genderResolver  genderResolver
 	countryResolver countryResolver
+	messageCodec    messageCodec
 	messageProducer messageProducer
+	enrichedSink    messageProducer
 	db              appStorage
+	batcher         *batcher
 }
 
 func NewMessageService(
+	log *logrus.Logger,
 	cache cache,
 	ageResolver ageResolver,
 	genderResolver genderResolver,
 	countryResolver countryResolver,
+	messageCodec messageCodec,
 	messageProducer messageProducer,
 	db appStorage,
 ) *MessageService {
 	return &MessageService{
+		log:             log.WithField("module", "message_service"),
 		metrics:         newMetrics(),
 		cache:           cache,
 		ageResolver:     ageResolver,
 		genderResolver:  genderResolver,
 		countryResolver: countryResolver,
+		messageCodec:    messageCodec,
 		messageProducer: messageProducer,
 		db:              db,
 	}
 }
 
-func (s *MessageService) Handle(ctx context.Context, val []byte) error {
+func (s *MessageService) Handle(ctx context.Context, msg models.Message) error {
+	ctx = withDefaultSource(ctx)
 
 	started := time.Now()
 	defer func() {
 		s.metrics.observe(time.Since(started))
 	}()
 
-	fn := models.UserFN{}
-
-	json := jsoniter.ConfigCompatibleWithStandardLibrary
-	if err := json.Unmarshal(val, &fn); err != nil {
+	pending, err := s.prepare(ctx, msg)
+	if err != nil || pending == nil {
 		return err
 	}
 
+	user, err := s.db.CreateUser(ctx, pending.val)
+	if err != nil {
+		return fmt.Errorf("err db create user %w", err)
+	}
+
+	if err := s.cache.Set(ctx, user); err != nil {
+		return fmt.Errorf("err cache set %w", err)
+	}
+
+	return s.stored(ctx, pending, user)
+}
+
+// HandleAsync handles msg and calls done with the result. Without batching
+// done is called before HandleAsync returns. With batching the enriched user
+// is added to the current batch and done is called once the batch is
+// written, so the caller acknowledges msg only after its user is stored
+// while it goes on with the next messages.
+func (s *MessageService) HandleAsync(ctx context.Context, msg models.Message, done func(err error)) {
+	if s.batcher == nil {
+		done(s.Handle(ctx, msg))
+		return
+	}
+
+	ctx = withDefaultSource(ctx)
+	started := time.Now()
+
+	finish := func(err error) {
+		s.metrics.observe(time.Since(started))
+		done(err)
+	}
+
+	pending, err := s.prepare(ctx, msg)
+	if err != nil || pending == nil {
+		finish(err)
+		return
+	}
+
+	s.batcher.Add(ctx, pending.val, func(user *models.User, err error) {
+		if err == nil {
+			err = s.stored(ctx, pending, user)
+		}
+
+		finish(err)
+	})
+}
+
+// pendingUser is an enriched user waiting to be stored.
+type pendingUser struct {
+	key  []byte
+	meta models.MessageMeta
+	val  models.UserCreate
+}
+
+// prepare decodes, validates and enriches msg. An invalid FN is sent to the
+// WRONG_FN topic and nil is returned, as there is nothing to store.
+func (s *MessageService) prepare(ctx context.Context, msg models.Message) (*pendingUser, error) {
+	decoded, err := s.messageCodec.Decode(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	fn, meta := decoded.FN, decoded.Meta
+
+	log := s.log.WithFields(meta.LogFields())
+
 	if err := fn.ValidateFN(); err != nil {
 		s.metrics.incInvalidFN(err)
 		resp := models.ResponseFNError{}
 		resp.UserFN = fn
 		resp.ErrMessage = err.Error()
 
-		json := jsoniter.ConfigCompatibleWithStandardLibrary
-		respByte, err := json.Marshal(resp)
+		respByte, err := s.messageCodec.Encode(ctx, decoded.Format, resp)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		if err := s.messageProducer.SendMessage(respByte); err != nil {
-			return err
+		wrongFN := models.Message{
+			Key:     msg.Key,
+			Value:   respByte,
+			Headers: meta.Headers(),
 		}
 
-		return nil
+		if err := s.messageProducer.SendMessage(ctx, wrongFN); err != nil {
+			return nil, err
+		}
+
+		log.Infof("invalid fn sent to wrong fn topic: %v", resp.ErrMessage)
+
+		return nil, nil
 	}
 
 	result := models.NewCreateUser(fn)
+	result.Provenance = meta.Provenance
 	eg, ctxE := errgroup.WithContext(ctx)
 	eg.Go(func() error {
 		age, err := s.ageResolver.GetAge(ctxE, fn.Name)
@@ -112,40 +200,117 @@ func (s *MessageService) Handle(ctx context.Context, val []byte) error {
 	})
 
 	eg.Go(func() error {
-		gender, err := s.genderResolver.GetGender(ctxE, fn.Name)
+		gender, probability, err := s.genderResolver.GetGender(ctxE, fn.Name)
 		if err != nil {
 			return err
 		}
 
 		result.Gender = gender
+		result.GenderProbability = &probability
 
 		return nil
 	})
 
-	eg.Go(func() error {
-		country, err := s.countryResolver.GetCountry(ctxE, fn.Name)
-		if err != nil {
-			return err
-		}
+	if meta.CountryHint != "" {
+		result.Nationality = meta.CountryHint
+		certain := 1.0
+		result.NationalityProbability = &certain
+	} else {
+		eg.Go(func() error {
+			country, probability, err := s.countryResolver.GetCountry(ctxE, fn.Name)
+			if err != nil {
+				return err
+			}
+
+			result.Nationality = country
+			result.NationalityProbability = &probability
+
+			return nil
+		})
+	}
 
-		result.Nationality = country
+	if err := eg.Wait(); err != nil {
+		return nil, fmt.Errorf("eg.Wait(): %w", err)
+	}
+
+	return &pendingUser{key: msg.Key, meta: meta, val: result}, nil
+}
+
+// stored finishes the handling of a stored user.
+func (s *MessageService) stored(ctx context.Context, pending *pendingUser, user *models.User) error {
+	s.log.WithFields(pending.meta.LogFields()).Debugf("user %d created", user.ID)
+
+	return s.publishEnriched(ctx, pending.key, pending.meta, user)
+}
 
+func withDefaultSource(ctx context.Context) context.Context {
+	if models.ChangeSourceFrom(ctx) == "" {
+		return models.WithChangeSource(ctx, models.ChangeKafka)
+	}
+
+	return ctx
+}
+
+// UseEnrichedSink makes the service publish every stored user to sink as
+// JSON.
+func (s *MessageService) UseEnrichedSink(sink messageProducer) {
+	s.enrichedSink = sink
+}
+
+func (s *MessageService) publishEnriched(ctx context.Context, key []byte, meta models.MessageMeta, user *models.User) error {
+	if s.enrichedSink == nil {
 		return nil
-	})
+	}
 
-	if err := eg.Wait(); err != nil {
-		return fmt.Errorf("eg.Wait(): %w", err)
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+
+	data, err := json.Marshal(user)
+	if err != nil {
+		return err
 	}
 
-	user, err := s.db.CreateUser(ctx, result)
+	event := models.Message{
+		Key:     key,
+		Value:   data,
+		Headers: meta.Headers(),
+	}
+
+	if err = s.enrichedSink.SendMessage(ctx, event); err != nil {
+		return fmt.Errorf("err send enriched user %d: %w", user.ID, err)
+	}
+
+	return nil
+}
+
+// UseBatching makes HandleAsync write users in batches of up to size users,
+// waiting at most interval for a batch to fill. Sizes below 2 disable it.
+// Handle always writes its user on its own.
+func (s *MessageService) UseBatching(size int, interval time.Duration) {
+	if size < 2 {
+		s.batcher = nil
+		return
+	}
+
+	s.batcher = newBatcher(s.db, s.cache, s.log, s.metrics, size, interval)
+}
+
+// DryRun decodes and validates msg without resolving, storing or sending
+// anything, and logs what Handle would have done with it.
+func (s *MessageService) DryRun(ctx context.Context, msg models.Message) error {
+	decoded, err := s.messageCodec.Decode(ctx, msg)
 	if err != nil {
-		return fmt.Errorf("err db create user %w", err)
+		return err
 	}
 
-	if err := s.cache.Set(ctx, user); err != nil {
-		return fmt.Errorf("err cache set %w", err)
+	log := s.log.WithFields(decoded.Meta.LogFields())
+
+	if err := decoded.FN.ValidateFN(); err != nil {
+		log.Infof("dry run: offset %d would be sent to wrong fn topic: %v", msg.Offset, err)
+		return err
 	}
 
+	log.Infof("dry run: offset %d would be enriched and stored: %s %s", msg.Offset, decoded.FN.Name, decoded.FN.Surname)
+
 	return nil
 }
 
@@ -163,7 +328,7 @@ func (s *MessageService) CreateUser(ctx context.Context, val models.UserCreate)
 }
 
 func (s *MessageService) DeleteUser(ctx context.Context, id int) error {
-	err := s.db.DeleteUser(ctx, id)
+	err := s.db.DeleteUser(ctx, id, "")
 	if err != nil {
 		return fmt.Errorf("err db delete user %w", err)
 	}
@@ -0,0 +1,177 @@
+package message_service
+
+import (
+	"context"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"github.com/zuzi90/tz-enricher/internal/providers/codec"
+	storagememory "github.com/zuzi90/tz-enricher/internal/providers/storage/memory"
+	"github.com/zuzi90/tz-enricher/internal/providers/transport/memory"
+	"testing"
+	"time"
+)
+
+func (f *fakeCache) Set(ctx context.Context, user *models.User) error {
+	return f.SetMany(ctx, []*models.User{user})
+}
+
+func (f *fakeCache) Update(ctx context.Context, user *models.User) error {
+	return f.SetMany(ctx, []*models.User{user})
+}
+
+func (f *fakeCache) Delete(_ context.Context, key int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.users, key)
+
+	return nil
+}
+
+type fakeResolver struct{}
+
+func (fakeResolver) GetAge(_ context.Context, _ string) (int, error) {
+	return 50, nil
+}
+
+func (fakeResolver) GetGender(_ context.Context, _ string) (string, float64, error) {
+	return "male", 0.99, nil
+}
+
+func (fakeResolver) GetCountry(_ context.Context, _ string) (string, float64, error) {
+	return "NZ", 0.5, nil
+}
+
+// newTestService builds the service around testMetrics, the collectors can be
+// registered once per test binary.
+func newTestService(t *testing.T, db appStorage, producer messageProducer) (*MessageService, *fakeCache) {
+	t.Helper()
+
+	log := logrus.New()
+
+	mCodec, err := codec.NewCodec(log, "json", nil, "WRONG_FN-value", false)
+	require.NoError(t, err)
+
+	c := &fakeCache{users: make(map[int]*models.User)}
+
+	return &MessageService{
+		log:             log.WithField("module", "message_service"),
+		metrics:         testMetrics,
+		cache:           c,
+		ageResolver:     fakeResolver{},
+		genderResolver:  fakeResolver{},
+		countryResolver: fakeResolver{},
+		messageCodec:    mCodec,
+		messageProducer: producer,
+		db:              db,
+	}, c
+}
+
+// Test_pipeline runs FN messages through the in-memory transport: consume,
+// enrich, store and publish to the WRONG_FN and enriched sinks.
+func Test_pipeline(t *testing.T) {
+	broker := memory.NewBroker()
+	db := storagememory.NewStorage()
+
+	s, c := newTestService(t, db, memory.NewSink(broker, "WRONG_FN"))
+	s.UseEnrichedSink(memory.NewSink(broker, "FN_ENRICHED"))
+
+	broker.Publish("FN", models.Message{
+		Key:     []byte("frodo"),
+		Value:   []byte(`{"name":"Frodo","surname":"Baggins"}`),
+		Headers: map[string]string{models.HeaderCorrelationID: "corr-1"},
+	})
+	broker.Publish("FN", models.Message{Key: []byte("frodo1"), Value: []byte(`{"name":"Frodo1","surname":"Baggins"}`)})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+
+	go func() {
+		done <- memory.NewSource(broker, "FN", logrus.New(), s).Run(ctx)
+	}()
+
+	require.Eventually(t, func() bool {
+		return len(broker.Messages("FN_ENRICHED")) == 1 && len(broker.Messages("WRONG_FN")) == 1
+	}, time.Second, 5*time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-done)
+
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+
+	t.Run("enriched user published", func(t *testing.T) {
+		event := broker.Messages("FN_ENRICHED")[0]
+		assert.Equal(t, "frodo", string(event.Key))
+		assert.Equal(t, "corr-1", event.Headers[models.HeaderCorrelationID])
+
+		user := models.User{}
+		require.NoError(t, json.Unmarshal(event.Value, &user))
+		assert.Equal(t, "Frodo", user.Name)
+		assert.Equal(t, 50, user.Age)
+		assert.Equal(t, "male", user.Gender)
+		assert.Equal(t, "NZ", user.Nationality)
+
+		stored, err := db.GetUser(context.Background(), user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "Baggins", stored.Surname)
+		assert.Contains(t, c.users, user.ID)
+	})
+
+	t.Run("invalid fn sent to wrong fn", func(t *testing.T) {
+		resp := models.ResponseFNError{}
+		require.NoError(t, json.Unmarshal(broker.Messages("WRONG_FN")[0].Value, &resp))
+		assert.Equal(t, "Frodo1", resp.Name)
+		assert.NotEmpty(t, resp.ErrMessage)
+	})
+}
+
+func (f *fakeStorage) DeleteUser(_ context.Context, _ int, _ string) error {
+	return nil
+}
+
+func Test_HandleAsync(t *testing.T) {
+	ctx := context.Background()
+	broker := memory.NewBroker()
+	db := &fakeStorage{}
+
+	s, _ := newTestService(t, db, memory.NewSink(broker, "WRONG_FN"))
+	s.UseEnrichedSink(memory.NewSink(broker, "FN_ENRICHED"))
+	s.UseBatching(3, time.Hour)
+
+	results := make(chan error, 4)
+	done := func(err error) {
+		results <- err
+	}
+
+	for _, name := range []string{"Frodo", "Sam"} {
+		s.HandleAsync(ctx, models.Message{Value: []byte(`{"name":"` + name + `","surname":"Baggins"}`)}, done)
+	}
+
+	t.Run("invalid fn done at once", func(t *testing.T) {
+		s.HandleAsync(ctx, models.Message{Value: []byte(`{"name":"Frodo1","surname":"Baggins"}`)}, done)
+
+		require.Len(t, results, 1)
+		assert.NoError(t, <-results)
+		assert.Len(t, broker.Messages("WRONG_FN"), 1)
+	})
+
+	t.Run("done after the batch is written", func(t *testing.T) {
+		assert.Empty(t, results)
+		assert.Empty(t, db.batches)
+		assert.Empty(t, broker.Messages("FN_ENRICHED"))
+
+		s.HandleAsync(ctx, models.Message{Value: []byte(`{"name":"Pippin","surname":"Took"}`)}, done)
+
+		require.Len(t, results, 3)
+		for i := 0; i < 3; i++ {
+			assert.NoError(t, <-results)
+		}
+
+		require.Len(t, db.batches, 1)
+		assert.Len(t, db.batches[0], 3)
+		assert.Len(t, broker.Messages("FN_ENRICHED"), 3)
+	})
+}
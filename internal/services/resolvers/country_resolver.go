@@ -27,15 +27,15 @@ func NewCountryResolver(log *logrus.Logger, url string) *CountryResolver {
 	return &resolver
 }
 
-func (r *CountryResolver) GetCountry(ctx context.Context, name string) (string, error) {
+func (r *CountryResolver) GetCountry(ctx context.Context, name string) (string, float64, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.countryURL+name, nil)
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
 
 	resp, err := r.client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("country resolver: %w", err)
+		return "", 0, fmt.Errorf("country resolver: %w", err)
 	}
 
 	defer func() {
@@ -46,24 +46,24 @@ func (r *CountryResolver) GetCountry(ctx context.Context, name string) (string,
 
 	if resp.StatusCode != http.StatusOK {
 		r.log.Warnf("unexpected status code %d", resp.StatusCode)
-		return "", fmt.Errorf("response status code: %d", resp.StatusCode)
+		return "", 0, fmt.Errorf("response status code: %d", resp.StatusCode)
 	}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
 
 	country := models.NationalityResolver{}
 
 	json := jsoniter.ConfigCompatibleWithStandardLibrary
 	if err = json.Unmarshal(body, &country); err != nil {
-		return "", err
+		return "", 0, err
 	}
 
 	if len(country.Country) == 0 || country.Country[0].CountryID == "" {
-		return "", fmt.Errorf("country is empty, name: %s", name)
+		return "", 0, fmt.Errorf("country is empty, name: %s", name)
 	}
 
-	return country.Country[0].CountryID, nil
+	return country.Country[0].CountryID, country.Country[0].Probability, nil
 }
@@ -27,15 +27,15 @@ func NewGenderResolver(log *logrus.Logger, url string) *GenderResolver {
 	return &resolver
 }
 
-func (r *GenderResolver) GetGender(ctx context.Context, name string) (string, error) {
+func (r *GenderResolver) GetGender(ctx context.Context, name string) (string, float64, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.genderURL+name, nil)
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
 
 	resp, err := r.client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf(" err gender resolver: %w", err)
+		return "", 0, fmt.Errorf(" err gender resolver: %w", err)
 	}
 
 	defer func() {
@@ -46,24 +46,24 @@ func (r *GenderResolver) GetGender(ctx context.Context, name string) (string, er
 
 	if resp.StatusCode != http.StatusOK {
 		r.log.Warnf("unexpected status code %d", resp.StatusCode)
-		return "", fmt.Errorf("err response status code: %d", resp.StatusCode)
+		return "", 0, fmt.Errorf("err response status code: %d", resp.StatusCode)
 	}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
 
 	gender := models.GenderResolver{}
 
 	json := jsoniter.ConfigCompatibleWithStandardLibrary
 	if err = json.Unmarshal(body, &gender); err != nil {
-		return "", err
+		return "", 0, err
 	}
 
 	if gender.Gender == "" {
-		return "", fmt.Errorf("err gender is empty, name: %s", name)
+		return "", 0, fmt.Errorf("err gender is empty, name: %s", name)
 	}
 
-	return gender.Gender, nil
+	return gender.Gender, gender.Probability, nil
 }
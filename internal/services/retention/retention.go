@@ -0,0 +1,211 @@
+// Package retention enforces the data retention policy: soft-deleted users
+// are erased and stale users are archived on a schedule.
+package retention
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/sirupsen/logrus"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"os"
+	"time"
+)
+
+// lockKey is the Postgres advisory lock held by the instance applying the
+// policy.
+const lockKey int64 = 0x72657465
+
+type retentionStorage interface {
+	TryAdvisoryLock(ctx context.Context, key int64, fn func(ctx context.Context) error) (bool, error)
+	CountRetention(ctx context.Context, purgeBefore, archiveBefore *time.Time) (purged, archived int, err error)
+	PurgeDeleted(ctx context.Context, before time.Time) ([]int, error)
+	ArchiveUsers(ctx context.Context, before time.Time) ([]int, error)
+	ExportArchivable(ctx context.Context, before time.Time, fn func(user *models.User) error) error
+	DeleteArchived(ctx context.Context, ids []int, before time.Time) ([]int, error)
+}
+
+type cache interface {
+	Delete(ctx context.Context, key int) error
+}
+
+type Service struct {
+	db     retentionStorage
+	log    *logrus.Entry
+	cache  cache
+	policy models.RetentionPolicy
+}
+
+func NewService(db retentionStorage, logger *logrus.Logger, cache cache, policy models.RetentionPolicy) *Service {
+	return &Service{
+		db:     db,
+		log:    logger.WithField("module", "retention"),
+		cache:  cache,
+		policy: policy,
+	}
+}
+
+// Run applies the policy every interval until ctx is done. Only the
+// instance holding the advisory lock applies it, the others skip the run.
+func (s *Service) Run(ctx context.Context, interval time.Duration, dryRun bool) error {
+	if !s.policy.Enabled() {
+		s.log.Info("no retention rules, retention job is not started")
+		return nil
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		report, err := s.Apply(ctx, dryRun)
+		switch {
+		case ctx.Err() != nil:
+			return nil
+		case err != nil:
+			s.log.Warnf("err applying retention policy: %v", err)
+		case report.Skipped:
+			s.log.Info("retention: another instance holds the lock")
+		default:
+			s.log.Infof("retention (dry run %t): purged %d, archived %d", report.DryRun, report.Purged, report.Archived)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Apply applies the policy once under the advisory lock. With dryRun
+// nothing is changed and the report counts the users the run would erase
+// and archive. On error the report tells what was done before it.
+func (s *Service) Apply(ctx context.Context, dryRun bool) (*models.RetentionReport, error) {
+	report := models.RetentionReport{DryRun: dryRun, StartedAt: time.Now()}
+
+	if s.policy.PurgeDeletedAfter > 0 {
+		before := report.StartedAt.Add(-s.policy.PurgeDeletedAfter)
+		report.PurgedBefore = &before
+	}
+
+	if s.policy.ArchiveAfter > 0 {
+		before := report.StartedAt.Add(-s.policy.ArchiveAfter)
+		report.ArchivedBefore = &before
+	}
+
+	if dryRun {
+		var err error
+		if report.Purged, report.Archived, err = s.db.CountRetention(ctx, report.PurgedBefore, report.ArchivedBefore); err != nil {
+			return nil, fmt.Errorf("err counting users: %w", err)
+		}
+
+		return &report, nil
+	}
+
+	locked, err := s.db.TryAdvisoryLock(ctx, lockKey, func(ctx context.Context) error {
+		return s.apply(ctx, &report)
+	})
+
+	report.Skipped = !locked
+
+	return &report, err
+}
+
+func (s *Service) apply(ctx context.Context, report *models.RetentionReport) error {
+	if report.PurgedBefore != nil {
+		ids, err := s.db.PurgeDeleted(ctx, *report.PurgedBefore)
+		if err != nil {
+			return fmt.Errorf("err purging deleted users: %w", err)
+		}
+
+		report.Purged = len(ids)
+		s.evict(ctx, ids)
+	}
+
+	if report.ArchivedBefore != nil {
+		var ids []int
+		var err error
+
+		if s.policy.ArchiveTarget == models.ArchiveFile {
+			ids, err = s.archiveToFile(ctx, report)
+		} else {
+			ids, err = s.db.ArchiveUsers(ctx, *report.ArchivedBefore)
+		}
+
+		if err != nil {
+			return fmt.Errorf("err archiving users: %w", err)
+		}
+
+		report.Archived = len(ids)
+		s.evict(ctx, ids)
+	}
+
+	return nil
+}
+
+// archiveToFile writes the users to archive to a new NDJSON file and erases
+// them once the file is synced. Users updated meanwhile are kept, their
+// older state stays in the file. The file is removed when nothing is
+// archived.
+func (s *Service) archiveToFile(ctx context.Context, report *models.RetentionReport) (ids []int, err error) {
+	if err = os.MkdirAll(s.policy.ArchiveDir, 0o750); err != nil {
+		return nil, err
+	}
+
+	// the random suffix keeps runs started in the same second apart
+	file, err := os.CreateTemp(s.policy.ArchiveDir, "users-"+report.StartedAt.UTC().Format("20060102T150405Z")+"-*.ndjson")
+	if err != nil {
+		return nil, err
+	}
+
+	name := file.Name()
+
+	defer func() {
+		if len(ids) == 0 {
+			_ = os.Remove(name)
+		}
+	}()
+
+	exported := make([]int, 0)
+	w := bufio.NewWriter(file)
+	enc := jsoniter.ConfigCompatibleWithStandardLibrary.NewEncoder(w)
+
+	err = s.db.ExportArchivable(ctx, *report.ArchivedBefore, func(user *models.User) error {
+		exported = append(exported, user.ID)
+		return enc.Encode(user)
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+
+	if err == nil {
+		err = file.Sync()
+	}
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil || len(exported) == 0 {
+		return nil, err
+	}
+
+	if ids, err = s.db.DeleteArchived(ctx, exported, *report.ArchivedBefore); err != nil {
+		return nil, err
+	}
+
+	report.ArchiveFile = name
+
+	return ids, nil
+}
+
+// evict drops erased users from the cache, so that they are not served
+// from it.
+func (s *Service) evict(ctx context.Context, ids []int) {
+	for _, id := range ids {
+		if err := s.cache.Delete(ctx, id); err != nil {
+			s.log.Warnf("err evicting user %d from cache: %v", id, err)
+		}
+	}
+}
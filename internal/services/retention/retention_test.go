@@ -0,0 +1,226 @@
+package retention
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeStorage struct {
+	locked    bool
+	users     map[int]*models.User
+	exportErr error
+}
+
+func (f *fakeStorage) TryAdvisoryLock(ctx context.Context, _ int64, fn func(ctx context.Context) error) (bool, error) {
+	if f.locked {
+		return false, nil
+	}
+
+	return true, fn(ctx)
+}
+
+func (f *fakeStorage) selectIDs(match func(user *models.User) bool) []int {
+	ids := make([]int, 0)
+
+	for id, user := range f.users {
+		if match(user) {
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Ints(ids)
+
+	return ids
+}
+
+func deletedBefore(before time.Time) func(user *models.User) bool {
+	return func(user *models.User) bool {
+		return user.IsDeleted && user.DeletedAt.Before(before)
+	}
+}
+
+func archivable(before time.Time) func(user *models.User) bool {
+	return func(user *models.User) bool {
+		return !user.IsDeleted && user.UpdatedAt.Before(before)
+	}
+}
+
+func (f *fakeStorage) remove(ids []int) []int {
+	for _, id := range ids {
+		delete(f.users, id)
+	}
+
+	return ids
+}
+
+func (f *fakeStorage) CountRetention(_ context.Context, purgeBefore, archiveBefore *time.Time) (int, int, error) {
+	purged, archived := 0, 0
+
+	if purgeBefore != nil {
+		purged = len(f.selectIDs(deletedBefore(*purgeBefore)))
+	}
+
+	if archiveBefore != nil {
+		archived = len(f.selectIDs(archivable(*archiveBefore)))
+	}
+
+	return purged, archived, nil
+}
+
+func (f *fakeStorage) PurgeDeleted(_ context.Context, before time.Time) ([]int, error) {
+	return f.remove(f.selectIDs(deletedBefore(before))), nil
+}
+
+func (f *fakeStorage) ArchiveUsers(_ context.Context, before time.Time) ([]int, error) {
+	return f.remove(f.selectIDs(archivable(before))), nil
+}
+
+func (f *fakeStorage) ExportArchivable(_ context.Context, before time.Time, fn func(user *models.User) error) error {
+	for _, id := range f.selectIDs(archivable(before)) {
+		if err := fn(f.users[id]); err != nil {
+			return err
+		}
+	}
+
+	return f.exportErr
+}
+
+func (f *fakeStorage) DeleteArchived(_ context.Context, ids []int, before time.Time) ([]int, error) {
+	deleted := make([]int, 0, len(ids))
+
+	for _, id := range ids {
+		if user, ok := f.users[id]; ok && archivable(before)(user) {
+			deleted = append(deleted, id)
+		}
+	}
+
+	return f.remove(deleted), nil
+}
+
+type fakeCache struct {
+	deleted []int
+}
+
+func (f *fakeCache) Delete(_ context.Context, key int) error {
+	f.deleted = append(f.deleted, key)
+	return nil
+}
+
+// newStorage returns users 1 and 2 deleted 60 and 10 days ago, 3 and 4
+// updated a year and a day ago. Users 1 and 2 were not updated for a year
+// either, deleted users are only purged and never archived.
+func newStorage() *fakeStorage {
+	now := time.Now()
+	day := 24 * time.Hour
+	longAgo, lastWeek := now.Add(-60*day), now.Add(-10*day)
+
+	return &fakeStorage{users: map[int]*models.User{
+		1: {ID: 1, IsDeleted: true, DeletedAt: &longAgo, UpdatedAt: now.Add(-365 * day)},
+		2: {ID: 2, IsDeleted: true, DeletedAt: &lastWeek, UpdatedAt: now.Add(-365 * day)},
+		3: {ID: 3, Name: "Frodo", UpdatedAt: now.Add(-365 * day)},
+		4: {ID: 4, UpdatedAt: now.Add(-day)},
+	}}
+}
+
+var policy = models.RetentionPolicy{PurgeDeletedAfter: 30 * 24 * time.Hour, ArchiveAfter: 180 * 24 * time.Hour}
+
+func TestService_Apply(t *testing.T) {
+	db, c := newStorage(), &fakeCache{}
+	s := NewService(db, logrus.New(), c, policy)
+
+	report, err := s.Apply(context.Background(), true)
+	require.NoError(t, err)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, 1, report.Purged)
+	assert.Equal(t, 1, report.Archived)
+	assert.Len(t, db.users, 4, "a dry run changes nothing")
+	assert.Empty(t, c.deleted)
+
+	report, err = s.Apply(context.Background(), false)
+	require.NoError(t, err)
+	assert.False(t, report.Skipped)
+	assert.Equal(t, 1, report.Purged)
+	assert.Equal(t, 1, report.Archived)
+	require.NotNil(t, report.PurgedBefore)
+	require.NotNil(t, report.ArchivedBefore)
+	assert.ElementsMatch(t, []int{2, 4}, db.selectIDs(func(*models.User) bool { return true }))
+	assert.Equal(t, []int{1, 3}, c.deleted)
+}
+
+func TestService_Apply_locked(t *testing.T) {
+	db := newStorage()
+	db.locked = true
+
+	report, err := NewService(db, logrus.New(), &fakeCache{}, policy).Apply(context.Background(), false)
+	require.NoError(t, err)
+	assert.True(t, report.Skipped)
+	assert.Len(t, db.users, 4)
+}
+
+func TestService_Apply_file(t *testing.T) {
+	db, c, dir := newStorage(), &fakeCache{}, t.TempDir()
+
+	p := policy
+	p.ArchiveTarget, p.ArchiveDir = models.ArchiveFile, dir
+
+	s := NewService(db, logrus.New(), c, p)
+
+	report, err := s.Apply(context.Background(), false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Purged)
+	assert.Equal(t, 1, report.Archived)
+	require.NotEmpty(t, report.ArchiveFile)
+	assert.NotContains(t, db.users, 3)
+	assert.Equal(t, []int{1, 3}, c.deleted)
+
+	file, err := os.Open(report.ArchiveFile)
+	require.NoError(t, err)
+
+	defer file.Close()
+
+	var lines []string
+	for scanner := bufio.NewScanner(file); scanner.Scan(); {
+		lines = append(lines, scanner.Text())
+	}
+
+	require.Len(t, lines, 1)
+	assert.True(t, strings.Contains(lines[0], `"name":"Frodo"`), lines[0])
+
+	// nothing left to archive, no empty file is kept
+	report, err = s.Apply(context.Background(), false)
+	require.NoError(t, err)
+	assert.Zero(t, report.Archived)
+	assert.Empty(t, report.ArchiveFile)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestService_Apply_fileError(t *testing.T) {
+	db := newStorage()
+	db.exportErr = errors.New("connection reset")
+
+	dir := t.TempDir()
+	s := NewService(db, logrus.New(), &fakeCache{}, models.RetentionPolicy{
+		ArchiveAfter: policy.ArchiveAfter, ArchiveTarget: models.ArchiveFile, ArchiveDir: dir,
+	})
+
+	_, err := s.Apply(context.Background(), false)
+	assert.ErrorIs(t, err, db.exportErr)
+	assert.Contains(t, db.users, 3, "users are kept when the export fails")
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
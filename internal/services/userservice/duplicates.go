@@ -0,0 +1,69 @@
+package userservice
+
+import (
+	"context"
+	"fmt"
+	"github.com/zuzi90/tz-enricher/internal/models"
+	"time"
+)
+
+// RunDuplicateDetection looks for duplicate users every interval until ctx
+// is done. Detection is idempotent, so several instances may run it.
+func (s *UserService) RunDuplicateDetection(ctx context.Context, interval time.Duration, threshold float64) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		started := time.Now()
+
+		found, err := s.db.DetectDuplicates(ctx, threshold)
+		switch {
+		case ctx.Err() != nil:
+			return nil
+		case err != nil:
+			s.log.Warnf("err detecting duplicates: %v", err)
+		default:
+			s.log.Infof("duplicate detection: %d candidate pairs in %v", found, time.Since(started))
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *UserService) GetDuplicates(ctx context.Context, params models.GetDuplicatesParams) ([]*models.DuplicateCandidate, error) {
+	candidates, err := s.db.GetDuplicates(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("err getting duplicates from db: %w", err)
+	}
+
+	return candidates, nil
+}
+
+func (s *UserService) DismissDuplicate(ctx context.Context, pair models.DuplicatePair) error {
+	if err := s.db.DismissDuplicate(ctx, pair); err != nil {
+		return fmt.Errorf("err dismissing duplicate: %w", err)
+	}
+
+	return nil
+}
+
+// MergeUser merges the duplicate into the survivor and drops both from the
+// cache.
+func (s *UserService) MergeUser(ctx context.Context, survivorID, duplicateID int) (*models.User, error) {
+	user, err := s.db.MergeUser(ctx, survivorID, duplicateID)
+	if err != nil {
+		return nil, fmt.Errorf("err merging users: %w", err)
+	}
+
+	for _, id := range []int{survivorID, duplicateID} {
+		if err = s.cache.Delete(ctx, id); err != nil {
+			s.log.Warnf("err merge users, cache delete %d: %v", id, err)
+		}
+	}
+
+	return user, nil
+}
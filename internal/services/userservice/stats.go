@@ -0,0 +1,32 @@
+package userservice
+
+import (
+	"context"
+	"fmt"
+	"github.com/zuzi90/tz-enricher/internal/models"
+)
+
+// GetStats returns the statistics from the cache, computing and caching
+// them on a miss. Cached statistics may lag behind by up to the TTL.
+func (s *UserService) GetStats(ctx context.Context, params models.StatsParams) (*models.Stats, error) {
+	key := params.CacheKey()
+
+	if s.statsTTL > 0 {
+		if stats, err := s.cache.GetStats(ctx, key); err == nil {
+			return stats, nil
+		}
+	}
+
+	stats, err := s.db.GetStats(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("err getting stats from db: %w", err)
+	}
+
+	if s.statsTTL > 0 {
+		if err = s.cache.SetStats(ctx, key, stats, s.statsTTL); err != nil {
+			s.log.Warnf("err writing stats to cache: %v", err)
+		}
+	}
+
+	return stats, nil
+}
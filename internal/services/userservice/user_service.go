@@ -5,14 +5,27 @@ import (
 	"fmt"
 	"github.com/sirupsen/logrus"
 	"github.com/zuzi90/tz-enricher/internal/models"
+	"strconv"
+	"time"
 )
 
 type userStorage interface {
 	CreateUser(ctx context.Context, val models.UserCreate) (*models.User, error)
 	GetUser(ctx context.Context, id int) (*models.User, error)
-	GetUsers(ctx context.Context, params models.GetUsersParams) ([]*models.User, error)
-	UpdateUser(ctx context.Context, user models.UserUpdate, id int) (*models.User, error)
-	DeleteUser(ctx context.Context, id int) error
+	GetUsers(ctx context.Context, params models.GetUsersParams) (*models.UsersPage, error)
+	UpdateUser(ctx context.Context, user models.UserUpdate, id int, versions []int) (*models.User, error)
+	DeleteUser(ctx context.Context, id int, reason string) error
+	RestoreUser(ctx context.Context, id int) (*models.User, error)
+	PurgeUser(ctx context.Context, id int) error
+	PurgeDeleted(ctx context.Context, before time.Time) ([]int, error)
+	GetUserHistory(ctx context.Context, id int) ([]*models.UserVersion, error)
+	GetUserAsOf(ctx context.Context, id int, at time.Time) (*models.User, error)
+	DetectDuplicates(ctx context.Context, threshold float64) (int64, error)
+	GetDuplicates(ctx context.Context, params models.GetDuplicatesParams) ([]*models.DuplicateCandidate, error)
+	DismissDuplicate(ctx context.Context, pair models.DuplicatePair) error
+	MergeUser(ctx context.Context, survivorID, duplicateID int) (*models.User, error)
+	GetStats(ctx context.Context, params models.StatsParams) (*models.Stats, error)
+	ExportUsers(ctx context.Context, params models.ExportParams, fn func(user *models.User) error) error
 }
 
 type cache interface {
@@ -20,19 +33,25 @@ type cache interface {
 	Set(ctx context.Context, user *models.User) error
 	Update(ctx context.Context, user *models.User) error
 	Delete(ctx context.Context, key int) error
+	GetStats(ctx context.Context, key string) (*models.Stats, error)
+	SetStats(ctx context.Context, key string, stats *models.Stats, ttl time.Duration) error
 }
 
 type UserService struct {
-	db    userStorage
-	log   *logrus.Entry
-	cache cache
+	db       userStorage
+	log      *logrus.Entry
+	cache    cache
+	statsTTL time.Duration
 }
 
-func NewUserService(db userStorage, logger *logrus.Logger, cache cache) *UserService {
+// NewUserService creates the service, statistics are cached for statsTTL,
+// 0 disables their caching.
+func NewUserService(db userStorage, logger *logrus.Logger, cache cache, statsTTL time.Duration) *UserService {
 	return &UserService{
-		db:    db,
-		log:   logger.WithField("module", "user_service"),
-		cache: cache,
+		db:       db,
+		log:      logger.WithField("module", "user_service"),
+		cache:    cache,
+		statsTTL: statsTTL,
 	}
 }
 
@@ -50,8 +69,9 @@ func (s *UserService) CreateUser(ctx context.Context, val models.UserCreate) (*m
 }
 
 func (s *UserService) GetUser(ctx context.Context, id int) (*models.User, error) {
-	user, err := s.cache.Get(ctx, fmt.Sprintf("UserKey:%d", id))
-	if err != nil {
+	user, err := s.cache.Get(ctx, strconv.Itoa(id))
+	// users cached before versioning have no version to serve as ETag
+	if err != nil || user.Version == 0 {
 
 		user, err = s.db.GetUser(ctx, id)
 		if err != nil {
@@ -71,18 +91,52 @@ func (s *UserService) GetUser(ctx context.Context, id int) (*models.User, error)
 	return user, nil
 }
 
-func (s *UserService) GetUsers(ctx context.Context, params models.GetUsersParams) ([]*models.User, error) {
+// GetUserAsOf reads the user as it was at the given time from its history,
+// bypassing the cache.
+func (s *UserService) GetUserAsOf(ctx context.Context, id int, at time.Time) (*models.User, error) {
+	user, err := s.db.GetUserAsOf(ctx, id, at)
+	if err != nil {
+		return nil, fmt.Errorf("err getting user history from db: %w", err)
+	}
+
+	return user, nil
+}
 
-	users, err := s.db.GetUsers(ctx, params)
+func (s *UserService) GetUserHistory(ctx context.Context, id int) ([]*models.UserVersion, error) {
+	versions, err := s.db.GetUserHistory(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("err getting user history from db: %w", err)
+	}
+
+	models.SetChanges(versions)
+
+	return versions, nil
+}
+
+func (s *UserService) GetUsers(ctx context.Context, params models.GetUsersParams) (*models.UsersPage, error) {
+
+	page, err := s.db.GetUsers(ctx, params)
 	if err != nil {
 		return nil, fmt.Errorf("err failed get users from db: %w", err)
 	}
 
-	return users, nil
+	return page, nil
+}
+
+// ExportUsers streams the users selected by params to fn, bypassing the
+// cache.
+func (s *UserService) ExportUsers(ctx context.Context, params models.ExportParams, fn func(user *models.User) error) error {
+	if err := s.db.ExportUsers(ctx, params, fn); err != nil {
+		return fmt.Errorf("err exporting users from db: %w", err)
+	}
+
+	return nil
 }
 
-func (s *UserService) UpdateUser(ctx context.Context, id int, val models.UserUpdate) (*models.User, error) {
-	user, err := s.db.UpdateUser(ctx, val, id)
+// UpdateUser updates the user, with versions given only if its current
+// version is one of them.
+func (s *UserService) UpdateUser(ctx context.Context, id int, val models.UserUpdate, versions []int) (*models.User, error) {
+	user, err := s.db.UpdateUser(ctx, val, id, versions)
 	if err != nil {
 		return nil, fmt.Errorf("err updating user db: %w", err)
 	}
@@ -94,8 +148,8 @@ func (s *UserService) UpdateUser(ctx context.Context, id int, val models.UserUpd
 	return user, nil
 }
 
-func (s *UserService) DeleteUser(ctx context.Context, id int) error {
-	err := s.db.DeleteUser(ctx, id)
+func (s *UserService) DeleteUser(ctx context.Context, id int, reason string) error {
+	err := s.db.DeleteUser(ctx, id, reason)
 	if err != nil {
 		return fmt.Errorf("err delete user, db delete %w", err)
 	}
@@ -108,3 +162,46 @@ func (s *UserService) DeleteUser(ctx context.Context, id int) error {
 
 	return nil
 }
+
+func (s *UserService) RestoreUser(ctx context.Context, id int) (*models.User, error) {
+	user, err := s.db.RestoreUser(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("err restore user, db restore: %w", err)
+	}
+
+	if err = s.cache.Set(ctx, user); err != nil {
+		s.log.Warnf("err restore user, cache set: %v", err)
+	}
+
+	return user, nil
+}
+
+// PurgeUser erases the user from the database and the cache.
+func (s *UserService) PurgeUser(ctx context.Context, id int) error {
+	if err := s.db.PurgeUser(ctx, id); err != nil {
+		return fmt.Errorf("err purge user, db delete: %w", err)
+	}
+
+	if err := s.cache.Delete(ctx, id); err != nil {
+		s.log.Warnf("err purge user, cache delete: %v", err)
+	}
+
+	return nil
+}
+
+// PurgeDeleted erases users soft-deleted more than olderThan ago and returns
+// how many were erased.
+func (s *UserService) PurgeDeleted(ctx context.Context, olderThan time.Duration) (int, error) {
+	ids, err := s.db.PurgeDeleted(ctx, time.Now().Add(-olderThan))
+	if err != nil {
+		return 0, fmt.Errorf("err purge deleted users, db delete: %w", err)
+	}
+
+	for _, id := range ids {
+		if err = s.cache.Delete(ctx, id); err != nil {
+			s.log.Warnf("err purge user %d, cache delete: %v", id, err)
+		}
+	}
+
+	return len(ids), nil
+}
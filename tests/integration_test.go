@@ -12,7 +12,9 @@ import (
 	"github.com/zuzi90/tz-enricher/internal/logger"
 	"github.com/zuzi90/tz-enricher/internal/models"
 	"github.com/zuzi90/tz-enricher/internal/providers/cache"
+	"github.com/zuzi90/tz-enricher/internal/providers/codec"
 	"github.com/zuzi90/tz-enricher/internal/providers/kafka"
+	"github.com/zuzi90/tz-enricher/internal/providers/schemaregistry"
 	"github.com/zuzi90/tz-enricher/internal/providers/storage/psql"
 	"github.com/zuzi90/tz-enricher/internal/rest"
 	message_service "github.com/zuzi90/tz-enricher/internal/services/message-service"
@@ -81,24 +83,28 @@ func (s *IntegrationTestSuite) SetupSuite() {
 
 	s.cache = cache.NewRedis(clientRedis, s.log)
 
-	s.db, err = psql.NewStorage(s.log, s.pgDSN)
+	s.db, err = psql.NewStorage(ctx, s.log, s.pgDSN, psql.Options{})
 	s.Require().NoError(err)
 	err = s.db.UpdateSchema()
 	s.Require().NoError(err)
 
-	s.producer, err = kafka.NewProducer(s.conf.Brokers, s.conf.KafkaTopicWrongFN, s.log)
+	s.producer, err = kafka.NewProducer(s.conf.Brokers, s.conf.KafkaTopicWrongFN, kafka.Options{}, s.log)
 	s.Require().NoError(err)
 
 	s.ageResolver = resolvers.NewAgeResolver(s.log, s.conf.AgeURL)
 	s.genderResolver = resolvers.NewGenderResolver(s.log, s.conf.GenderURL)
 	s.countryResolver = resolvers.NewCountryResolver(s.log, s.conf.NationalityURL)
 
-	s.service = message_service.NewMessageService(s.cache, s.ageResolver, s.genderResolver, s.countryResolver, s.producer, s.db)
-	s.uService = userservice.NewUserService(s.db, s.log, s.cache)
+	registry := schemaregistry.NewClient(s.log, s.conf.SchemaRegistryURL, s.conf.SchemaRegistryUser, s.conf.SchemaRegistryPassword)
+	mCodec, err := codec.NewCodec(s.log, s.conf.MessageFormat, registry, s.conf.KafkaTopicWrongFN+"-value", s.conf.SchemaRegistryAutoRegister)
+	s.Require().NoError(err)
+
+	s.service = message_service.NewMessageService(s.log, s.cache, s.ageResolver, s.genderResolver, s.countryResolver, mCodec, s.producer, s.db)
+	s.uService = userservice.NewUserService(s.db, s.log, s.cache, s.conf.StatsCacheTTL)
 
-	s.consumer = kafka.NewConsumer(s.conf.Brokers, s.conf.WorkersCount, s.conf.KafkaTopic, s.log, s.service)
+	s.consumer = kafka.NewConsumer(s.conf.Brokers, s.conf.WorkersCount, s.conf.WorkerQueueSize, s.conf.ConsumerMaxLag, s.conf.KafkaTopic, kafka.Options{}, s.log, s.service)
 
-	s.server = rest.NewServer(port, s.log, s.service, s.uService)
+	s.server = rest.NewServer(port, "", s.log, s.service, s.uService, s.consumer)
 
 	go func() {
 		err = s.consumer.Run(ctx)
@@ -123,10 +129,10 @@ func (s *IntegrationTestSuite) sendRequest(t *testing.T, ctx context.Context, me
 	if params != nil {
 		query := req.URL.Query()
 		query.Set("text", params.Text)
+		query.Set("match", string(params.Match))
 		query.Set("limit", strconv.Itoa(params.Limit))
 		query.Set("offset", strconv.Itoa(params.Offset))
-		query.Set("sorting", params.Sorting)
-		query.Set("descending", strconv.FormatBool(params.Descending))
+		query.Set("sort", models.FormatSort(params.Sort))
 		req.URL.RawQuery = query.Encode()
 	}
 
@@ -152,7 +158,7 @@ func (s *IntegrationTestSuite) TearDownSuite() {
 }
 
 func (s *IntegrationTestSuite) TearDownTest() {
-	err := s.db.TruncateTables(`users`)
+	err := s.db.TruncateTables(`users`, `users_archive`)
 	s.Require().NoError(err)
 }
 
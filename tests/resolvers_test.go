@@ -19,7 +19,7 @@ func (s *IntegrationTestSuite) TestResolvers() {
 
 	time.Sleep(1 * time.Second)
 	s.Run("get gender by name", func() {
-		gender, err := s.genderResolver.GetGender(ctx, name)
+		gender, _, err := s.genderResolver.GetGender(ctx, name)
 		s.Require().NoError(err)
 		s.Require().Equal("female", gender)
 		fmt.Println(gender)
@@ -27,7 +27,7 @@ func (s *IntegrationTestSuite) TestResolvers() {
 
 	time.Sleep(1 * time.Second)
 	s.Run("get country by name", func() {
-		country, err := s.countryResolver.GetCountry(ctx, name)
+		country, _, err := s.countryResolver.GetCountry(ctx, name)
 		s.Require().NoError(err)
 		s.Require().Equal("IL", country)
 		fmt.Println(country)
@@ -1,11 +1,22 @@
 package tests
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
+	"github.com/stretchr/testify/require"
 	"github.com/zuzi90/tz-enricher/internal/models"
+	"github.com/zuzi90/tz-enricher/internal/providers/storage"
+	"github.com/zuzi90/tz-enricher/internal/providers/storage/storagetest"
+	"github.com/zuzi90/tz-enricher/internal/services/retention"
+	"io"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"testing"
+	"time"
 )
 
 func (s *IntegrationTestSuite) TestCreateUser() {
@@ -119,15 +130,47 @@ func (s *IntegrationTestSuite) TestGetUsers() {
 	text := "Shoshana"
 	limit := "10"
 	offset := "0"
-	sorting := "age"
+	sorting := "-age,surname"
 	descending := "false"
 
-	params := models.NewParams(text, limit, offset, sorting, descending)
-	var usersResp []*models.User
+	params, err := models.NewParams(text, limit, offset, sorting, descending)
+	s.Require().NoError(err)
+
+	var usersResp models.UsersPage
 	s.Run("get users with name Shoshana", func() {
 		code := s.sendRequest(s.T(), ctx, http.MethodGet, s.host, "/api/v1/users/", []byte{}, &usersResp, &params)
 		s.Require().Equal(http.StatusOK, code)
-		s.Require().Equal("Shoshana", usersResp[0].Name)
+		s.Require().Equal("Shoshana", usersResp.Items[0].Name)
+		s.Require().Equal(int64(1), *usersResp.Total)
+	})
+
+	s.Run("sort by unknown field", func() {
+		var body string
+		code := s.sendRequest(s.T(), ctx, http.MethodGet, s.host, "/api/v1/users/?sort=password", []byte{}, &body, nil)
+		s.Require().Equal(http.StatusBadRequest, code)
+	})
+
+	s.Run("search users by lower case prefix", func() {
+		params, err := models.NewParams("shosh", limit, offset, sorting, descending)
+		s.Require().NoError(err)
+		params.Match = models.MatchPrefix
+
+		var usersResp models.UsersPage
+		code := s.sendRequest(s.T(), ctx, http.MethodGet, s.host, "/api/v1/users/", []byte{}, &usersResp, &params)
+		s.Require().Equal(http.StatusOK, code)
+		s.Require().Len(usersResp.Items, 1)
+		s.Require().Equal("Shoshana", usersResp.Items[0].Name)
+	})
+
+	s.Run("fuzzy search users with a typo", func() {
+		params, err := models.NewParams("Shoshanna", limit, offset, sorting, descending)
+		s.Require().NoError(err)
+
+		var usersResp models.UsersPage
+		code := s.sendRequest(s.T(), ctx, http.MethodGet, s.host, "/api/v1/users/", []byte{}, &usersResp, &params)
+		s.Require().Equal(http.StatusOK, code)
+		s.Require().NotEmpty(usersResp.Items)
+		s.Require().Equal("Shoshana", usersResp.Items[0].Name)
 	})
 
 }
@@ -226,8 +269,49 @@ func (s *IntegrationTestSuite) TestDeleteUser() {
 	})
 
 	s.Run("delete user", func() {
-		code := s.sendRequest(s.T(), ctx, http.MethodDelete, s.host, "/api/v1/users/"+strconv.Itoa(s.userID), []byte{}, nil, nil)
+		code := s.sendRequest(s.T(), ctx, http.MethodDelete, s.host, "/api/v1/users/"+strconv.Itoa(s.userID)+"?reason=duplicate", []byte{}, nil, nil)
+		s.Require().Equal(http.StatusOK, code)
+	})
+
+	s.Run("list deleted users", func() {
+		var page models.UsersPage
+		code := s.sendRequest(s.T(), ctx, http.MethodGet, s.host, "/api/v1/users/?only_deleted=true&text=Ben-Gurion&match=exact", []byte{}, &page, nil)
+		s.Require().Equal(http.StatusOK, code)
+		s.Require().NotEmpty(page.Items)
+
+		user := page.Items[0]
+		s.Require().True(user.IsDeleted)
+		s.Require().NotNil(user.DeletedAt)
+		s.Require().Equal("duplicate", user.DeletedReason)
+	})
+
+	s.Run("restore user", func() {
+		var userResp models.User
+		code := s.sendRequest(s.T(), ctx, http.MethodPost, s.host, "/api/v1/users/"+strconv.Itoa(s.userID)+"/restore", []byte{}, &userResp, nil)
+		s.Require().Equal(http.StatusOK, code)
+		s.Require().False(userResp.IsDeleted)
+		s.Require().Nil(userResp.DeletedAt)
+
+		code = s.sendRequest(s.T(), ctx, http.MethodPost, s.host, "/api/v1/users/"+strconv.Itoa(s.userID)+"/restore", []byte{}, &userResp, nil)
+		s.Require().Equal(http.StatusNotFound, code)
+	})
+
+	s.Run("purge user", func() {
+		code := s.sendRequest(s.T(), ctx, http.MethodDelete, s.host, "/api/v1/users/"+strconv.Itoa(s.userID)+"/purge", []byte{}, nil, nil)
 		s.Require().Equal(http.StatusOK, code)
+
+		var userResp models.User
+		code = s.sendRequest(s.T(), ctx, http.MethodPost, s.host, "/api/v1/users/"+strconv.Itoa(s.userID)+"/restore", []byte{}, &userResp, nil)
+		s.Require().Equal(http.StatusNotFound, code)
+	})
+
+	s.Run("purge deleted users", func() {
+		var result models.PurgeResult
+		code := s.sendRequest(s.T(), ctx, http.MethodDelete, s.host, "/api/v1/users/deleted?olderThan=720h", []byte{}, &result, nil)
+		s.Require().Equal(http.StatusOK, code)
+
+		code = s.sendRequest(s.T(), ctx, http.MethodDelete, s.host, "/api/v1/users/deleted?olderThan=month", []byte{}, &result, nil)
+		s.Require().Equal(http.StatusBadRequest, code)
 	})
 
 	s.Run("delete a invalid id", func() {
@@ -243,3 +327,420 @@ func (s *IntegrationTestSuite) TestDeleteUser() {
 	})
 
 }
+
+func (s *IntegrationTestSuite) TestUserHistory() {
+	ctx := context.Background()
+
+	val := models.UserCreate{
+		Name:        "Golda",
+		Surname:     "Meir",
+		Age:         80,
+		Gender:      "female",
+		Nationality: "UA",
+	}
+
+	reqBody, err := json.Marshal(val)
+	s.Require().NoError(err)
+
+	var created models.User
+
+	s.Run("create and update user", func() {
+		code := s.sendRequest(s.T(), ctx, http.MethodPost, s.host, "/api/v1/users", reqBody, &created, nil)
+		s.Require().Equal(http.StatusCreated, code)
+
+		reqBody, err = json.Marshal(map[string]string{"nationality": "IL"})
+		s.Require().NoError(err)
+
+		var userResp models.User
+		code = s.sendRequest(s.T(), ctx, http.MethodPatch, s.host, "/api/v1/users/"+strconv.Itoa(created.ID), reqBody, &userResp, nil)
+		s.Require().Equal(http.StatusOK, code)
+	})
+
+	s.Run("get history", func() {
+		var versions []models.UserVersion
+		code := s.sendRequest(s.T(), ctx, http.MethodGet, s.host, "/api/v1/users/"+strconv.Itoa(created.ID)+"/history", []byte{}, &versions, nil)
+		s.Require().Equal(http.StatusOK, code)
+		s.Require().Len(versions, 2)
+
+		s.Require().Equal(models.OperationCreate, versions[0].Operation)
+		s.Require().Equal(models.OperationUpdate, versions[1].Operation)
+		s.Require().Equal(models.ChangeREST, versions[1].Source)
+		s.Require().Equal([]string{"nationality"}, versions[1].Changes)
+		s.Require().Equal("UA", versions[0].Nationality)
+	})
+
+	s.Run("get user as of creation", func() {
+		var userResp models.User
+		endpoint := "/api/v1/users/" + strconv.Itoa(created.ID) + "?as_of=" + url.QueryEscape(created.UpdatedAt.Format(time.RFC3339Nano))
+		code := s.sendRequest(s.T(), ctx, http.MethodGet, s.host, endpoint, []byte{}, &userResp, nil)
+		s.Require().Equal(http.StatusOK, code)
+		s.Require().Equal("UA", userResp.Nationality)
+	})
+
+	s.Run("get user before it existed", func() {
+		var userResp models.User
+		code := s.sendRequest(s.T(), ctx, http.MethodGet, s.host, "/api/v1/users/"+strconv.Itoa(created.ID)+"?as_of=2000-01-01T00:00:00Z", []byte{}, &userResp, nil)
+		s.Require().Equal(http.StatusNotFound, code)
+	})
+}
+
+func (s *IntegrationTestSuite) TestUserETag() {
+	ctx := context.Background()
+
+	val := models.UserCreate{
+		Name:        "Levi",
+		Surname:     "Eshkol",
+		Age:         73,
+		Gender:      "male",
+		Nationality: "UA",
+	}
+
+	reqBody, err := json.Marshal(val)
+	s.Require().NoError(err)
+
+	var created models.User
+	code := s.sendRequest(s.T(), ctx, http.MethodPost, s.host, "/api/v1/users", reqBody, &created, nil)
+	s.Require().Equal(http.StatusCreated, code)
+
+	endpoint := s.host + "/api/v1/users/" + strconv.Itoa(created.ID)
+	tag := `"` + strconv.Itoa(created.Version) + `"`
+
+	do := func(method, header, value string, body []byte) *http.Response {
+		req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(body))
+		s.Require().NoError(err)
+		req.Header.Set(header, value)
+
+		resp, err := s.client.Do(req)
+		s.Require().NoError(err)
+		s.Require().NoError(resp.Body.Close())
+
+		return resp
+	}
+
+	s.Run("not modified", func() {
+		resp := do(http.MethodGet, "If-None-Match", tag, nil)
+		s.Require().Equal(http.StatusNotModified, resp.StatusCode)
+		s.Require().Equal(tag, resp.Header.Get("ETag"))
+	})
+
+	s.Run("update with current version", func() {
+		resp := do(http.MethodPatch, "If-Match", tag, []byte(`{"age": 74}`))
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+		s.Require().NotEqual(tag, resp.Header.Get("ETag"))
+	})
+
+	s.Run("update with stale version", func() {
+		resp := do(http.MethodPatch, "If-Match", tag, []byte(`{"age": 75}`))
+		s.Require().Equal(http.StatusPreconditionFailed, resp.StatusCode)
+	})
+
+	s.Run("modified", func() {
+		resp := do(http.MethodGet, "If-None-Match", tag, nil)
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+	})
+}
+
+func (s *IntegrationTestSuite) TestMergeDuplicates() {
+	ctx := context.Background()
+
+	var survivor, duplicate models.User
+
+	for _, val := range []struct {
+		user models.UserCreate
+		resp *models.User
+	}{
+		{models.UserCreate{Name: "Yitzhak", Surname: "Rabinowitz", Age: 73, Gender: "male", Nationality: "IL"}, &survivor},
+		{models.UserCreate{Name: "Yitzchak", Surname: "Rabinowitz", Patronymic: "Nehemiah", Age: 73, Gender: "male", Nationality: "IL"}, &duplicate},
+	} {
+		reqBody, err := json.Marshal(val.user)
+		s.Require().NoError(err)
+
+		code := s.sendRequest(s.T(), ctx, http.MethodPost, s.host, "/api/v1/users", reqBody, val.resp, nil)
+		s.Require().Equal(http.StatusCreated, code)
+	}
+
+	s.Run("detect duplicates", func() {
+		_, err := s.db.DetectDuplicates(ctx, 0.6)
+		s.Require().NoError(err)
+
+		var candidates []models.DuplicateCandidate
+		code := s.sendRequest(s.T(), ctx, http.MethodGet, s.host, "/api/v1/duplicates?minSimilarity=0.6", []byte{}, &candidates, nil)
+		s.Require().Equal(http.StatusOK, code)
+
+		found := false
+		for _, c := range candidates {
+			found = found || c.DuplicatePair == models.NewPair(survivor.ID, duplicate.ID)
+		}
+
+		s.Require().True(found)
+	})
+
+	s.Run("merge into itself", func() {
+		reqBody := []byte(`{"duplicateId": ` + strconv.Itoa(survivor.ID) + `}`)
+		code := s.sendRequest(s.T(), ctx, http.MethodPost, s.host, "/api/v1/users/"+strconv.Itoa(survivor.ID)+"/merge", reqBody, nil, nil)
+		s.Require().Equal(http.StatusBadRequest, code)
+	})
+
+	s.Run("merge", func() {
+		reqBody := []byte(`{"duplicateId": ` + strconv.Itoa(duplicate.ID) + `}`)
+
+		var merged models.User
+		code := s.sendRequest(s.T(), ctx, http.MethodPost, s.host, "/api/v1/users/"+strconv.Itoa(survivor.ID)+"/merge", reqBody, &merged, nil)
+		s.Require().Equal(http.StatusOK, code)
+		s.Require().Equal("Yitzhak", merged.Name)
+		s.Require().Equal("Nehemiah", merged.Patronymic)
+
+		var versions []models.UserVersion
+		code = s.sendRequest(s.T(), ctx, http.MethodGet, s.host, "/api/v1/users/"+strconv.Itoa(duplicate.ID)+"/history", []byte{}, &versions, nil)
+		s.Require().Equal(http.StatusOK, code)
+
+		last := versions[len(versions)-1]
+		s.Require().Equal(models.OperationMerge, last.Operation)
+		s.Require().True(last.IsDeleted)
+		s.Require().Equal(survivor.ID, *last.MergedInto)
+
+		code = s.sendRequest(s.T(), ctx, http.MethodGet, s.host, "/api/v1/users/"+strconv.Itoa(duplicate.ID), []byte{}, nil, nil)
+		s.Require().Equal(http.StatusNotFound, code)
+	})
+}
+
+func (s *IntegrationTestSuite) TestStats() {
+	ctx := context.Background()
+
+	val := models.UserCreate{
+		Name:        "Menachem",
+		Surname:     "Begin",
+		Age:         78,
+		Gender:      "male",
+		Nationality: "XQ",
+	}
+
+	reqBody, err := json.Marshal(val)
+	s.Require().NoError(err)
+
+	var created models.User
+	code := s.sendRequest(s.T(), ctx, http.MethodPost, s.host, "/api/v1/users", reqBody, &created, nil)
+	s.Require().Equal(http.StatusCreated, code)
+
+	s.Run("gender", func() {
+		var stats models.Stats
+		code := s.sendRequest(s.T(), ctx, http.MethodGet, s.host, "/api/v1/stats/gender?nationality=XQ", []byte{}, &stats, nil)
+		s.Require().Equal(http.StatusOK, code)
+		s.Require().Equal(int64(1), stats.Total)
+		s.Require().Equal([]models.StatsGroup{{Key: "male", Count: 1}}, stats.Groups)
+	})
+
+	s.Run("age buckets", func() {
+		var stats models.Stats
+		code := s.sendRequest(s.T(), ctx, http.MethodGet, s.host, "/api/v1/stats/age?nationality=XQ&buckets=18,65", []byte{}, &stats, nil)
+		s.Require().Equal(http.StatusOK, code)
+		s.Require().Equal([]models.StatsGroup{{Key: "0-17"}, {Key: "18-64"}, {Key: "65+", Count: 1}}, stats.Groups)
+	})
+
+	s.Run("unknown dimension", func() {
+		code := s.sendRequest(s.T(), ctx, http.MethodGet, s.host, "/api/v1/stats/height", []byte{}, nil, nil)
+		s.Require().Equal(http.StatusBadRequest, code)
+	})
+}
+
+func (s *IntegrationTestSuite) TestExportUsers() {
+	ctx := context.Background()
+
+	val := models.UserCreate{
+		Name:        "Yitzhak",
+		Surname:     "Shamir",
+		Age:         96,
+		Gender:      "male",
+		Nationality: "XE",
+	}
+
+	reqBody, err := json.Marshal(val)
+	s.Require().NoError(err)
+
+	var created models.User
+	code := s.sendRequest(s.T(), ctx, http.MethodPost, s.host, "/api/v1/users", reqBody, &created, nil)
+	s.Require().Equal(http.StatusCreated, code)
+
+	export := func(query string, gzipped bool) []string {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.host+"/api/v1/users/export?nationality=XE&"+query, nil)
+		s.Require().NoError(err)
+
+		if gzipped {
+			// set explicitly, the transport then leaves the body compressed
+			req.Header.Set("Accept-Encoding", "gzip")
+		}
+
+		resp, err := s.client.Do(req)
+		s.Require().NoError(err)
+
+		defer resp.Body.Close()
+
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+
+		var body io.Reader = resp.Body
+		if gzipped {
+			s.Require().Equal("gzip", resp.Header.Get("Content-Encoding"))
+
+			body, err = gzip.NewReader(resp.Body)
+			s.Require().NoError(err)
+		}
+
+		data, err := io.ReadAll(body)
+		s.Require().NoError(err)
+
+		return strings.Split(strings.TrimSpace(string(data)), "\n")
+	}
+
+	s.Run("ndjson", func() {
+		lines := export("format=ndjson", false)
+		s.Require().Len(lines, 1)
+
+		var user models.User
+		s.Require().NoError(json.Unmarshal([]byte(lines[0]), &user))
+		s.Require().Equal(created.ID, user.ID)
+	})
+
+	s.Run("gzipped csv", func() {
+		lines := export("format=csv", true)
+		s.Require().Len(lines, 2)
+		s.Require().True(strings.HasPrefix(lines[1], strconv.Itoa(created.ID)+",Yitzhak,Shamir,"))
+	})
+
+	s.Run("unknown format", func() {
+		code := s.sendRequest(s.T(), ctx, http.MethodGet, s.host, "/api/v1/users/export?format=xml", []byte{}, nil, nil)
+		s.Require().Equal(http.StatusBadRequest, code)
+	})
+}
+
+func (s *IntegrationTestSuite) TestCreateUsers() {
+	ctx := models.WithChangeSource(context.Background(), models.ChangeKafka)
+
+	vals := []models.UserCreate{
+		{Name: "Meriadoc", Surname: "Brandybuck", Age: 36, Gender: "male", Nationality: "NZ"},
+		{Name: "Peregrin", Surname: "Took", Age: 28, Gender: "male", Nationality: "NZ"},
+	}
+
+	users, err := s.db.CreateUsers(ctx, vals)
+	s.Require().NoError(err)
+	s.Require().Len(users, len(vals))
+
+	for i, user := range users {
+		s.Require().Equal(vals[i].Name, user.Name)
+		s.Require().Equal(1, user.Version)
+
+		history, err := s.db.GetUserHistory(ctx, user.ID)
+		s.Require().NoError(err)
+		s.Require().Len(history, 1)
+		s.Require().Equal(models.OperationCreate, history[0].Operation)
+		s.Require().Equal(models.ChangeKafka, history[0].Source)
+	}
+
+	s.Require().NoError(s.cache.SetMany(ctx, users))
+
+	cached, err := s.cache.Get(ctx, strconv.Itoa(users[0].ID))
+	s.Require().NoError(err)
+	s.Require().Equal(users[0].Name, cached.Name)
+}
+
+func (s *IntegrationTestSuite) TestMigrations() {
+	ctx := context.Background()
+
+	s.Require().NoError(s.db.CheckSchema(ctx))
+
+	migrations, err := s.db.MigrationStatus(ctx)
+	s.Require().NoError(err)
+	s.Require().NotEmpty(migrations)
+
+	latest := migrations[len(migrations)-1]
+
+	s.Run("redo", func() {
+		redone, err := s.db.MigrateRedo(ctx)
+		s.Require().NoError(err)
+		s.Require().Len(redone, 2)
+		s.Require().False(redone[0].Applied)
+		s.Require().True(redone[1].Applied)
+		s.Require().Equal(latest.Version, redone[1].Version)
+	})
+
+	s.Run("outdated schema", func() {
+		_, err := s.db.MigrateTo(ctx, latest.Version-1)
+		s.Require().NoError(err)
+		s.Require().ErrorIs(s.db.CheckSchema(ctx), models.ErrSchemaOutdated)
+
+		applied, err := s.db.MigrateUp(ctx)
+		s.Require().NoError(err)
+		s.Require().Len(applied, 1)
+		s.Require().NoError(s.db.CheckSchema(ctx))
+	})
+}
+
+// TestStorageConformance runs the suite the memory and SQLite storages pass
+// against Postgres, so that they keep behaving the same.
+func (s *IntegrationTestSuite) TestStorageConformance() {
+	storagetest.Run(s.T(), func(t *testing.T) storage.Storage {
+		require.NoError(t, s.db.TruncateTables(`users`))
+		return s.db
+	})
+}
+
+// TestRetention applies the retention rules to users created moments apart.
+func (s *IntegrationTestSuite) TestRetention() {
+	ctx := context.Background()
+
+	users, err := s.db.CreateUsers(ctx, []models.UserCreate{
+		{Name: "Frodo", Age: 50}, {Name: "Samwise", Age: 38}, {Name: "Meriadoc", Age: 36},
+	})
+	s.Require().NoError(err)
+	s.Require().NoError(s.db.DeleteUser(ctx, users[0].ID, ""))
+
+	time.Sleep(300 * time.Millisecond)
+
+	// stale, but deleted within the purge window
+	s.Require().NoError(s.db.DeleteUser(ctx, users[2].ID, ""))
+
+	fresh, err := s.db.CreateUser(ctx, models.UserCreate{Name: "Bilbo", Age: 111})
+	s.Require().NoError(err)
+
+	service := retention.NewService(s.db, s.log, s.cache, models.RetentionPolicy{
+		PurgeDeletedAfter: 150 * time.Millisecond,
+		ArchiveAfter:      150 * time.Millisecond,
+		ArchiveTarget:     models.ArchiveTable,
+	})
+
+	report, err := service.Apply(ctx, true)
+	s.Require().NoError(err)
+	s.Require().Equal(1, report.Purged)
+	s.Require().Equal(1, report.Archived)
+
+	_, err = s.db.GetUser(ctx, users[1].ID)
+	s.Require().NoError(err, "a dry run changes nothing")
+
+	report, err = service.Apply(ctx, false)
+	s.Require().NoError(err)
+	s.Require().False(report.Skipped)
+	s.Require().Equal(1, report.Purged)
+	s.Require().Equal(1, report.Archived)
+
+	_, err = s.db.GetUser(ctx, users[1].ID)
+	s.Require().ErrorIs(err, models.ErrUserNotFound)
+
+	_, err = s.db.GetUser(ctx, fresh.ID)
+	s.Require().NoError(err)
+
+	_, err = s.db.RestoreUser(ctx, users[2].ID)
+	s.Require().NoError(err, "a recently deleted user is not archived")
+
+	s.Run("advisory lock", func() {
+		locked, err := s.db.TryAdvisoryLock(ctx, 42, func(ctx context.Context) error {
+			locked, err := s.db.TryAdvisoryLock(ctx, 42, func(context.Context) error {
+				return nil
+			})
+			s.Require().NoError(err)
+			s.Require().False(locked, "the lock is held by another session")
+
+			return nil
+		})
+		s.Require().NoError(err)
+		s.Require().True(locked)
+	})
+}